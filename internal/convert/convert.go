@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package convert renders uploads that printers handle badly, such as
+// images, plain text, Markdown, HTML and DOCX, to PDF using only the
+// standard library. The result is simple but readable on paper: text keeps
+// its headings, lists and paragraphs, and images are fitted to the page.
+package convert
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/storage"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxInputSize bounds the files that are converted, and how much a DOCX
+// may inflate to.
+const maxInputSize = 50 << 20
+
+// ErrUnsupported is returned for files of a type that can't be converted.
+var ErrUnsupported = errors.New("unsupported file type")
+
+type format int
+
+const (
+	formatUnknown format = iota
+	formatPrintable
+	formatImage
+	formatText
+	formatMarkdown
+	formatHTML
+	formatDocx
+)
+
+// Path returns where the PDF conversion of a file is stored: next to it,
+// with .pdf appended to its name.
+func Path(src string) string {
+	return src + ".pdf"
+}
+
+// Document converts a document's file to PDF if printers can't take it as
+// it is, and records the outcome on the document. A failed conversion
+// isn't fatal: the original is still printed.
+func Document(doc *models.Document) {
+	doc.ConvertedPath = nil
+	doc.ConvertedHash = nil
+	doc.ConversionError = nil
+
+	data, err := storage.ReadFile(doc.FilePath)
+	if err != nil {
+		fail(doc, models.ConversionFailed, fmt.Errorf("failed to read file: %w", err))
+		return
+	}
+
+	f := detect(doc.FilePath, data)
+	if f == formatPrintable {
+		doc.ConversionStatus = models.ConversionNotNeeded
+		return
+	}
+
+	dst := Path(doc.FilePath)
+	converted, err := convert(f, data, dst)
+	if err != nil {
+		status := models.ConversionFailed
+		if errors.Is(err, ErrUnsupported) {
+			status = models.ConversionUnsupported
+		}
+		fail(doc, status, err)
+		return
+	}
+
+	hash := storage.Hash(converted)
+	doc.ConversionStatus = models.ConversionConverted
+	doc.ConvertedPath = &dst
+	doc.ConvertedHash = &hash
+}
+
+func fail(doc *models.Document, status string, err error) {
+	message := err.Error()
+	doc.ConversionStatus = status
+	doc.ConversionError = &message
+
+	if status == models.ConversionFailed {
+		log.Printf("Failed to convert %s to PDF: %v", doc.FilePath, err)
+	}
+}
+
+// ToPDF converts the file at src to a PDF at dst. Files that are already
+// PDF or PostScript are copied unchanged.
+func ToPDF(src, dst string) error {
+	data, err := storage.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	f := detect(src, data)
+	if f == formatPrintable {
+		return storage.WriteFile(dst, data, 0644)
+	}
+
+	_, err = convert(f, data, dst)
+	return err
+}
+
+// convert renders data to a PDF at dst, returning the PDF.
+func convert(f format, data []byte, dst string) ([]byte, error) {
+	if len(data) > maxInputSize {
+		return nil, errors.New("file too large to convert")
+	}
+
+	var doc *pdf.Document
+	var err error
+
+	switch f {
+	case formatImage:
+		doc, err = renderImage(data)
+	case formatText:
+		doc = typeset(textBlocks(decodeText(data)))
+	case formatMarkdown:
+		doc = typeset(markdownBlocks(decodeText(data)))
+	case formatHTML:
+		doc = typeset(htmlBlocks(decodeText(data)))
+	case formatDocx:
+		var blocks []block
+		blocks, err = docxBlocks(data)
+		doc = typeset(blocks)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// WriteFile replaces dst in one step, so a half written conversion
+	// is never printed.
+	converted := doc.Bytes()
+	if err := storage.WriteFile(dst, converted, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save PDF: %w", err)
+	}
+	return converted, nil
+}
+
+// detect works out a file's format from its extension, falling back to
+// its content for files without a known one.
+func detect(path string, data []byte) format {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")), bytes.HasPrefix(data, []byte("%!PS")):
+		return formatPrintable
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if strings.EqualFold(filepath.Ext(path), ".docx") {
+			return formatDocx
+		}
+		return formatUnknown
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return formatImage
+	case ".md", ".markdown":
+		return formatMarkdown
+	case ".html", ".htm":
+		return formatHTML
+	case ".txt", ".text", ".csv", ".log":
+		return formatText
+	}
+
+	contentType := http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(contentType, "image/png"),
+		strings.HasPrefix(contentType, "image/jpeg"),
+		strings.HasPrefix(contentType, "image/gif"):
+		return formatImage
+	case strings.HasPrefix(contentType, "text/html"):
+		return formatHTML
+	case strings.HasPrefix(contentType, "text/plain"):
+		return formatText
+	}
+
+	return formatUnknown
+}
+
+// decodeText returns text as UTF-8. Files that aren't valid UTF-8 are
+// read as Latin-1, which is what older Windows exports tend to be.
+func decodeText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// docxBlocks extracts the text of a DOCX file's main document: paragraphs,
+// headings, list items and table rows. Formatting, images and headers are
+// left out.
+func docxBlocks(data []byte) ([]block, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCX: %w", err)
+	}
+
+	var document *zip.File
+	for _, f := range archive.File {
+		if f.Name == "word/document.xml" {
+			document = f
+		}
+	}
+	if document == nil {
+		return nil, errors.New("invalid DOCX: no word/document.xml")
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCX: %w", err)
+	}
+	defer rc.Close()
+
+	var blocks []block
+	var text strings.Builder
+	var current block
+	var cell, cells []string
+	tableDepth := 0
+	inRun := false
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxInputSize))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid DOCX: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				text.Reset()
+				current = block{kind: blockParagraph}
+			case "pStyle":
+				current = paragraphStyle(current, attr(t, "val"))
+			case "numPr":
+				if current.kind == blockParagraph {
+					current.kind = blockListItem
+					current.marker = "•"
+				}
+			case "ilvl":
+				if level, err := strconv.Atoi(attr(t, "val")); err == nil {
+					current.level = min(level, 4)
+				}
+			case "r":
+				inRun = true
+			case "tab":
+				// Tab stops are defined with tab elements too, outside runs.
+				if inRun {
+					text.WriteString("\t")
+				}
+			case "br", "cr":
+				if inRun {
+					text.WriteString("\n")
+				}
+			case "tbl":
+				tableDepth++
+			case "tr":
+				cells = nil
+			case "t":
+				var s string
+				if err := decoder.DecodeElement(&s, &t); err != nil {
+					return nil, fmt.Errorf("invalid DOCX: %w", err)
+				}
+				text.WriteString(s)
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "r":
+				inRun = false
+			case "p":
+				if tableDepth > 0 {
+					cell = append(cell, strings.TrimSpace(text.String()))
+					continue
+				}
+
+				current.text = strings.TrimSpace(text.String())
+				if current.text != "" {
+					blocks = append(blocks, current)
+				}
+			case "tc":
+				cells = append(cells, strings.TrimSpace(strings.Join(cell, " ")))
+				cell = nil
+			case "tr":
+				var row []string
+				for _, cell := range cells {
+					if cell != "" {
+						row = append(row, cell)
+					}
+				}
+				if len(row) > 0 {
+					blocks = append(blocks, block{kind: blockParagraph, text: strings.Join(row, "  ·  ")})
+				}
+				cells = nil
+			case "tbl":
+				tableDepth--
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+// paragraphStyle applies a Word paragraph style to a block. Only styles
+// that change the layout are recognised.
+func paragraphStyle(b block, style string) block {
+	lower := strings.ToLower(style)
+
+	switch {
+	case lower == "title":
+		b.kind, b.level = blockHeading, 1
+	case lower == "subtitle":
+		b.kind, b.level = blockHeading, 3
+	case strings.HasPrefix(lower, "heading"):
+		if level, err := strconv.Atoi(strings.TrimPrefix(lower, "heading")); err == nil {
+			b.kind, b.level = blockHeading, level
+		}
+	case strings.HasPrefix(lower, "listparagraph"), strings.HasPrefix(lower, "listbullet"):
+		b.kind, b.marker = blockListItem, "•"
+	case lower == "quote", lower == "intensequote":
+		b.kind = blockQuote
+	}
+
+	return b
+}
+
+func attr(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
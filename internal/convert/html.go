@@ -0,0 +1,200 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package convert
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	htmlTag     = regexp.MustCompile(`(?s)<(/?)([a-zA-Z][a-zA-Z0-9]*)([^>]*?)(/?)>`)
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->|<![^>]*>|<\?.*?\?>`)
+	htmlHidden  = regexp.MustCompile(`(?is)<(script|style|head|template)\b.*?</(script|style|head|template)\s*>`)
+	htmlHref    = regexp.MustCompile(`(?i)\bhref\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlAlt     = regexp.MustCompile(`(?i)\balt\s*=\s*("[^"]*"|'[^']*')`)
+	whitespace  = regexp.MustCompile(`\s+`)
+)
+
+// htmlBlocks lays out simple HTML, such as pages exported from a wiki:
+// headings, paragraphs, lists, preformatted text, rules and tables. Styles,
+// scripts and anything else are ignored.
+func htmlBlocks(text string) []block {
+	text = htmlComment.ReplaceAllString(text, "")
+	text = htmlHidden.ReplaceAllString(text, "")
+
+	p := &htmlParser{}
+	pos := 0
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(text, -1) {
+		p.text(text[pos:m[0]])
+		pos = m[1]
+
+		closing := m[3] > m[2]
+		tag := strings.ToLower(text[m[4]:m[5]])
+		attrs := text[m[6]:m[7]]
+		p.tag(tag, attrs, closing)
+	}
+	p.text(text[pos:])
+	p.flush()
+
+	return p.blocks
+}
+
+type htmlParser struct {
+	blocks []block
+	buf    strings.Builder
+
+	// current is the kind of block being collected, with its level and
+	// list marker.
+	current block
+	pre     int
+	href    string
+
+	// lists holds the open lists, with the next number for ordered ones
+	// and 0 for bulleted ones.
+	lists []int
+	cells []string
+}
+
+func (p *htmlParser) text(s string) {
+	if s == "" {
+		return
+	}
+	s = html.UnescapeString(s)
+	if p.pre == 0 {
+		s = whitespace.ReplaceAllString(s, " ")
+	}
+	p.buf.WriteString(s)
+}
+
+// flush ends the block being collected.
+func (p *htmlParser) flush() {
+	text := p.buf.String()
+	p.buf.Reset()
+
+	if p.pre > 0 {
+		text = strings.Trim(text, "\n")
+	} else {
+		text = strings.TrimSpace(text)
+	}
+
+	// An empty block, such as a list item that wraps its text in <p>,
+	// carries over to the text that follows.
+	if text == "" {
+		return
+	}
+
+	b := p.current
+	b.text = text
+	p.blocks = append(p.blocks, b)
+	p.current = block{kind: blockParagraph}
+}
+
+// end closes a block that sets its own kind, such as a heading.
+func (p *htmlParser) end() {
+	p.flush()
+	p.current = block{kind: blockParagraph}
+}
+
+func (p *htmlParser) tag(tag, attrs string, closing bool) {
+	switch tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		p.end()
+		if !closing {
+			level, _ := strconv.Atoi(tag[1:])
+			p.current = block{kind: blockHeading, level: level}
+		}
+
+	case "p", "div", "section", "article", "header", "footer", "main", "nav", "aside", "figure", "figcaption", "dl", "dt", "dd", "form", "fieldset":
+		p.flush()
+
+	case "br":
+		if p.pre > 0 {
+			p.buf.WriteString("\n")
+		} else {
+			p.flush()
+		}
+
+	case "hr":
+		p.flush()
+		p.blocks = append(p.blocks, block{kind: blockRule})
+
+	case "pre":
+		p.end()
+		if closing {
+			p.pre = max(p.pre-1, 0)
+		} else {
+			p.pre++
+			p.current = block{kind: blockCode}
+		}
+
+	case "blockquote":
+		p.end()
+		if !closing {
+			p.current = block{kind: blockQuote}
+		}
+
+	case "ul", "ol":
+		p.flush()
+		if closing {
+			if len(p.lists) > 0 {
+				p.lists = p.lists[:len(p.lists)-1]
+			}
+		} else if tag == "ol" {
+			p.lists = append(p.lists, 1)
+		} else {
+			p.lists = append(p.lists, 0)
+		}
+
+	case "li":
+		p.end()
+		if !closing {
+			marker := "•"
+			if n := len(p.lists); n > 0 && p.lists[n-1] > 0 {
+				marker = strconv.Itoa(p.lists[n-1]) + "."
+				p.lists[n-1]++
+			}
+			p.current = block{kind: blockListItem, level: max(len(p.lists)-1, 0), marker: marker}
+		}
+
+	case "tr":
+		p.flush()
+		if closing && len(p.cells) > 0 {
+			p.blocks = append(p.blocks, block{kind: blockParagraph, text: strings.Join(p.cells, "  ·  ")})
+		}
+		p.cells = nil
+
+	case "td", "th":
+		if closing {
+			p.cells = append(p.cells, strings.TrimSpace(whitespace.ReplaceAllString(p.buf.String(), " ")))
+			p.buf.Reset()
+		}
+
+	case "table":
+		p.flush()
+		p.cells = nil
+
+	case "a":
+		// Keep link addresses, which can't be followed on paper.
+		if closing {
+			if p.href != "" {
+				p.buf.WriteString(" (" + p.href + ")")
+			}
+			p.href = ""
+		} else if m := htmlHref.FindStringSubmatch(attrs); m != nil {
+			href := html.UnescapeString(strings.Trim(m[1], `"'`))
+			if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+				p.href = href
+			}
+		}
+
+	case "img":
+		if m := htmlAlt.FindStringSubmatch(attrs); m != nil {
+			p.text(strings.Trim(m[1], `"'`))
+		}
+	}
+}
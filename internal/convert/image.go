@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package convert
+
+import (
+	"blackoutbox/internal/pdf"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// maxImagePixels bounds the size of images that are decoded, so a small
+	// file can't claim enormous dimensions and exhaust memory.
+	maxImagePixels = 60_000_000
+
+	// imageDPI is the resolution small images are printed at. Larger
+	// images, such as scans, are shrunk to fit the page instead.
+	imageDPI = 96.0
+)
+
+// renderImage places an image on a single A4 page, scaled down to fit
+// within the margins.
+func renderImage(data []byte) (*pdf.Document, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("invalid image: no pixels")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, errors.New("image too large to convert")
+	}
+
+	doc := pdf.New()
+
+	var img *pdf.Image
+	if format == "jpeg" {
+		img, err = doc.AddJPEG(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid image: %w", err)
+		}
+		img = doc.AddImage(decoded)
+	}
+
+	maxWidth := pdf.PageWidth - 2*margin
+	maxHeight := pdf.PageHeight - 2*margin
+
+	scale := 72 / imageDPI
+	scale = min(scale, maxWidth/float64(img.Width), maxHeight/float64(img.Height))
+
+	w := float64(img.Width) * scale
+	h := float64(img.Height) * scale
+
+	// Centre horizontally and keep the image at the top of the page.
+	page := doc.AddPage()
+	page.Image(img, (pdf.PageWidth-w)/2, pdf.PageHeight-margin-h, w, h)
+
+	return doc, nil
+}
@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package convert
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBullet    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdNumbered  = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	mdRule      = regexp.MustCompile(`^\s{0,3}(-(\s*-){2,}|\*(\s*\*){2,}|_(\s*_){2,})\s*$`)
+	mdSetext    = regexp.MustCompile(`^\s{0,3}(=+|-+)\s*$`)
+	mdImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdStrong    = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdEmphasis  = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	mdCodeSpan  = regexp.MustCompile("`([^`]+)`")
+	mdEscape    = regexp.MustCompile(`\\([!-/:-@\[-` + "`" + `{-~])`)
+	mdTableRule = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// markdownBlocks parses the common parts of Markdown: headings, paragraphs,
+// lists, block quotes, code blocks, rules and tables. Inline formatting is
+// dropped, and links keep their address in brackets so it can be typed in.
+func markdownBlocks(text string) []block {
+	var blocks []block
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, block{kind: blockParagraph, text: inline(strings.Join(paragraph, " "))})
+			paragraph = nil
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		// Lines following a list item continue it, until a blank line.
+		inList := len(blocks) > 0 && blocks[len(blocks)-1].kind == blockListItem && i > 0 && strings.TrimSpace(lines[i-1]) != ""
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, block{kind: blockCode, text: strings.Join(code, "\n")})
+
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			blocks = append(blocks, block{kind: blockHeading, level: len(m[1]), text: inline(m[2])})
+
+		case len(paragraph) > 0 && mdSetext.MatchString(line):
+			level := 1
+			if strings.HasPrefix(trimmed, "-") {
+				level = 2
+			}
+			blocks = append(blocks, block{kind: blockHeading, level: level, text: inline(strings.Join(paragraph, " "))})
+			paragraph = nil
+
+		case mdRule.MatchString(line):
+			flush()
+			blocks = append(blocks, block{kind: blockRule})
+
+		case mdBullet.MatchString(line):
+			flush()
+			m := mdBullet.FindStringSubmatch(line)
+			blocks = append(blocks, block{kind: blockListItem, level: depth(m[1]), marker: "•", text: inline(m[2])})
+
+		case mdNumbered.MatchString(line):
+			flush()
+			m := mdNumbered.FindStringSubmatch(line)
+			blocks = append(blocks, block{kind: blockListItem, level: depth(m[1]), marker: m[2] + ".", text: inline(m[3])})
+
+		case inList && len(paragraph) == 0 && !mdBullet.MatchString(line) && !mdNumbered.MatchString(line):
+			blocks[len(blocks)-1].text += " " + inline(trimmed)
+
+		case len(paragraph) == 0 && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+			}
+			i--
+			blocks = append(blocks, block{kind: blockCode, text: strings.TrimRight(strings.Join(code, "\n"), "\n")})
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			i--
+			blocks = append(blocks, block{kind: blockQuote, text: inline(strings.Join(quote, " "))})
+
+		case strings.HasPrefix(trimmed, "|"):
+			flush()
+			if !mdTableRule.MatchString(trimmed) {
+				cells := strings.Split(strings.Trim(trimmed, "|"), "|")
+				for j := range cells {
+					cells[j] = inline(strings.TrimSpace(cells[j]))
+				}
+				blocks = append(blocks, block{kind: blockParagraph, text: strings.Join(cells, "  ·  ")})
+			}
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+
+	return blocks
+}
+
+// depth returns the list nesting level for the indentation before a marker.
+func depth(indent string) int {
+	indent = strings.ReplaceAll(indent, "\t", "    ")
+	return min(len(indent)/2, 4)
+}
+
+// inline strips inline Markdown formatting.
+func inline(text string) string {
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdLink.FindStringSubmatch(s)
+		if m[1] == m[2] {
+			return m[1]
+		}
+		return m[1] + " (" + m[2] + ")"
+	})
+	text = mdCodeSpan.ReplaceAllString(text, "$1")
+	text = mdStrong.ReplaceAllString(text, "$2")
+	text = mdEmphasis.ReplaceAllString(text, "$1$2")
+	return mdEscape.ReplaceAllString(text, "$1")
+}
@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package convert
+
+import (
+	"blackoutbox/internal/pdf"
+	"strings"
+)
+
+const (
+	margin      = 56.0
+	bodySize    = 10.5
+	bodyLeading = 14.0
+	codeSize    = 9.0
+	codeLeading = 11.5
+	listIndent  = 16.0
+	tabWidth    = 8
+)
+
+var headingSizes = []float64{20, 16, 13.5, 12, 11, 10.5}
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockListItem
+	blockQuote
+	blockCode
+	blockRule
+)
+
+// block is a unit of text laid out on its own lines. Level is the heading
+// level for headings and the nesting depth for list items.
+type block struct {
+	kind   blockKind
+	level  int
+	marker string
+	text   string
+}
+
+// typesetter flows blocks down A4 pages, starting a new page whenever the
+// next line doesn't fit.
+type typesetter struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+}
+
+func typeset(blocks []block) *pdf.Document {
+	t := &typesetter{doc: pdf.New()}
+	t.newPage()
+
+	for i, b := range blocks {
+		if i > 0 {
+			t.space(b)
+		}
+		t.block(b)
+	}
+
+	return t.doc
+}
+
+func (t *typesetter) newPage() {
+	t.page = t.doc.AddPage()
+	t.y = pdf.PageHeight - margin
+}
+
+// line reserves height for the next line and returns its baseline.
+func (t *typesetter) line(height float64) float64 {
+	if t.y-height < margin {
+		t.newPage()
+	}
+	t.y -= height
+	return t.y
+}
+
+// space adds the gap that goes before a block.
+func (t *typesetter) space(b block) {
+	gap := bodyLeading * 0.6
+	switch b.kind {
+	case blockHeading:
+		gap = bodyLeading
+	case blockListItem:
+		gap = 2
+	}
+
+	if t.y-gap > margin {
+		t.y -= gap
+	}
+}
+
+func (t *typesetter) block(b block) {
+	width := pdf.PageWidth - 2*margin
+
+	switch b.kind {
+	case blockHeading:
+		level := min(max(b.level, 1), len(headingSizes))
+		size := headingSizes[level-1]
+		for _, line := range pdf.WrapText(pdf.HelveticaBold, size, b.text, width) {
+			t.page.Text(margin, t.line(size*1.3), pdf.HelveticaBold, size, line)
+		}
+	case blockListItem:
+		indent := margin + float64(b.level)*listIndent
+		lines := pdf.WrapText(pdf.Helvetica, bodySize, b.text, width-(indent-margin)-listIndent)
+		for i, line := range lines {
+			y := t.line(bodyLeading)
+			if i == 0 {
+				t.page.Text(indent, y, pdf.Helvetica, bodySize, b.marker)
+			}
+			t.page.Text(indent+listIndent, y, pdf.Helvetica, bodySize, line)
+		}
+	case blockQuote:
+		for _, line := range pdf.WrapText(pdf.Helvetica, bodySize, b.text, width-listIndent) {
+			y := t.line(bodyLeading)
+			t.page.Line(margin+4, y-3, margin+4, y+bodyLeading-3, 1.5)
+			t.page.Text(margin+listIndent, y, pdf.Helvetica, bodySize, line)
+		}
+	case blockCode:
+		for _, line := range hardWrap(b.text, int(width/(codeSize*0.6))) {
+			t.page.Text(margin, t.line(codeLeading), pdf.Courier, codeSize, line)
+		}
+	case blockRule:
+		y := t.line(bodyLeading)
+		t.page.Line(margin, y+bodyLeading/2, pdf.PageWidth-margin, y+bodyLeading/2, 0.5)
+	default:
+		for _, line := range pdf.WrapText(pdf.Helvetica, bodySize, b.text, width) {
+			t.page.Text(margin, t.line(bodyLeading), pdf.Helvetica, bodySize, line)
+		}
+	}
+}
+
+// hardWrap breaks preformatted text into lines of at most width characters,
+// keeping its spacing and expanding tabs.
+func hardWrap(text string, width int) []string {
+	var lines []string
+
+	for line := range strings.SplitSeq(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		var current []rune
+		for _, r := range strings.TrimRight(line, " \t\r") {
+			if r == '\t' {
+				for {
+					current = append(current, ' ')
+					if len(current)%tabWidth == 0 {
+						break
+					}
+				}
+			} else {
+				current = append(current, r)
+			}
+
+			for len(current) > width {
+				lines = append(lines, string(current[:width]))
+				current = current[width:]
+			}
+		}
+		lines = append(lines, string(current))
+	}
+
+	return lines
+}
+
+// textBlocks lays plain text out as it is, in a fixed width font.
+func textBlocks(text string) []block {
+	return []block{{kind: blockCode, text: strings.TrimRight(text, "\n")}}
+}
@@ -0,0 +1,309 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package cron parses standard five field cron expressions and works out
+// when they next fire in a given time zone.
+//
+// Expressions are evaluated against the wall clock of the time zone, so
+// "0 7 * * *" fires at 07:00 local time all year round. Around daylight
+// saving transitions:
+//
+//   - a time skipped when the clock jumps forward fires at the moment of
+//     the jump, so the run still happens that day
+//   - a time that occurs twice when the clock falls back fires only on its
+//     first occurrence
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embed the time zone database, so schedules work on hosts without one.
+	_ "time/tzdata"
+)
+
+// maxSearchDays bounds the search for the next run, so expressions that
+// can never fire, such as "0 0 30 2 *", don't loop forever.
+const maxSearchDays = 366 * 5
+
+var ErrNeverRuns = errors.New("cron expression never runs")
+
+type Schedule struct {
+	minutes  []int
+	hours    []int
+	days     []int
+	months   []int
+	weekdays []int
+
+	// Standard cron matches either field when both day of month and day
+	// of week are restricted.
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+type field struct {
+	name   string
+	min    int
+	max    int
+	labels []string
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, labels: []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, labels: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression of the form "minute hour day-of-month
+// month day-of-week". Fields accept *, lists, ranges, steps, and month and
+// weekday names. Sunday is 0 or 7.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(parts))
+	}
+
+	values := make([][]int, len(fields))
+	for i, part := range parts {
+		parsed, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = parsed
+	}
+
+	schedule := &Schedule{
+		minutes:            values[0],
+		hours:              values[1],
+		days:               values[2],
+		months:             values[3],
+		weekdays:           normalizeWeekdays(values[4]),
+		daysRestricted:     parts[2] != "*",
+		weekdaysRestricted: parts[4] != "*",
+	}
+
+	if _, err := schedule.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.UTC); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+func parseField(part string, f field) ([]int, error) {
+	var values []int
+
+	for item := range strings.SplitSeq(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("invalid step %q in %s field", stepPart, f.name)
+			}
+			step = parsed
+		}
+
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			if start, err = parseValue(from, f); err != nil {
+				return nil, err
+			}
+
+			end = start
+			if isRange {
+				if end, err = parseValue(to, f); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				end = f.max
+			}
+
+			if end < start {
+				return nil, fmt.Errorf("invalid range %q in %s field", rangePart, f.name)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			values = append(values, v)
+		}
+	}
+
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	if i := slices.Index(f.labels, strings.ToLower(s)); s != "" && i >= 0 {
+		return i, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be %d-%d", s, f.name, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// normalizeWeekdays folds 7 into 0, both meaning Sunday.
+func normalizeWeekdays(weekdays []int) []int {
+	for i, weekday := range weekdays {
+		weekdays[i] = weekday % 7
+	}
+	slices.Sort(weekdays)
+	return slices.Compact(weekdays)
+}
+
+func (s *Schedule) matchesDay(day time.Time) bool {
+	if !slices.Contains(s.months, int(day.Month())) {
+		return false
+	}
+
+	dayMatches := slices.Contains(s.days, day.Day())
+	weekdayMatches := slices.Contains(s.weekdays, int(day.Weekday()))
+
+	if s.daysRestricted && s.weekdaysRestricted {
+		return dayMatches || weekdayMatches
+	}
+	return dayMatches && weekdayMatches
+}
+
+// Next returns the first time after the given time at which the schedule
+// fires, evaluated against the wall clock in loc.
+func (s *Schedule) Next(after time.Time, loc *time.Location) (time.Time, error) {
+	local := after.In(loc)
+	year, month, day := local.Date()
+
+	for i := range maxSearchDays {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, time.UTC)
+		if !s.matchesDay(date) {
+			continue
+		}
+
+		var next time.Time
+		for _, hour := range s.hours {
+			for _, minute := range s.minutes {
+				run := resolve(date.Year(), date.Month(), date.Day(), hour, minute, loc)
+				if run.After(after) && (next.IsZero() || run.Before(next)) {
+					next = run
+				}
+			}
+		}
+
+		if !next.IsZero() {
+			return next, nil
+		}
+	}
+
+	return time.Time{}, ErrNeverRuns
+}
+
+// Upcoming returns the next count times the schedule fires after the given time.
+func (s *Schedule) Upcoming(after time.Time, loc *time.Location, count int) ([]time.Time, error) {
+	runs := make([]time.Time, 0, count)
+
+	for range count {
+		next, err := s.Next(after, loc)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, next)
+		after = next
+	}
+
+	return runs, nil
+}
+
+// resolve turns a wall clock time in loc into an instant. Ambiguous times
+// resolve to their first occurrence, and skipped times to the moment the
+// clock jumps past them.
+func resolve(year int, month time.Month, day, hour, minute int, loc *time.Location) time.Time {
+	wall := time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+
+	// The offsets in effect a day either side cover any transition.
+	_, offsetBefore := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, offsetAfter := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	var first time.Time
+	for _, offset := range []int{offsetBefore, offsetAfter} {
+		candidate := wall.Add(-time.Duration(offset) * time.Second)
+		if sameWallClock(candidate.In(loc), wall) && (first.IsZero() || candidate.Before(first)) {
+			first = candidate
+		}
+	}
+
+	if !first.IsZero() {
+		return first
+	}
+
+	// The time falls in a gap. Find the first instant after the gap by
+	// searching between the two readings of the wall clock.
+	lo := wall.Add(-time.Duration(offsetAfter) * time.Second)
+	hi := wall.Add(-time.Duration(offsetBefore) * time.Second)
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if _, offset := mid.In(loc).Zone(); offset == offsetAfter {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return hi.Truncate(time.Second)
+}
+
+func sameWallClock(t time.Time, wall time.Time) bool {
+	return t.Year() == wall.Year() && t.YearDay() == wall.YearDay() &&
+		t.Hour() == wall.Hour() && t.Minute() == wall.Minute()
+}
+
+// Load parses expr and loads the IANA time zone it should run in.
+func Load(expr, timeZone string) (*Schedule, *time.Location, error) {
+	schedule, err := Parse(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil || timeZone == "" || timeZone == "Local" {
+		return nil, nil, fmt.Errorf("unknown time zone %q", timeZone)
+	}
+
+	return schedule, loc, nil
+}
+
+// NextRun returns when expr next fires after the given time in timeZone.
+func NextRun(expr, timeZone string, after time.Time) (time.Time, error) {
+	schedule, loc, err := Load(expr, timeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return schedule.Next(after, loc)
+}
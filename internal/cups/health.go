@@ -0,0 +1,183 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cups
+
+import (
+	"blackoutbox/internal/models"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	printerStatusRetention = 7 * 24 * time.Hour
+	defaultMarkerLowLevel  = 10
+)
+
+// notReadyReasons are printer-state-reasons that stop documents from
+// coming out, whatever severity suffix the printer reports them with.
+var notReadyReasons = []string{
+	"media-empty",
+	"media-jam",
+	"media-needed",
+	"toner-empty",
+	"marker-supply-empty",
+	"door-open",
+	"cover-open",
+	"input-tray-missing",
+	"output-area-full",
+	"offline",
+	"paused",
+	"shutdown",
+	"stopped",
+	"connecting-to-device",
+}
+
+// cupsPrinterStates maps the IPP printer-state enum onto our printer states.
+var cupsPrinterStates = map[int]string{
+	3: models.PrinterIdle,
+	4: models.PrinterProcessing,
+	5: models.PrinterStopped,
+}
+
+// CheckPrinterStatus asks CUPS for the state of the default printer.
+func (p *Printer) CheckPrinterStatus() (models.PrinterStatus, error) {
+	status := models.PrinterStatus{CheckedAt: time.Now().Unix()}
+
+	resp, err := newIppRequest(ippOpCupsGetDefault).
+		add(ippTagKeyword, "requested-attributes",
+			"printer-name",
+			"printer-state",
+			"printer-state-reasons",
+			"printer-is-accepting-jobs",
+			"marker-names",
+			"marker-types",
+			"marker-levels",
+			"marker-low-levels",
+		).
+		do("/")
+	if err != nil {
+		return status, err
+	}
+
+	status.PrinterName = resp.string(ippTagPrinter, "printer-name")
+
+	state, _ := resp.int(ippTagPrinter, "printer-state")
+	status.State = cupsPrinterStates[state]
+	if status.State == "" {
+		return status, fmt.Errorf("unknown printer-state %d", state)
+	}
+
+	for _, reason := range resp.strings(ippTagPrinter, "printer-state-reasons") {
+		if reason != "none" {
+			status.StateReasons = append(status.StateReasons, reason)
+		}
+	}
+
+	names := resp.strings(ippTagPrinter, "marker-names")
+	types := resp.strings(ippTagPrinter, "marker-types")
+	levels := resp.ints(ippTagPrinter, "marker-levels")
+	lowLevels := resp.ints(ippTagPrinter, "marker-low-levels")
+
+	for i, name := range names {
+		marker := models.PrinterMarker{Name: name, Level: -1, LowLevel: defaultMarkerLowLevel}
+		if i < len(types) {
+			marker.Type = types[i]
+		}
+		if i < len(levels) {
+			marker.Level = levels[i]
+		}
+		if i < len(lowLevels) && lowLevels[i] > 0 {
+			marker.LowLevel = lowLevels[i]
+		}
+		status.Markers = append(status.Markers, marker)
+	}
+
+	accepting := true
+	if values := resp.groups[ippTagPrinter]["printer-is-accepting-jobs"]; len(values) > 0 {
+		accepting, _ = values[0].(bool)
+	}
+
+	EvaluateReadiness(&status, accepting)
+	return status, nil
+}
+
+// EvaluateReadiness decides whether the printer can print right now and
+// collects anything an operator should look at before an outage.
+func EvaluateReadiness(status *models.PrinterStatus, accepting bool) {
+	status.Ready = status.State != models.PrinterStopped && accepting
+
+	if status.State == models.PrinterStopped {
+		status.Warnings = append(status.Warnings, "printer is stopped")
+	}
+
+	if !accepting {
+		status.Warnings = append(status.Warnings, "printer is not accepting jobs")
+	}
+
+	for _, reason := range status.StateReasons {
+		keyword, severity := SplitStateReason(reason)
+
+		switch {
+		case slices.Contains(notReadyReasons, keyword) || severity == "error":
+			status.Ready = false
+			status.Warnings = append(status.Warnings, reason)
+		case severity == "warning":
+			status.Warnings = append(status.Warnings, reason)
+		}
+	}
+
+	for _, marker := range status.Markers {
+		switch {
+		case marker.Level == 0:
+			status.Ready = false
+			status.Warnings = append(status.Warnings, fmt.Sprintf("%s is empty", marker.Name))
+		case marker.Level > 0 && marker.Level <= marker.LowLevel:
+			status.Warnings = append(status.Warnings, fmt.Sprintf("%s is low (%d%%)", marker.Name, marker.Level))
+		}
+	}
+}
+
+// SplitStateReason splits a printer-state-reasons keyword such as
+// "media-empty-error" into its reason and severity.
+func SplitStateReason(reason string) (string, string) {
+	for _, severity := range []string{"error", "warning", "report"} {
+		if keyword, found := strings.CutSuffix(reason, "-"+severity); found {
+			return keyword, severity
+		}
+	}
+
+	return reason, ""
+}
+
+// CheckPrinterHealth records the current printer status and logs a warning
+// whenever the printer is not ready, so problems surface before an outage.
+func (p *Printer) CheckPrinterHealth() error {
+	status, err := p.CheckPrinterStatus()
+	if err != nil {
+		status.State = models.PrinterUnreachable
+		status.Ready = false
+		status.Warnings = []string{fmt.Sprintf("printer unreachable: %v", err)}
+	}
+
+	if !status.Ready {
+		log.Printf("WARNING: printer not ready (%s): %s", status.State, strings.Join(status.Warnings, ", "))
+	} else if len(status.Warnings) > 0 {
+		log.Printf("Printer warnings: %s", strings.Join(status.Warnings, ", "))
+	}
+
+	if err := p.printerStatusStore.Add(status); err != nil {
+		return fmt.Errorf("failed to record printer status: %w", err)
+	}
+
+	cutoff := time.Now().Add(-printerStatusRetention).Unix()
+	if err := p.printerStatusStore.DeleteOlderThan(cutoff); err != nil {
+		return fmt.Errorf("failed to prune printer status history: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,296 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cups
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Minimal IPP/2.0 client (RFC 8010/8011) for talking to the local CUPS
+// scheduler. Only the operations and value types we need are supported.
+
+const (
+	cupsServer = "http://localhost:631"
+
+	ippOpCancelJob            = 0x0008
+	ippOpGetJobAttributes     = 0x0009
+	ippOpGetJobs              = 0x000A
+	ippOpGetPrinterAttributes = 0x000B
+	ippOpCupsGetDefault       = 0x4001
+
+	ippTagOperation = 0x01
+	ippTagJob       = 0x02
+	ippTagEnd       = 0x03
+	ippTagPrinter   = 0x04
+
+	ippTagInteger  = 0x21
+	ippTagBoolean  = 0x22
+	ippTagEnum     = 0x23
+	ippTagText     = 0x41
+	ippTagName     = 0x42
+	ippTagKeyword  = 0x44
+	ippTagURI      = 0x45
+	ippTagCharset  = 0x47
+	ippTagLanguage = 0x48
+
+	ippStatusNotFound = 0x0406
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+var ippRequestId atomic.Uint32
+
+type ippAttribute struct {
+	tag    byte
+	name   string
+	values []any
+}
+
+type ippRequest struct {
+	operation  uint16
+	attributes []ippAttribute
+}
+
+type ippResponse struct {
+	status uint16
+	groups map[byte]map[string][]any
+
+	// objects keeps each repeated group, such as one per job, separately.
+	objects map[byte][]map[string][]any
+}
+
+func newIppRequest(operation uint16) *ippRequest {
+	return &ippRequest{
+		operation: operation,
+		attributes: []ippAttribute{
+			{tag: ippTagCharset, name: "attributes-charset", values: []any{"utf-8"}},
+			{tag: ippTagLanguage, name: "attributes-natural-language", values: []any{"en"}},
+		},
+	}
+}
+
+func (r *ippRequest) add(tag byte, name string, values ...any) *ippRequest {
+	r.attributes = append(r.attributes, ippAttribute{tag: tag, name: name, values: values})
+	return r
+}
+
+func (r *ippRequest) encode() []byte {
+	var buf bytes.Buffer
+
+	buf.Write([]byte{0x02, 0x00})
+	binary.Write(&buf, binary.BigEndian, r.operation)
+	binary.Write(&buf, binary.BigEndian, ippRequestId.Add(1))
+	buf.WriteByte(ippTagOperation)
+
+	for _, attr := range r.attributes {
+		for i, value := range attr.values {
+			name := attr.name
+			if i > 0 {
+				name = ""
+			}
+
+			buf.WriteByte(attr.tag)
+			binary.Write(&buf, binary.BigEndian, uint16(len(name)))
+			buf.WriteString(name)
+
+			switch v := value.(type) {
+			case int:
+				binary.Write(&buf, binary.BigEndian, uint16(4))
+				binary.Write(&buf, binary.BigEndian, int32(v))
+			case bool:
+				binary.Write(&buf, binary.BigEndian, uint16(1))
+				if v {
+					buf.WriteByte(1)
+				} else {
+					buf.WriteByte(0)
+				}
+			case string:
+				binary.Write(&buf, binary.BigEndian, uint16(len(v)))
+				buf.WriteString(v)
+			}
+		}
+	}
+
+	buf.WriteByte(ippTagEnd)
+	return buf.Bytes()
+}
+
+func decodeIppResponse(data []byte) (*ippResponse, error) {
+	r := bytes.NewReader(data)
+
+	var header struct {
+		Version   uint16
+		Status    uint16
+		RequestId uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("read ipp header: %w", err)
+	}
+
+	resp := &ippResponse{
+		status:  header.Status,
+		groups:  map[byte]map[string][]any{},
+		objects: map[byte][]map[string][]any{},
+	}
+
+	var group, object map[string][]any
+	var lastName string
+
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read ipp tag: %w", err)
+		}
+
+		if tag == ippTagEnd {
+			return resp, nil
+		}
+
+		if tag < 0x10 {
+			// Repeated groups (e.g. several jobs) are merged in groups, which
+			// suits requests about a single object, and kept apart in objects.
+			if resp.groups[tag] == nil {
+				resp.groups[tag] = map[string][]any{}
+			}
+			group = resp.groups[tag]
+			object = map[string][]any{}
+			resp.objects[tag] = append(resp.objects[tag], object)
+			continue
+		}
+
+		name, err := readIppString(r)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := readIppString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			name = lastName
+		}
+		lastName = name
+
+		if group == nil {
+			return nil, errors.New("ipp attribute outside of group")
+		}
+
+		value := decodeIppValue(tag, []byte(raw))
+		group[name] = append(group[name], value)
+		object[name] = append(object[name], value)
+	}
+}
+
+func readIppString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", fmt.Errorf("read ipp length: %w", err)
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", fmt.Errorf("read ipp value: %w", err)
+	}
+
+	return string(value), nil
+}
+
+func decodeIppValue(tag byte, raw []byte) any {
+	switch tag {
+	case ippTagInteger, ippTagEnum:
+		if len(raw) == 4 {
+			return int(int32(binary.BigEndian.Uint32(raw)))
+		}
+	case ippTagBoolean:
+		if len(raw) == 1 {
+			return raw[0] != 0
+		}
+	}
+
+	return string(raw)
+}
+
+// do posts an IPP request to the given CUPS resource path.
+func (r *ippRequest) do(resource string) (*ippResponse, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	httpResp, err := client.Post(cupsServer+resource, "application/ipp", bytes.NewReader(r.encode()))
+	if err != nil {
+		return nil, fmt.Errorf("ipp request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ipp request failed: %s", httpResp.Status)
+	}
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read ipp response: %w", err)
+	}
+
+	resp, err := decodeIppResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status == ippStatusNotFound {
+		return resp, ErrJobNotFound
+	}
+
+	if resp.status > 0x00FF {
+		return resp, fmt.Errorf("ipp status 0x%04x: %s", resp.status, resp.string(ippTagOperation, "status-message"))
+	}
+
+	return resp, nil
+}
+
+func (r *ippResponse) string(group byte, name string) string {
+	values := r.groups[group][name]
+	if len(values) == 0 {
+		return ""
+	}
+
+	s, _ := values[0].(string)
+	return s
+}
+
+func (r *ippResponse) strings(group byte, name string) []string {
+	var out []string
+	for _, value := range r.groups[group][name] {
+		if s, ok := value.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (r *ippResponse) int(group byte, name string) (int, bool) {
+	values := r.groups[group][name]
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	i, ok := values[0].(int)
+	return i, ok
+}
+
+func (r *ippResponse) ints(group byte, name string) []int {
+	var out []int
+	for _, value := range r.groups[group][name] {
+		if i, ok := value.(int); ok {
+			out = append(out, i)
+		}
+	}
+	return out
+}
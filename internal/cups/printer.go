@@ -6,130 +6,435 @@ package cups
 
 import (
 	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
 	"blackoutbox/internal/validation"
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
+	"os/user"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// requestingUserName is the user lp submits jobs as, which CUPS requires
+// to match when canceling them.
+func requestingUserName() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return "blackoutbox"
+}
+
+const (
+	// A job lp fails to take stays queued and is tried again, waiting
+	// twice as long after each failure up to maxDispatchRetryDelay. lp
+	// fails while CUPS is down, which is to be expected for a while
+	// during a power incident, so jobs are never given up on for it.
+	minDispatchRetryDelay = 30 * time.Second
+	maxDispatchRetryDelay = 5 * time.Minute
+
+	stampTimeLayout = "2006-01-02 15:04"
+)
+
 type Printer struct {
-	printJobStore stores.PrintJobStoreInterface
+	printJobStore       stores.PrintJobStoreInterface
+	printerStatusStore  stores.PrinterStatusStoreInterface
+	printedVersionStore stores.PrintedVersionStoreInterface
+
+	// dispatchMu ensures a queued job is only ever submitted by one caller,
+	// and isn't canceled while it is being submitted.
+	dispatchMu sync.Mutex
+
+	// queued is signaled when jobs are added to the outbox, so the worker
+	// dispatches them without keeping the caller waiting on lp.
+	queued chan struct{}
 }
 
-func NewPrinter(printJobStore stores.PrintJobStoreInterface) *Printer {
+func NewPrinter(
+	printJobStore stores.PrintJobStoreInterface,
+	printerStatusStore stores.PrinterStatusStoreInterface,
+	printedVersionStore stores.PrintedVersionStoreInterface,
+) *Printer {
 	return &Printer{
-		printJobStore: printJobStore,
+		printJobStore:       printJobStore,
+		printerStatusStore:  printerStatusStore,
+		printedVersionStore: printedVersionStore,
+		queued:              make(chan struct{}, 1),
 	}
 }
 
-func (p *Printer) CreatePrintJob(documentId int64, filePath string) error {
-	jobId, err := p.submitPrint(filePath)
-	if err != nil {
-		p.recordFailedJob(documentId, err.Error())
-		return fmt.Errorf("failed to submit print job: %w", err)
+// Queued is signaled whenever jobs have been added to the outbox and are
+// waiting for DispatchQueued.
+func (p *Printer) Queued() <-chan struct{} {
+	return p.queued
+}
+
+// signalQueued wakes the dispatcher. A signal that is already pending
+// covers the new jobs too.
+func (p *Printer) signalQueued() {
+	select {
+	case p.queued <- struct{}{}:
+	default:
+	}
+}
+
+// queuedJob turns a print request into a job waiting in the outbox.
+func queuedJob(req models.PrintRequest) models.PrintJob {
+	job := models.PrintJob{
+		Source:          req.Source,
+		DocumentId:      req.DocumentId,
+		TemplateId:      req.TemplateId,
+		TemplateVersion: req.TemplateVersion,
+		StockWard:       req.StockWard,
+		FilePath:        req.FilePath,
+		Status:          models.PrintJobQueued,
+		Options:         &req.Options,
+		Stamp:           req.Stamp,
+		Origin:          req.Origin,
+		ParentJobId:     req.ParentJobId,
+	}
+
+	if req.Binder != "" {
+		job.Binder = &req.Binder
+		job.DocumentUpdatedAt = req.DocumentUpdatedAt
+		if req.ContentHash != "" {
+			job.ContentHash = &req.ContentHash
+		}
 	}
 
-	return p.recordSuccessfulJob(documentId, jobId)
+	return job
 }
 
-func (p *Printer) submitPrint(filePath string) (string, error) {
-	if err := validation.ValidatePrintableFile(filePath); err != nil {
-		return "", fmt.Errorf("refusing to print invalid file: %w", err)
+// PrintBatch queues every request of a batch in one transaction for the
+// worker to dispatch. Once queued, the jobs are printed even if the process
+// stops before dispatching them. A batch whose key was queued before is
+// ignored, and false is returned.
+func (p *Printer) PrintBatch(batch models.PrintBatch, reqs []models.PrintRequest) (bool, error) {
+	jobs := make([]models.PrintJob, len(reqs))
+	for i, req := range reqs {
+		jobs[i] = queuedJob(req)
 	}
 
-	cmd := exec.Command("lp", filePath)
-	output, err := cmd.CombinedOutput()
+	queued, err := p.printJobStore.AddBatch(batch, jobs)
 	if err != nil {
-		return "", fmt.Errorf("lp command failed: %w, output: %s", err, string(output))
+		return false, fmt.Errorf("failed to queue print batch: %w", err)
 	}
 
-	jobId := p.parseJobId(string(output))
-	if jobId == "" {
-		return "", fmt.Errorf("could not parse job id from lp output: %s", string(output))
+	if !queued {
+		return false, nil
 	}
 
-	log.Printf("Submitted print job %s for file: %s", jobId, filePath)
-	return jobId, nil
+	p.signalQueued()
+	return true, nil
 }
 
-func (p *Printer) parseJobId(output string) string {
-	re := regexp.MustCompile(`request id is \S+-(\d+)`)
-	matches := re.FindStringSubmatch(output)
-	if len(matches) < 2 {
-		return ""
+// CreatePrintJob queues a single file for printing and returns the id of
+// the recorded job. The worker dispatches it, so the caller isn't kept
+// waiting on lp or on jobs queued before it.
+func (p *Printer) CreatePrintJob(req models.PrintRequest) (int64, error) {
+	id, err := p.printJobStore.Add(queuedJob(req))
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue print job: %w", err)
 	}
-	return matches[1]
+
+	p.signalQueued()
+	return id, nil
 }
 
-func (p *Printer) recordSuccessfulJob(documentId int64, cupsJobId string) error {
-	now := time.Now().Unix()
+// DispatchQueued submits every queued job to CUPS, oldest first. It is
+// safe to call at any time, including after a crash: a job whose previous
+// dispatch may have reached CUPS is looked up there before being submitted
+// again, so nothing prints twice.
+func (p *Printer) DispatchQueued() error {
+	p.dispatchMu.Lock()
+	defer p.dispatchMu.Unlock()
 
-	job := models.PrintJob{
-		DocumentId:  documentId,
-		CupsJobId:   &cupsJobId,
-		Status:      "printing",
-		SubmittedAt: now,
+	jobs, err := p.printJobStore.GetQueued()
+	if err != nil {
+		return fmt.Errorf("failed to get queued jobs: %w", err)
 	}
 
-	if err := p.printJobStore.Add(job); err != nil {
-		return fmt.Errorf("failed to record print job: %w", err)
+	for _, job := range jobs {
+		if err := p.dispatch(job); err != nil {
+			log.Printf("Failed to dispatch print job %d: %v", job.Id, err)
+		}
 	}
 
 	return nil
 }
 
-func (p *Printer) recordFailedJob(documentId int64, errorMessage string) error {
+func (p *Printer) dispatch(job models.PrintJob) error {
+	if job.NextDispatchAt != nil && *job.NextDispatchAt > time.Now().Unix() {
+		return nil
+	}
+
+	if job.DispatchStartedAt != nil {
+		// The last attempt may have reached CUPS before we could record it.
+		cupsJobId, err := p.findCupsJob(job)
+		if err != nil {
+			return fmt.Errorf("failed to look up earlier attempt in CUPS: %w", err)
+		}
+		if cupsJobId != "" {
+			log.Printf("Print job %d was already submitted as CUPS job %s", job.Id, cupsJobId)
+			return p.markSubmitted(job, cupsJobId)
+		}
+	}
+
+	if (job.Binder != nil || job.Stamp != nil) && job.ContentHash == nil {
+		hash, err := storage.HashFile(job.FilePath)
+		if err != nil {
+			return p.abort(job, fmt.Sprintf("failed to hash file: %v", err))
+		}
+		job.ContentHash = &hash
+	}
+
+	var options models.PrintOptions
+	if job.Options != nil {
+		options = *job.Options
+	}
+
+	if err := validation.ValidatePrintOptions(options); err != nil {
+		return p.abort(job, fmt.Sprintf("invalid print options: %v", err))
+	}
+
+	if err := validation.ValidatePrintableFile(job.FilePath); err != nil {
+		return p.abort(job, fmt.Sprintf("refusing to print invalid file: %v", err))
+	}
+
 	now := time.Now().Unix()
+	job.DispatchStartedAt = &now
+	job.DispatchAttempts++
+	if err := p.printJobStore.Update(job); err != nil {
+		return fmt.Errorf("failed to record dispatch: %w", err)
+	}
 
-	job := models.PrintJob{
-		DocumentId:   documentId,
-		Status:       "failed",
-		SubmittedAt:  now,
-		ErrorMessage: &errorMessage,
+	// The file is decrypted into memory and handed to lp on its standard
+	// input, so no plain text copy is written to disk.
+	data, err := storage.ReadFile(job.FilePath)
+	if err != nil {
+		return p.abort(job, fmt.Sprintf("failed to read file: %v", err))
+	}
+
+	if job.Stamp != nil {
+		stamped, err := stampFile(job, data, now)
+		if err != nil {
+			// An unstamped copy is better than none during an outage.
+			log.Printf("Failed to stamp print job %d, printing it unstamped: %v", job.Id, err)
+		} else {
+			data = stamped
+		}
+	}
+
+	output, err := runLp(append(lpOptions(options), "-t", cupsJobName(job)), data)
+	if err != nil {
+		// lp failed, so nothing reached CUPS and the next attempt can
+		// submit without looking for this one.
+		message := err.Error()
+		next := time.Now().Add(dispatchRetryDelay(job.DispatchAttempts)).Unix()
+		job.DispatchStartedAt = nil
+		job.NextDispatchAt = &next
+		job.ErrorMessage = &message
+		if err := p.printJobStore.Update(job); err != nil {
+			return fmt.Errorf("failed to record dispatch failure: %w", err)
+		}
+		return err
+	}
+
+	cupsJobId := p.parseJobId(output)
+	if cupsJobId == "" {
+		// The job may well be in CUPS; the next attempt will find it by name.
+		return fmt.Errorf("could not parse job id from lp output: %s", output)
+	}
+
+	log.Printf("Submitted print job %s for file: %s", cupsJobId, job.FilePath)
+	return p.markSubmitted(job, cupsJobId)
+}
+
+// dispatchRetryDelay is how long to wait before trying a job again after
+// its attempts-th attempt failed.
+func dispatchRetryDelay(attempts int) time.Duration {
+	delay := minDispatchRetryDelay
+	for i := 1; i < attempts && delay < maxDispatchRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDispatchRetryDelay)
+}
+
+// stampFile returns a copy of the job's file with its page stamp.
+func stampFile(job models.PrintJob, data []byte, printedAt int64) ([]byte, error) {
+	version := "unknown"
+	if job.ContentHash != nil && len(*job.ContentHash) >= 12 {
+		version = (*job.ContentHash)[:12]
 	}
 
-	if err := p.printJobStore.Add(job); err != nil {
-		return fmt.Errorf("failed to record failed print job: %w", err)
+	replacer := strings.NewReplacer(
+		"{printed_at}", time.Unix(printedAt, 0).Format(stampTimeLayout),
+		"{version}", version,
+	)
+	text := func(part *string) string {
+		if part == nil {
+			return ""
+		}
+		return replacer.Replace(*part)
 	}
 
+	return pdf.StampPDF(data, pdf.Stamp{
+		Header:    text(job.Stamp.Header),
+		Footer:    text(job.Stamp.Footer),
+		Watermark: text(job.Stamp.Watermark),
+	})
+}
+
+func (p *Printer) markSubmitted(job models.PrintJob, cupsJobId string) error {
+	now := time.Now().Unix()
+	job.CupsJobId = &cupsJobId
+	job.Status = models.PrintJobSubmitted
+	job.SubmittedAt = &now
+	job.NextDispatchAt = nil
+	job.ErrorMessage = nil
+
+	if err := p.printJobStore.Update(job); err != nil {
+		return fmt.Errorf("failed to record submitted job: %w", err)
+	}
 	return nil
 }
 
-func (p *Printer) CheckJobStatus(cupsJobId string) (string, error) {
-	cmd := exec.Command("lpq")
-	output, err := cmd.CombinedOutput()
+func (p *Printer) abort(job models.PrintJob, errorMessage string) error {
+	job.Status = models.PrintJobAborted
+	job.ErrorMessage = &errorMessage
+
+	if err := p.printJobStore.Update(job); err != nil {
+		return fmt.Errorf("failed to record aborted job: %w", err)
+	}
+	return errors.New(errorMessage)
+}
+
+// cupsJobName is the job name a print job is submitted under, which lets
+// the dispatcher find it in CUPS again. The queue time keeps names unique
+// should job ids ever be reused.
+func cupsJobName(job models.PrintJob) string {
+	return fmt.Sprintf("blackoutbox-job-%d-%d", job.Id, job.QueuedAt)
+}
+
+// findCupsJob returns the CUPS job id of an earlier submission of job, or
+// an empty string if CUPS has no such job.
+func (p *Printer) findCupsJob(job models.PrintJob) (string, error) {
+	resp, err := newIppRequest(ippOpGetJobs).
+		add(ippTagURI, "printer-uri", "ipp://localhost/").
+		add(ippTagName, "requesting-user-name", requestingUserName()).
+		add(ippTagKeyword, "which-jobs", "all").
+		add(ippTagKeyword, "requested-attributes", "job-id", "job-name").
+		do("/")
+	if errors.Is(err, ErrJobNotFound) {
+		return "", nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("lpq command failed: %w, output: %s", err, string(output))
+		return "", err
 	}
 
-	return p.parseJobStatus(string(output), cupsJobId)
+	name := cupsJobName(job)
+	for _, object := range resp.objects[ippTagJob] {
+		if len(object["job-name"]) == 0 || len(object["job-id"]) == 0 || object["job-name"][0] != name {
+			continue
+		}
+		if id, ok := object["job-id"][0].(int); ok {
+			return strconv.Itoa(id), nil
+		}
+	}
+
+	return "", nil
+}
+
+// runLp runs lp with args, giving it data to print on its standard input.
+func runLp(args []string, data []byte) (string, error) {
+	cmd := exec.Command("lp", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("lp command failed: %w, output: %s", err, string(output))
+	}
+	return string(output), nil
 }
 
-func (p *Printer) parseJobStatus(output, cupsJobId string) (string, error) {
-	lines := strings.SplitSeq(output, "\n")
-	for line := range lines {
-		if strings.Contains(line, cupsJobId) {
-			fields := strings.Fields(line)
-			if len(fields) >= 4 {
-				status := strings.ToLower(fields[3])
-				if status == "active" || status == "printing" {
-					return "printing", nil
-				}
-				if status == "completed" || status == "done" {
-					return "completed", nil
-				}
-				if status == "held" || status == "error" {
-					return "failed", nil
-				}
-			}
+// lpOptions translates print options into lp command line arguments.
+func lpOptions(options models.PrintOptions) []string {
+	var args []string
+
+	if options.Copies != nil {
+		args = append(args, "-n", strconv.Itoa(*options.Copies))
+	}
+	if options.Sides != nil {
+		args = append(args, "-o", "sides="+*options.Sides)
+	}
+	if options.Media != nil {
+		args = append(args, "-o", "media="+*options.Media)
+	}
+	if options.PageRanges != nil {
+		args = append(args, "-o", "page-ranges="+*options.PageRanges)
+	}
+	if options.Orientation != nil {
+		// IPP orientation-requested: 3 = portrait, 4 = landscape
+		orientation := "3"
+		if *options.Orientation == "landscape" {
+			orientation = "4"
 		}
+		args = append(args, "-o", "orientation-requested="+orientation)
+	}
+
+	return args
+}
+
+func (p *Printer) parseJobId(output string) string {
+	re := regexp.MustCompile(`request id is \S+-(\d+)`)
+	matches := re.FindStringSubmatch(output)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+// cupsJobStates maps the IPP job-state enum onto our print job states.
+var cupsJobStates = map[int]string{
+	3: models.PrintJobSubmitted, // pending
+	4: models.PrintJobHeld,
+	5: models.PrintJobProcessing,
+	6: models.PrintJobStopped,
+	7: models.PrintJobCanceled,
+	8: models.PrintJobAborted,
+	9: models.PrintJobCompleted,
+}
+
+// CheckJobStatus asks CUPS for the current state of a job. It returns
+// ErrJobNotFound if CUPS no longer knows about the job.
+func (p *Printer) CheckJobStatus(cupsJobId string) (string, string, error) {
+	resp, err := newIppRequest(ippOpGetJobAttributes).
+		add(ippTagURI, "job-uri", "ipp://localhost/jobs/"+cupsJobId).
+		add(ippTagName, "requesting-user-name", requestingUserName()).
+		add(ippTagKeyword, "requested-attributes", "job-state", "job-state-reasons").
+		do("/jobs/")
+	if err != nil {
+		return "", "", err
 	}
 
-	return "completed", nil
+	state, ok := resp.int(ippTagJob, "job-state")
+	if !ok {
+		return "", "", fmt.Errorf("no job-state for job %s", cupsJobId)
+	}
+
+	status, ok := cupsJobStates[state]
+	if !ok {
+		return "", "", fmt.Errorf("unknown job-state %d for job %s", state, cupsJobId)
+	}
+
+	reasons := strings.Join(resp.strings(ippTagJob, "job-state-reasons"), ",")
+	return status, reasons, nil
 }
 
 func (p *Printer) UpdateJobStatus(jobId int64) error {
@@ -138,25 +443,122 @@ func (p *Printer) UpdateJobStatus(jobId int64) error {
 		return fmt.Errorf("failed to get print job: %w", err)
 	}
 
+	if models.IsTerminalPrintJobState(job.Status) || job.Status == models.PrintJobQueued {
+		return nil
+	}
+
 	if job.CupsJobId == nil {
 		return fmt.Errorf("job has no cups job id")
 	}
 
-	status, err := p.CheckJobStatus(*job.CupsJobId)
-	if err != nil {
+	now := time.Now().Unix()
+	job.LastCheckedAt = &now
+
+	status, reasons, err := p.CheckJobStatus(*job.CupsJobId)
+	if errors.Is(err, ErrJobNotFound) {
+		// The scheduler keeps job history, so a job it doesn't know about
+		// was purged or lost. We can't tell if it printed, so never assume it did.
+		message := "job no longer known to CUPS"
+		status, reasons, job.ErrorMessage = models.PrintJobAborted, "", &message
+	} else if err != nil {
 		return fmt.Errorf("failed to check job status: %w", err)
 	}
 
+	if !models.CanTransitionPrintJob(job.Status, status) {
+		return fmt.Errorf("invalid print job transition %s -> %s", job.Status, status)
+	}
+
 	if status != job.Status {
-		if status == "completed" {
-			now := time.Now().Unix()
-			job.Status = status
-			job.CompletedAt = &now
-		} else {
-			job.Status = status
+		log.Printf("Print job %d: %s -> %s", job.Id, job.Status, status)
+	}
+
+	job.Status = status
+	if reasons != "" {
+		job.StateReason = &reasons
+	} else {
+		job.StateReason = nil
+	}
+
+	if status == models.PrintJobCompleted {
+		job.CompletedAt = &now
+	}
+
+	if err := p.printJobStore.Update(*job); err != nil {
+		return err
+	}
+
+	if status == models.PrintJobCompleted {
+		if err := p.printedVersionStore.Record(*job); err != nil {
+			return fmt.Errorf("failed to record printed version: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CancelPrintJob cancels a job in CUPS and records it as canceled. Jobs
+// that never reached CUPS are only marked as canceled.
+func (p *Printer) CancelPrintJob(jobId int64) (*models.PrintJob, error) {
+	p.dispatchMu.Lock()
+	defer p.dispatchMu.Unlock()
+
+	job, err := p.printJobStore.GetById(jobId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get print job: %w", err)
+	}
+
+	if models.IsTerminalPrintJobState(job.Status) {
+		return job, models.ErrPrintJobFinished
+	}
+
+	if job.CupsJobId == nil && job.DispatchStartedAt != nil {
+		cupsJobId, err := p.findCupsJob(*job)
+		if err != nil {
+			return job, fmt.Errorf("failed to look up job in CUPS: %w", err)
+		}
+		if cupsJobId != "" {
+			job.CupsJobId = &cupsJobId
+		}
+	}
+
+	if job.CupsJobId != nil {
+		_, err := newIppRequest(ippOpCancelJob).
+			add(ippTagURI, "job-uri", "ipp://localhost/jobs/"+*job.CupsJobId).
+			add(ippTagName, "requesting-user-name", requestingUserName()).
+			do("/jobs/")
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			return job, fmt.Errorf("failed to cancel job in CUPS: %w", err)
+		}
+	}
+
+	now := time.Now().Unix()
+	job.Status = models.PrintJobCanceled
+	job.CanceledAt = &now
+	job.LastCheckedAt = &now
+
+	if err := p.printJobStore.Update(*job); err != nil {
+		return job, fmt.Errorf("failed to record canceled job: %w", err)
+	}
+
+	log.Printf("Canceled print job %d", job.Id)
+	return job, nil
+}
+
+// CheckActiveJobs polls CUPS for every job that has not reached a terminal state.
+func (p *Printer) CheckActiveJobs() error {
+	jobs, err := p.printJobStore.GetActiveJobs()
+	if err != nil {
+		return fmt.Errorf("failed to get active jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		if job.CupsJobId == nil {
+			continue
 		}
 
-		return p.printJobStore.Update(*job)
+		if err := p.UpdateJobStatus(job.Id); err != nil {
+			log.Printf("Failed to update job %d status: %v", job.Id, err)
+		}
 	}
 
 	return nil
@@ -169,12 +571,7 @@ func (p *Printer) CheckStuckJobs(thresholdSeconds int) error {
 	}
 
 	for _, job := range stuckJobs {
-		log.Printf("Found stuck job %d (document %d), status: %s", job.Id, job.DocumentId, job.Status)
-		if job.CupsJobId != nil {
-			if err := p.UpdateJobStatus(job.Id); err != nil {
-				log.Printf("Failed to update job %d status: %v", job.Id, err)
-			}
-		}
+		log.Printf("Found stuck job %d (%s), status: %s", job.Id, job.FilePath, job.Status)
 	}
 
 	return nil
@@ -0,0 +1,181 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package forms fills in PDF templates with data, so forms printed during
+// an outage come out with names, rooms and medication rows already on them.
+package forms
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSize = 10.0
+
+	// bottomMargin is how close to the bottom of the page table rows may
+	// go when a table field doesn't set max_rows.
+	bottomMargin = 18.0
+)
+
+// Render fills in a PDF template. A table field with more rows than fit
+// continues on further copies of the template, with the other fields
+// repeated on each, so one set of data can produce several sheets. Each
+// sheet is returned as a complete PDF.
+func Render(template []byte, fields []models.TemplateField, data map[string]any) ([][]byte, error) {
+	sheets := 1
+	for _, field := range fields {
+		if len(field.Columns) > 0 {
+			rows := len(tableRows(lookup(data, field.Name)))
+			sheets = max(sheets, int(math.Ceil(float64(rows)/float64(capacity(field)))))
+		}
+	}
+
+	out := make([][]byte, sheets)
+	for sheet := range sheets {
+		filled, err := pdf.OverlayPDF(template, func(p *pdf.Page, page, pages int, width, height float64) {
+			for _, field := range fields {
+				if max(field.Page, 1) != page {
+					continue
+				}
+
+				if len(field.Columns) > 0 {
+					drawTable(p, field, lookup(data, field.Name), sheet)
+				} else {
+					drawText(p, field, field.X, field.Y, field.Width, format(lookup(data, field.Name)))
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fill in template: %w", err)
+		}
+		out[sheet] = filled
+	}
+
+	return out, nil
+}
+
+// capacity returns how many rows of a table field fit on one sheet.
+func capacity(field models.TemplateField) int {
+	if field.MaxRows > 0 {
+		return field.MaxRows
+	}
+	return max(int((field.Y-bottomMargin)/rowHeight(field))+1, 1)
+}
+
+func rowHeight(field models.TemplateField) float64 {
+	if field.RowHeight > 0 {
+		return field.RowHeight
+	}
+	return fontSize(field) * 1.5
+}
+
+func fontSize(field models.TemplateField) float64 {
+	if field.Size > 0 {
+		return field.Size
+	}
+	return defaultSize
+}
+
+// drawTable draws the rows of a table field that belong on a sheet.
+func drawTable(p *pdf.Page, field models.TemplateField, value any, sheet int) {
+	rows := tableRows(value)
+	perSheet := capacity(field)
+
+	start := min(sheet*perSheet, len(rows))
+	end := min(start+perSheet, len(rows))
+
+	for i, row := range rows[start:end] {
+		y := field.Y - float64(i)*rowHeight(field)
+		for _, column := range field.Columns {
+			drawText(p, field, column.X, y, column.Width, format(lookup(row, column.Name)))
+		}
+	}
+}
+
+// drawText draws a value with its first baseline at x, y. Values with
+// several lines continue downwards.
+func drawText(p *pdf.Page, field models.TemplateField, x, y, width float64, text string) {
+	if text == "" {
+		return
+	}
+
+	font := pdf.Helvetica
+	if field.Bold {
+		font = pdf.HelveticaBold
+	}
+	size := fontSize(field)
+
+	for i, line := range strings.Split(text, "\n") {
+		if width > 0 {
+			line = pdf.Truncate(font, size, line, width)
+		}
+		p.Text(x, y-float64(i)*size*1.2, font, size, line)
+	}
+}
+
+// tableRows returns the rows of a table value, which is a list of objects.
+func tableRows(value any) []map[string]any {
+	list, _ := value.([]any)
+
+	var rows []map[string]any
+	for _, item := range list {
+		if row, ok := item.(map[string]any); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// lookup finds a value by name, following dots into nested objects.
+func lookup(data map[string]any, name string) any {
+	if value, ok := data[name]; ok {
+		return value
+	}
+
+	var value any = data
+	for key := range strings.SplitSeq(name, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// format turns a JSON value into the text printed for it. True is printed
+// as an X, for ticking boxes, and false leaves the field empty.
+func format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "X"
+		}
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s := format(item); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
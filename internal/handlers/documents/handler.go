@@ -5,14 +5,24 @@
 package documents
 
 import (
+	"blackoutbox/internal/convert"
 	"blackoutbox/internal/models"
 	"blackoutbox/internal/response"
+	"blackoutbox/internal/search"
 	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"blackoutbox/internal/validation"
+	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -21,27 +31,68 @@ import (
 )
 
 type DocumentHandler struct {
-	Store stores.DocumentStoreInterface
+	Store       stores.DocumentStoreInterface
+	Templates   stores.TemplateStoreInterface
+	Attachments stores.TemplateAttachmentStoreInterface
+	Printer     DocumentPrinter
+	Index       DocumentSearcher
 }
 
+type DocumentPrinter interface {
+	PrintDocument(documentId int64, override *models.PrintOptions) (*models.PrintJob, error)
+}
+
+type DocumentSearcher interface {
+	Search(query models.SearchQuery) ([]models.SearchResult, error)
+	RequestRefresh()
+}
+
+// Get handles GET /documents - List documents. system-id narrows the list
+// to one system and file-id finds a single document. Documents can be
+// selected by their tags with tag, given once per tag, matching documents
+// with all of them or, with match=any, any of them; or with tags, a tag
+// expression:
+//
+//	GET /documents?system-id=1&tag=wing-b&tag=icu&match=any
+//	GET /documents?tags=wing-b and not (night or weekend)
 func (h *DocumentHandler) Get() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		systemIdFilter := r.URL.Query().Get("system-id")
 		fileIdFilter := r.URL.Query().Get("file-id")
 
-		systemIntId, err := strconv.ParseInt(systemIdFilter, 10, 64)
-		if err != nil {
-			return
-		}
-
-		fileIntId, err := strconv.ParseInt(fileIdFilter, 10, 64)
+		tags, err := tagFilter(r.URL.Query())
 		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		var data any
 
-		if systemIdFilter != "" {
+		if tags != nil {
+			var systemId *int64
+			if systemIdFilter != "" {
+				id, err := strconv.ParseInt(systemIdFilter, 10, 64)
+				if err != nil {
+					http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+					return
+				}
+				systemId = &id
+			}
+
+			documents, err := h.Store.GetByTags(systemId, tags)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			data = documents
+		} else if systemIdFilter != "" {
+			systemIntId, err := strconv.ParseInt(systemIdFilter, 10, 64)
+			if err != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+
 			documents, err := h.Store.GetBySystemId(systemIntId)
 			if err != nil {
 				w.WriteHeader(http.StatusNoContent)
@@ -50,6 +101,12 @@ func (h *DocumentHandler) Get() http.HandlerFunc {
 
 			data = documents
 		} else if fileIdFilter != "" {
+			fileIntId, err := strconv.ParseInt(fileIdFilter, 10, 64)
+			if err != nil {
+				http.Error(w, "file-id must be an integer", http.StatusBadRequest)
+				return
+			}
+
 			document, err := h.Store.GetByFileId(fileIntId)
 			if err != nil {
 				w.WriteHeader(http.StatusNotFound)
@@ -71,6 +128,33 @@ func (h *DocumentHandler) Get() http.HandlerFunc {
 	}
 }
 
+// tagFilter reads the tag selection of a request: tag, given once per tag,
+// with match=all (the default) or match=any, and a tags expression. When
+// both are given, documents must satisfy both. It returns nil if neither
+// is given.
+func tagFilter(params url.Values) (*tagexpr.Expr, error) {
+	var tags *tagexpr.Expr
+
+	switch match := params.Get("match"); match {
+	case "", "all":
+		tags = tagexpr.All(params["tag"]...)
+	case "any":
+		tags = tagexpr.Any(params["tag"]...)
+	default:
+		return nil, errors.New("match must be all or any")
+	}
+
+	if expression := params.Get("tags"); expression != "" {
+		parsed, err := tagexpr.Parse(expression)
+		if err != nil {
+			return nil, err
+		}
+		tags = tagexpr.And(tags, parsed)
+	}
+
+	return tags, nil
+}
+
 func (h *DocumentHandler) Post() http.HandlerFunc {
 	const maxFileSize = 10 << 20 // 10MB
 
@@ -114,27 +198,6 @@ func (h *DocumentHandler) Post() http.HandlerFunc {
 			return
 		}
 
-		uploadDir := filepath.Join(storage.DocumentsRoot, systemId)
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
-			return
-		}
-
-		filename := fmt.Sprintf("%d_%s", time.Now().Unix(), fileHeader.Filename)
-		filePath := filepath.Join(uploadDir, filename)
-
-		dst, err := os.Create(filePath)
-		if err != nil {
-			http.Error(w, "Failed to create file", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			return
-		}
-
 		var printAt *int64
 		printAtStr := r.FormValue("print_at")
 		if printAtStr != "" {
@@ -155,51 +218,808 @@ func (h *DocumentHandler) Post() http.HandlerFunc {
 			}
 		}
 
+		if err := validation.ValidateTags(tags); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		printOptions, err := validation.ParsePrintOptions(r.FormValue("print_options"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		now := time.Now().Unix()
 
-		if err := h.Store.Add(models.Document{
+		document := models.Document{
 			SystemId:      systemIntId,
 			FileReference: fileId,
-			FilePath:      filepath.Join(storage.DocumentsRoot, systemId, filename),
 			PrintAt:       printAt,
 			LastPrintedAt: nil,
 			Tags:          tags,
+			PrintOptions:  printOptions,
 			UpdatedAt:     &now,
 			DeletedAt:     nil,
-		}); err != nil {
+		}
+
+		blob, err := storage.PutBlob(file, fileHeader.Filename)
+		if err != nil {
+			log.Printf("Failed to save upload of %s: %v", fileId, err)
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		setFile(&document, blob, r.FormValue("uploaded_by"))
+
+		// Convert while the upload is at hand, so a file that can't be
+		// printed well shows up now rather than during an outage.
+		convert.Document(&document)
+
+		if err := h.Store.Add(document); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		h.reindex()
 
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
+// Update handles PATCH /documents/{id} - Update a document.
+// Only the fields given are changed; null or an empty value clears
+// print_at, tags and print_options. The version being updated must be
+// sent in an If-Match header (the ETag of GET /documents/{id}) or as a
+// version field, and the update is rejected with 412 if the document has
+// changed since:
+//
+//	{
+//	  "version": 3,
+//	  "print_at": 1738581234,
+//	  "tags": ["icu", "daily"]
+//	}
+//
+// To replace the file as well, send the same fields as multipart form
+// values with the new contents as file, and optionally who uploaded it as
+// uploaded_by. The file_id is kept, the file is converted again and the
+// previous file stays available as an earlier version.
 func (h *DocumentHandler) Update() http.HandlerFunc {
+	const maxFileSize = 10 << 20 // 10MB
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		var fields map[string]string
+		var file multipart.File
+		var fileHeader *multipart.FileHeader
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			if err := r.ParseMultipartForm(maxFileSize); err != nil {
+				http.Error(w, "Unable to parse multipart form", http.StatusBadRequest)
+				return
+			}
+
+			fields = make(map[string]string)
+			for key, values := range r.MultipartForm.Value {
+				fields[key] = values[0]
+			}
+
+			var err error
+			file, fileHeader, err = r.FormFile("file")
+			if err == nil {
+				defer file.Close()
+				if fileHeader.Size > maxFileSize {
+					http.Error(w, "File size exceeds 10MB limit", http.StatusBadRequest)
+					return
+				}
+			} else if !errors.Is(err, http.ErrMissingFile) {
+				http.Error(w, "Unable to read file", http.StatusBadRequest)
+				return
+			}
+		} else {
+			var raw map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			fields = make(map[string]string, len(raw))
+			for key, value := range raw {
+				fields[key] = string(value)
+			}
+		}
+
+		version, err := expectedVersion(r, fields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if version == 0 {
+			http.Error(w, "If-Match header or version is required", http.StatusPreconditionRequired)
+			return
+		}
+		if version == anyVersion {
+			version = document.Version
+		}
+		if version != document.Version {
+			http.Error(w, models.ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		delete(fields, "version")
+
+		uploadedBy := fields["uploaded_by"]
+		if file != nil {
+			delete(fields, "uploaded_by")
+		}
+
+		if len(fields) == 0 && file == nil {
+			http.Error(w, "Nothing to update", http.StatusBadRequest)
+			return
+		}
+
+		if err := applyDocumentFields(document, fields); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if file != nil {
+			blob, err := storage.PutBlob(file, fileHeader.Filename)
+			if err != nil {
+				log.Printf("Failed to save replacement for document %d: %v", document.Id, err)
+				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				return
+			}
+
+			setFile(document, blob, uploadedBy)
+			convert.Document(document)
+		}
+
+		err = h.Store.Update(*document)
+		if errors.Is(err, models.ErrVersionConflict) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if file != nil {
+			h.pruneVersions(document.Id)
+		}
+
+		h.updated(w, document.Id)
+	}
+}
+
+// GetVersions handles GET /documents/{id}/versions - List a document's file versions, newest first.
+func (h *DocumentHandler) GetVersions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		versions, err := h.Store.GetVersions(document.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
+		response.JSON(w, http.StatusOK, versions)
 	}
 }
 
-func (h *DocumentHandler) GetById() http.HandlerFunc {
+// Rollback handles POST /documents/{id}/versions/{version}/rollback - Make an earlier file current again.
+// The file is recorded as a new version, so the rollback can itself be
+// undone. An If-Match header is optional; if given, it must match the
+// document's version.
+func (h *DocumentHandler) Rollback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		versionId, err := strconv.ParseInt(r.PathValue("version"), 10, 64)
+		if err != nil {
+			http.Error(w, "version must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		expected, err := expectedVersion(r, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if expected > 0 && expected != document.Version {
+			http.Error(w, models.ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+			return
+		}
+
+		version, err := h.Store.GetVersion(document.Id, versionId)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := os.Stat(version.FilePath); err != nil {
+			http.Error(w, "Version file is missing", http.StatusConflict)
+			return
+		}
+
+		document.FilePath = version.FilePath
+		document.FileSize = version.FileSize
+		document.FileHash = version.FileHash
+		document.UploadedBy = version.UploadedBy
+		convert.Document(document)
+
+		err = h.Store.Update(*document)
+		if errors.Is(err, models.ErrVersionConflict) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.pruneVersions(document.Id)
+		h.updated(w, document.Id)
+	}
+}
+
+// Delete handles DELETE /documents/{id} - Move a document to the trash.
+// It is purged with its files once its system's trash_days have passed,
+// unless it is restored first.
+func (h *DocumentHandler) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if id == "" {
-			http.Error(w, "id is required", http.StatusBadRequest)
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
 			return
 		}
 
-		intId, err := strconv.ParseInt(id, 10, 64)
+		deleted, err := h.Store.Delete(id)
 		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		h.reindex()
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Restore handles POST /documents/{id}/restore - Take a document out of the trash.
+func (h *DocumentHandler) Restore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
 			return
 		}
 
-		document, err := h.Store.GetById(intId)
+		restored, err := h.Store.Restore(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !restored {
+			http.Error(w, "Document not found in the trash, or its system has been deleted", http.StatusNotFound)
+			return
+		}
+
+		h.updated(w, id)
+	}
+}
+
+// Trash handles GET /documents/trash - List the documents in the trash,
+// most recently deleted first, with when each will be purged. system-id
+// narrows the list to one system.
+func (h *DocumentHandler) Trash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var systemId *int64
+		if systemIdFilter := r.URL.Query().Get("system-id"); systemIdFilter != "" {
+			id, err := strconv.ParseInt(systemIdFilter, 10, 64)
+			if err != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			systemId = &id
+		}
+
+		documents, err := h.Store.GetDeleted(systemId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, documents)
+	}
+}
+
+// updated responds with a document after it has been changed.
+func (h *DocumentHandler) updated(w http.ResponseWriter, id int64) {
+	h.reindex()
+
+	document, err := h.Store.GetById(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(document.Version))
+	response.JSON(w, http.StatusOK, document)
+}
+
+// pruneVersions applies the system's retention policy to a document's file
+// versions. Failures are only logged: the update itself has succeeded.
+func (h *DocumentHandler) pruneVersions(id int64) {
+	if err := h.Store.PruneVersions(id); err != nil {
+		log.Printf("Failed to prune versions of document %d: %v", id, err)
+	}
+}
+
+// reindex has the search index brought up to date after a document changed. The
+// worker refreshes it, so the request isn't kept waiting on the files
+// being read.
+func (h *DocumentHandler) reindex() {
+	if h.Index == nil {
+		return
+	}
+	h.Index.RequestRefresh()
+}
+
+// setFile makes a blob a document's file, recording who uploaded it.
+func setFile(document *models.Document, blob models.Blob, uploadedBy string) {
+	document.FilePath = blob.Path
+	document.FileSize = &blob.Size
+	document.FileHash = &blob.Hash
+	document.UploadedBy = nil
+	if uploadedBy != "" {
+		document.UploadedBy = &uploadedBy
+	}
+}
+
+// anyVersion is the expected version for If-Match: *.
+const anyVersion = -1
+
+// expectedVersion returns the document version an update is based on,
+// from the If-Match header or else the version field, or 0 if neither
+// is given. If-Match: * matches any version.
+func expectedVersion(r *http.Request, fields map[string]string) (int64, error) {
+	value := fields["version"]
+	if match := r.Header.Get("If-Match"); match != "" {
+		if match == "*" {
+			return anyVersion, nil
+		}
+		value = strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+	}
+
+	if value == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 1 {
+		return 0, errors.New("version must be a positive integer")
+	}
+
+	return version, nil
+}
+
+// applyDocumentFields sets the fields given in an update on a document.
+// Values are JSON, or plain form values for print_at; null or an empty
+// value clears the field.
+func applyDocumentFields(document *models.Document, fields map[string]string) error {
+	for key, value := range fields {
+		empty := value == "" || value == "null"
+
+		switch key {
+		case "print_at":
+			if empty {
+				document.PrintAt = nil
+				continue
+			}
+			timestamp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("print_at must be a valid Unix timestamp")
+			}
+			document.PrintAt = &timestamp
+		case "tags":
+			var tags []string
+			if !empty {
+				if err := json.Unmarshal([]byte(value), &tags); err != nil {
+					return errors.New("tags must be a valid JSON array")
+				}
+			}
+			if err := validation.ValidateTags(tags); err != nil {
+				return err
+			}
+			document.Tags = tags
+		case "print_options":
+			if empty {
+				document.PrintOptions = nil
+				continue
+			}
+			printOptions, err := validation.ParsePrintOptions(value)
+			if err != nil {
+				return err
+			}
+			document.PrintOptions = printOptions
+		default:
+			return fmt.Errorf("%s can't be updated", key)
+		}
+	}
+
+	return nil
+}
+
+// versionETag formats a document version as the ETag of its metadata.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Search handles GET /documents/search - Find documents by their contents.
+// q holds the words to look for; a word ending in * matches words starting
+// with it. Results can be narrowed to a system with system-id and by tags
+// as in GET /documents, and are limited to limit (default 20, at most
+// 100):
+//
+//	GET /documents/search?q=room+12&system-id=1&tag=wing-b
+func (h *DocumentHandler) Search() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		query := models.SearchQuery{Text: params.Get("q")}
+		if strings.TrimSpace(query.Text) == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+
+		tags, err := tagFilter(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.Tags = tags
+
+		if systemId := params.Get("system-id"); systemId != "" {
+			id, err := strconv.ParseInt(systemId, 10, 64)
+			if err != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			query.SystemId = &id
+		}
+
+		if limit := params.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			query.Limit = n
+		}
+
+		if h.Index == nil {
+			http.Error(w, search.ErrUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		results, err := h.Index.Search(query)
+		if errors.Is(err, search.ErrUnavailable) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The index may lag behind a change by a moment, so documents
+		// are looked up again and ones that are gone skipped.
+		found := []models.SearchResult{}
+		for _, result := range results {
+			document, err := h.Store.GetById(result.DocumentId)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result.Document = document
+			found = append(found, result)
+		}
+
+		response.JSON(w, http.StatusOK, found)
+	}
+}
+
+// GetById handles GET /documents/{id} - Get a document's metadata.
+// The ETag is the document's version, to send in If-Match when updating,
+// and with Last-Modified lets clients revalidate a cached copy with
+// If-None-Match or If-Modified-Since.
+func (h *DocumentHandler) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		etag := versionETag(document.Version)
+		w.Header().Set("ETag", etag)
+
+		var modified time.Time
+		if document.UpdatedAt != nil {
+			modified = time.Unix(*document.UpdatedAt, 0)
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 
 		response.JSON(w, http.StatusOK, document)
 	}
 }
+
+// notModified reports whether a GET request's cached copy is still
+// current. If-None-Match takes precedence over If-Modified-Since, which is
+// only checked when the last modification time is known.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// Content handles GET /documents/{id}/content - Download a document's file.
+// Serves the uploaded file, or with ?format=pdf the PDF that is printed.
+// Range requests are supported, and the ETag (the file's SHA-256) and
+// Last-Modified headers let clients such as ward tablets revalidate a
+// cached copy with If-None-Match or If-Modified-Since. The ETag is the
+// hash recorded for the file, so a revalidation doesn't read the file.
+func (h *DocumentHandler) Content() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		path, hash := document.FilePath, document.FileHash
+		switch r.URL.Query().Get("format") {
+		case "", "original":
+		case "pdf":
+			if document.ConversionStatus != models.ConversionConverted && document.ConversionStatus != models.ConversionNotNeeded {
+				http.Error(w, "Document has no PDF version", http.StatusNotFound)
+				return
+			}
+			if document.PrintPath() != document.FilePath {
+				path, hash = document.PrintPath(), document.ConvertedHash
+			}
+		default:
+			http.Error(w, "format must be original or pdf", http.StatusBadRequest)
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			http.Error(w, "Document file not found", http.StatusNotFound)
+			return
+		}
+
+		// Stored files may be encrypted, so the content is decrypted
+		// into memory for this reader only, and only once it is sent.
+		content := &fileContent{path: path}
+
+		// Synced files, and conversions made before their hash was
+		// recorded, are hashed as they are served.
+		if hash == nil {
+			if err := content.load(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			sum := storage.Hash(content.data)
+			hash = &sum
+		}
+
+		// Without a type, ServeContent sniffs the content
+		if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("ETag", `"`+*hash+`"`)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", document.FileReference+filepath.Ext(path)))
+
+		http.ServeContent(w, r, path, info.ModTime(), content)
+	}
+}
+
+// fileContent reads a stored file when it is first read or sought, so
+// requests answered from their headers alone, such as revalidations,
+// don't decrypt it.
+type fileContent struct {
+	path string
+	data []byte
+	r    *bytes.Reader
+}
+
+func (c *fileContent) load() error {
+	if c.r != nil {
+		return nil
+	}
+
+	data, err := storage.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	c.data = data
+	c.r = bytes.NewReader(data)
+	return nil
+}
+
+func (c *fileContent) Read(p []byte) (int, error) {
+	if err := c.load(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (c *fileContent) Seek(offset int64, whence int) (int64, error) {
+	if err := c.load(); err != nil {
+		return 0, err
+	}
+	return c.r.Seek(offset, whence)
+}
+
+// Print handles POST /documents/{id}/print - Print a single document on demand.
+// The optional payload overrides the configured print options:
+//
+//	{
+//	  "print_options": {"copies": 2}
+//	}
+func (h *DocumentHandler) Print() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		override, err := validation.DecodePrintOverride(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job, err := h.Printer.PrintDocument(id, override)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, job)
+	}
+}
+
+// document looks up the document named by the id in the path, writing an
+// error response if there is none.
+func (h *DocumentHandler) document(w http.ResponseWriter, r *http.Request) (*models.Document, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+
+	document, err := h.Store.GetById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return document, true
+}
+
+// GetTemplates handles GET /documents/{id}/templates - List the templates attached to a document.
+func (h *DocumentHandler) GetTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		attachments, err := h.Attachments.GetByDocument(document.SystemId, document.FileReference)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, attachments)
+	}
+}
+
+// PutTemplates handles PUT /documents/{id}/templates - Set the templates attached to a document.
+// Templates are printed after the document in the order given, each with
+// its number of copies (default 1). An empty list detaches all templates:
+//
+//	[
+//	  {"template_id": 4},
+//	  {"template_id": 7, "copies": 3}
+//	]
+func (h *DocumentHandler) PutTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document, ok := h.document(w, r)
+		if !ok {
+			return
+		}
+
+		var attachments []models.TemplateAttachment
+		if err := json.NewDecoder(r.Body).Decode(&attachments); err != nil {
+			http.Error(w, "payload must be a JSON array of template attachments", http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateTemplateAttachments(attachments); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for i := range attachments {
+			template, err := h.Templates.GetById(attachments[i].TemplateId)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && (template.DeletedAt != nil || template.SystemId != document.SystemId)) {
+				http.Error(w, fmt.Sprintf("template %d not found in the document's system", attachments[i].TemplateId), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if attachments[i].Copies == 0 {
+				attachments[i].Copies = 1
+			}
+		}
+
+		if err := h.Attachments.Replace(document.SystemId, document.FileReference, attachments); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		saved, err := h.Attachments.GetByDocument(document.SystemId, document.FileReference)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, saved)
+	}
+}
@@ -0,0 +1,280 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package formstock
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/stores"
+	"blackoutbox/internal/validation"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type FormStockHandler struct {
+	Store     stores.FormStockStoreInterface
+	Templates stores.TemplateStoreInterface
+	Printer   FormStockPrinter
+}
+
+type FormStockPrinter interface {
+	PrintFormStock(systemId int64, hours *int, ward *string) (int, error)
+}
+
+// decodeRule reads a rule from the request body and checks that its
+// template belongs to its system and that the system has no other rule
+// for the same ward and template.
+func (h *FormStockHandler) decodeRule(r *http.Request, rule *models.FormStockRule) (int, error) {
+	var req struct {
+		SystemId      int64  `json:"system_id"`
+		Ward          string `json:"ward"`
+		TemplateId    int64  `json:"template_id"`
+		CopiesPerHour int    `json:"copies_per_hour"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, errors.New("invalid request body")
+	}
+
+	rule.SystemId = req.SystemId
+	rule.Ward = strings.TrimSpace(req.Ward)
+	rule.TemplateId = req.TemplateId
+	rule.CopiesPerHour = req.CopiesPerHour
+
+	if err := validation.ValidateFormStockRule(*rule); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	template, err := h.Templates.GetById(rule.TemplateId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (template.DeletedAt != nil || template.SystemId != rule.SystemId)) {
+		return http.StatusBadRequest, fmt.Errorf("template %d not found in system %d", rule.TemplateId, rule.SystemId)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	existing, err := h.Store.GetBySystemId(rule.SystemId)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, other := range existing {
+		if other.Id != rule.Id && other.Ward == rule.Ward && other.TemplateId == rule.TemplateId {
+			return http.StatusConflict, fmt.Errorf("rule %d already stocks this template for this ward", other.Id)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+func (h *FormStockHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rules []models.FormStockRule
+		var err error
+
+		if systemIdFilter := r.URL.Query().Get("system-id"); systemIdFilter != "" {
+			systemId, parseErr := strconv.ParseInt(systemIdFilter, 10, 64)
+			if parseErr != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			rules, err = h.Store.GetBySystemId(systemId)
+		} else {
+			rules, err = h.Store.Get()
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, rules)
+	}
+}
+
+func (h *FormStockHandler) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		rule, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, "Form stock rule not found", http.StatusNotFound)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, rule)
+	}
+}
+
+// Post creates a form stock rule. An empty ward stocks the whole system.
+// Expected payload:
+//
+//	{
+//	  "system_id": 1,
+//	  "ward": "3B",
+//	  "template_id": 4,
+//	  "copies_per_hour": 2
+//	}
+func (h *FormStockHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rule models.FormStockRule
+		if status, err := h.decodeRule(r, &rule); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		id, err := h.Store.Add(rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		created, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, created)
+	}
+}
+
+// Put replaces a form stock rule, with the same payload as Post.
+func (h *FormStockHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		rule, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, "Form stock rule not found", http.StatusNotFound)
+			return
+		}
+
+		if status, err := h.decodeRule(r, rule); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if err := h.Store.Update(*rule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, updated)
+	}
+}
+
+func (h *FormStockHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := h.Store.GetById(id); err != nil {
+			http.Error(w, "Form stock rule not found", http.StatusNotFound)
+			return
+		}
+
+		if err := h.Store.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Print handles POST /form_stock/print - Print blank form stock now.
+// Prints enough for an outage of hours, or the system's expected outage
+// length when left out. A ward limits the stock to that ward:
+//
+//	{
+//	  "system_id": 1,
+//	  "hours": 12,
+//	  "ward": "3B"
+//	}
+func (h *FormStockHandler) Print() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SystemId int64   `json:"system_id"`
+			Hours    *int    `json:"hours"`
+			Ward     *string `json:"ward"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.SystemId <= 0 {
+			http.Error(w, "system_id is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Hours != nil && (*req.Hours < 1 || *req.Hours > validation.MaxOutageHours) {
+			http.Error(w, fmt.Sprintf("hours must be between 1 and %d", validation.MaxOutageHours), http.StatusBadRequest)
+			return
+		}
+
+		if req.Ward != nil {
+			ward := strings.TrimSpace(*req.Ward)
+			req.Ward = &ward
+		}
+
+		copies, err := h.Printer.PrintFormStock(req.SystemId, req.Hours, req.Ward)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusAccepted, map[string]int{"copies": copies})
+	}
+}
+
+// GetCounts handles GET /form_stock/prints - Blank stock printed per incident.
+// Counts are per print batch, ward and template version, newest first, and
+// can be filtered by system-id.
+func (h *FormStockHandler) GetCounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var systemId int64
+
+		if systemIdFilter := r.URL.Query().Get("system-id"); systemIdFilter != "" {
+			parsed, err := strconv.ParseInt(systemIdFilter, 10, 64)
+			if err != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			systemId = parsed
+		}
+
+		counts, err := h.Store.GetCounts(systemId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, counts)
+	}
+}
@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package health
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Overall states of the box.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+)
+
+type HealthHandler struct {
+	Printer stores.PrinterStatusStoreInterface
+	Scrubs  stores.ScrubStoreInterface
+}
+
+type healthStatus struct {
+	Status   string                `json:"status"` // ok, degraded
+	Printer  *models.PrinterStatus `json:"printer"`
+	Storage  *models.ScrubReport   `json:"storage"`
+	Warnings []string              `json:"warnings"`
+}
+
+// Get handles GET /health - Whether the box is ready for an outage.
+// The box is degraded if the printer isn't ready or stored files are
+// missing or corrupt. Each warning is also sent as a Warning header.
+func (h *HealthHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		printer, err := h.Printer.GetLatest()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		scrub, err := h.Scrubs.GetLatest()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		health := healthStatus{Status: StatusOK, Printer: printer, Storage: scrub, Warnings: []string{}}
+
+		switch {
+		case printer == nil:
+			health.Warnings = append(health.Warnings, "printer has not been checked yet")
+		case !printer.Ready:
+			health.Status = StatusDegraded
+			health.Warnings = append(health.Warnings, "printer not ready")
+		}
+
+		switch {
+		case scrub == nil:
+			health.Warnings = append(health.Warnings, "stored files have not been checked yet")
+		default:
+			if !scrub.Healthy() {
+				health.Status = StatusDegraded
+			}
+			if scrub.Missing > 0 {
+				health.Warnings = append(health.Warnings, fmt.Sprintf("%d stored files are missing", scrub.Missing))
+			}
+			if scrub.Corrupt > 0 {
+				health.Warnings = append(health.Warnings, fmt.Sprintf("%d stored files are corrupt", scrub.Corrupt))
+			}
+			if scrub.Orphaned > 0 {
+				health.Warnings = append(health.Warnings, fmt.Sprintf("%d stored files are orphaned", scrub.Orphaned))
+			}
+		}
+
+		headers := http.Header{}
+		for _, warning := range health.Warnings {
+			headers.Add("Warning", fmt.Sprintf(`199 blackoutbox %q`, warning))
+		}
+
+		response.JSONWithHeaders(w, http.StatusOK, health, headers)
+	}
+}
+
+// GetScrub handles GET /storage/scrub - The latest check of the stored files.
+func (h *HealthHandler) GetScrub() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := h.Scrubs.GetLatest()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if report == nil {
+			http.Error(w, "Stored files have not been checked yet", http.StatusNotFound)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, report)
+	}
+}
+
+// PostScrub handles POST /storage/scrub - Check the stored files now.
+func (h *HealthHandler) PostScrub() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := storage.Scrub(h.Scrubs)
+		if errors.Is(err, storage.ErrScrubRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, report)
+	}
+}
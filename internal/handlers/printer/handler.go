@@ -0,0 +1,78 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package printer
+
+import (
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/stores"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+type PrinterHandler struct {
+	Store stores.PrinterStatusStoreInterface
+}
+
+// GetStatus handles GET /printer/status - The latest printer status.
+// A printer that is not ready is also flagged with a Warning header.
+func (h *PrinterHandler) GetStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := h.Store.GetLatest()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if status == nil {
+			http.Error(w, "Printer has not been checked yet", http.StatusNotFound)
+			return
+		}
+
+		var headers http.Header
+		if !status.Ready {
+			headers = http.Header{"Warning": {`199 blackoutbox "printer not ready"`}}
+		}
+
+		response.JSONWithHeaders(w, http.StatusOK, status, headers)
+	}
+}
+
+// GetHistory handles GET /printer/status/history - Past printer statuses, newest first.
+func (h *PrinterHandler) GetHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().Add(-24 * time.Hour).Unix()
+		if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+			parsed, err := strconv.ParseInt(sinceStr, 10, 64)
+			if err != nil {
+				http.Error(w, "since must be a valid Unix timestamp", http.StatusBadRequest)
+				return
+			}
+			since = parsed
+		}
+
+		limit := defaultHistoryLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			parsed, err := strconv.Atoi(limitStr)
+			if err != nil || parsed < 1 || parsed > maxHistoryLimit {
+				http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		history, err := h.Store.GetHistory(since, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, history)
+	}
+}
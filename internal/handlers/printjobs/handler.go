@@ -5,32 +5,69 @@
 package printjobs
 
 import (
+	"blackoutbox/internal/models"
 	"blackoutbox/internal/response"
 	"blackoutbox/internal/stores"
-	"encoding/json"
+	"blackoutbox/internal/validation"
+	"database/sql"
+	"errors"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type PrintJobHandler struct {
-	Store stores.PrintJobStoreInterface
+	Store     stores.PrintJobStoreInterface
+	Canceler  PrintJobCanceler
+	Reprinter PrintJobReprinter
 }
 
+type PrintJobCanceler interface {
+	CancelPrintJob(jobId int64) (*models.PrintJob, error)
+}
+
+type PrintJobReprinter interface {
+	ReprintJob(jobId int64, override *models.PrintOptions) (*models.PrintJob, error)
+}
+
+// Get handles GET /print_jobs - List print jobs, optionally filtered by
+// template-id or source.
 func (h *PrintJobHandler) Get() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		jobs, err := h.Store.Get()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		templateId := r.URL.Query().Get("template-id")
+		source := r.URL.Query().Get("source")
+
+		if source != "" && !slices.Contains(models.PrintSources, source) {
+			http.Error(w, "source must be one of "+strings.Join(models.PrintSources, ", "), http.StatusBadRequest)
 			return
 		}
 
-		data, err := json.Marshal(jobs)
+		var jobs []models.PrintJob
+		var err error
+
+		switch {
+		case templateId != "":
+			id, parseErr := strconv.ParseInt(templateId, 10, 64)
+			if parseErr != nil {
+				http.Error(w, "template-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			jobs, err = h.Store.GetByTemplateId(id)
+			if source != "" {
+				jobs = slices.DeleteFunc(jobs, func(job models.PrintJob) bool { return job.Source != source })
+			}
+		case source != "":
+			jobs, err = h.Store.GetBySource(source)
+		default:
+			jobs, err = h.Store.Get()
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		response.JSON(w, http.StatusOK, data)
+		response.JSON(w, http.StatusOK, jobs)
 	}
 }
 
@@ -53,13 +90,7 @@ func (h *PrintJobHandler) GetById() http.HandlerFunc {
 			return
 		}
 
-		data, err := json.Marshal(job)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		response.JSON(w, http.StatusOK, data)
+		response.JSON(w, http.StatusOK, job)
 	}
 }
 
@@ -83,12 +114,68 @@ func (h *PrintJobHandler) GetStuck() http.HandlerFunc {
 			return
 		}
 
-		data, err := json.Marshal(jobs)
+		response.JSON(w, http.StatusOK, jobs)
+	}
+}
+
+// Cancel handles POST /print_jobs/{id}/cancel - Cancel a job that has not finished printing.
+func (h *PrintJobHandler) Cancel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		job, err := h.Canceler.CancelPrintJob(id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "Print job not found", http.StatusNotFound)
+			return
+		case errors.Is(err, models.ErrPrintJobFinished):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, job)
+	}
+}
+
+// Reprint handles POST /print_jobs/{id}/reprint - Print the document of a job again.
+// The new job is linked to the original and reuses its print options, which
+// the optional payload can override. Jobs whose document is in the trash
+// can't be reprinted:
+//
+//	{
+//	  "print_options": {"copies": 1}
+//	}
+func (h *PrintJobHandler) Reprint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		override, err := validation.DecodePrintOverride(r.Body)
 		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job, err := h.Reprinter.ReprintJob(id, override)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "Print job or its document not found", http.StatusNotFound)
+			return
+		case err != nil:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		response.JSON(w, http.StatusOK, data)
+		response.JSON(w, http.StatusCreated, job)
 	}
 }
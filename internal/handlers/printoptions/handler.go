@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package printoptions
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"blackoutbox/internal/validation"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+type TagPrintOptionsHandler struct {
+	Store stores.TagPrintOptionsStoreInterface
+}
+
+func (h *TagPrintOptionsHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		systemIdFilter := r.URL.Query().Get("system-id")
+		if systemIdFilter == "" {
+			http.Error(w, "system-id is required", http.StatusBadRequest)
+			return
+		}
+
+		systemId, err := strconv.ParseInt(systemIdFilter, 10, 64)
+		if err != nil {
+			http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		options, err := h.Store.GetBySystemId(systemId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, options)
+	}
+}
+
+// Put creates or replaces the print options for a tag.
+// Expected payload:
+//
+//	{
+//	  "system_id": 1,
+//	  "tag": "care-plan",
+//	  "options": {"sides": "two-sided-long-edge", "media": "A4"}
+//	}
+func (h *TagPrintOptionsHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.TagPrintOptions
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.SystemId == 0 {
+			http.Error(w, "system_id is required", http.StatusBadRequest)
+			return
+		}
+
+		req.Tag = tagexpr.Normalize(req.Tag)
+		if req.Tag == "" {
+			http.Error(w, "tag is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := tagexpr.CheckTag(req.Tag); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Options.IsEmpty() {
+			http.Error(w, "options are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidatePrintOptions(req.Options); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := h.Store.Set(req); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *TagPrintOptionsHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			http.Error(w, "id is required", http.StatusBadRequest)
+			return
+		}
+
+		intId, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := h.Store.GetById(intId); err != nil {
+			http.Error(w, "Tag print options not found", http.StatusNotFound)
+			return
+		}
+
+		if err := h.Store.Delete(intId); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,268 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schedules
+
+import (
+	"blackoutbox/internal/cron"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"blackoutbox/internal/validation"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultUpcomingCount = 10
+	maxUpcomingCount     = 100
+)
+
+type PrintScheduleHandler struct {
+	Store stores.PrintScheduleStoreInterface
+}
+
+type scheduleRequest struct {
+	SystemId      int64   `json:"system_id"`
+	Name          string  `json:"name"`
+	Cron          string  `json:"cron"`
+	TimeZone      string  `json:"time_zone"`
+	Target        string  `json:"target"`
+	FileReference *string `json:"file_id"`
+	Tag           *string `json:"tag"`
+	Mode          string  `json:"mode"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+// decodeSchedule reads and validates a schedule from the request body and
+// works out when it runs next.
+func decodeSchedule(r *http.Request, schedule *models.PrintSchedule) error {
+	var req scheduleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("invalid request body")
+	}
+
+	schedule.SystemId = req.SystemId
+	schedule.Name = req.Name
+	schedule.Cron = req.Cron
+	schedule.TimeZone = req.TimeZone
+	schedule.Target = req.Target
+	schedule.FileReference = req.FileReference
+	schedule.Tag = req.Tag
+	schedule.Mode = req.Mode
+	if schedule.Mode == "" {
+		schedule.Mode = models.PrintModeFull
+	}
+	schedule.Enabled = req.Enabled == nil || *req.Enabled
+
+	if err := validation.ValidatePrintSchedule(*schedule); err != nil {
+		return err
+	}
+
+	// Store tag expressions in their canonical form
+	if schedule.Target == models.ScheduleTargetTag {
+		tags, err := tagexpr.Parse(*schedule.Tag)
+		if err != nil {
+			return err
+		}
+		canonical := tags.String()
+		schedule.Tag = &canonical
+	}
+
+	next, err := cron.NextRun(schedule.Cron, schedule.TimeZone, time.Now())
+	if err != nil {
+		return err
+	}
+
+	nextRunAt := next.Unix()
+	schedule.NextRunAt = &nextRunAt
+
+	return nil
+}
+
+func (h *PrintScheduleHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var schedules []models.PrintSchedule
+		var err error
+
+		if systemIdFilter := r.URL.Query().Get("system-id"); systemIdFilter != "" {
+			systemId, parseErr := strconv.ParseInt(systemIdFilter, 10, 64)
+			if parseErr != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			schedules, err = h.Store.GetBySystemId(systemId)
+		} else {
+			schedules, err = h.Store.Get()
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, schedules)
+	}
+}
+
+func (h *PrintScheduleHandler) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		schedule, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, "Print schedule not found", http.StatusNotFound)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, schedule)
+	}
+}
+
+// Post creates a print schedule. A tag schedule prints the documents
+// matching tag, a tag expression such as "medication and not night".
+// Expected payload:
+//
+//	{
+//	  "system_id": 1,
+//	  "name": "Medication list at shift change",
+//	  "cron": "0 7,15,23 * * *",
+//	  "time_zone": "Europe/Stockholm",
+//	  "target": "tag",
+//	  "tag": "medication",
+//	  "mode": "delta"
+//	}
+func (h *PrintScheduleHandler) Post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var schedule models.PrintSchedule
+		if err := decodeSchedule(r, &schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id, err := h.Store.Add(schedule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		created, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusCreated, created)
+	}
+}
+
+// Put replaces a print schedule, with the same payload as Post.
+func (h *PrintScheduleHandler) Put() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		schedule, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, "Print schedule not found", http.StatusNotFound)
+			return
+		}
+
+		if err := decodeSchedule(r, schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := h.Store.Update(*schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, schedule)
+	}
+}
+
+func (h *PrintScheduleHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := h.Store.GetById(id); err != nil {
+			http.Error(w, "Print schedule not found", http.StatusNotFound)
+			return
+		}
+
+		if err := h.Store.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetUpcoming handles GET /schedules/{id}/upcoming - The next run times of a schedule.
+// The number of runs defaults to 10 and can be set with count (max 100).
+func (h *PrintScheduleHandler) GetUpcoming() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		count := defaultUpcomingCount
+		if countStr := r.URL.Query().Get("count"); countStr != "" {
+			parsed, err := strconv.Atoi(countStr)
+			if err != nil || parsed < 1 || parsed > maxUpcomingCount {
+				http.Error(w, "count must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			count = parsed
+		}
+
+		schedule, err := h.Store.GetById(id)
+		if err != nil {
+			http.Error(w, "Print schedule not found", http.StatusNotFound)
+			return
+		}
+
+		parsed, loc, err := cron.Load(schedule.Cron, schedule.TimeZone)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		times, err := parsed.Upcoming(time.Now(), loc, count)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		runs := make([]models.ScheduledRun, 0, len(times))
+		for _, at := range times {
+			runs = append(runs, models.ScheduledRun{
+				At:    at.Unix(),
+				Local: at.In(loc).Format(time.RFC3339),
+			})
+		}
+
+		response.JSON(w, http.StatusOK, runs)
+	}
+}
@@ -7,7 +7,11 @@ package systems
 import (
 	"blackoutbox/internal/models"
 	"blackoutbox/internal/stores"
+	"blackoutbox/internal/validation"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,6 +19,17 @@ import (
 
 type SystemHandler struct {
 	SystemStore stores.SystemStoreInterface
+	Emergency   EmergencyActivator
+	Index       DocumentIndex
+}
+
+type EmergencyActivator interface {
+	ActivateEmergency(systemId int64, mode string, outageHours *int, override *models.PrintOptions) error
+}
+
+// DocumentIndex is kept up to date with the documents a sync changes.
+type DocumentIndex interface {
+	RequestRefresh()
 }
 
 // Sync replaces all documents and files for a system.
@@ -25,15 +40,16 @@ type SystemHandler struct {
 //	  "documents": [
 //	    {
 //	      "file_id": "file-1",
-//	      "file_path": "uploads/system-123/file1.pdf",
+//	      "file_path": "upload/system-123/file1.pdf",
 //	      "print_at": 1710000000,
-//	      "tags": ["invoice", "pdf"]
+//	      "tags": ["invoice", "pdf"],
+//	      "print_options": {"copies": 2, "sides": "two-sided-long-edge"}
 //	    }
 //	  ]
 //	}
 func (h *SystemHandler) Sync() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		systemRef := r.PathValue("system_id")
+		systemRef := r.PathValue("id")
 		if systemRef == "" {
 			http.Error(w, "system_id is required", http.StatusBadRequest)
 			return
@@ -56,6 +72,27 @@ func (h *SystemHandler) Sync() http.HandlerFunc {
 			return
 		}
 
+		for _, doc := range documents {
+			if err := validation.ValidateSyncedPath(doc.FilePath); err != nil {
+				http.Error(w, fmt.Sprintf("document %s: %s", doc.FileReference, err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			if err := validation.ValidateTags(doc.Tags); err != nil {
+				http.Error(w, fmt.Sprintf("document %s: %s", doc.FileReference, err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			if doc.PrintOptions == nil {
+				continue
+			}
+
+			if err := validation.ValidatePrintOptions(*doc.PrintOptions); err != nil {
+				http.Error(w, fmt.Sprintf("document %s: %s", doc.FileReference, err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+
 		now := time.Now().Unix()
 
 		for i := range documents {
@@ -68,11 +105,78 @@ func (h *SystemHandler) Sync() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		h.reindex()
 
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// ActivateEmergency handles POST /systems/{id}/emergency - Print all documents for a system now.
+// The optional payload selects delta mode, which only prints documents changed
+// since the last emergency print, sets how long the outage is expected to
+// last for printing blank form stock, and overrides the configured print
+// options:
+//
+//	{
+//	  "mode": "delta",
+//	  "outage_hours": 24,
+//	  "print_options": {"copies": 3, "media": "A4"}
+//	}
+func (h *SystemHandler) ActivateEmergency() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reference := r.PathValue("id")
+		if reference == "" {
+			http.Error(w, "System reference is required", http.StatusBadRequest)
+			return
+		}
+
+		system, err := h.SystemStore.GetSystemByReference(reference)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if system == nil {
+			http.Error(w, "System not found", http.StatusNotFound)
+			return
+		}
+
+		req := struct {
+			Mode         string               `json:"mode"`
+			OutageHours  *int                 `json:"outage_hours"`
+			PrintOptions *models.PrintOptions `json:"print_options"`
+		}{Mode: models.PrintModeFull}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidatePrintMode(req.Mode); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateOutageHours(req.OutageHours); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.PrintOptions != nil {
+			if err := validation.ValidatePrintOptions(*req.PrintOptions); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := h.Emergency.ActivateEmergency(system.Id, req.Mode, req.OutageHours, req.PrintOptions); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
 // Delete removes all documents and files for a system.
 func (h *SystemHandler) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -177,6 +281,26 @@ func (h *SystemHandler) CreateSystem() http.HandlerFunc {
 			return
 		}
 
+		if err := validation.ValidatePageStamp(system.Stamp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateOutageHours(system.OutageHours); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateDocumentVersionsKept(system.DocumentVersionsKept); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateTrashDays(system.TrashDays); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Set timestamps
 		now := time.Now().Unix()
 		system.CreatedAt = now
@@ -240,6 +364,26 @@ func (h *SystemHandler) UpdateSystem() http.HandlerFunc {
 			return
 		}
 
+		if err := validation.ValidatePageStamp(updatedSystem.Stamp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateOutageHours(updatedSystem.OutageHours); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateDocumentVersionsKept(updatedSystem.DocumentVersionsKept); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := validation.ValidateTrashDays(updatedSystem.TrashDays); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Set the ID and reference for update
 		updatedSystem.Id = existingSystem.Id
 		if updatedSystem.Reference == "" {
@@ -282,7 +426,18 @@ func (h *SystemHandler) DeleteSystem() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		h.reindex()
 
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// reindex has the search index brought up to date after documents changed. The
+// worker refreshes it, so the request isn't kept waiting on the files
+// being read.
+func (h *SystemHandler) reindex() {
+	if h.Index == nil {
+		return
+	}
+	h.Index.RequestRefresh()
+}
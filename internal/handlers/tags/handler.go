@@ -0,0 +1,40 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tags
+
+import (
+	"blackoutbox/internal/response"
+	"blackoutbox/internal/stores"
+	"net/http"
+	"strconv"
+)
+
+type TagHandler struct {
+	Store stores.TagStoreInterface
+}
+
+// Get handles GET /tags - List the tags in use with how many documents
+// have each, per system. system-id narrows the list to one system.
+func (h *TagHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var systemId *int64
+		if systemIdFilter := r.URL.Query().Get("system-id"); systemIdFilter != "" {
+			id, err := strconv.ParseInt(systemIdFilter, 10, 64)
+			if err != nil {
+				http.Error(w, "system-id must be an integer", http.StatusBadRequest)
+				return
+			}
+			systemId = &id
+		}
+
+		counts, err := h.Store.GetCounts(systemId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, counts)
+	}
+}
@@ -5,11 +5,21 @@
 package templates
 
 import (
+	"blackoutbox/internal/forms"
 	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
 	"blackoutbox/internal/response"
+	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
+	"blackoutbox/internal/validation"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,8 +28,12 @@ import (
 	"time"
 )
 
+// maxDataSize bounds the template data accepted in one request.
+const maxDataSize = 10 << 20 // 10MB
+
 type TemplatesHandler struct {
-	Store stores.TemplateStoreInterface
+	Store     stores.TemplateStoreInterface
+	DataStore stores.TemplateDataStoreInterface
 }
 
 func (h *TemplatesHandler) Get() http.HandlerFunc {
@@ -95,6 +109,11 @@ func (h *TemplatesHandler) Post() http.HandlerFunc {
 			return
 		}
 
+		if strings.Contains(fileId, "..") || strings.ContainsAny(fileId, "/\\") {
+			http.Error(w, "Invalid file_id", http.StatusBadRequest)
+			return
+		}
+
 		file, fileHeader, err := r.FormFile("file")
 		if err != nil {
 			http.Error(w, "file is required", http.StatusBadRequest)
@@ -107,27 +126,32 @@ func (h *TemplatesHandler) Post() http.HandlerFunc {
 			return
 		}
 
-		uploadDir := filepath.Join("templates", fileId)
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
+		filePath, err := saveUpload(fileId, 1, file, fileHeader)
+		if err != nil {
+			log.Printf("Failed to save template upload: %v", err)
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
 			return
 		}
 
-		filename := fmt.Sprintf("%d_%s", time.Now().Unix(), fileHeader.Filename)
-		filePath := filepath.Join(uploadDir, filename)
-
-		dst, err := os.Create(filePath)
+		printOptions, err := validation.ParsePrintOptions(r.FormValue("print_options"))
 		if err != nil {
-			http.Error(w, "Failed to create file", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer dst.Close()
 
-		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		fields, err := validation.ParseTemplateFields(r.FormValue("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if len(fields) > 0 {
+			if err := validateFieldPages(filePath, fields); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		now := time.Now().Unix()
 
 		if err := h.Store.Add(models.Template{
@@ -135,6 +159,8 @@ func (h *TemplatesHandler) Post() http.HandlerFunc {
 			FileReference: fileId,
 			FilePath:      filePath,
 			Description:   r.FormValue("description"),
+			Fields:        fields,
+			PrintOptions:  printOptions,
 			CreatedAt:     now,
 			DeletedAt:     nil,
 		}); err != nil {
@@ -146,8 +172,459 @@ func (h *TemplatesHandler) Post() http.HandlerFunc {
 	}
 }
 
+// GetById handles GET /templates/{id} - Get a template.
+func (h *TemplatesHandler) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		response.JSON(w, http.StatusOK, template)
+	}
+}
+
+// Put handles PUT /templates/{id} - Replace a template, making it a new version.
+// Takes the same multipart form as uploading a template, without system_id
+// and file_id. The file may be left out to keep the current one; the
+// description, fields and print options are replaced by what is sent.
+func (h *TemplatesHandler) Put() http.HandlerFunc {
+	const maxFileSize = 10 << 20 // 10MB
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		if err := r.ParseMultipartForm(maxFileSize); err != nil {
+			http.Error(w, "Unable to parse multipart form", http.StatusBadRequest)
+			return
+		}
+
+		printOptions, err := validation.ParsePrintOptions(r.FormValue("print_options"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fields, err := validation.ParseTemplateFields(r.FormValue("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filePath := template.FilePath
+
+		file, fileHeader, err := r.FormFile("file")
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+		case err != nil:
+			http.Error(w, "Unable to read file", http.StatusBadRequest)
+			return
+		default:
+			defer file.Close()
+
+			if fileHeader.Size > maxFileSize {
+				http.Error(w, "File size exceeds 10MB limit", http.StatusBadRequest)
+				return
+			}
+
+			filePath, err = saveUpload(template.FileReference, template.Version+1, file, fileHeader)
+			if err != nil {
+				log.Printf("Failed to save template upload: %v", err)
+				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if len(fields) > 0 {
+			if err := validateFieldPages(filePath, fields); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		template.FilePath = filePath
+		template.Description = r.FormValue("description")
+		template.Fields = fields
+		template.PrintOptions = printOptions
+
+		err = h.Store.Update(*template)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := h.Store.GetById(template.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, updated)
+	}
+}
+
+// Delete handles DELETE /templates/{id} - Delete a template.
+// The template is no longer listed, attached or printed, but its versions
+// and print history are kept.
 func (h *TemplatesHandler) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "id must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := h.Store.Delete(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Template not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Content handles GET /templates/{id}/content - Download a template's file.
+// An earlier version's file is downloaded with ?version=N.
+func (h *TemplatesHandler) Content() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		filePath := template.FilePath
+
+		if raw := r.URL.Query().Get("version"); raw != "" {
+			version, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, "version must be an integer", http.StatusBadRequest)
+				return
+			}
+
+			templateVersion, err := h.Store.GetVersion(template.Id, version)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Template version not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			filePath = templateVersion.FilePath
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			http.Error(w, "Template file not found", http.StatusNotFound)
+			return
+		}
+
+		data, err := storage.ReadFile(filePath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(filePath)))
+		http.ServeContent(w, r, filePath, info.ModTime(), bytes.NewReader(data))
+	}
+}
+
+// GetVersions handles GET /templates/{id}/versions - List a template's versions, newest first.
+func (h *TemplatesHandler) GetVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		versions, err := h.Store.GetVersions(template.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, versions)
+	}
+}
+
+// Rollback handles POST /templates/{id}/versions/{version}/rollback - Make an earlier version current.
+// The rollback is itself a new version, so the history stays complete.
+func (h *TemplatesHandler) Rollback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		version, err := strconv.Atoi(r.PathValue("version"))
+		if err != nil {
+			http.Error(w, "version must be an integer", http.StatusBadRequest)
+			return
+		}
+
+		err = h.Store.Rollback(template.Id, version)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Template version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := h.Store.GetById(template.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, updated)
+	}
+}
+
+// saveUpload stores an uploaded template file for a version of a template.
+// Files are never overwritten, so earlier versions can still be printed.
+func saveUpload(fileId string, version int, file io.Reader, header *multipart.FileHeader) (string, error) {
+	uploadDir := filepath.Join(storage.TemplatesRoot, fileId)
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	filename := fmt.Sprintf("%d_v%d_%s", time.Now().Unix(), version, filepath.Base(header.Filename))
+	filePath := filepath.Join(uploadDir, filename)
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if err := storage.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save file: %w", err)
+	}
+
+	return filePath, nil
+}
+
+// validateFieldPages checks that a template with fields is a PDF with a
+// page for each of them.
+func validateFieldPages(path string, fields []models.TemplateField) error {
+	data, err := storage.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pages, err := pdf.PageCount(data)
+	if err != nil {
+		return fmt.Errorf("a template with fields must be a readable PDF: %w", err)
+	}
+
+	return validation.ValidateTemplateFieldPages(fields, pages)
+}
+
+// template looks up the template named by the id in the path, writing an
+// error response if there is none.
+func (h *TemplatesHandler) template(w http.ResponseWriter, r *http.Request) (*models.Template, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return nil, false
+	}
+
+	template, err := h.Store.GetById(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && template.DeletedAt != nil) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return template, true
+}
+
+// GetData handles GET /templates/{id}/data - List the data the template is filled in with,
+// for any document and for each document.
+func (h *TemplatesHandler) GetData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		data, err := h.DataStore.GetByTemplateId(template.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.JSON(w, http.StatusOK, data)
+	}
+}
+
+// PutData handles PUT /templates/{id}/data - Replace all data of a template.
+// One filled-in copy is printed per subject, such as per resident. With
+// file-id, only the data printed with that document is replaced:
+//
+//	{
+//	  "resident-12": {"name": "Anna Berg", "room": "3B", "medications": [{"drug": "Waran", "dose": "2.5 mg"}]},
+//	  "resident-14": {"name": "Erik Lund", "room": "4A"}
+//	}
+func (h *TemplatesHandler) PutData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		var payload map[string]map[string]any
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDataSize)).Decode(&payload); err != nil {
+			http.Error(w, "payload must be a JSON object of data objects by subject", http.StatusBadRequest)
+			return
+		}
+
+		data := make([]models.TemplateData, 0, len(payload))
+		for subject, values := range payload {
+			if err := validation.ValidateTemplateSubject(subject); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data = append(data, models.TemplateData{TemplateId: template.Id, Subject: subject, Data: values})
+		}
+
+		if err := h.DataStore.Replace(template.Id, dataFileReference(r), data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PutSubjectData handles PUT /templates/{id}/data/{subject} - Set the data for one subject,
+// for the document given by file-id if any. The payload is the data object itself:
+//
+//	{"name": "Anna Berg", "room": "3B"}
+func (h *TemplatesHandler) PutSubjectData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		subject := r.PathValue("subject")
+		if err := validation.ValidateTemplateSubject(subject); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var values map[string]any
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDataSize)).Decode(&values); err != nil || values == nil {
+			http.Error(w, "payload must be a JSON object", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.DataStore.Set(models.TemplateData{TemplateId: template.Id, FileReference: dataFileReference(r), Subject: subject, Data: values}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteData handles DELETE /templates/{id}/data/{subject} - Remove the data for one subject,
+// for the document given by file-id if any.
+func (h *TemplatesHandler) DeleteData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := h.DataStore.Delete(template.Id, dataFileReference(r), r.PathValue("subject"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Template data not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// dataFileReference is the document template data is for, from the
+// file-id query parameter, or nil for data printed with any document.
+func dataFileReference(r *http.Request) *string {
+	if fileReference := r.URL.Query().Get("file-id"); fileReference != "" {
+		return &fileReference
+	}
+	return nil
+}
+
+// Render handles POST /templates/{id}/render - Preview a template filled in with the posted data.
+// The response is the filled-in PDF. Tables too long for one sheet continue
+// on further sheets, which are selected with ?sheet=2 and so on; the
+// X-Sheet-Count header says how many there are.
+func (h *TemplatesHandler) Render() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		template, ok := h.template(w, r)
+		if !ok {
+			return
+		}
+
+		sheet := 1
+		if raw := r.URL.Query().Get("sheet"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				http.Error(w, "sheet must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			sheet = n
+		}
+
+		var values map[string]any
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDataSize)).Decode(&values); err != nil {
+			http.Error(w, "payload must be a JSON object", http.StatusBadRequest)
+			return
+		}
+
+		file, err := storage.ReadFile(template.FilePath)
+		if err != nil {
+			http.Error(w, "Failed to read template file", http.StatusInternalServerError)
+			return
+		}
+
+		sheets, err := forms.Render(file, template.Fields, values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if sheet > len(sheets) {
+			http.Error(w, fmt.Sprintf("the filled-in template has %d sheets", len(sheets)), http.StatusNotFound)
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("X-Sheet-Count", strconv.Itoa(len(sheets)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(sheets[sheet-1])
 	}
 }
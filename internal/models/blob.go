@@ -0,0 +1,16 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// Blob is an uploaded file in the content-addressed store. Identical
+// uploads share a blob; RefCount is how many documents, document versions
+// and print jobs use it.
+type Blob struct {
+	Path      string `json:"path"`
+	Hash      string `json:"hash"` // hex SHA-256
+	Size      int64  `json:"size"`
+	RefCount  int64  `json:"ref_count"`
+	CreatedAt int64  `json:"created_at"`
+}
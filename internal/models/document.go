@@ -4,14 +4,75 @@
 
 package models
 
+import "errors"
+
+// Conversion states of a document. Files printers handle badly, such as
+// images or Markdown, are converted to a PDF stored next to the original.
+// Pending documents are converted when they are next printed.
+const (
+	ConversionPending     = "pending"
+	ConversionNotNeeded   = "not_needed"
+	ConversionConverted   = "converted"
+	ConversionUnsupported = "unsupported"
+	ConversionFailed      = "failed"
+)
+
 type Document struct {
-	Id            int64    `json:"id"`
-	SystemId      int64    `json:"system_id"`
-	FileReference string   `json:"file_id"`
-	FilePath      string   `json:"file_path"`
-	PrintAt       *int64   `json:"print_at"`
-	LastPrintedAt *int64   `json:"last_printed_at"`
-	Tags          []string `json:"tags"`
-	UpdatedAt     *int64   `json:"updated_at"`
-	DeletedAt     *int64   `json:"deleted_at"`
+	Id               int64         `json:"id"`
+	SystemId         int64         `json:"system_id"`
+	FileReference    string        `json:"file_id"`
+	FilePath         string        `json:"file_path"`
+	FileSize         *int64        `json:"file_size"`
+	FileHash         *string       `json:"file_hash"` // hex SHA-256
+	UploadedBy       *string       `json:"uploaded_by"`
+	ConversionStatus string        `json:"conversion_status"` // pending, not_needed, converted, unsupported, failed
+	ConvertedPath    *string       `json:"converted_path"`
+	ConvertedHash    *string       `json:"converted_hash"` // hex SHA-256 of the conversion
+	ConversionError  *string       `json:"conversion_error"`
+	PrintAt          *int64        `json:"print_at"`
+	LastPrintedAt    *int64        `json:"last_printed_at"`
+	MissedPrintAt    *int64        `json:"missed_print_at"`
+	Tags             []string      `json:"tags"`
+	PrintOptions     *PrintOptions `json:"print_options"`
+	Version          int64         `json:"version"` // bumped on every update
+	UpdatedAt        *int64        `json:"updated_at"`
+	DeletedAt        *int64        `json:"deleted_at"`
+}
+
+// DefaultTrashDays is how long deleted documents are kept for systems
+// that don't set trash_days.
+const DefaultTrashDays = 30
+
+// DeletedDocument is a document in the trash, which is purged with its
+// files at PurgeAt unless it is restored first.
+type DeletedDocument struct {
+	Document
+	PurgeAt int64 `json:"purge_at"`
+}
+
+// DocumentVersion is a file a document has had. A version is recorded
+// whenever a document's file changes, numbered by the document version
+// that set it, so versions of a document need not be consecutive.
+type DocumentVersion struct {
+	Id         int64   `json:"id"`
+	DocumentId int64   `json:"document_id"`
+	Version    int64   `json:"version"`
+	FilePath   string  `json:"file_path"`
+	FileSize   *int64  `json:"file_size"`
+	FileHash   *string `json:"file_hash"`
+	UploadedBy *string `json:"uploaded_by"`
+	CreatedAt  int64   `json:"created_at"`
+}
+
+// ErrVersionConflict is returned when a document was updated by someone
+// else since the version the update was based on.
+var ErrVersionConflict = errors.New("document has been changed since it was read")
+
+// PrintPath returns the file to send to the printer: the converted PDF if
+// there is one, otherwise the original.
+func (d Document) PrintPath() string {
+	if d.ConversionStatus == ConversionConverted && d.ConvertedPath != nil {
+		return *d.ConvertedPath
+	}
+	return d.FilePath
 }
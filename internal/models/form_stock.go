@@ -0,0 +1,42 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// DefaultOutageHours is how long an outage is expected to last for systems
+// that don't set outage_hours.
+const DefaultOutageHours = 8
+
+// FormStockRule asks for blank copies of a template to be printed in an
+// emergency, so a ward doesn't run out of paper forms during a long
+// outage. An empty ward means the whole system.
+type FormStockRule struct {
+	Id            int64  `json:"id"`
+	SystemId      int64  `json:"system_id"`
+	Ward          string `json:"ward"`
+	TemplateId    int64  `json:"template_id"`
+	CopiesPerHour int    `json:"copies_per_hour"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
+}
+
+// Copies returns how many blank copies a rule asks for when an outage is
+// expected to last the given number of hours.
+func (r FormStockRule) Copies(hours int) int {
+	return r.CopiesPerHour * hours
+}
+
+// FormStockCount is how many blank copies of a template were printed for
+// a ward in one print batch, such as one emergency. Queued counts every
+// copy asked for, printed only those of completed jobs.
+type FormStockCount struct {
+	BatchId         *int64 `json:"batch_id"`
+	Reason          string `json:"reason"`
+	QueuedAt        int64  `json:"queued_at"`
+	Ward            string `json:"ward"`
+	TemplateId      *int64 `json:"template_id"`
+	TemplateVersion *int   `json:"template_version"`
+	Queued          int    `json:"queued"`
+	Printed         int    `json:"printed"`
+}
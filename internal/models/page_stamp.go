@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+import "strings"
+
+// Default page stamp text, used for parts a system doesn't configure.
+const (
+	DefaultStampHeader    = "EMERGENCY COPY · {system}"
+	DefaultStampFooter    = "{file_id} · Version {version} · Printed {printed_at} · Page {page} of {pages}"
+	DefaultStampWatermark = "EMERGENCY COPY"
+)
+
+// StampPlaceholders can be used in page stamp text. {system} and {file_id}
+// are filled in when a job is queued, {printed_at} and {version} when it
+// is sent to the printer, and {page} and {pages} on each page.
+var StampPlaceholders = []string{"{system}", "{file_id}", "{printed_at}", "{version}", "{page}", "{pages}"}
+
+// PageStamp is the text stamped onto every page of a printed document, so
+// paper copies can be told apart from older versions. A nil part uses the
+// default text and an empty one leaves that part out.
+type PageStamp struct {
+	Disabled  bool    `json:"disabled"`
+	Header    *string `json:"header"`
+	Footer    *string `json:"footer"`
+	Watermark *string `json:"watermark"`
+}
+
+// ForDocument returns the stamp for a document of a system, with defaults
+// applied and the system and document filled in. It returns nil if
+// stamping is disabled.
+func (s *PageStamp) ForDocument(systemName, fileReference string) *PageStamp {
+	if s != nil && s.Disabled {
+		return nil
+	}
+
+	replacer := strings.NewReplacer("{system}", systemName, "{file_id}", fileReference)
+	part := func(value *string, fallback string) *string {
+		if value != nil {
+			fallback = *value
+		}
+		resolved := replacer.Replace(fallback)
+		return &resolved
+	}
+
+	var configured PageStamp
+	if s != nil {
+		configured = *s
+	}
+
+	return &PageStamp{
+		Header:    part(configured.Header, DefaultStampHeader),
+		Footer:    part(configured.Footer, DefaultStampFooter),
+		Watermark: part(configured.Watermark, DefaultStampWatermark),
+	}
+}
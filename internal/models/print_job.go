@@ -4,12 +4,162 @@
 
 package models
 
+import (
+	"errors"
+	"slices"
+)
+
+// Print job states. A job starts out queued, becomes submitted once the
+// printer backend has accepted it, and from then on mirrors the state
+// reported by CUPS until it reaches one of the terminal states.
+const (
+	PrintJobQueued     = "queued"
+	PrintJobSubmitted  = "submitted"
+	PrintJobProcessing = "processing"
+	PrintJobHeld       = "held"
+	PrintJobStopped    = "stopped"
+	PrintJobCanceled   = "canceled"
+	PrintJobAborted    = "aborted"
+	PrintJobCompleted  = "completed"
+)
+
+// Where a print job came from.
+const (
+	PrintOriginEmergency = "emergency"
+	PrintOriginManual    = "manual"
+	PrintOriginReprint   = "reprint"
+	PrintOriginScheduled = "scheduled"
+)
+
+// What a print job prints. Cover sheets, separators and reports are
+// generated when printing and have neither a document nor a template.
+const (
+	PrintSourceDocument   = "document"
+	PrintSourceTemplate   = "template"
+	PrintSourceCoverSheet = "cover_sheet"
+	PrintSourceSeparator  = "separator"
+	PrintSourceReport     = "report"
+)
+
+// PrintSources lists every print job source.
+var PrintSources = []string{
+	PrintSourceDocument,
+	PrintSourceTemplate,
+	PrintSourceCoverSheet,
+	PrintSourceSeparator,
+	PrintSourceReport,
+}
+
+var ErrPrintJobFinished = errors.New("print job has already finished")
+
+// printJobTransitions lists the states a job may move to from each state.
+// Jobs are polled periodically, so intermediate states may be skipped.
+var printJobTransitions = map[string][]string{
+	PrintJobQueued: {
+		PrintJobSubmitted, PrintJobCanceled, PrintJobAborted,
+	},
+	PrintJobSubmitted: {
+		PrintJobProcessing, PrintJobHeld, PrintJobStopped,
+		PrintJobCanceled, PrintJobAborted, PrintJobCompleted,
+	},
+	PrintJobProcessing: {
+		PrintJobSubmitted, PrintJobHeld, PrintJobStopped,
+		PrintJobCanceled, PrintJobAborted, PrintJobCompleted,
+	},
+	PrintJobHeld: {
+		PrintJobSubmitted, PrintJobProcessing, PrintJobStopped,
+		PrintJobCanceled, PrintJobAborted, PrintJobCompleted,
+	},
+	PrintJobStopped: {
+		PrintJobSubmitted, PrintJobProcessing, PrintJobHeld,
+		PrintJobCanceled, PrintJobAborted, PrintJobCompleted,
+	},
+}
+
+// ActivePrintJobStates are the non-terminal states that still need polling.
+var ActivePrintJobStates = []string{
+	PrintJobQueued,
+	PrintJobSubmitted,
+	PrintJobProcessing,
+	PrintJobHeld,
+	PrintJobStopped,
+}
+
 type PrintJob struct {
-	Id           int64   `json:"id"`
-	DocumentId   int64   `json:"document_id"`
-	CupsJobId    *string `json:"cups_job_id"`
-	Status       string  `json:"status"` // pending, printing, completed, failed
-	SubmittedAt  int64   `json:"submitted_at"`
-	CompletedAt  *int64  `json:"completed_at"`
-	ErrorMessage *string `json:"error_message"`
+	Id                int64         `json:"id"`
+	BatchId           *int64        `json:"batch_id"`
+	Source            string        `json:"source"`      // document, template, cover_sheet, separator, report
+	DocumentId        *int64        `json:"document_id"` // set for document jobs
+	TemplateId        *int64        `json:"template_id"` // set for template jobs
+	TemplateVersion   *int          `json:"template_version"`
+	StockWard         *string       `json:"stock_ward"` // set for blank form stock, "" for the whole system
+	FilePath          string        `json:"file_path"`
+	CupsJobId         *string       `json:"cups_job_id"`
+	Status            string        `json:"status"` // queued, submitted, processing, held, stopped, canceled, aborted, completed
+	StateReason       *string       `json:"state_reason"`
+	Options           *PrintOptions `json:"options"`
+	Stamp             *PageStamp    `json:"stamp"`
+	Origin            string        `json:"origin"` // emergency, manual, reprint, scheduled
+	ParentJobId       *int64        `json:"parent_job_id"`
+	Binder            *string       `json:"binder"`
+	ContentHash       *string       `json:"content_hash"`
+	DocumentUpdatedAt *int64        `json:"document_updated_at"`
+	DispatchAttempts  int           `json:"dispatch_attempts"`
+	DispatchStartedAt *int64        `json:"dispatch_started_at"`
+	NextDispatchAt    *int64        `json:"next_dispatch_at"` // when a job lp failed to take is retried
+	QueuedAt          int64         `json:"queued_at"`
+	SubmittedAt       *int64        `json:"submitted_at"`
+	CompletedAt       *int64        `json:"completed_at"`
+	CanceledAt        *int64        `json:"canceled_at"`
+	LastCheckedAt     *int64        `json:"last_checked_at"`
+	ErrorMessage      *string       `json:"error_message"`
+}
+
+// PrintBatch groups print jobs that are queued together, such as every
+// sheet and document printed for one emergency. Queuing a batch whose key
+// has been queued before does nothing, so a batch survives being retried.
+type PrintBatch struct {
+	Id        int64   `json:"id"`
+	Key       *string `json:"key"`
+	SystemId  *int64  `json:"system_id"`
+	Reason    string  `json:"reason"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+// PrintRequest describes a file to print and why it is being printed.
+// Generated sheets have neither a DocumentId nor a TemplateId. Template
+// requests also carry the template version being printed, and blank form
+// stock the ward it is printed for.
+type PrintRequest struct {
+	Source          string
+	DocumentId      *int64
+	TemplateId      *int64
+	TemplateVersion *int
+	StockWard       *string
+	FilePath        string
+	Options         PrintOptions
+	Stamp           *PageStamp
+	Origin          string
+	ParentJobId     *int64
+
+	// Binder is set for jobs that keep a binder up to date. Once such a
+	// job completes, the printed content becomes the binder's version.
+	Binder            string
+	ContentHash       string
+	DocumentUpdatedAt *int64
+}
+
+// IsTerminalPrintJobState reports whether a job in the given state will never change again.
+func IsTerminalPrintJobState(state string) bool {
+	return state == PrintJobCanceled || state == PrintJobAborted || state == PrintJobCompleted
+}
+
+// CanTransitionPrintJob reports whether a job may move from one state to another.
+// Staying in the same state is always allowed.
+func CanTransitionPrintJob(from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	return slices.Contains(printJobTransitions[from], to)
 }
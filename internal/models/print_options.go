@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// PrintOptions are the printer settings for a job. Unset fields fall back
+// to whatever is configured at a lower level, and finally the printer default.
+type PrintOptions struct {
+	Copies      *int    `json:"copies,omitempty"`
+	Sides       *string `json:"sides,omitempty"`       // one-sided, two-sided-long-edge, two-sided-short-edge
+	Media       *string `json:"media,omitempty"`       // A3, A4, A5, Letter, Legal
+	PageRanges  *string `json:"page_ranges,omitempty"` // e.g. 1-3,5
+	Orientation *string `json:"orientation,omitempty"` // portrait, landscape
+}
+
+// TagPrintOptions are the print options applied to every document in a
+// system carrying the tag.
+type TagPrintOptions struct {
+	Id        int64        `json:"id"`
+	SystemId  int64        `json:"system_id"`
+	Tag       string       `json:"tag"`
+	Options   PrintOptions `json:"options"`
+	CreatedAt int64        `json:"created_at"`
+	UpdatedAt int64        `json:"updated_at"`
+}
+
+// Merge returns a copy of o with every field set in other taking precedence.
+func (o PrintOptions) Merge(other *PrintOptions) PrintOptions {
+	if other == nil {
+		return o
+	}
+
+	if other.Copies != nil {
+		o.Copies = other.Copies
+	}
+	if other.Sides != nil {
+		o.Sides = other.Sides
+	}
+	if other.Media != nil {
+		o.Media = other.Media
+	}
+	if other.PageRanges != nil {
+		o.PageRanges = other.PageRanges
+	}
+	if other.Orientation != nil {
+		o.Orientation = other.Orientation
+	}
+
+	return o
+}
+
+// IsEmpty reports whether no option is set.
+func (o PrintOptions) IsEmpty() bool {
+	return o.Copies == nil && o.Sides == nil && o.Media == nil && o.PageRanges == nil && o.Orientation == nil
+}
@@ -0,0 +1,41 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// What a print schedule prints on each run.
+const (
+	ScheduleTargetDocument = "document"
+	ScheduleTargetTag      = "tag"
+	ScheduleTargetSystem   = "system"
+)
+
+// PrintSchedule prints documents repeatedly, following a cron expression
+// evaluated in an IANA time zone. Targets are resolved on every run, so
+// each run prints the current version of the documents.
+type PrintSchedule struct {
+	Id            int64   `json:"id"`
+	SystemId      int64   `json:"system_id"`
+	Name          string  `json:"name"`
+	Cron          string  `json:"cron"`
+	TimeZone      string  `json:"time_zone"`
+	Target        string  `json:"target"` // document, tag, system
+	FileReference *string `json:"file_id"`
+	Tag           *string `json:"tag"`
+	Mode          string  `json:"mode"` // full, delta
+	Enabled       bool    `json:"enabled"`
+	NextRunAt     *int64  `json:"next_run_at"`
+	LastRunAt     *int64  `json:"last_run_at"`
+	FailedRuns    int     `json:"failed_runs"`   // failed attempts at the run due at NextRunAt
+	RetryAt       *int64  `json:"retry_at"`      // when a failed run is tried again
+	MissedRunAt   *int64  `json:"missed_run_at"` // the last run that wasn't printed
+	CreatedAt     int64   `json:"created_at"`
+	UpdatedAt     int64   `json:"updated_at"`
+}
+
+// ScheduledRun is an upcoming run of a print schedule.
+type ScheduledRun struct {
+	At    int64  `json:"at"`
+	Local string `json:"local"` // RFC 3339 in the schedule's time zone
+}
@@ -0,0 +1,39 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+import "fmt"
+
+// Print modes. A full print prints every targeted document, a delta print
+// only those that are new or changed since they were last printed.
+const (
+	PrintModeFull  = "full"
+	PrintModeDelta = "delta"
+)
+
+// PrintedVersion is the version of a document that was last printed
+// successfully into a binder. A binder is the pile of paper kept up to
+// date by one emergency setup or print schedule, so delta prints can tell
+// what in it is out of date.
+type PrintedVersion struct {
+	Binder            string   `json:"binder"`
+	SystemId          int64    `json:"system_id"`
+	FileReference     string   `json:"file_id"`
+	Tags              []string `json:"tags"`
+	ContentHash       string   `json:"content_hash"`
+	DocumentUpdatedAt *int64   `json:"document_updated_at"`
+	PrintJobId        int64    `json:"print_job_id"`
+	PrintedAt         int64    `json:"printed_at"`
+}
+
+// EmergencyBinder names the binder printed when a system's emergency fires.
+func EmergencyBinder(systemId int64) string {
+	return fmt.Sprintf("emergency:%d", systemId)
+}
+
+// ScheduleBinder names the binder a print schedule keeps up to date.
+func ScheduleBinder(scheduleId int64) string {
+	return fmt.Sprintf("schedule:%d", scheduleId)
+}
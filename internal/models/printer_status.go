@@ -0,0 +1,32 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+const (
+	PrinterIdle        = "idle"
+	PrinterProcessing  = "processing"
+	PrinterStopped     = "stopped"
+	PrinterUnreachable = "unreachable"
+)
+
+type PrinterStatus struct {
+	Id           int64           `json:"id"`
+	PrinterName  string          `json:"printer_name"`
+	State        string          `json:"state"` // idle, processing, stopped, unreachable
+	StateReasons []string        `json:"state_reasons"`
+	Markers      []PrinterMarker `json:"markers"`
+	Ready        bool            `json:"ready"`
+	Warnings     []string        `json:"warnings"`
+	CheckedAt    int64           `json:"checked_at"`
+}
+
+// PrinterMarker is a consumable such as toner or ink. Level is a percentage,
+// or negative when the printer can't report it.
+type PrinterMarker struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Level    int    `json:"level"`
+	LowLevel int    `json:"low_level"`
+}
@@ -0,0 +1,54 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// Kinds of stored files.
+const (
+	StoredBlob       = "blob"       // an uploaded document file
+	StoredConversion = "conversion" // the PDF conversion of a document
+	StoredDocument   = "document"   // a document file outside the blob store
+	StoredTemplate   = "template"
+)
+
+// Problems a scrub can find with a stored file.
+const (
+	ScrubMissing  = "missing"
+	ScrubCorrupt  = "corrupt"
+	ScrubOrphaned = "orphaned"
+)
+
+// StoredFile is a file the box relies on. Hash and Size are set when they
+// were recorded when the file was stored.
+type StoredFile struct {
+	Path string
+	Kind string
+	Hash *string
+	Size *int64
+}
+
+type ScrubProblem struct {
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+	Problem string `json:"problem"` // missing, corrupt, orphaned
+	Detail  string `json:"detail,omitempty"`
+}
+
+// ScrubReport is the outcome of checking every stored file.
+type ScrubReport struct {
+	Id           int64          `json:"id"`
+	StartedAt    int64          `json:"started_at"`
+	FinishedAt   int64          `json:"finished_at"`
+	FilesChecked int            `json:"files_checked"`
+	Missing      int            `json:"missing"`
+	Corrupt      int            `json:"corrupt"`
+	Orphaned     int            `json:"orphaned"`
+	Problems     []ScrubProblem `json:"problems"`
+}
+
+// Healthy reports whether every file the box needs is intact. Orphaned
+// files waste space but don't stop anything from printing.
+func (r ScrubReport) Healthy() bool {
+	return r.Missing == 0 && r.Corrupt == 0
+}
@@ -0,0 +1,25 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+import "blackoutbox/internal/tagexpr"
+
+// SearchQuery is a full-text search over document contents. Documents
+// must match Tags, if it is set.
+type SearchQuery struct {
+	Text     string
+	SystemId *int64
+	Tags     *tagexpr.Expr
+	Limit    int
+}
+
+// SearchResult is a document matching a search, best match first. The
+// snippet shows the matched words in <mark> tags; lower ranks are better.
+type SearchResult struct {
+	DocumentId int64     `json:"document_id"`
+	Snippet    string    `json:"snippet"`
+	Rank       float64   `json:"rank"`
+	Document   *Document `json:"document"`
+}
@@ -5,11 +5,16 @@
 package models
 
 type System struct {
-	Id          int64  `json:"id"`
-	Reference   string `json:"reference"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	CreatedAt   int64  `json:"created_at"`
-	UpdatedAt   int64  `json:"updated_at"`
-	DeletedAt   *int64 `json:"deleted_at"`
+	Id                   int64      `json:"id"`
+	Reference            string     `json:"reference"`
+	Name                 string     `json:"name"`
+	Description          string     `json:"description"`
+	PrintSeparators      bool       `json:"print_separators"`
+	Stamp                *PageStamp `json:"stamp"`
+	OutageHours          *int       `json:"outage_hours"`           // DefaultOutageHours when unset
+	DocumentVersionsKept *int       `json:"document_versions_kept"` // all versions are kept when unset
+	TrashDays            *int       `json:"trash_days"`             // DefaultTrashDays when unset
+	CreatedAt            int64      `json:"created_at"`
+	UpdatedAt            int64      `json:"updated_at"`
+	DeletedAt            *int64     `json:"deleted_at"`
 }
@@ -0,0 +1,12 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package models
+
+// TagCount is how many of a system's documents have a tag.
+type TagCount struct {
+	SystemId  int64  `json:"system_id"`
+	Tag       string `json:"tag"`
+	Documents int    `json:"documents"`
+}
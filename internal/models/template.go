@@ -5,12 +5,80 @@
 package models
 
 type Template struct {
-	Id          int64  `json:"id"`
-	SystemId    int64  `json:"system_id"`
+	Id            int64           `json:"id"`
+	SystemId      int64           `json:"system_id"`
+	FileReference string          `json:"file_id"`
+	FilePath      string          `json:"file_path"`
+	Description   string          `json:"description"`
+	Fields        []TemplateField `json:"fields"`
+	PrintOptions  *PrintOptions   `json:"print_options"`
+	Version       int             `json:"version"`
+	CreatedAt     int64           `json:"created_at"`
+	UpdatedAt     int64           `json:"updated_at"`
+	DeletedAt     *int64          `json:"deleted_at"`
+}
+
+// TemplateVersion is a snapshot of a template as it was at one version.
+// Every change to a template's file, fields, description or print options
+// creates a new version.
+type TemplateVersion struct {
+	Id           int64           `json:"id"`
+	TemplateId   int64           `json:"template_id"`
+	Version      int             `json:"version"`
+	FilePath     string          `json:"file_path"`
+	Description  string          `json:"description"`
+	Fields       []TemplateField `json:"fields"`
+	PrintOptions *PrintOptions   `json:"print_options"`
+	CreatedAt    int64           `json:"created_at"`
+}
+
+// TemplateField is a place on a PDF template where a value from the
+// template's data is printed. Positions are in points from the bottom left
+// corner of the page. A field with columns is a table: its value is a list
+// of rows, printed row_height apart starting at y.
+type TemplateField struct {
+	Name      string           `json:"name"` // key in the data, nested keys joined with dots
+	Page      int              `json:"page"` // 1 when unset
+	X         float64          `json:"x"`
+	Y         float64          `json:"y"`
+	Size      float64          `json:"size"`  // font size, 10 when unset
+	Width     float64          `json:"width"` // longer values are shortened to fit, unlimited when unset
+	Bold      bool             `json:"bold"`
+	Columns   []TemplateColumn `json:"columns,omitempty"`
+	RowHeight float64          `json:"row_height,omitempty"` // 1.5 times the font size when unset
+	MaxRows   int              `json:"max_rows,omitempty"`   // rows that fit above the page margin when unset
+}
+
+// TemplateColumn is one column of a table field.
+type TemplateColumn struct {
+	Name  string  `json:"name"` // key in each row
+	X     float64 `json:"x"`
+	Width float64 `json:"width"`
+}
+
+// TemplateData is the data one filled-in copy of a template is printed
+// with. Subject says who or what the data is about, such as a resident.
+// Data for a document is only printed with that document; data without
+// one is printed once per emergency, with the first document the template
+// is attached to.
+type TemplateData struct {
+	Id            int64          `json:"id"`
+	TemplateId    int64          `json:"template_id"`
+	FileReference *string        `json:"file_id"`
+	Subject       string         `json:"subject"`
+	Data          map[string]any `json:"data"`
+	UpdatedAt     int64          `json:"updated_at"`
+}
+
+// TemplateAttachment attaches a template to a document, so that it is
+// printed after the document in an emergency. Attachments follow the
+// document's file_id, so they survive a sync.
+type TemplateAttachment struct {
+	Id            int64  `json:"id"`
+	SystemId      int64  `json:"system_id"`
 	FileReference string `json:"file_id"`
-	FilePath    string `json:"file_path"`
-	Description string `json:"description"`
-	CreatedAt   int64  `json:"created_at"`
-	UpdatedAt   int64  `json:"updated_at"`
-	DeletedAt   *int64 `json:"deleted_at"`
+	TemplateId    int64  `json:"template_id"`
+	Position      int    `json:"position"`
+	Copies        int    `json:"copies"`
+	CreatedAt     int64  `json:"created_at"`
 }
@@ -5,11 +5,22 @@
 package monitor
 
 import (
+	"blackoutbox/internal/convert"
+	"blackoutbox/internal/cron"
+	"blackoutbox/internal/forms"
 	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/sheets"
+	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"blackoutbox/internal/validation"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -17,33 +28,82 @@ const (
 	maxRetries     = 3
 	checkTimeout   = 10 * time.Second
 	triggeredState = "triggered"
+
+	// scheduleCatchUpWindow is how late a scheduled print may still go out,
+	// for example after a reboot. Older schedules are marked as missed.
+	scheduleCatchUpWindow = 12 * time.Hour
+
+	// A print schedule run that fails is tried again, waiting twice as
+	// long after each failure, and given up on after maxScheduleRunAttempts
+	// so a lasting problem doesn't hold up the schedule's next run.
+	maxScheduleRunAttempts = 5
+	minScheduleRetryDelay  = time.Minute
 )
 
 type Monitor struct {
-	triggerStore    stores.TriggerStoreInterface
-	documentStore   stores.DocumentStoreInterface
-	templateStore   stores.TemplateStoreInterface
-	printJobStore   stores.PrintJobStoreInterface
-	printJobCreator PrintJobCreator
+	systemStore             stores.SystemStoreInterface
+	triggerStore            stores.TriggerStoreInterface
+	documentStore           stores.DocumentStoreInterface
+	templateStore           stores.TemplateStoreInterface
+	templateDataStore       stores.TemplateDataStoreInterface
+	templateAttachmentStore stores.TemplateAttachmentStoreInterface
+	formStockStore          stores.FormStockStoreInterface
+	printJobStore           stores.PrintJobStoreInterface
+	tagPrintOptionsStore    stores.TagPrintOptionsStoreInterface
+	printScheduleStore      stores.PrintScheduleStoreInterface
+	printedVersionStore     stores.PrintedVersionStoreInterface
+	printJobCreator         PrintJobCreator
 }
 
 type PrintJobCreator interface {
-	CreatePrintJob(documentId int64, filePath string) error
+	CreatePrintJob(req models.PrintRequest) (int64, error)
+	PrintBatch(batch models.PrintBatch, reqs []models.PrintRequest) (bool, error)
+}
+
+// incident describes why an emergency print batch was started.
+type incident struct {
+	// key identifies the batch, so an incident that is handled again, for
+	// example after a restart, isn't printed twice. Empty for incidents
+	// that should always print.
+	key       string
+	systemId  int64
+	reason    string
+	startedAt time.Time
+	mode      string
+	override  *models.PrintOptions
+
+	// outageHours is how long the outage is expected to last, for
+	// printing blank form stock. Nil uses the system's setting.
+	outageHours *int
 }
 
 func NewMonitor(
+	systemStore stores.SystemStoreInterface,
 	triggerStore stores.TriggerStoreInterface,
 	documentStore stores.DocumentStoreInterface,
 	templateStore stores.TemplateStoreInterface,
+	templateDataStore stores.TemplateDataStoreInterface,
+	templateAttachmentStore stores.TemplateAttachmentStoreInterface,
+	formStockStore stores.FormStockStoreInterface,
 	printJobStore stores.PrintJobStoreInterface,
+	tagPrintOptionsStore stores.TagPrintOptionsStoreInterface,
+	printScheduleStore stores.PrintScheduleStoreInterface,
+	printedVersionStore stores.PrintedVersionStoreInterface,
 	printJobCreator PrintJobCreator,
 ) *Monitor {
 	return &Monitor{
-		triggerStore:    triggerStore,
-		documentStore:   documentStore,
-		templateStore:   templateStore,
-		printJobStore:   printJobStore,
-		printJobCreator: printJobCreator,
+		systemStore:             systemStore,
+		triggerStore:            triggerStore,
+		documentStore:           documentStore,
+		templateStore:           templateStore,
+		templateDataStore:       templateDataStore,
+		templateAttachmentStore: templateAttachmentStore,
+		formStockStore:          formStockStore,
+		printJobStore:           printJobStore,
+		tagPrintOptionsStore:    tagPrintOptionsStore,
+		printScheduleStore:      printScheduleStore,
+		printedVersionStore:     printedVersionStore,
+		printJobCreator:         printJobCreator,
 	}
 }
 
@@ -109,11 +169,25 @@ func (m *Monitor) handleFailure(trigger models.Trigger, now int64, reason string
 		failureDuration := now - *trigger.LastFailedAt
 		if failureDuration >= int64(trigger.BufferSeconds) {
 			log.Printf("Trigger %d buffer exceeded (%ds), triggering print jobs", trigger.Id, failureDuration)
+
+			// Queue the batch before marking the trigger, so a crash in
+			// between queues it again rather than never printing it. The
+			// batch key makes queuing it again harmless.
+			if err := m.triggerPrintJobs(incident{
+				key:       fmt.Sprintf("trigger:%d:%d", trigger.Id, *trigger.LastFailedAt),
+				systemId:  trigger.SystemId,
+				reason:    fmt.Sprintf("Health check of %s failed: %s", trigger.Url, reason),
+				startedAt: time.Unix(*trigger.LastFailedAt, 0),
+				mode:      models.PrintModeFull,
+			}); err != nil {
+				return err
+			}
+
 			trigger.Status = triggeredState
 			if err := m.triggerStore.UpdateStatus(triggerId, triggeredState); err != nil {
 				return fmt.Errorf("failed to update trigger status: %w", err)
 			}
-			return m.triggerPrintJobs(trigger.SystemId)
+			return nil
 		}
 	}
 
@@ -134,33 +208,809 @@ func (m *Monitor) handleSuccess(trigger models.Trigger, now int64) error {
 	return m.triggerStore.Update(trigger)
 }
 
-func (m *Monitor) triggerPrintJobs(systemId int64) error {
-	documents, err := m.documentStore.GetBySystemId(systemId)
+// ActivateEmergency prints the documents for a system right away, without
+// waiting for a trigger to fail. In delta mode only documents that changed
+// since the last emergency print are printed. Options set in override take
+// precedence over the configured print options. Blank form stock is printed
+// for outageHours, or the system's expected outage length when nil.
+func (m *Monitor) ActivateEmergency(systemId int64, mode string, outageHours *int, override *models.PrintOptions) error {
+	log.Printf("Emergency manually activated for system %d (%s)", systemId, mode)
+	return m.triggerPrintJobs(incident{
+		systemId:    systemId,
+		reason:      "Manual emergency activation",
+		startedAt:   time.Now(),
+		mode:        mode,
+		override:    override,
+		outageHours: outageHours,
+	})
+}
+
+func (m *Monitor) triggerPrintJobs(inc incident) error {
+	system, err := m.systemStore.GetSystemById(inc.systemId)
 	if err != nil {
-		return fmt.Errorf("failed to get documents for system %s: %w", systemId, err)
+		return fmt.Errorf("failed to get system %d: %w", inc.systemId, err)
+	}
+	if system == nil {
+		return fmt.Errorf("system %d not found", inc.systemId)
 	}
 
-	for _, doc := range documents {
-		templates, err := m.templateStore.GetByFileReference(doc.FileReference)
+	documents, err := m.documentStore.GetBySystemId(inc.systemId)
+	if err != nil {
+		return fmt.Errorf("failed to get documents for system %d: %w", inc.systemId, err)
+	}
+	for i := range documents {
+		m.convertDocument(&documents[i])
+	}
+
+	tagOptions, err := m.tagPrintOptions(inc.systemId)
+	if err != nil {
+		log.Printf("Failed to gather tag print options for system %d: %v", inc.systemId, err)
+	}
+
+	binder := models.EmergencyBinder(system.Id)
+
+	// Blank forms run out in every outage, so stock is printed even when
+	// no document changed.
+	stock := m.formStockRequests(system, outageHours(system, inc.outageHours), nil, inc.override, models.PrintOriginEmergency)
+
+	var changes *sheets.ChangeSummary
+	if inc.mode == models.PrintModeDelta {
+		changed, summary, err := m.planDelta(system.Name, binder, documents)
 		if err != nil {
-			log.Printf("Failed to gather templates from db for document %d: %v", doc.Id, err)
+			return fmt.Errorf("failed to compare documents with the last print: %w", err)
+		}
+		if summary.IsEmpty() && len(stock) == 0 {
+			log.Printf("No documents changed for system %d since the last print", system.Id)
+			return nil
 		}
+		documents, changes = changed, &summary
+	}
+
+	// Everything is queued as one batch, so after a crash the batch is
+	// either printed in full or not queued at all.
+	var reqs []models.PrintRequest
 
-		if err := m.printJobCreator.CreatePrintJob(doc.Id, doc.FilePath); err != nil {
-			log.Printf("Failed to create print job for document %d: %v", doc.Id, err)
+	cover := sheets.CoverSheet{
+		SystemName: system.Name,
+		IncidentAt: inc.startedAt,
+		Reason:     inc.reason,
+		Documents:  documents,
+	}
+	if req, err := m.sheetRequest(system.Id, models.PrintSourceCoverSheet, cover.Render(), models.PrintOriginEmergency); err != nil {
+		log.Printf("Failed to generate cover sheet for system %d: %v", system.Id, err)
+	} else {
+		reqs = append(reqs, req)
+	}
+
+	if changes != nil {
+		if req, err := m.sheetRequest(system.Id, models.PrintSourceReport, changes.Render(), models.PrintOriginEmergency); err != nil {
+			log.Printf("Failed to generate change summary for %s: %v", binder, err)
+		} else {
+			reqs = append(reqs, req)
 		}
+	}
 
-		//TODO Should support multiple templates tied to single file_id?
-		if templates != nil {
-			if err := m.printJobCreator.CreatePrintJob(templates.Id, templates.FilePath); err != nil {
-				log.Printf("Failed to create print job for document %d: %v", doc.Id, err)
+	// Templates whose data for any document has been printed in the batch
+	sharedPrinted := make(map[int64]bool)
+
+	for i, doc := range documents {
+		if system.PrintSeparators && i > 0 {
+			separator := sheets.SeparatorSheet(system.Name, doc, i+1, len(documents))
+			if req, err := m.sheetRequest(system.Id, models.PrintSourceSeparator, separator, models.PrintOriginEmergency); err != nil {
+				log.Printf("Failed to generate separator sheet for document %d: %v", doc.Id, err)
+			} else {
+				reqs = append(reqs, req)
 			}
 		}
+
+		reqs = append(reqs, models.PrintRequest{
+			Source:            models.PrintSourceDocument,
+			DocumentId:        &doc.Id,
+			FilePath:          doc.PrintPath(),
+			Options:           documentPrintOptions(doc, tagOptions).Merge(inc.override),
+			Stamp:             documentStamp(system, doc),
+			Origin:            models.PrintOriginEmergency,
+			Binder:            binder,
+			DocumentUpdatedAt: doc.UpdatedAt,
+		})
+
+		reqs = append(reqs, m.attachedTemplateRequests(doc, inc.override, sharedPrinted)...)
+	}
+
+	reqs = append(reqs, stock...)
+
+	batch := models.PrintBatch{SystemId: &system.Id, Reason: inc.reason}
+	if inc.key != "" {
+		batch.Key = &inc.key
+	}
+
+	queued, err := m.printJobCreator.PrintBatch(batch, reqs)
+	if err != nil {
+		return fmt.Errorf("failed to queue print jobs for system %d: %w", system.Id, err)
+	}
+	if !queued {
+		log.Printf("Print jobs for %s were already queued", inc.key)
+		return nil
+	}
+
+	if changes != nil {
+		m.dropRemoved(*changes)
+	}
+
+	return nil
+}
+
+// PrintDocument prints a single document on demand, with the same print
+// options an emergency batch would use. If submission fails the recorded
+// job is returned together with the error.
+func (m *Monitor) PrintDocument(documentId int64, override *models.PrintOptions) (*models.PrintJob, error) {
+	doc, err := m.documentStore.GetById(documentId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document %d: %w", documentId, err)
+	}
+	m.convertDocument(doc)
+
+	tagOptions, err := m.tagPrintOptions(doc.SystemId)
+	if err != nil {
+		log.Printf("Failed to gather tag print options for system %d: %v", doc.SystemId, err)
+	}
+
+	system, err := m.systemStore.GetSystemById(doc.SystemId)
+	if err != nil {
+		log.Printf("Failed to get system %d: %v", doc.SystemId, err)
+	}
+
+	return m.submit(models.PrintRequest{
+		Source:     models.PrintSourceDocument,
+		DocumentId: &doc.Id,
+		FilePath:   doc.PrintPath(),
+		Options:    documentPrintOptions(*doc, tagOptions).Merge(override),
+		Stamp:      documentStamp(system, *doc),
+		Origin:     models.PrintOriginManual,
+	})
+}
+
+// ReprintJob prints the document of an earlier job again with the options
+// that job used, and links the new job to it. Jobs without a document,
+// such as cover sheets, reprint the file they printed.
+func (m *Monitor) ReprintJob(jobId int64, override *models.PrintOptions) (*models.PrintJob, error) {
+	original, err := m.printJobStore.GetById(jobId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get print job %d: %w", jobId, err)
+	}
+
+	filePath := original.FilePath
+	if original.DocumentId != nil {
+		doc, err := m.documentStore.GetById(*original.DocumentId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get document %d: %w", *original.DocumentId, err)
+		}
+		m.convertDocument(doc)
+		filePath = doc.PrintPath()
+	}
+
+	return m.submit(models.PrintRequest{
+		Source:          original.Source,
+		DocumentId:      original.DocumentId,
+		TemplateId:      original.TemplateId,
+		TemplateVersion: original.TemplateVersion,
+		FilePath:        filePath,
+		Options:         models.PrintOptions{}.Merge(original.Options).Merge(override),
+		Stamp:           original.Stamp,
+		Origin:          models.PrintOriginReprint,
+		ParentJobId:     &original.Id,
+	})
+}
+
+// PrintScheduledDocuments prints every document whose print_at has passed
+// and records when it was printed, so each schedule prints once. Schedules
+// missed by more than scheduleCatchUpWindow are marked as missed instead,
+// rather than printing a stale document.
+func (m *Monitor) PrintScheduledDocuments() error {
+	now := time.Now()
+
+	documents, err := m.documentStore.GetDueForPrinting(now.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to get documents due for printing: %w", err)
+	}
+
+	tagOptionsBySystem := make(map[int64]map[string]models.PrintOptions)
+	systems := make(map[int64]*models.System)
+
+	for _, doc := range documents {
+		printAt := time.Unix(*doc.PrintAt, 0)
+
+		if now.Sub(printAt) > scheduleCatchUpWindow {
+			log.Printf("Scheduled print of document %d at %s was missed, skipping", doc.Id, printAt.Format(time.RFC3339))
+			if err := m.documentStore.MarkPrintMissed(doc.Id, *doc.PrintAt); err != nil {
+				log.Printf("Failed to mark scheduled print of document %d as missed: %v", doc.Id, err)
+			}
+			continue
+		}
+
+		tagOptions, ok := tagOptionsBySystem[doc.SystemId]
+		if !ok {
+			tagOptions, err = m.tagPrintOptions(doc.SystemId)
+			if err != nil {
+				log.Printf("Failed to gather tag print options for system %d: %v", doc.SystemId, err)
+			}
+			tagOptionsBySystem[doc.SystemId] = tagOptions
+		}
+
+		system, ok := systems[doc.SystemId]
+		if !ok {
+			system, err = m.systemStore.GetSystemById(doc.SystemId)
+			if err != nil {
+				log.Printf("Failed to get system %d: %v", doc.SystemId, err)
+			}
+			systems[doc.SystemId] = system
+		}
+
+		m.convertDocument(&doc)
+
+		// If queuing fails it is retried on the next run. The batch key
+		// keeps a crash before MarkPrinted from printing the document twice.
+		key := fmt.Sprintf("document:%d:print_at:%d", doc.Id, *doc.PrintAt)
+		if _, err := m.printJobCreator.PrintBatch(models.PrintBatch{
+			Key:      &key,
+			SystemId: &doc.SystemId,
+			Reason:   "Scheduled print of " + doc.FileReference,
+		}, []models.PrintRequest{{
+			Source:     models.PrintSourceDocument,
+			DocumentId: &doc.Id,
+			FilePath:   doc.PrintPath(),
+			Options:    documentPrintOptions(doc, tagOptions),
+			Stamp:      documentStamp(system, doc),
+			Origin:     models.PrintOriginScheduled,
+		}}); err != nil {
+			log.Printf("Failed to print scheduled document %d: %v", doc.Id, err)
+			continue
+		}
+
+		if err := m.documentStore.MarkPrinted(doc.Id, now.Unix()); err != nil {
+			log.Printf("Failed to record scheduled print of document %d: %v", doc.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// RunPrintSchedules prints the targets of every schedule that is due and
+// moves it on to its next run. Runs missed while the box was off are
+// coalesced into one, printed only if within scheduleCatchUpWindow. A run
+// that fails is retried up to maxScheduleRunAttempts times.
+func (m *Monitor) RunPrintSchedules() error {
+	now := time.Now()
+
+	schedules, err := m.printScheduleStore.GetDue(now.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to get due print schedules: %w", err)
+	}
+
+	for _, schedule := range schedules {
+		var lastRunAt *int64
+
+		dueAt := time.Unix(*schedule.NextRunAt, 0)
+		if now.Sub(dueAt) > scheduleCatchUpWindow {
+			log.Printf("Print schedule %d run at %s was missed, skipping", schedule.Id, dueAt.Format(time.RFC3339))
+			m.markRunMissed(schedule)
+		} else if err := m.printScheduleTargets(schedule, *schedule.NextRunAt); err != nil {
+			attempts := schedule.FailedRuns + 1
+			if attempts < maxScheduleRunAttempts {
+				// The run stays due; its batch key keeps a retry from
+				// queueing it twice.
+				retryAt := now.Add(scheduleRetryDelay(attempts))
+				log.Printf("Failed to run print schedule %d, retrying at %s: %v", schedule.Id, retryAt.Format(time.RFC3339), err)
+				if err := m.printScheduleStore.RecordFailedRun(schedule.Id, retryAt.Unix()); err != nil {
+					log.Printf("Failed to record failed run of print schedule %d: %v", schedule.Id, err)
+				}
+				continue
+			}
+
+			log.Printf("Print schedule %d run at %s failed %d times, skipping: %v", schedule.Id, dueAt.Format(time.RFC3339), attempts, err)
+			m.markRunMissed(schedule)
+		} else {
+			ranAt := now.Unix()
+			lastRunAt = &ranAt
+		}
+
+		var nextRunAt *int64
+		next, err := cron.NextRun(schedule.Cron, schedule.TimeZone, now)
+		if err != nil {
+			log.Printf("Print schedule %d has no next run: %v", schedule.Id, err)
+		} else {
+			nextUnix := next.Unix()
+			nextRunAt = &nextUnix
+		}
+
+		if err := m.printScheduleStore.RecordRun(schedule.Id, lastRunAt, nextRunAt); err != nil {
+			log.Printf("Failed to record run of print schedule %d: %v", schedule.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// markRunMissed records that a schedule's due run wasn't printed.
+func (m *Monitor) markRunMissed(schedule models.PrintSchedule) {
+	if err := m.printScheduleStore.MarkRunMissed(schedule.Id, *schedule.NextRunAt); err != nil {
+		log.Printf("Failed to mark run of print schedule %d as missed: %v", schedule.Id, err)
+	}
+}
+
+// scheduleRetryDelay is how long to wait before trying a schedule's run
+// again after its attempts-th attempt failed.
+func scheduleRetryDelay(attempts int) time.Duration {
+	return minScheduleRetryDelay << (attempts - 1)
+}
+
+// printScheduleTargets prints the current version of every document a
+// schedule targets, as one batch for the run due at dueAt.
+func (m *Monitor) printScheduleTargets(schedule models.PrintSchedule, dueAt int64) error {
+	documents, err := m.documentStore.GetBySystemId(schedule.SystemId)
+	if err != nil {
+		return fmt.Errorf("failed to get documents for system %d: %w", schedule.SystemId, err)
+	}
+
+	var tags *tagexpr.Expr
+	if schedule.Target == models.ScheduleTargetTag && schedule.Tag != nil {
+		tags, err = tagexpr.Parse(*schedule.Tag)
+		if err != nil {
+			return fmt.Errorf("print schedule %d has an invalid tag expression: %w", schedule.Id, err)
+		}
+	}
+
+	var targets []models.Document
+	for _, doc := range documents {
+		if doc.DeletedAt == nil && scheduleTargets(schedule, tags, doc) {
+			m.convertDocument(&doc)
+			targets = append(targets, doc)
+		}
+	}
+
+	binder := models.ScheduleBinder(schedule.Id)
+
+	system, err := m.systemStore.GetSystemById(schedule.SystemId)
+	if err != nil || system == nil {
+		return fmt.Errorf("failed to get system %d: %w", schedule.SystemId, err)
+	}
+
+	var reqs []models.PrintRequest
+	var changes *sheets.ChangeSummary
+
+	if schedule.Mode == models.PrintModeDelta {
+		changed, summary, err := m.planDelta(system.Name, binder, targets)
+		if err != nil {
+			return fmt.Errorf("failed to compare documents with the last print: %w", err)
+		}
+		if summary.IsEmpty() {
+			log.Printf("Print schedule %d has no changed documents", schedule.Id)
+			return nil
+		}
+
+		req, err := m.sheetRequest(system.Id, models.PrintSourceReport, summary.Render(), models.PrintOriginScheduled)
+		if err != nil {
+			log.Printf("Failed to generate change summary for %s: %v", binder, err)
+		} else {
+			reqs = append(reqs, req)
+		}
+
+		targets, changes = changed, &summary
+	}
+
+	tagOptions, err := m.tagPrintOptions(schedule.SystemId)
+	if err != nil {
+		log.Printf("Failed to gather tag print options for system %d: %v", schedule.SystemId, err)
+	}
+
+	for _, doc := range targets {
+		reqs = append(reqs, models.PrintRequest{
+			Source:            models.PrintSourceDocument,
+			DocumentId:        &doc.Id,
+			FilePath:          doc.PrintPath(),
+			Options:           documentPrintOptions(doc, tagOptions),
+			Stamp:             documentStamp(system, doc),
+			Origin:            models.PrintOriginScheduled,
+			Binder:            binder,
+			DocumentUpdatedAt: doc.UpdatedAt,
+		})
+	}
+
+	key := fmt.Sprintf("schedule:%d:%d", schedule.Id, dueAt)
+	queued, err := m.printJobCreator.PrintBatch(models.PrintBatch{
+		Key:      &key,
+		SystemId: &system.Id,
+		Reason:   "Print schedule " + schedule.Name,
+	}, reqs)
+	if err != nil {
+		return fmt.Errorf("failed to queue print jobs: %w", err)
+	}
+	if !queued {
+		log.Printf("Print schedule %d run was already queued", schedule.Id)
+		return nil
+	}
+
+	if changes != nil {
+		m.dropRemoved(*changes)
 	}
 
+	log.Printf("Print schedule %d queued %d documents", schedule.Id, len(targets))
 	return nil
 }
 
+// scheduleTargets reports whether a schedule prints a document. tags is
+// the schedule's parsed tag expression, for tag schedules.
+func scheduleTargets(schedule models.PrintSchedule, tags *tagexpr.Expr, doc models.Document) bool {
+	switch schedule.Target {
+	case models.ScheduleTargetDocument:
+		return schedule.FileReference != nil && doc.FileReference == *schedule.FileReference
+	case models.ScheduleTargetTag:
+		return tags != nil && tags.Match(doc.Tags)
+	case models.ScheduleTargetSystem:
+		return true
+	}
+	return false
+}
+
+// submit creates a print job and loads the job that was recorded for it.
+func (m *Monitor) submit(req models.PrintRequest) (*models.PrintJob, error) {
+	jobId, err := m.printJobCreator.CreatePrintJob(req)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := m.printJobStore.GetById(jobId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get print job %d: %w", jobId, err)
+	}
+
+	return job, nil
+}
+
+// planDelta compares documents with what was last printed into a binder.
+// It returns the new and changed documents, which need printing, and a
+// summary that also lists documents that have left the binder.
+func (m *Monitor) planDelta(systemName, binder string, documents []models.Document) ([]models.Document, sheets.ChangeSummary, error) {
+	summary := sheets.ChangeSummary{SystemName: systemName, Binder: binder, GeneratedAt: time.Now()}
+
+	versions, err := m.printedVersionStore.GetByBinder(binder)
+	if err != nil {
+		return nil, summary, err
+	}
+
+	printed := make(map[string]models.PrintedVersion, len(versions))
+	for _, version := range versions {
+		printed[version.FileReference] = version
+	}
+
+	var changed []models.Document
+	current := make(map[string]bool, len(documents))
+
+	for _, doc := range documents {
+		current[doc.FileReference] = true
+
+		version, ok := printed[doc.FileReference]
+		switch {
+		case !ok:
+			summary.Added = append(summary.Added, doc)
+		case documentChanged(doc, version):
+			summary.Replaced = append(summary.Replaced, doc)
+		default:
+			continue
+		}
+
+		changed = append(changed, doc)
+	}
+
+	for _, version := range versions {
+		if !current[version.FileReference] {
+			summary.Removed = append(summary.Removed, version)
+		}
+	}
+
+	return changed, summary, nil
+}
+
+// documentChanged reports whether a document differs from the version last
+// printed. An unchanged updated_at is enough to skip it, but sync touches
+// updated_at on every run, so otherwise the content hash decides.
+func documentChanged(doc models.Document, version models.PrintedVersion) bool {
+	if doc.UpdatedAt != nil && version.DocumentUpdatedAt != nil && *doc.UpdatedAt == *version.DocumentUpdatedAt {
+		return false
+	}
+
+	hash, err := storage.HashFile(doc.PrintPath())
+	if err != nil {
+		log.Printf("Failed to hash document %d, treating it as changed: %v", doc.Id, err)
+		return true
+	}
+
+	return hash != version.ContentHash
+}
+
+// dropRemoved removes documents that have left a binder once the change
+// summary telling staff to discard them has been queued.
+func (m *Monitor) dropRemoved(summary sheets.ChangeSummary) {
+	for _, version := range summary.Removed {
+		if err := m.printedVersionStore.Delete(summary.Binder, version.FileReference); err != nil {
+			log.Printf("Failed to remove %s from %s: %v", version.FileReference, summary.Binder, err)
+		}
+	}
+}
+
+// sheetRequest saves a generated sheet under the generated root and
+// returns a request to print it. The source names the kind of sheet.
+func (m *Monitor) sheetRequest(systemId int64, source string, sheet *pdf.Document, origin string) (models.PrintRequest, error) {
+	dir := filepath.Join(storage.GeneratedRoot, strconv.FormatInt(systemId, 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return models.PrintRequest{}, fmt.Errorf("failed to create generated directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d_%s.pdf", time.Now().UnixNano(), source))
+	if err := storage.WriteFile(path, sheet.Bytes(), 0644); err != nil {
+		return models.PrintRequest{}, fmt.Errorf("failed to save %s sheet: %w", source, err)
+	}
+
+	return models.PrintRequest{Source: source, FilePath: path, Origin: origin}, nil
+}
+
+// attachedTemplateRequests returns requests to print the templates
+// attached to a document, in attachment order. Templates are filled in
+// with their data for the document, and with their data for any document
+// unless sharedPrinted says it was printed already, which it is updated
+// to say.
+func (m *Monitor) attachedTemplateRequests(doc models.Document, override *models.PrintOptions, sharedPrinted map[int64]bool) []models.PrintRequest {
+	attachments, err := m.templateAttachmentStore.GetByDocument(doc.SystemId, doc.FileReference)
+	if err != nil {
+		log.Printf("Failed to gather templates from db for document %d: %v", doc.Id, err)
+		return nil
+	}
+
+	var reqs []models.PrintRequest
+	for _, attachment := range attachments {
+		template, err := m.templateStore.GetById(attachment.TemplateId)
+		if err != nil {
+			log.Printf("Failed to get template %d attached to document %d: %v", attachment.TemplateId, doc.Id, err)
+			continue
+		}
+		if template.DeletedAt != nil {
+			continue
+		}
+
+		reqs = append(reqs, m.templateRequests(doc, *template, attachment.Copies, override, !sharedPrinted[template.Id])...)
+		sharedPrinted[template.Id] = true
+	}
+
+	return reqs
+}
+
+// templateRequests returns requests to print copies of a template attached
+// to a document. A template with fields is printed once per subject it has
+// data for, filled in with that data: the document's data, and with shared
+// set, the data for any document. It is printed blank if it has no fields
+// or data, or can't be filled in, since a blank form is better than none
+// during an outage.
+func (m *Monitor) templateRequests(doc models.Document, template models.Template, copies int, override *models.PrintOptions, shared bool) []models.PrintRequest {
+	options := models.PrintOptions{}.Merge(template.PrintOptions).Merge(override)
+	if copies > 1 {
+		// The attachment's copies multiply whatever the options ask for.
+		total := copies
+		if options.Copies != nil {
+			total *= *options.Copies
+		}
+		total = min(total, validation.MaxCopies)
+		options.Copies = &total
+	}
+	blank := []models.PrintRequest{{
+		Source:          models.PrintSourceTemplate,
+		TemplateId:      &template.Id,
+		TemplateVersion: &template.Version,
+		FilePath:        template.FilePath,
+		Options:         options,
+		Origin:          models.PrintOriginEmergency,
+	}}
+
+	if len(template.Fields) == 0 {
+		return blank
+	}
+
+	all, err := m.templateDataStore.GetByTemplateId(template.Id)
+	if err != nil {
+		log.Printf("Failed to get data for template %d, printing it blank: %v", template.Id, err)
+		return blank
+	}
+
+	var data []models.TemplateData
+	for _, item := range all {
+		if item.FileReference == nil && shared || item.FileReference != nil && *item.FileReference == doc.FileReference {
+			data = append(data, item)
+		}
+	}
+	if len(data) == 0 {
+		return blank
+	}
+
+	file, err := storage.ReadFile(template.FilePath)
+	if err != nil {
+		log.Printf("Failed to read template %d, printing it blank: %v", template.Id, err)
+		return blank
+	}
+
+	dir := filepath.Join(storage.GeneratedRoot, strconv.FormatInt(doc.SystemId, 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create generated directory, printing template %d blank: %v", template.Id, err)
+		return blank
+	}
+
+	var reqs []models.PrintRequest
+	for _, item := range data {
+		sheets, err := forms.Render(file, template.Fields, item.Data)
+		if err != nil {
+			log.Printf("Failed to fill in template %d for %s, printing it blank: %v", template.Id, item.Subject, err)
+			reqs = append(reqs, blank...)
+			continue
+		}
+
+		for i, sheet := range sheets {
+			path := filepath.Join(dir, fmt.Sprintf("%d_form_%d_%d.pdf", time.Now().UnixNano(), item.Id, i+1))
+			if err := storage.WriteFile(path, sheet, 0644); err != nil {
+				log.Printf("Failed to save filled-in template %d for %s: %v", template.Id, item.Subject, err)
+				continue
+			}
+			reqs = append(reqs, models.PrintRequest{
+				Source:          models.PrintSourceTemplate,
+				TemplateId:      &template.Id,
+				TemplateVersion: &template.Version,
+				FilePath:        path,
+				Options:         options,
+				Origin:          models.PrintOriginEmergency,
+			})
+		}
+	}
+
+	return reqs
+}
+
+// PrintFormStock prints the blank form stock of a system now, enough for an
+// outage of the given length, or the system's expected outage length when
+// nil. A ward limits the stock to that ward's rules. It returns the number
+// of copies queued.
+func (m *Monitor) PrintFormStock(systemId int64, hours *int, ward *string) (int, error) {
+	system, err := m.systemStore.GetSystemById(systemId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get system %d: %w", systemId, err)
+	}
+	if system == nil {
+		return 0, fmt.Errorf("system %d not found", systemId)
+	}
+
+	reqs := m.formStockRequests(system, outageHours(system, hours), ward, nil, models.PrintOriginManual)
+	if len(reqs) == 0 {
+		return 0, nil
+	}
+
+	if _, err := m.printJobCreator.PrintBatch(models.PrintBatch{SystemId: &system.Id, Reason: "Form stock"}, reqs); err != nil {
+		return 0, fmt.Errorf("failed to queue form stock for system %d: %w", system.Id, err)
+	}
+
+	copies := 0
+	for _, req := range reqs {
+		copies += *req.Options.Copies
+	}
+	return copies, nil
+}
+
+// formStockRequests returns requests to print the blank form stock of a
+// system for an outage of the given length. A ward limits the stock to
+// that ward's rules. Quantities above the most copies one job may ask for
+// are split over several jobs.
+func (m *Monitor) formStockRequests(system *models.System, hours int, ward *string, override *models.PrintOptions, origin string) []models.PrintRequest {
+	rules, err := m.formStockStore.GetBySystemId(system.Id)
+	if err != nil {
+		log.Printf("Failed to gather form stock rules for system %d: %v", system.Id, err)
+		return nil
+	}
+
+	var reqs []models.PrintRequest
+	for _, rule := range rules {
+		if ward != nil && rule.Ward != *ward {
+			continue
+		}
+
+		template, err := m.templateStore.GetById(rule.TemplateId)
+		if err != nil {
+			log.Printf("Failed to get template %d for form stock: %v", rule.TemplateId, err)
+			continue
+		}
+		if template.DeletedAt != nil {
+			continue
+		}
+
+		for remaining := rule.Copies(hours); remaining > 0; remaining -= validation.MaxCopies {
+			copies := min(remaining, validation.MaxCopies)
+
+			options := models.PrintOptions{}.Merge(template.PrintOptions).Merge(override)
+			options.Copies = &copies
+
+			reqs = append(reqs, models.PrintRequest{
+				Source:          models.PrintSourceTemplate,
+				TemplateId:      &template.Id,
+				TemplateVersion: &template.Version,
+				StockWard:       &rule.Ward,
+				FilePath:        template.FilePath,
+				Options:         options,
+				Origin:          origin,
+			})
+		}
+	}
+
+	return reqs
+}
+
+// outageHours returns how long an outage is expected to last: the hours
+// asked for, or else the system's setting.
+func outageHours(system *models.System, requested *int) int {
+	if requested != nil {
+		return *requested
+	}
+	if system.OutageHours != nil {
+		return *system.OutageHours
+	}
+	return models.DefaultOutageHours
+}
+
+// convertDocument converts a document whose conversion to PDF is still
+// pending, such as one that was synced, and records the outcome.
+func (m *Monitor) convertDocument(doc *models.Document) {
+	if doc.ConversionStatus != models.ConversionPending {
+		return
+	}
+
+	convert.Document(doc)
+	if err := m.documentStore.UpdateConversion(*doc); err != nil {
+		log.Printf("Failed to record conversion of document %d: %v", doc.Id, err)
+	}
+}
+
+// documentStamp returns the page stamp for a document, using the defaults
+// if its system can't be found.
+func documentStamp(system *models.System, doc models.Document) *models.PageStamp {
+	if system == nil {
+		var defaults *models.PageStamp
+		return defaults.ForDocument("", doc.FileReference)
+	}
+	return system.Stamp.ForDocument(system.Name, doc.FileReference)
+}
+
+// tagPrintOptions returns the print options configured per tag for a system.
+func (m *Monitor) tagPrintOptions(systemId int64) (map[string]models.PrintOptions, error) {
+	options, err := m.tagPrintOptionsStore.GetBySystemId(systemId)
+	if err != nil {
+		return nil, err
+	}
+
+	byTag := make(map[string]models.PrintOptions, len(options))
+	for _, option := range options {
+		byTag[option.Tag] = option.Options
+	}
+
+	return byTag, nil
+}
+
+// documentPrintOptions merges the options of every tag on the document, in
+// tag order, with the document's own options taking precedence.
+func documentPrintOptions(doc models.Document, tagOptions map[string]models.PrintOptions) models.PrintOptions {
+	var options models.PrintOptions
+
+	for _, tag := range doc.Tags {
+		if tagOption, ok := tagOptions[tag]; ok {
+			options = options.Merge(&tagOption)
+		}
+	}
+
+	return options.Merge(doc.PrintOptions)
+}
+
 func (m *Monitor) CheckAllTriggers() error {
 	triggers, err := m.triggerStore.Get()
 	if err != nil {
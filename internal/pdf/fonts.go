@@ -0,0 +1,210 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Font is one of the standard 14 PDF fonts, which every PDF reader and
+// printer has built in, so nothing needs to be embedded.
+type Font int
+
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	Courier
+)
+
+// standardFonts lists every font, in the order their resources are written.
+var standardFonts = []Font{Helvetica, HelveticaBold, Courier}
+
+func (f Font) baseFont() string {
+	switch f {
+	case HelveticaBold:
+		return "Helvetica-Bold"
+	case Courier:
+		return "Courier"
+	}
+	return "Helvetica"
+}
+
+func (f Font) resourceName() string {
+	switch f {
+	case HelveticaBold:
+		return "F2"
+	case Courier:
+		return "F3"
+	}
+	return "F1"
+}
+
+// Glyph widths for ASCII 32..126 in 1/1000 em, from the Adobe AFM files.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// accentBase maps accented Latin-1 letters to the letter they share a width with.
+var accentBase = map[rune]rune{
+	'À': 'A', 'Á': 'A', 'Â': 'A', 'Ã': 'A', 'Ä': 'A', 'Å': 'A', 'Ç': 'C',
+	'È': 'E', 'É': 'E', 'Ê': 'E', 'Ë': 'E', 'Ì': 'I', 'Í': 'I', 'Î': 'I', 'Ï': 'I',
+	'Ñ': 'N', 'Ò': 'O', 'Ó': 'O', 'Ô': 'O', 'Õ': 'O', 'Ö': 'O', 'Ø': 'O',
+	'Ù': 'U', 'Ú': 'U', 'Û': 'U', 'Ü': 'U', 'Ý': 'Y',
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ç': 'c',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ñ': 'n', 'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ý': 'y', 'ÿ': 'y',
+}
+
+// winAnsiSpecials are the characters WinAnsiEncoding places in 0x80..0x9F.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func runeWidth(font Font, r rune) int {
+	if font == Courier {
+		return 600
+	}
+
+	widths := &helveticaWidths
+	if font == HelveticaBold {
+		widths = &helveticaBoldWidths
+	}
+
+	if base, ok := accentBase[r]; ok {
+		r = base
+	}
+
+	switch {
+	case r >= 32 && r <= 126:
+		return widths[r-32]
+	case r == '–' || r == '•':
+		return 556
+	case r == '—' || r == '…':
+		return 1000
+	default:
+		return 556
+	}
+}
+
+// TextWidth returns the width of text in points when set in font at size.
+func TextWidth(font Font, size float64, text string) float64 {
+	total := 0
+	for _, r := range text {
+		total += runeWidth(font, r)
+	}
+	return float64(total) * size / 1000
+}
+
+// encodeWinAnsi converts text to WinAnsiEncoding, replacing anything that
+// can't be represented with a question mark.
+func encodeWinAnsi(text string) []byte {
+	out := make([]byte, 0, len(text))
+
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r < 32 || r == 127:
+			continue
+		case r < 127 || (r >= 0xA0 && r <= 0xFF):
+			out = append(out, byte(r))
+		default:
+			if b, ok := winAnsiSpecials[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+
+	return out
+}
+
+// WrapText breaks text into lines no wider than maxWidth. Words longer than
+// a full line are broken mid-word.
+func WrapText(font Font, size float64, text string, maxWidth float64) []string {
+	var lines []string
+
+	for paragraph := range strings.SplitSeq(text, "\n") {
+		words := strings.FieldsFunc(paragraph, unicode.IsSpace)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		line := ""
+		for _, word := range words {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+
+			if TextWidth(font, size, candidate) <= maxWidth {
+				line = candidate
+				continue
+			}
+
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			for TextWidth(font, size, word) > maxWidth {
+				cut := fitRunes(font, size, word, maxWidth)
+				lines = append(lines, word[:cut])
+				word = word[cut:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Truncate shortens text with an ellipsis so it fits within maxWidth.
+func Truncate(font Font, size float64, text string, maxWidth float64) string {
+	if TextWidth(font, size, text) <= maxWidth {
+		return text
+	}
+
+	ellipsis := "…"
+	cut := fitRunes(font, size, text, maxWidth-TextWidth(font, size, ellipsis))
+	return strings.TrimRight(text[:cut], " ") + ellipsis
+}
+
+// fitRunes returns the byte length of the longest prefix of text that fits
+// within maxWidth, always at least one rune.
+func fitRunes(font Font, size float64, text string, maxWidth float64) int {
+	width := 0.0
+	for i, r := range text {
+		width += float64(runeWidth(font, r)) * size / 1000
+		if width > maxWidth {
+			if i == 0 {
+				return len(string(r))
+			}
+			return i
+		}
+	}
+	return len(text)
+}
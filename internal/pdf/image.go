@@ -0,0 +1,126 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// Image is a raster image that can be drawn on any page of the document it
+// was added to.
+type Image struct {
+	Width, Height int
+
+	id         int
+	colorSpace string
+	filter     string
+	data       []byte
+}
+
+// AddImage adds an image to the document. Transparent areas are drawn
+// onto white, as they would appear on paper.
+func (d *Document) AddImage(img image.Image) *Image {
+	bounds := img.Bounds()
+	grey := isGrey(img)
+
+	var raw bytes.Buffer
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+
+			// Colours are alpha-premultiplied, so adding the missing
+			// alpha blends them onto white.
+			white := 0xffff - a
+			r, g, b = (r+white)>>8, (g+white)>>8, (b+white)>>8
+
+			if grey {
+				raw.WriteByte(byte(r))
+			} else {
+				raw.Write([]byte{byte(r), byte(g), byte(b)})
+			}
+		}
+	}
+
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	zw.Write(raw.Bytes())
+	zw.Close()
+
+	colorSpace := "DeviceRGB"
+	if grey {
+		colorSpace = "DeviceGray"
+	}
+
+	return d.addImage(&Image{
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		colorSpace: colorSpace,
+		filter:     "FlateDecode",
+		data:       data.Bytes(),
+	})
+}
+
+// AddJPEG adds a JPEG image to the document without decoding it, since
+// PDF can embed JPEG data as it is.
+func (d *Document) AddJPEG(data []byte) (*Image, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JPEG: %w", err)
+	}
+
+	var colorSpace string
+	switch config.ColorModel {
+	case color.GrayModel:
+		colorSpace = "DeviceGray"
+	case color.YCbCrModel:
+		colorSpace = "DeviceRGB"
+	default:
+		// CMYK JPEGs are stored inverted by some encoders, so decode
+		// them rather than guess.
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JPEG: %w", err)
+		}
+		return d.AddImage(img), nil
+	}
+
+	return d.addImage(&Image{
+		Width:      config.Width,
+		Height:     config.Height,
+		colorSpace: colorSpace,
+		filter:     "DCTDecode",
+		data:       data,
+	}), nil
+}
+
+func (d *Document) addImage(img *Image) *Image {
+	d.images = append(d.images, img)
+	img.id = len(d.images)
+	return img
+}
+
+// Image draws an image scaled to w by h points, with its bottom left
+// corner at x, y.
+func (p *Page) Image(img *Image, x, y, w, h float64) {
+	if p.images == nil {
+		p.images = make(map[int]*Image)
+	}
+	p.images[img.id] = img
+
+	fmt.Fprintf(&p.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", num(w), num(h), num(x), num(y), img.id)
+}
+
+func isGrey(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
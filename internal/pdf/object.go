@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Objects read from existing PDF files. Besides these, values are nil,
+// bool, int64 or float64.
+type (
+	name      string
+	array     []any
+	dict      map[name]any
+	pdfString []byte
+	keyword   string
+
+	ref struct {
+		num, gen int
+	}
+
+	// stream holds the stream data as stored in the file, still encoded.
+	stream struct {
+		dict dict
+		data []byte
+	}
+)
+
+func (d dict) clone() dict {
+	out := make(dict, len(d))
+	for k, v := range d {
+		out[k] = v
+	}
+	return out
+}
+
+// writeObject serialises an object in PDF syntax.
+func writeObject(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case name:
+		writeName(buf, v)
+	case pdfString:
+		fmt.Fprintf(buf, "<%x>", []byte(v))
+	case keyword:
+		buf.WriteString(string(v))
+	case ref:
+		fmt.Fprintf(buf, "%d %d R", v.num, v.gen)
+	case array:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, item)
+		}
+		buf.WriteByte(']')
+	case dict:
+		keys := make([]name, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		buf.WriteString("<<")
+		for _, k := range keys {
+			writeName(buf, k)
+			buf.WriteByte(' ')
+			writeObject(buf, v[k])
+		}
+		buf.WriteString(">>")
+	case stream:
+		d := v.dict.clone()
+		d["Length"] = int64(len(v.data))
+		writeObject(buf, d)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.data)
+		buf.WriteString("\nendstream")
+	}
+}
+
+func writeName(buf *bytes.Buffer, n name) {
+	buf.WriteByte('/')
+	for _, b := range []byte(n) {
+		if b < '!' || b > '~' || b == '#' || isDelimiter(b) {
+			fmt.Fprintf(buf, "#%02x", b)
+		} else {
+			buf.WriteByte(b)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxNesting bounds how deeply arrays and dictionaries may nest, so a
+// malformed file can't exhaust the stack.
+const maxNesting = 100
+
+var errEOF = errors.New("unexpected end of PDF data")
+
+// lexer reads PDF objects from a byte slice.
+type lexer struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func isWhitespace(b byte) bool {
+	return b == 0 || b == '\t' || b == '\n' || b == '\f' || b == '\r' || b == ' '
+}
+
+func isDelimiter(b byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), b) >= 0
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch b := l.data[l.pos]; {
+		case isWhitespace(b):
+			l.pos++
+		case b == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(l.data[l.pos:], []byte(prefix))
+}
+
+// token reads a run of regular characters, such as a number or keyword.
+func (l *lexer) token() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// readObject reads the next object. Keywords such as obj or stream are
+// returned as keyword values.
+func (l *lexer) readObject() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errEOF
+	}
+
+	switch l.data[l.pos] {
+	case '/':
+		l.pos++
+		return l.readName(), nil
+	case '(':
+		l.pos++
+		return l.readLiteral()
+	case '<':
+		if l.hasPrefix("<<") {
+			l.pos += 2
+			return l.readDict()
+		}
+		l.pos++
+		return l.readHex()
+	case '[':
+		l.pos++
+		return l.readArray()
+	case ']', '>', ')', '{', '}':
+		return nil, fmt.Errorf("unexpected %q at offset %d", l.data[l.pos], l.pos)
+	}
+
+	tok := l.token()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected %q at offset %d", l.data[l.pos], l.pos)
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		if r, ok := l.readRefTail(n); ok {
+			return r, nil
+		}
+		return n, nil
+	}
+
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+
+	return keyword(tok), nil
+}
+
+// readRefTail checks whether the integer just read starts an indirect
+// reference such as "12 0 R", and consumes the rest of it if so.
+func (l *lexer) readRefTail(num int64) (ref, bool) {
+	start := l.pos
+
+	l.skipSpace()
+	gen, err := strconv.ParseInt(l.token(), 10, 32)
+	if err == nil {
+		l.skipSpace()
+		if l.token() == "R" && num >= 0 && gen >= 0 {
+			return ref{num: int(num), gen: int(gen)}, true
+		}
+	}
+
+	l.pos = start
+	return ref{}, false
+}
+
+func (l *lexer) readName() name {
+	var out []byte
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		b := l.data[l.pos]
+		if b == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		out = append(out, b)
+		l.pos++
+	}
+	return name(out)
+}
+
+func (l *lexer) readLiteral() (pdfString, error) {
+	var out []byte
+	depth := 1
+
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		l.pos++
+
+		switch b {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out, nil
+			}
+		case '\r':
+			// End of line markers in strings read as a single newline.
+			if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+				l.pos++
+			}
+			b = '\n'
+		case '\\':
+			if l.pos >= len(l.data) {
+				return nil, errEOF
+			}
+			b = l.data[l.pos]
+			l.pos++
+
+			switch b {
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			case 't':
+				b = '\t'
+			case 'b':
+				b = '\b'
+			case 'f':
+				b = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if b >= '0' && b <= '7' {
+					v := int(b - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					b = byte(v)
+				}
+			}
+		}
+
+		out = append(out, b)
+	}
+
+	return nil, errEOF
+}
+
+func (l *lexer) readHex() (pdfString, error) {
+	var digits []byte
+	for l.pos < len(l.data) {
+		b := l.data[l.pos]
+		l.pos++
+
+		if b == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			out := make([]byte, len(digits)/2)
+			for i := range out {
+				v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hex string at offset %d", l.pos)
+				}
+				out[i] = byte(v)
+			}
+			return out, nil
+		}
+
+		if !isWhitespace(b) {
+			digits = append(digits, b)
+		}
+	}
+
+	return nil, errEOF
+}
+
+func (l *lexer) readArray() (array, error) {
+	if l.depth++; l.depth > maxNesting {
+		return nil, errors.New("PDF objects nested too deeply")
+	}
+	defer func() { l.depth-- }()
+
+	out := array{}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return nil, errEOF
+		}
+		if l.data[l.pos] == ']' {
+			l.pos++
+			return out, nil
+		}
+
+		item, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+}
+
+func (l *lexer) readDict() (dict, error) {
+	if l.depth++; l.depth > maxNesting {
+		return nil, errors.New("PDF objects nested too deeply")
+	}
+	defer func() { l.depth-- }()
+
+	out := dict{}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return nil, errEOF
+		}
+		if l.hasPrefix(">>") {
+			l.pos += 2
+			return out, nil
+		}
+
+		key, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(name)
+		if !ok {
+			return nil, fmt.Errorf("dictionary key is not a name at offset %d", l.pos)
+		}
+
+		value, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		out[k] = value
+	}
+}
@@ -0,0 +1,600 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// reader gives access to the objects of an existing PDF file. It reads
+// classic cross-reference tables as well as cross-reference and object
+// streams, and rebuilds the cross-reference by scanning the file when it
+// is damaged.
+type reader struct {
+	data    []byte
+	xref    map[int]xrefEntry
+	trailer dict
+
+	// startxref is the offset of the newest cross-reference section, and
+	// xrefStream whether it is a stream. rebuilt is set when the
+	// cross-reference had to be recovered by scanning.
+	startxref  int
+	xrefStream bool
+	rebuilt    bool
+
+	objects    map[int]any
+	objStreams map[int]*objStream
+	resolving  map[int]bool
+}
+
+type xrefEntry struct {
+	free       bool
+	offset     int
+	gen        int
+	compressed bool
+	stream     int
+	index      int
+}
+
+type objStream struct {
+	data  []byte
+	first int
+
+	// indexes maps object numbers to their index in the stream, and
+	// offsets holds the offset of each object by index.
+	indexes map[int]int
+	offsets []int
+}
+
+var objectHeader = regexp.MustCompile(`(\d+)[ \t\r\n\f\x00]+(\d+)[ \t\r\n\f\x00]+obj\b`)
+
+func read(data []byte) (*reader, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	r := &reader{
+		data:       data,
+		objects:    map[int]any{},
+		objStreams: map[int]*objStream{},
+		resolving:  map[int]bool{},
+	}
+
+	if err := r.loadXref(); err != nil || r.trailer["Root"] == nil {
+		if err := r.rebuildXref(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := r.resolve(r.trailer["Root"]).(dict); !ok {
+		return nil, errors.New("PDF has no document catalog")
+	}
+
+	return r, nil
+}
+
+func (r *reader) loadXref() error {
+	i := bytes.LastIndex(r.data, []byte("startxref"))
+	if i < 0 {
+		return errors.New("no startxref")
+	}
+
+	l := &lexer{data: r.data, pos: i + len("startxref")}
+	l.skipSpace()
+	offset, err := strconv.Atoi(l.token())
+	if err != nil {
+		return fmt.Errorf("invalid startxref: %w", err)
+	}
+
+	r.startxref = offset
+	r.xref = map[int]xrefEntry{}
+	seen := map[int]bool{}
+
+	for first := true; ; first = false {
+		if offset <= 0 || offset >= len(r.data) || seen[offset] {
+			if first {
+				return fmt.Errorf("invalid xref offset %d", offset)
+			}
+			return nil
+		}
+		seen[offset] = true
+
+		trailer, isStream, err := r.loadXrefSection(offset)
+		if err != nil {
+			return err
+		}
+
+		if first {
+			r.trailer = trailer
+			r.xrefStream = isStream
+		}
+
+		// Hybrid files keep their compressed objects in a separate stream.
+		if stm, ok := trailer["XRefStm"].(int64); ok && !seen[int(stm)] {
+			seen[int(stm)] = true
+			if _, _, err := r.loadXrefSection(int(stm)); err != nil {
+				return err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			return nil
+		}
+		offset = int(prev)
+	}
+}
+
+// loadXrefSection reads one cross-reference section. Entries already known
+// come from newer sections and take precedence.
+func (r *reader) loadXrefSection(offset int) (dict, bool, error) {
+	l := &lexer{data: r.data, pos: offset}
+	l.skipSpace()
+
+	if !l.hasPrefix("xref") {
+		trailer, err := r.loadXrefStream(l.pos)
+		return trailer, true, err
+	}
+	l.pos += len("xref")
+
+	for {
+		l.skipSpace()
+		if l.hasPrefix("trailer") {
+			l.pos += len("trailer")
+			break
+		}
+
+		start, err1 := strconv.Atoi(l.token())
+		l.skipSpace()
+		count, err2 := strconv.Atoi(l.token())
+		if err1 != nil || err2 != nil || start < 0 || count < 0 {
+			return nil, false, fmt.Errorf("invalid xref subsection at offset %d", l.pos)
+		}
+
+		for num := start; num < start+count; num++ {
+			l.skipSpace()
+			entryOffset, err1 := strconv.Atoi(l.token())
+			l.skipSpace()
+			gen, err2 := strconv.Atoi(l.token())
+			l.skipSpace()
+			kind := l.token()
+			if err1 != nil || err2 != nil || (kind != "n" && kind != "f") {
+				return nil, false, fmt.Errorf("invalid xref entry for object %d", num)
+			}
+
+			if _, ok := r.xref[num]; !ok {
+				r.xref[num] = xrefEntry{free: kind == "f", offset: entryOffset, gen: gen}
+			}
+		}
+	}
+
+	trailer, err := l.readObject()
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid trailer: %w", err)
+	}
+	d, ok := trailer.(dict)
+	if !ok {
+		return nil, false, errors.New("trailer is not a dictionary")
+	}
+
+	return d, false, nil
+}
+
+func (r *reader) loadXrefStream(offset int) (dict, error) {
+	_, obj, err := r.parseIndirect(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := obj.(stream)
+	if !ok || s.dict["Type"] != name("XRef") {
+		return nil, fmt.Errorf("no xref at offset %d", offset)
+	}
+
+	data, err := r.decodeStream(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode xref stream: %w", err)
+	}
+
+	w, ok := s.dict["W"].(array)
+	if !ok || len(w) != 3 {
+		return nil, errors.New("xref stream has no valid W")
+	}
+	widths := make([]int, 3)
+	for i, v := range w {
+		width, ok := v.(int64)
+		if !ok || width < 0 || width > 8 {
+			return nil, errors.New("xref stream has no valid W")
+		}
+		widths[i] = int(width)
+	}
+	entrySize := widths[0] + widths[1] + widths[2]
+	if entrySize == 0 {
+		return nil, errors.New("xref stream has no valid W")
+	}
+
+	index, ok := s.dict["Index"].(array)
+	if !ok {
+		size, _ := s.dict["Size"].(int64)
+		index = array{int64(0), size}
+	}
+
+	field := func(b []byte) int {
+		v := 0
+		for _, c := range b {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 {
+			return nil, errors.New("xref stream has an invalid Index")
+		}
+
+		for num := int(start); num < int(start+count); num++ {
+			if pos+entrySize > len(data) {
+				return nil, errors.New("xref stream is truncated")
+			}
+			entry := data[pos : pos+entrySize]
+			pos += entrySize
+
+			kind := 1
+			if widths[0] > 0 {
+				kind = field(entry[:widths[0]])
+			}
+			a := field(entry[widths[0] : widths[0]+widths[1]])
+			b := field(entry[widths[0]+widths[1]:])
+
+			if _, ok := r.xref[num]; ok {
+				continue
+			}
+
+			switch kind {
+			case 0:
+				r.xref[num] = xrefEntry{free: true}
+			case 1:
+				r.xref[num] = xrefEntry{offset: a, gen: b}
+			case 2:
+				r.xref[num] = xrefEntry{compressed: true, stream: a, index: b}
+			}
+		}
+	}
+
+	return s.dict, nil
+}
+
+// rebuildXref recovers the cross-reference of a damaged file by scanning
+// it for object headers. Later definitions of an object win, as they
+// would in an incrementally updated file.
+func (r *reader) rebuildXref() error {
+	r.xref = map[int]xrefEntry{}
+	r.objects = map[int]any{}
+	r.rebuilt = true
+	r.xrefStream = false
+
+	for _, match := range objectHeader.FindAllSubmatchIndex(r.data, -1) {
+		num, err1 := strconv.Atoi(string(r.data[match[2]:match[3]]))
+		gen, err2 := strconv.Atoi(string(r.data[match[4]:match[5]]))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		r.xref[num] = xrefEntry{offset: match[0], gen: gen}
+	}
+
+	// Objects inside object streams.
+	for num, entry := range r.xref {
+		if entry.compressed {
+			continue
+		}
+		s, ok := r.object(num).(stream)
+		if !ok || s.dict["Type"] != name("ObjStm") {
+			continue
+		}
+		objects, err := r.objStream(num)
+		if err != nil {
+			continue
+		}
+		for inner, index := range objects.indexes {
+			if _, ok := r.xref[inner]; !ok {
+				r.xref[inner] = xrefEntry{compressed: true, stream: num, index: index}
+			}
+		}
+	}
+
+	r.trailer = dict{}
+	if i := bytes.LastIndex(r.data, []byte("trailer")); i >= 0 {
+		l := &lexer{data: r.data, pos: i + len("trailer")}
+		if trailer, err := l.readObject(); err == nil {
+			if d, ok := trailer.(dict); ok {
+				r.trailer = d
+			}
+		}
+	}
+
+	if _, ok := r.resolve(r.trailer["Root"]).(dict); !ok {
+		for num := range r.xref {
+			if d, ok := r.object(num).(dict); ok && d["Type"] == name("Catalog") {
+				r.trailer["Root"] = ref{num: num}
+				break
+			}
+		}
+	}
+
+	if r.trailer["Root"] == nil {
+		return errors.New("PDF has no document catalog")
+	}
+	return nil
+}
+
+// parseIndirect parses the indirect object starting at offset.
+func (r *reader) parseIndirect(offset int) (int, any, error) {
+	l := &lexer{data: r.data, pos: offset}
+
+	num, err1 := l.readObject()
+	_, err2 := l.readObject()
+	kw, err3 := l.readObject()
+	n, ok := num.(int64)
+	if err1 != nil || err2 != nil || err3 != nil || !ok || kw != keyword("obj") {
+		return 0, nil, fmt.Errorf("no object at offset %d", offset)
+	}
+
+	obj, err := l.readObject()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse object %d: %w", n, err)
+	}
+
+	d, ok := obj.(dict)
+	if !ok {
+		return int(n), obj, nil
+	}
+
+	l.skipSpace()
+	if !l.hasPrefix("stream") {
+		return int(n), obj, nil
+	}
+	l.pos += len("stream")
+	if l.pos < len(r.data) && r.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(r.data) && r.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	// Trust Length only if endstream follows it, as it is often wrong.
+	if length, ok := r.resolve(d["Length"]).(int64); ok && length >= 0 && start+int(length) <= len(r.data) {
+		end := &lexer{data: r.data, pos: start + int(length)}
+		end.skipSpace()
+		if end.hasPrefix("endstream") {
+			return int(n), stream{dict: d, data: r.data[start : start+int(length)]}, nil
+		}
+	}
+
+	end := bytes.Index(r.data[start:], []byte("endstream"))
+	if end < 0 {
+		return 0, nil, fmt.Errorf("stream of object %d has no end", n)
+	}
+	data := bytes.TrimSuffix(r.data[start:start+end], []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+
+	return int(n), stream{dict: d, data: data}, nil
+}
+
+// object returns object num, or nil if it doesn't exist or can't be read.
+func (r *reader) object(num int) any {
+	if obj, ok := r.objects[num]; ok {
+		return obj
+	}
+
+	entry, ok := r.xref[num]
+	if !ok || entry.free || r.resolving[num] {
+		return nil
+	}
+	r.resolving[num] = true
+	defer delete(r.resolving, num)
+
+	var obj any
+	if entry.compressed {
+		obj = r.compressedObject(entry)
+	} else if found, parsed, err := r.parseIndirect(entry.offset); err == nil && found == num {
+		obj = parsed
+	}
+
+	r.objects[num] = obj
+	return obj
+}
+
+func (r *reader) compressedObject(entry xrefEntry) any {
+	objects, err := r.objStream(entry.stream)
+	if err != nil || entry.index < 0 || entry.index >= len(objects.offsets) {
+		return nil
+	}
+
+	l := &lexer{data: objects.data, pos: objects.first + objects.offsets[entry.index]}
+	obj, err := l.readObject()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// objStream decodes the object stream num and reads its header.
+func (r *reader) objStream(num int) (*objStream, error) {
+	if objects, ok := r.objStreams[num]; ok {
+		return objects, nil
+	}
+
+	s, ok := r.object(num).(stream)
+	if !ok {
+		return nil, fmt.Errorf("object stream %d not found", num)
+	}
+
+	data, err := r.decodeStream(s)
+	if err != nil {
+		return nil, err
+	}
+
+	first, _ := r.resolve(s.dict["First"]).(int64)
+	count, _ := r.resolve(s.dict["N"]).(int64)
+
+	objects := &objStream{data: data, first: int(first), indexes: map[int]int{}}
+	l := &lexer{data: data}
+	for i := range int(count) {
+		l.skipSpace()
+		inner, err1 := strconv.Atoi(l.token())
+		l.skipSpace()
+		offset, err2 := strconv.Atoi(l.token())
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid header in object stream %d", num)
+		}
+		objects.indexes[inner] = i
+		objects.offsets = append(objects.offsets, offset)
+	}
+
+	r.objStreams[num] = objects
+	return objects, nil
+}
+
+// resolve follows indirect references.
+func (r *reader) resolve(v any) any {
+	for range maxNesting {
+		ref, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = r.object(ref.num)
+	}
+	return nil
+}
+
+// decodeStream decodes stream data. Only the filters used for
+// cross-reference and object streams are supported.
+func (r *reader) decodeStream(s stream) ([]byte, error) {
+	filters := r.resolve(s.dict["Filter"])
+	params := r.resolve(s.dict["DecodeParms"])
+
+	if f, ok := filters.(name); ok {
+		filters = array{f}
+		params = array{params}
+	}
+
+	data := s.data
+	list, _ := filters.(array)
+	paramList, _ := params.(array)
+
+	for i, filter := range list {
+		if r.resolve(filter) != name("FlateDecode") {
+			return nil, fmt.Errorf("unsupported filter %v", filter)
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(zr)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		data = decoded
+
+		if i < len(paramList) {
+			if p, ok := r.resolve(paramList[i]).(dict); ok {
+				if data, err = unpredict(data, p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// unpredict reverses the PNG predictors used on cross-reference streams.
+func unpredict(data []byte, params dict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("unsupported predictor %d", predictor)
+		}
+		return data, nil
+	}
+
+	columns := int64(1)
+	if c, ok := params["Columns"].(int64); ok && c > 0 {
+		columns = c
+	}
+	colors := int64(1)
+	if c, ok := params["Colors"].(int64); ok && c > 0 {
+		colors = c
+	}
+	bits := int64(8)
+	if b, ok := params["BitsPerComponent"].(int64); ok && b > 0 {
+		bits = b
+	}
+
+	bpp := int(max((colors*bits+7)/8, 1))
+	rowSize := int((columns*colors*bits + 7) / 8)
+
+	var out []byte
+	prev := make([]byte, rowSize)
+
+	for pos := 0; pos+1+rowSize <= len(data); pos += 1 + rowSize {
+		kind := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowSize]...)
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,548 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	stampFontPrefix = "BlackoutBox"
+	stampMargin     = 18.0
+	stampHeaderSize = 10.0
+	stampFooterSize = 8.0
+	stampPadding    = 3.0
+)
+
+var ErrEncrypted = errors.New("encrypted PDFs can't be stamped")
+
+// Stamp is text drawn onto every page of an existing PDF: a header at the
+// top, a footer at the bottom and an outlined watermark across the page.
+// Empty parts are left out. {page} and {pages} are replaced with the page
+// number and page count.
+type Stamp struct {
+	Header    string
+	Footer    string
+	Watermark string
+}
+
+type pageInfo struct {
+	ref    ref
+	dict   dict
+	res    any
+	box    [4]float64
+	rotate int
+}
+
+// StampPDF returns a copy of a PDF file with the stamp drawn over every
+// page. The original file is kept byte for byte and the stamp appended as
+// an incremental update, unless the file was damaged and had to be
+// rewritten.
+func StampPDF(data []byte, stamp Stamp) ([]byte, error) {
+	return OverlayPDF(data, stamp.draw)
+}
+
+// OverlayFunc draws onto one page of an existing PDF. The origin is the
+// bottom left corner of the page as it is displayed, and width and height
+// are its displayed size, so rotated pages need no special handling.
+type OverlayFunc func(p *Page, page, pages int, width, height float64)
+
+// OverlayPDF returns a copy of a PDF file with draw called to add content
+// over each page, appended as an incremental update like StampPDF.
+func OverlayPDF(data []byte, draw OverlayFunc) ([]byte, error) {
+	r, err := read(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.trailer["Encrypt"] != nil {
+		return nil, ErrEncrypted
+	}
+
+	pages, err := r.pages()
+	if err != nil {
+		return nil, err
+	}
+
+	u := newUpdate(r)
+
+	fonts := dict{}
+	for _, font := range standardFonts {
+		fonts[name(stampFontPrefix+font.resourceName())] = u.add(dict{
+			"Type":     name("Font"),
+			"Subtype":  name("Type1"),
+			"BaseFont": name(font.baseFont()),
+			"Encoding": name("WinAnsiEncoding"),
+		})
+	}
+
+	// The page's own content is wrapped in q/Q, so the stamp is drawn with
+	// a clean graphics state whatever the page leaves behind.
+	save := u.add(stream{dict: dict{}, data: []byte("q\n")})
+
+	for i, page := range pages {
+		overlay := u.add(stream{dict: dict{}, data: render(page, i+1, len(pages), draw)})
+
+		contents := array{save}
+		switch c := page.dict["Contents"].(type) {
+		case ref:
+			if list, ok := r.resolve(c).(array); ok {
+				contents = append(contents, list...)
+			} else {
+				contents = append(contents, c)
+			}
+		case array:
+			contents = append(contents, c...)
+		}
+		contents = append(contents, overlay)
+
+		updated := page.dict.clone()
+		updated["Contents"] = contents
+		updated["Resources"] = r.withFonts(page.res, fonts)
+		u.set(page.ref, updated)
+	}
+
+	return u.bytes(), nil
+}
+
+// PageCount returns the number of pages in a PDF file.
+func PageCount(data []byte) (int, error) {
+	r, err := read(data)
+	if err != nil {
+		return 0, err
+	}
+
+	pages, err := r.pages()
+	if err != nil {
+		return 0, err
+	}
+	return len(pages), nil
+}
+
+// pages walks the page tree, resolving inherited attributes.
+func (r *reader) pages() ([]pageInfo, error) {
+	catalog, _ := r.resolve(r.trailer["Root"]).(dict)
+	root, ok := catalog["Pages"].(ref)
+	if !ok {
+		return nil, errors.New("PDF has no page tree")
+	}
+
+	var pages []pageInfo
+	visited := map[int]bool{}
+
+	var walk func(node ref, inherited pageInfo, depth int) error
+	walk = func(node ref, inherited pageInfo, depth int) error {
+		if visited[node.num] || depth > maxNesting {
+			return errors.New("PDF page tree contains a cycle")
+		}
+		visited[node.num] = true
+
+		d, ok := r.object(node.num).(dict)
+		if !ok {
+			return fmt.Errorf("page tree node %d not found", node.num)
+		}
+
+		info := inherited
+		if res, ok := d["Resources"]; ok {
+			info.res = res
+		}
+		if box, ok := r.box(d["MediaBox"]); ok {
+			info.box = box
+		}
+		if box, ok := r.box(d["CropBox"]); ok {
+			info.box = box
+		}
+		if rotate, ok := r.resolve(d["Rotate"]).(int64); ok {
+			info.rotate = int(((rotate%360)+360)%360) / 90 * 90
+		}
+
+		kids, isNode := r.resolve(d["Kids"]).(array)
+		if !isNode || d["Type"] == name("Page") {
+			info.ref, info.dict = node, d
+			pages = append(pages, info)
+			return nil
+		}
+
+		for _, kid := range kids {
+			kidRef, ok := kid.(ref)
+			if !ok {
+				return errors.New("PDF page tree has a direct kid")
+			}
+			if err := walk(kidRef, info, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Pages without a box are taken to be A4.
+	if err := walk(root, pageInfo{box: [4]float64{0, 0, PageWidth, PageHeight}}, 0); err != nil {
+		return nil, err
+	}
+
+	if len(pages) == 0 {
+		return nil, errors.New("PDF has no pages")
+	}
+	return pages, nil
+}
+
+func (r *reader) box(v any) ([4]float64, bool) {
+	var box [4]float64
+
+	values, ok := r.resolve(v).(array)
+	if !ok || len(values) != 4 {
+		return box, false
+	}
+
+	for i, value := range values {
+		switch n := r.resolve(value).(type) {
+		case int64:
+			box[i] = float64(n)
+		case float64:
+			box[i] = n
+		default:
+			return box, false
+		}
+	}
+
+	box[0], box[2] = min(box[0], box[2]), max(box[0], box[2])
+	box[1], box[3] = min(box[1], box[3]), max(box[1], box[3])
+	return box, box[2] > box[0] && box[3] > box[1]
+}
+
+// withFonts returns a copy of a resource dictionary with fonts added.
+func (r *reader) withFonts(resources any, fonts dict) dict {
+	res, _ := r.resolve(resources).(dict)
+	if res == nil {
+		res = dict{}
+	}
+	res = res.clone()
+
+	pageFonts, _ := r.resolve(res["Font"]).(dict)
+	if pageFonts == nil {
+		pageFonts = dict{}
+	}
+	pageFonts = pageFonts.clone()
+	for k, v := range fonts {
+		pageFonts[k] = v
+	}
+
+	res["Font"] = pageFonts
+	return res
+}
+
+// render draws the overlay for one page. Coordinates are first turned to
+// match how the page is displayed, so the text reads upright on rotated
+// pages.
+func render(info pageInfo, page, pages int, draw OverlayFunc) []byte {
+	llx, lly, urx, ury := info.box[0], info.box[1], info.box[2], info.box[3]
+	width, height := urx-llx, ury-lly
+
+	var matrix [6]float64
+	switch info.rotate {
+	case 90:
+		matrix = [6]float64{0, 1, -1, 0, urx, lly}
+		width, height = height, width
+	case 180:
+		matrix = [6]float64{-1, 0, 0, -1, urx, ury}
+	case 270:
+		matrix = [6]float64{0, -1, 1, 0, llx, ury}
+		width, height = height, width
+	default:
+		matrix = [6]float64{1, 0, 0, 1, llx, lly}
+	}
+
+	p := &Page{fontPrefix: stampFontPrefix}
+	p.content.WriteString("\nQ\nq\n")
+	for i, v := range matrix {
+		if i > 0 {
+			p.content.WriteByte(' ')
+		}
+		p.content.WriteString(num(v))
+	}
+	p.content.WriteString(" cm\n")
+
+	draw(p, page, pages, width, height)
+
+	p.content.WriteString("Q\n")
+	return p.content.Bytes()
+}
+
+// draw draws the stamp onto one page.
+func (s Stamp) draw(p *Page, page, pages int, width, height float64) {
+	replacer := strings.NewReplacer("{page}", strconv.Itoa(page), "{pages}", strconv.Itoa(pages))
+
+	if s.Watermark != "" {
+		p.watermark(width, height, replacer.Replace(s.Watermark))
+	}
+	if s.Header != "" {
+		p.label(width, height-stampMargin-stampHeaderSize, HelveticaBold, stampHeaderSize, replacer.Replace(s.Header))
+	}
+	if s.Footer != "" {
+		p.label(width, stampMargin, Helvetica, stampFooterSize, replacer.Replace(s.Footer))
+	}
+}
+
+// label draws a line of text centred across the page on a white band, so
+// it stays legible over the page content.
+func (p *Page) label(pageWidth, y float64, font Font, size float64, text string) {
+	text = Truncate(font, size, text, pageWidth-2*stampMargin)
+	textWidth := TextWidth(font, size, text)
+	x := (pageWidth - textWidth) / 2
+
+	p.FillRect(x-stampPadding, y-stampPadding, textWidth+2*stampPadding, size+stampPadding, 1)
+	p.Text(x, y, font, size, text)
+}
+
+// watermark draws outlined text diagonally across the page. Only the
+// outline is drawn, so the content underneath stays readable.
+func (p *Page) watermark(pageWidth, pageHeight float64, text string) {
+	angle := math.Atan2(pageHeight, pageWidth)
+	diagonal := math.Hypot(pageWidth, pageHeight)
+
+	size := 72.0
+	if width := TextWidth(HelveticaBold, size, text); width > 0 {
+		size = min(size*diagonal*0.7/width, 144)
+	}
+	width := TextWidth(HelveticaBold, size, text)
+
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	capHeight := size * 0.72
+	x := pageWidth/2 - width/2*cos + capHeight/2*sin
+	y := pageHeight/2 - width/2*sin - capHeight/2*cos
+
+	fmt.Fprintf(&p.content, "BT /%s%s %s Tf 1 Tr 0.6 G 1.5 w %s %s %s %s %s %s Tm ",
+		p.fontPrefix, HelveticaBold.resourceName(), num(size), num4(cos), num4(sin), num4(-sin), num4(cos), num(x), num(y))
+	writeString(&p.content, encodeWinAnsi(text))
+	p.content.WriteString(" Tj ET 0 Tr 0 G\n")
+}
+
+func num4(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 4, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// update collects new and changed objects and writes them after the
+// original file.
+type update struct {
+	r       *reader
+	objects map[int]any
+	gens    map[int]int
+	next    int
+}
+
+func newUpdate(r *reader) *update {
+	next := 1
+	if size, ok := r.trailer["Size"].(int64); ok {
+		next = int(size)
+	}
+	for num := range r.xref {
+		next = max(next, num+1)
+	}
+
+	return &update{r: r, objects: map[int]any{}, gens: map[int]int{}, next: next}
+}
+
+func (u *update) add(obj any) ref {
+	num := u.next
+	u.next++
+	u.objects[num] = obj
+	return ref{num: num}
+}
+
+func (u *update) set(target ref, obj any) {
+	u.objects[target.num] = obj
+	u.gens[target.num] = target.gen
+}
+
+// gen returns the generation an object is written with.
+func (u *update) gen(num int) int {
+	if gen, ok := u.gens[num]; ok {
+		return gen
+	}
+	if _, ok := u.objects[num]; ok {
+		return 0
+	}
+	return u.r.xref[num].gen
+}
+
+func (u *update) bytes() []byte {
+	if u.r.rebuilt {
+		return u.rewrite()
+	}
+
+	var buf bytes.Buffer
+	buf.Write(u.r.data)
+	if !bytes.HasSuffix(u.r.data, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+
+	offsets := u.writeObjects(&buf, u.sortedNums(u.objects))
+
+	trailer := u.trailer()
+	trailer["Prev"] = int64(u.r.startxref)
+
+	if u.r.xrefStream {
+		u.writeXrefStream(&buf, offsets, trailer)
+	} else {
+		u.writeXrefTable(&buf, offsets, trailer)
+	}
+
+	return buf.Bytes()
+}
+
+// rewrite writes the whole document afresh, for files whose
+// cross-reference was damaged.
+func (u *update) rewrite() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	all := map[int]any{}
+	for num := range u.r.xref {
+		obj := u.r.object(num)
+		if s, ok := obj.(stream); ok && (s.dict["Type"] == name("ObjStm") || s.dict["Type"] == name("XRef")) {
+			continue
+		}
+		if obj != nil {
+			all[num] = obj
+		}
+	}
+	for num, obj := range u.objects {
+		all[num] = obj
+	}
+
+	nums := u.sortedNums(all)
+	offsets := u.writeObjects(&buf, nums)
+	u.writeXrefTable(&buf, offsets, u.trailer())
+
+	return buf.Bytes()
+}
+
+func (u *update) sortedNums(objects map[int]any) []int {
+	nums := make([]int, 0, len(objects))
+	for num := range objects {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+	return nums
+}
+
+func (u *update) writeObjects(buf *bytes.Buffer, nums []int) map[int]int {
+	offsets := make(map[int]int, len(nums))
+
+	for _, num := range nums {
+		obj, ok := u.objects[num]
+		if !ok {
+			obj = u.r.object(num)
+		}
+
+		offsets[num] = buf.Len()
+		fmt.Fprintf(buf, "%d %d obj\n", num, u.gen(num))
+		writeObject(buf, obj)
+		buf.WriteString("\nendobj\n")
+	}
+
+	return offsets
+}
+
+func (u *update) trailer() dict {
+	trailer := dict{"Size": int64(u.next), "Root": u.r.trailer["Root"]}
+	for _, key := range []name{"Info", "ID"} {
+		if v, ok := u.r.trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	return trailer
+}
+
+// xrefRuns splits sorted object numbers into runs of consecutive numbers.
+func xrefRuns(nums []int) [][]int {
+	var runs [][]int
+	for _, num := range nums {
+		if n := len(runs); n > 0 && runs[n-1][len(runs[n-1])-1] == num-1 {
+			runs[n-1] = append(runs[n-1], num)
+		} else {
+			runs = append(runs, []int{num})
+		}
+	}
+	return runs
+}
+
+func (u *update) writeXrefTable(buf *bytes.Buffer, offsets map[int]int, trailer dict) {
+	nums := make([]int, 0, len(offsets))
+	for num := range offsets {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+
+	xref := buf.Len()
+	buf.WriteString("xref\n")
+
+	if u.r.rebuilt {
+		// A complete table, with the gaps marked free.
+		fmt.Fprintf(buf, "0 %d\n", u.next)
+		for num := range u.next {
+			if offset, ok := offsets[num]; ok {
+				fmt.Fprintf(buf, "%010d %05d n \n", offset, u.gen(num))
+			} else {
+				buf.WriteString("0000000000 65535 f \n")
+			}
+		}
+	} else {
+		for _, run := range xrefRuns(nums) {
+			fmt.Fprintf(buf, "%d %d\n", run[0], len(run))
+			for _, num := range run {
+				fmt.Fprintf(buf, "%010d %05d n \n", offsets[num], u.gen(num))
+			}
+		}
+	}
+
+	buf.WriteString("trailer\n")
+	writeObject(buf, trailer)
+	fmt.Fprintf(buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+}
+
+// writeXrefStream writes the update's cross-reference as a stream, for
+// files that use cross-reference streams themselves.
+func (u *update) writeXrefStream(buf *bytes.Buffer, offsets map[int]int, trailer dict) {
+	self := u.next
+	u.next++
+	offsets[self] = buf.Len()
+	trailer["Size"] = int64(u.next)
+
+	nums := make([]int, 0, len(offsets))
+	for num := range offsets {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+
+	var data []byte
+	index := array{}
+	for _, run := range xrefRuns(nums) {
+		index = append(index, int64(run[0]), int64(len(run)))
+		for _, num := range run {
+			offset, gen := offsets[num], u.gen(num)
+			data = append(data, 1,
+				byte(offset>>24), byte(offset>>16), byte(offset>>8), byte(offset),
+				byte(gen>>8), byte(gen))
+		}
+	}
+
+	trailer["Type"] = name("XRef")
+	trailer["W"] = array{int64(1), int64(4), int64(2)}
+	trailer["Index"] = index
+
+	fmt.Fprintf(buf, "%d 0 obj\n", self)
+	writeObject(buf, stream{dict: trailer, data: data})
+	buf.WriteString("\nendobj\n")
+	fmt.Fprintf(buf, "startxref\n%d\n%%%%EOF\n", offsets[self])
+}
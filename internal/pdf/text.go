@@ -0,0 +1,407 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+)
+
+// textFont decodes the strings shown in one font. Fonts with a ToUnicode
+// map are decoded through it; other simple fonts are taken to be in
+// WinAnsiEncoding, which is close enough for searching.
+type textFont struct {
+	codeLen   int
+	toUnicode map[uint32]string
+}
+
+// ExtractText returns the text shown on the pages of a PDF file, in the
+// order it is drawn, with a blank line between pages. Text in fonts that
+// can't be decoded is left out.
+func ExtractText(data []byte) (string, error) {
+	r, err := read(data)
+	if err != nil {
+		return "", err
+	}
+	if r.trailer["Encrypt"] != nil {
+		return "", ErrEncrypted
+	}
+
+	pages, err := r.pages()
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for i, page := range pages {
+		if i > 0 {
+			out.WriteString("\n\n")
+		}
+		e := &textExtractor{r: r, out: &out, fonts: map[int]*textFont{}, visited: map[int]bool{}}
+		e.contents(r.contentData(page.dict["Contents"]), page.res, 0)
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+// contentData returns the decoded content of a page's content streams.
+// Streams with filters that aren't supported are skipped.
+func (r *reader) contentData(contents any) []byte {
+	var streams array
+	switch c := r.resolve(contents).(type) {
+	case stream:
+		return r.decodeOrEmpty(c)
+	case array:
+		streams = c
+	}
+
+	var data []byte
+	for _, s := range streams {
+		if s, ok := r.resolve(s).(stream); ok {
+			data = append(data, r.decodeOrEmpty(s)...)
+			data = append(data, '\n')
+		}
+	}
+	return data
+}
+
+func (r *reader) decodeOrEmpty(s stream) []byte {
+	data, err := r.decodeStream(s)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+type textExtractor struct {
+	r       *reader
+	out     *strings.Builder
+	fonts   map[int]*textFont
+	visited map[int]bool
+}
+
+// contents writes the text shown by a content stream, following form
+// XObjects it draws.
+func (e *textExtractor) contents(data []byte, resources any, depth int) {
+	if depth > maxNesting {
+		return
+	}
+
+	res, _ := e.r.resolve(resources).(dict)
+	fontDicts, _ := e.r.resolve(res["Font"]).(dict)
+	xobjects, _ := e.r.resolve(res["XObject"]).(dict)
+
+	var font *textFont
+	var operands []any
+
+	l := &lexer{data: data}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return
+		}
+
+		obj, err := l.readObject()
+		if err != nil {
+			// Skip what can't be parsed rather than lose the rest of
+			// the page.
+			l.pos++
+			operands = operands[:0]
+			continue
+		}
+
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "BI":
+			skipInlineImage(l)
+		case "Tf":
+			if len(operands) > 0 {
+				if fontName, ok := operands[0].(name); ok {
+					font = e.font(fontDicts[fontName])
+				}
+			}
+		case "Tj":
+			e.show(font, operands)
+		case "'", "\"":
+			e.newline()
+			e.show(font, operands)
+		case "TJ":
+			if len(operands) > 0 {
+				items, _ := operands[len(operands)-1].(array)
+				for _, item := range items {
+					switch v := item.(type) {
+					case pdfString:
+						e.write(font, v)
+					case int64, float64:
+						// A large negative adjustment moves the
+						// next glyph along by about a space.
+						if number(v) < -200 {
+							e.space()
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && number(operands[1]) != 0 {
+				e.newline()
+			} else {
+				e.space()
+			}
+		case "T*", "Tm", "ET":
+			e.newline()
+		case "Do":
+			if len(operands) > 0 {
+				if xobjectName, ok := operands[0].(name); ok {
+					e.form(xobjects[xobjectName], resources, depth)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// form writes the text of a form XObject.
+func (e *textExtractor) form(v any, resources any, depth int) {
+	target, ok := v.(ref)
+	if !ok || e.visited[target.num] {
+		return
+	}
+	e.visited[target.num] = true
+
+	s, ok := e.r.resolve(target).(stream)
+	if !ok || s.dict["Subtype"] != name("Form") {
+		return
+	}
+	if res, ok := s.dict["Resources"]; ok {
+		resources = res
+	}
+	e.contents(e.r.decodeOrEmpty(s), resources, depth+1)
+}
+
+func (e *textExtractor) show(font *textFont, operands []any) {
+	if len(operands) > 0 {
+		if s, ok := operands[len(operands)-1].(pdfString); ok {
+			e.write(font, s)
+		}
+	}
+}
+
+func (e *textExtractor) write(font *textFont, s pdfString) {
+	if font == nil {
+		font = &textFont{codeLen: 1}
+	}
+	e.out.WriteString(font.decode(s))
+}
+
+func (e *textExtractor) space() {
+	text := e.out.String()
+	if text != "" && !strings.HasSuffix(text, " ") && !strings.HasSuffix(text, "\n") {
+		e.out.WriteByte(' ')
+	}
+}
+
+func (e *textExtractor) newline() {
+	text := e.out.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		e.out.WriteByte('\n')
+	}
+}
+
+// font returns the decoder for a font dictionary.
+func (e *textExtractor) font(v any) *textFont {
+	num := -1
+	if target, ok := v.(ref); ok {
+		num = target.num
+		if font, ok := e.fonts[num]; ok {
+			return font
+		}
+	}
+
+	font := &textFont{codeLen: 1}
+	d, _ := e.r.resolve(v).(dict)
+	if d["Subtype"] == name("Type0") {
+		font.codeLen = 2
+	}
+	if s, ok := e.r.resolve(d["ToUnicode"]).(stream); ok {
+		font.toUnicode = parseToUnicode(e.r.decodeOrEmpty(s))
+	} else if font.codeLen == 2 {
+		// Composite fonts can't be decoded without a map.
+		font.toUnicode = map[uint32]string{}
+	}
+
+	if num >= 0 {
+		e.fonts[num] = font
+	}
+	return font
+}
+
+func (f *textFont) decode(s pdfString) string {
+	if f.toUnicode == nil {
+		return decodeWinAnsi(s)
+	}
+
+	var out strings.Builder
+	for i := 0; i+f.codeLen <= len(s); i += f.codeLen {
+		out.WriteString(f.toUnicode[codeOf(s[i:i+f.codeLen])])
+	}
+	return out.String()
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode
+// CMap.
+func parseToUnicode(data []byte) map[uint32]string {
+	mapping := map[uint32]string{}
+
+	var operands []any
+	l := &lexer{data: data}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return mapping
+		}
+
+		obj, err := l.readObject()
+		if err != nil {
+			l.pos++
+			continue
+		}
+
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					mapping[codeOf(src)] = decodeUTF16(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := codeOf(lo), codeOf(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					// Consecutive codes map to consecutive characters.
+					runes := []rune(decodeUTF16(dst))
+					if len(runes) == 0 {
+						continue
+					}
+					for code := start; code <= end; code++ {
+						last := runes[len(runes)-1] + rune(code-start)
+						mapping[code] = string(runes[:len(runes)-1]) + string(last)
+					}
+				case array:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && start+uint32(j) <= end {
+							mapping[start+uint32(j)] = decodeUTF16(s)
+						}
+					}
+				}
+			}
+		}
+
+		operands = operands[:0]
+	}
+}
+
+func codeOf(s pdfString) uint32 {
+	var code uint32
+	for _, b := range s {
+		code = code<<8 | uint32(b)
+	}
+	return code
+}
+
+func decodeUTF16(s pdfString) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeWinAnsi reverses encodeWinAnsi.
+func decodeWinAnsi(s pdfString) string {
+	var out strings.Builder
+	for _, b := range s {
+		switch {
+		case b == '\t' || b == '\n' || b == '\r':
+			out.WriteByte(' ')
+		case b < 32:
+		case b >= 0x80 && b <= 0x9F:
+			if r, ok := winAnsiRunes[b]; ok {
+				out.WriteRune(r)
+			}
+		default:
+			out.WriteRune(rune(b))
+		}
+	}
+	return out.String()
+}
+
+var winAnsiRunes = func() map[byte]rune {
+	runes := make(map[byte]rune, len(winAnsiSpecials))
+	for r, b := range winAnsiSpecials {
+		runes[b] = r
+	}
+	return runes
+}()
+
+// skipInlineImage moves past the data of an inline image, which isn't
+// PDF syntax, to the EI operator that ends it.
+func skipInlineImage(l *lexer) {
+	start := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if start < 0 {
+		l.pos = len(l.data)
+		return
+	}
+
+	pos := l.pos + start + 2
+	for {
+		end := bytes.Index(l.data[pos:], []byte("EI"))
+		if end < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		pos += end
+		before := pos == 0 || isWhitespace(l.data[pos-1])
+		after := pos+2 >= len(l.data) || isWhitespace(l.data[pos+2])
+		pos += 2
+		if before && after {
+			l.pos = pos
+			return
+		}
+	}
+}
+
+func number(v any) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package pdf writes simple PDF documents using only the standard library.
+// It supports what the print pipeline needs: text in the built-in
+// Helvetica and Courier fonts, lines, rectangles and images on A4 pages,
+// and stamping text onto the pages of existing PDF files.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// A4 in points.
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Document struct {
+	pages  []*Page
+	images []*Image
+}
+
+type Page struct {
+	content bytes.Buffer
+
+	// fontPrefix is prepended to font resource names, so stamps don't
+	// clash with the fonts of the page they are drawn on.
+	fontPrefix string
+
+	// images holds the images drawn on the page by id.
+	images map[int]*Image
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Text draws a single line of text with its baseline starting at x, y.
+// The origin is the bottom left corner of the page.
+func (p *Page) Text(x, y float64, font Font, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /%s%s %s Tf %s %s Td ", p.fontPrefix, font.resourceName(), num(size), num(x), num(y))
+	writeString(&p.content, encodeWinAnsi(text))
+	p.content.WriteString(" Tj ET\n")
+}
+
+// Line draws a straight line of the given width.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// Rect strokes a rectangle with its bottom left corner at x, y.
+func (p *Page) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s %s %s re S\n", num(width), num(x), num(y), num(w), num(h))
+}
+
+// FillRect fills a rectangle with a shade of grey, 0 being black and 1 white.
+func (p *Page) FillRect(x, y, w, h, grey float64) {
+	fmt.Fprintf(&p.content, "%s g %s %s %s %s re f 0 g\n", num(grey), num(x), num(y), num(w), num(h))
+}
+
+// WriteTo writes the document as a complete PDF file.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	startObject := func() int {
+		offsets = append(offsets, buf.Len())
+		id := len(offsets)
+		fmt.Fprintf(&buf, "%d 0 obj\n", id)
+		return id
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Fixed objects: 1 catalog, 2 page tree, then the fonts. Pages follow
+	// as page/content pairs, and images come last.
+	firstFont := 3
+	firstPage := firstFont + len(standardFonts)
+	firstImage := firstPage + 2*len(d.pages)
+
+	startObject()
+	buf.WriteString("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	startObject()
+	buf.WriteString("<< /Type /Pages /Kids [")
+	for i := range d.pages {
+		fmt.Fprintf(&buf, " %d 0 R", firstPage+i*2)
+	}
+	fmt.Fprintf(&buf, " ] /Count %d >>\nendobj\n", len(d.pages))
+
+	var fontResources strings.Builder
+	for i, font := range standardFonts {
+		startObject()
+		fmt.Fprintf(&buf, "<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>\nendobj\n", font.baseFont())
+		fmt.Fprintf(&fontResources, " /%s %d 0 R", font.resourceName(), firstFont+i)
+	}
+
+	for _, page := range d.pages {
+		pageId := startObject()
+		fmt.Fprintf(&buf, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font <<%s >>",
+			num(PageWidth), num(PageHeight), fontResources.String())
+		if len(page.images) > 0 {
+			buf.WriteString(" /XObject <<")
+			for _, img := range d.images {
+				if page.images[img.id] != nil {
+					fmt.Fprintf(&buf, " /Im%d %d 0 R", img.id, firstImage+img.id-1)
+				}
+			}
+			buf.WriteString(" >>")
+		}
+		fmt.Fprintf(&buf, " >> /Contents %d 0 R >>\nendobj\n", pageId+1)
+
+		startObject()
+		fmt.Fprintf(&buf, "<< /Length %d >>\nstream\n", page.content.Len())
+		buf.Write(page.content.Bytes())
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+
+	for _, img := range d.images {
+		startObject()
+		fmt.Fprintf(&buf, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s /Length %d >>\nstream\n",
+			img.Width, img.Height, img.colorSpace, img.filter, len(img.data))
+		buf.Write(img.data)
+		buf.WriteString("\nendstream\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Bytes returns the document as a complete PDF file.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// Save writes the document to a file.
+func (d *Document) Save(path string) error {
+	return os.WriteFile(path, d.Bytes(), 0644)
+}
+
+// writeString writes a PDF literal string, escaping delimiters.
+func writeString(buf *bytes.Buffer, text []byte) {
+	buf.WriteByte('(')
+	for _, b := range text {
+		if b == '(' || b == ')' || b == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(b)
+	}
+	buf.WriteByte(')')
+}
+
+func num(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,309 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package search keeps a full-text index of document contents in an
+// in-memory SQLite FTS5 table. Stored files may be encrypted, so the
+// index is never written to disk; it is built again on startup.
+package search
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/storage"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+
+	// Matches in the reference and tags count for more than matches in
+	// the content.
+	referenceWeight = 10.0
+	tagsWeight      = 5.0
+	contentWeight   = 1.0
+
+	snippetTokens = 16
+)
+
+var ErrUnavailable = errors.New("full-text search is not available")
+
+// DocumentLister lists the documents to index.
+type DocumentLister interface {
+	Get() ([]models.Document, error)
+}
+
+type Index struct {
+	db        *sql.DB
+	documents DocumentLister
+
+	// mu serialises refreshes. indexed holds the state of each indexed
+	// document when it was indexed, so unchanged ones are skipped.
+	mu      sync.Mutex
+	indexed map[int64]string
+
+	// refresh is signaled when documents changed, so the worker refreshes
+	// the index without keeping the request that changed them waiting.
+	refresh chan struct{}
+}
+
+// NewIndex creates an empty index. If SQLite was built without FTS5, the
+// error wraps ErrUnavailable; a nil index can still be used, but finds
+// nothing.
+func NewIndex(documents DocumentLister) (*Index, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+
+	// Every connection to :memory: gets its own database.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	_, err = db.Exec(`
+		CREATE VIRTUAL TABLE document_search USING fts5(
+			file_id, tags, content,
+			system_id UNINDEXED, tag_list UNINDEXED,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)
+	`)
+	if err != nil {
+		db.Close()
+		if strings.Contains(err.Error(), "no such module") {
+			return nil, fmt.Errorf("%w: SQLite was built without FTS5 (build with -tags sqlite_fts5)", ErrUnavailable)
+		}
+		return nil, err
+	}
+
+	return &Index{db: db, documents: documents, indexed: map[int64]string{}, refresh: make(chan struct{}, 1)}, nil
+}
+
+// RequestRefresh asks for the index to be refreshed after documents
+// changed. A request that is already pending covers the new changes too.
+func (i *Index) RequestRefresh() {
+	if i == nil {
+		return
+	}
+	select {
+	case i.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshRequested is signaled whenever a refresh has been requested and
+// is waiting for Refresh.
+func (i *Index) RefreshRequested() <-chan struct{} {
+	if i == nil {
+		return nil
+	}
+	return i.refresh
+}
+
+// Refresh brings the index up to date with the documents: new and
+// changed documents are indexed and removed ones dropped. It returns how
+// many documents were indexed.
+func (i *Index) Refresh() (int, error) {
+	if i == nil {
+		return 0, nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	documents, err := i.documents.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	live := make(map[int64]bool, len(documents))
+	indexed := 0
+
+	for _, doc := range documents {
+		if doc.DeletedAt != nil {
+			continue
+		}
+		live[doc.Id] = true
+
+		state := documentState(doc)
+		if i.indexed[doc.Id] == state {
+			continue
+		}
+
+		if err := i.put(doc, extract(doc)); err != nil {
+			return indexed, err
+		}
+		i.indexed[doc.Id] = state
+		indexed++
+	}
+
+	for id := range i.indexed {
+		if live[id] {
+			continue
+		}
+		if _, err := i.db.Exec(`DELETE FROM document_search WHERE rowid = ?`, id); err != nil {
+			return indexed, err
+		}
+		delete(i.indexed, id)
+	}
+
+	return indexed, nil
+}
+
+func (i *Index) put(doc models.Document, content string) error {
+	tagList, err := json.Marshal(doc.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM document_search WHERE rowid = ?`, doc.Id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO document_search (rowid, file_id, tags, content, system_id, tag_list)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, doc.Id, doc.FileReference, strings.Join(doc.Tags, " "), content, doc.SystemId, string(tagList))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Search returns the documents matching a query, best match first. Only
+// DocumentId, Snippet and Rank are set on the results.
+func (i *Index) Search(query models.SearchQuery) ([]models.SearchResult, error) {
+	if i == nil {
+		return nil, ErrUnavailable
+	}
+
+	match := matchExpression(query.Text)
+	if match == "" {
+		return []models.SearchResult{}, nil
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	where := []string{`document_search MATCH ?`}
+	args := []any{match}
+	if query.SystemId != nil {
+		where = append(where, `system_id = ?`)
+		args = append(args, *query.SystemId)
+	}
+	if query.Tags != nil {
+		cond, tagArgs := query.Tags.SQL(`EXISTS (SELECT 1 FROM json_each(tag_list) WHERE value = ?)`)
+		where = append(where, cond)
+		args = append(args, tagArgs...)
+	}
+	args = append(args, limit)
+
+	rows, err := i.db.Query(fmt.Sprintf(`
+		SELECT rowid, snippet(document_search, -1, '<mark>', '</mark>', '…', %d), bm25(document_search, %g, %g, %g) AS score
+		FROM document_search
+		WHERE %s
+		ORDER BY score
+		LIMIT ?
+	`, snippetTokens, referenceWeight, tagsWeight, contentWeight, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.DocumentId, &result.Snippet, &result.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// matchExpression turns what someone typed into an FTS5 query matching
+// documents with all of the words. Words are quoted, so punctuation such
+// as the hyphen in "room-12" isn't read as query syntax; a trailing *
+// searches for words starting with what comes before it.
+func matchExpression(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.Trim(word, "*")
+		if word == "" {
+			continue
+		}
+
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// documentState describes what the indexed text of a document depends
+// on. Synced documents may point at a file that is changed in place, so
+// the file's size and modification time are part of it.
+func documentState(doc models.Document) string {
+	state := fmt.Sprint(doc.SystemId, "|", doc.FileReference, "|", doc.Tags, "|", doc.FilePath, "|", doc.PrintPath())
+	if info, err := os.Stat(doc.FilePath); err == nil {
+		state += fmt.Sprint("|", info.Size(), "|", info.ModTime().UnixNano())
+	}
+	return state
+}
+
+// extract returns the text of a document: that of the original if it is
+// a PDF, otherwise that of the PDF it was converted to, or the original
+// as it is if it is text. Files that can't be read are indexed by their
+// reference and tags only.
+func extract(doc models.Document) string {
+	data, err := storage.ReadFile(doc.FilePath)
+	if err != nil {
+		log.Printf("Failed to read document %d for indexing: %v", doc.Id, err)
+		return ""
+	}
+
+	if !bytes.HasPrefix(data, []byte("%PDF")) && doc.PrintPath() != doc.FilePath {
+		if converted, err := storage.ReadFile(doc.PrintPath()); err == nil {
+			data = converted
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte("%PDF")) {
+		text, err := pdf.ExtractText(data)
+		if err != nil {
+			log.Printf("Failed to extract text from document %d: %v", doc.Id, err)
+		}
+		return text
+	}
+
+	if utf8.Valid(data) && bytes.IndexByte(data, 0) < 0 {
+		return string(data)
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package sheets
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChangeSummary is printed in front of a delta print and tells staff which
+// pages in their binder to add, replace or throw away.
+type ChangeSummary struct {
+	SystemName  string
+	Binder      string
+	GeneratedAt time.Time
+	Added       []models.Document
+	Replaced    []models.Document
+	Removed     []models.PrintedVersion
+}
+
+var changeColumns = []column{
+	{"Change", margin, 70},
+	{"File ID", margin + 70, 170},
+	{"Tags", margin + 240, 130},
+	{"Last updated", margin + 370, pdf.PageWidth - 2*margin - 370},
+}
+
+// IsEmpty reports whether nothing in the binder needs changing.
+func (c ChangeSummary) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Replaced) == 0 && len(c.Removed) == 0
+}
+
+// Render lays out the change summary, continuing the list on extra pages
+// when needed.
+func (c ChangeSummary) Render() *pdf.Document {
+	doc := pdf.New()
+	page := doc.AddPage()
+	y := pdf.PageHeight - margin - 20
+
+	page.Text(margin, y, pdf.HelveticaBold, 26, "CHANGES SINCE LAST PRINT")
+	y -= 40
+
+	page.Text(margin, y, pdf.HelveticaBold, 18, pdf.Truncate(pdf.HelveticaBold, 18, c.SystemName, pdf.PageWidth-2*margin))
+	y -= 30
+
+	details := [][2]string{
+		{"Printed", c.GeneratedAt.Format(timeLayout)},
+		{"Binder", c.Binder},
+		{"Summary", fmt.Sprintf("%d new, %d replaced, %d removed", len(c.Added), len(c.Replaced), len(c.Removed))},
+	}
+	for _, detail := range details {
+		page.Text(margin, y, pdf.HelveticaBold, 11, detail[0])
+		page.Text(margin+100, y, pdf.Helvetica, 11, pdf.Truncate(pdf.Helvetica, 11, detail[1], pdf.PageWidth-2*margin-100))
+		y -= lineHeight
+	}
+
+	y -= 10
+	page.Text(margin, y, pdf.Helvetica, 10, "Add new pages, swap replaced pages for the ones that follow, and discard removed pages.")
+	y -= 10
+	y = tableHeader(page, y, changeColumns)
+
+	var rows [][]string
+	for _, document := range c.Added {
+		rows = append(rows, []string{"New", document.FileReference, strings.Join(document.Tags, ", "), formatUnix(document.UpdatedAt)})
+	}
+	for _, document := range c.Replaced {
+		rows = append(rows, []string{"Replaced", document.FileReference, strings.Join(document.Tags, ", "), formatUnix(document.UpdatedAt)})
+	}
+	for _, version := range c.Removed {
+		rows = append(rows, []string{"Removed", version.FileReference, strings.Join(version.Tags, ", "), "-"})
+	}
+
+	for _, cells := range rows {
+		if y < margin+rowHeight {
+			page = doc.AddPage()
+			y = tableHeader(page, pdf.PageHeight-margin, changeColumns)
+		}
+
+		y -= rowHeight
+		for j, col := range changeColumns {
+			font := pdf.Helvetica
+			if j == 0 {
+				font = pdf.HelveticaBold
+			}
+			page.Text(col.x+2, y+5, font, 10, pdf.Truncate(font, 10, cells[j], col.width-6))
+		}
+		page.Line(margin, y, pdf.PageWidth-margin, y, 0.25)
+	}
+
+	return doc
+}
+
+func formatUnix(timestamp *int64) string {
+	if timestamp == nil {
+		return "-"
+	}
+	return time.Unix(*timestamp, 0).Format(timeLayout)
+}
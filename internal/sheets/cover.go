@@ -0,0 +1,135 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package sheets renders the pages the print pipeline adds around a batch
+// of documents, so staff can tell printed piles apart.
+package sheets
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	margin     = 50.0
+	lineHeight = 16.0
+	rowHeight  = 18.0
+	timeLayout = "2006-01-02 15:04"
+)
+
+// CoverSheet is printed in front of every system's batch.
+type CoverSheet struct {
+	SystemName string
+	IncidentAt time.Time
+	Reason     string
+	Documents  []models.Document
+}
+
+type column struct {
+	title string
+	x     float64
+	width float64
+}
+
+var tocColumns = []column{
+	{"#", margin, 30},
+	{"File ID", margin + 30, 170},
+	{"Tags", margin + 200, 170},
+	{"Last updated", margin + 370, pdf.PageWidth - 2*margin - 370},
+}
+
+// Render lays out the cover sheet, continuing the table of contents on
+// extra pages when there are more documents than fit on one.
+func (c CoverSheet) Render() *pdf.Document {
+	doc := pdf.New()
+	page := doc.AddPage()
+	y := pdf.PageHeight - margin - 20
+
+	page.Text(margin, y, pdf.HelveticaBold, 26, "EMERGENCY PRINT")
+	y -= 40
+
+	page.Text(margin, y, pdf.HelveticaBold, 18, pdf.Truncate(pdf.HelveticaBold, 18, c.SystemName, pdf.PageWidth-2*margin))
+	y -= 30
+
+	details := [][2]string{
+		{"Incident time", c.IncidentAt.Format(timeLayout)},
+		{"Reason", c.Reason},
+		{"Documents", fmt.Sprintf("%d", len(c.Documents))},
+	}
+	for _, detail := range details {
+		page.Text(margin, y, pdf.HelveticaBold, 11, detail[0])
+		for i, line := range pdf.WrapText(pdf.Helvetica, 11, detail[1], pdf.PageWidth-2*margin-100) {
+			if i > 0 {
+				y -= lineHeight
+			}
+			page.Text(margin+100, y, pdf.Helvetica, 11, line)
+		}
+		y -= lineHeight
+	}
+
+	y -= 20
+	page.Text(margin, y, pdf.HelveticaBold, 14, "Contents")
+	y -= 10
+	y = tableHeader(page, y, tocColumns)
+
+	for i, document := range c.Documents {
+		if y < margin+rowHeight {
+			page = doc.AddPage()
+			y = tableHeader(page, pdf.PageHeight-margin, tocColumns)
+		}
+
+		cells := []string{
+			fmt.Sprintf("%d", i+1),
+			document.FileReference,
+			strings.Join(document.Tags, ", "),
+			formatUnix(document.UpdatedAt),
+		}
+
+		y -= rowHeight
+		for j, col := range tocColumns {
+			page.Text(col.x+2, y+5, pdf.Helvetica, 10, pdf.Truncate(pdf.Helvetica, 10, cells[j], col.width-6))
+		}
+		page.Line(margin, y, pdf.PageWidth-margin, y, 0.25)
+	}
+
+	return doc
+}
+
+func tableHeader(page *pdf.Page, y float64, columns []column) float64 {
+	y -= rowHeight
+	page.FillRect(margin, y, pdf.PageWidth-2*margin, rowHeight, 0.85)
+	for _, col := range columns {
+		page.Text(col.x+2, y+5, pdf.HelveticaBold, 10, col.title)
+	}
+	return y
+}
+
+// SeparatorSheet is printed between documents in a batch and names the
+// document that follows it.
+func SeparatorSheet(systemName string, document models.Document, position, total int) *pdf.Document {
+	doc := pdf.New()
+	page := doc.AddPage()
+	y := pdf.PageHeight/2 + 60
+
+	page.Text(margin, y, pdf.Helvetica, 12, pdf.Truncate(pdf.Helvetica, 12, systemName, pdf.PageWidth-2*margin))
+	y -= 36
+
+	page.Text(margin, y, pdf.HelveticaBold, 22, pdf.Truncate(pdf.HelveticaBold, 22, document.FileReference, pdf.PageWidth-2*margin))
+	y -= 28
+
+	page.Text(margin, y, pdf.Helvetica, 12, fmt.Sprintf("Document %d of %d", position, total))
+	y -= lineHeight
+
+	if len(document.Tags) > 0 {
+		page.Text(margin, y, pdf.Helvetica, 12, pdf.Truncate(pdf.Helvetica, 12, "Tags: "+strings.Join(document.Tags, ", "), pdf.PageWidth-2*margin))
+	}
+
+	page.Line(margin, pdf.PageHeight/2+100, pdf.PageWidth-margin, pdf.PageHeight/2+100, 2)
+	page.Line(margin, y-20, pdf.PageWidth-margin, y-20, 2)
+
+	return doc
+}
@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package storage
+
+import (
+	"blackoutbox/internal/models"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempPrefix names files that are still being written.
+const tempPrefix = ".upload-"
+
+// BlobIndex keeps track of the blobs in the store and what uses them.
+type BlobIndex interface {
+	GetUnreferenced() ([]models.Blob, error)
+	Exists(path string) (bool, error)
+	Delete(path string) (bool, error)
+	GetLegacyPaths() ([]string, error)
+	Adopt(oldPath string, blob models.Blob) error
+}
+
+// BlobPath returns where the blob with a hash is stored. The extension of
+// the uploaded file is kept, as conversion and downloads go by it.
+func BlobPath(hash, ext string) string {
+	return filepath.Join(BlobsRoot, hash[:2], hash+strings.ToLower(ext))
+}
+
+// IsBlob reports whether a file is in the blob store.
+func IsBlob(path string) bool {
+	return strings.HasPrefix(filepath.ToSlash(path), BlobsRoot+"/")
+}
+
+// PutBlob stores the content read from r as a blob, keeping the extension
+// of name. If the content is already stored, the existing blob is used.
+// The blob isn't in the index until a document using it is saved.
+func PutBlob(r io.Reader, name string) (models.Blob, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return models.Blob{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	blob := models.Blob{Hash: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	blob.Path = BlobPath(blob.Hash, filepath.Ext(filepath.Base(name)))
+
+	if err := os.MkdirAll(filepath.Dir(blob.Path), 0755); err != nil {
+		return models.Blob{}, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Touch an existing blob, so garbage collection leaves it alone
+	// until the document using it again is saved.
+	now := time.Now()
+	if err := os.Chtimes(blob.Path, now, now); err == nil {
+		return blob, nil
+	}
+
+	if err := WriteFile(blob.Path, data, 0644); err != nil {
+		return models.Blob{}, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	return blob, nil
+}
+
+// CollectBlobs removes blobs that are no longer used, with their PDF
+// conversions, and files left behind by uploads that failed. Files
+// changed within the grace period are kept, as an upload may be about to
+// use them. It returns how many files were removed.
+func CollectBlobs(index BlobIndex, gracePeriod time.Duration) (int, error) {
+	unreferenced, err := index.GetUnreferenced()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, blob := range unreferenced {
+		if info, err := os.Stat(blob.Path); err == nil && time.Since(info.ModTime()) < gracePeriod {
+			continue
+		}
+
+		deleted, err := index.Delete(blob.Path)
+		if err != nil {
+			return removed, err
+		}
+		if !deleted {
+			continue
+		}
+
+		for _, path := range []string{blob.Path, blob.Path + ".pdf"} {
+			if err := os.Remove(path); err == nil {
+				removed++
+			} else if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to remove blob %s: %v", path, err)
+			}
+		}
+	}
+
+	err = filepath.WalkDir(BlobsRoot, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < gracePeriod {
+			return err
+		}
+
+		if !strings.HasPrefix(entry.Name(), tempPrefix) {
+			// A file is used if it is a blob, or the conversion of one
+			for _, blobPath := range []string{path, strings.TrimSuffix(path, ".pdf")} {
+				if exists, err := index.Exists(blobPath); err != nil || exists {
+					return err
+				}
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+
+	return removed, err
+}
+
+// ImportFile stores a file placed outside the blob store, such as one a
+// sync names under upload/, as a blob. The file is left where it is, as
+// a system may name it again, but is encrypted like the blob if it isn't
+// already.
+func ImportFile(path string) (models.Blob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return models.Blob{}, err
+	}
+
+	plain, err := unseal(data)
+	if err != nil {
+		return models.Blob{}, err
+	}
+
+	blob, err := PutBlob(bytes.NewReader(plain), path)
+	if err != nil {
+		return models.Blob{}, err
+	}
+
+	if ring := keyring.Load(); ring != nil && needsSealing(ring, data) {
+		if err := encryptFile(path, plain); err != nil {
+			return models.Blob{}, fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+	}
+
+	return blob, nil
+}
+
+// ImportFiles moves document files stored before the blob store into it,
+// along with their PDF conversions. Files that are missing are skipped.
+// It returns how many files were imported.
+func ImportFiles(index BlobIndex) (int, error) {
+	paths, err := index.GetLegacyPaths()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+
+	for _, path := range paths {
+		blob, err := ImportFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return imported, err
+		}
+
+		converted := path + ".pdf"
+		if _, err := os.Stat(converted); err == nil {
+			if _, err := os.Stat(blob.Path + ".pdf"); err == nil {
+				os.Remove(converted)
+			} else if err := os.Rename(converted, blob.Path+".pdf"); err != nil {
+				return imported, err
+			}
+		}
+
+		if err := index.Adopt(path, blob); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+
+	return imported, nil
+}
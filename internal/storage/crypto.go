@@ -0,0 +1,413 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Environment variables the keyring is unlocked from. A key file
+	// holds base64 encoded 256-bit keys, one per line, the first being
+	// the one new files are encrypted with. A passphrase is stretched
+	// into a key instead, and the previous passphrase is kept readable
+	// while files are moved over to a new one.
+	keyFileEnv           = "BLACKOUTBOX_KEY_FILE"
+	passphraseEnv        = "BLACKOUTBOX_PASSPHRASE"
+	oldPassphraseEnv     = "BLACKOUTBOX_OLD_PASSPHRASE"
+	passphraseSaltFile   = "keyring.salt"
+	passphraseIterations = 600000
+
+	keySize   = 32
+	keyIdSize = 8
+	nonceSize = 12
+
+	// valuePrefix marks a database value that is encrypted.
+	valuePrefix = "enc:"
+)
+
+// fileMagic starts every encrypted file, followed by the id of the key
+// and the nonce. The whole header is authenticated with the content.
+const (
+	fileMagic  = "BBXENC1\x00"
+	headerSize = len(fileMagic) + keyIdSize + nonceSize
+)
+
+var (
+	ErrNoKey      = errors.New("file is encrypted and no key is loaded")
+	ErrUnknownKey = errors.New("file is encrypted with a key that isn't loaded")
+)
+
+// Keyring holds the keys stored files are encrypted with. The first key
+// encrypts new files; the others only decrypt files from before a
+// rotation until they are encrypted again.
+type Keyring struct {
+	keys []keyringKey
+}
+
+type keyringKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+var keyring atomic.Pointer[Keyring]
+
+// NewKeyring makes a keyring of 256-bit keys, the first being active.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys given")
+	}
+
+	ring := &Keyring{}
+	for i, key := range keys {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %d is %d bytes, expected %d", i+1, len(key), keySize)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		// Files name their key by a fingerprint, so the order of the
+		// keys can change without breaking them.
+		sum := sha256.Sum256(append([]byte("blackoutbox key id\x00"), key...))
+		ring.keys = append(ring.keys, keyringKey{id: sum[:keyIdSize], aead: aead})
+	}
+
+	return ring, nil
+}
+
+// ReadKeyFile reads a keyring from a file of base64 encoded keys. Blank
+// lines and lines starting with # are skipped.
+func ReadKeyFile(path string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key isn't valid base64", line)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewKeyring(keys...)
+}
+
+// DeriveKeyring stretches passphrases into a keyring, the first being
+// active. The salt is created on first use and must be kept with the
+// files, as the keys can't be derived again without it.
+func DeriveKeyring(saltPath string, passphrases ...string) (*Keyring, error) {
+	salt, err := os.ReadFile(saltPath)
+	if errors.Is(err, os.ErrNotExist) {
+		salt = make([]byte, 16)
+		rand.Read(salt)
+		err = os.WriteFile(saltPath, salt, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	var keys [][]byte
+	for _, passphrase := range passphrases {
+		key, err := pbkdf2.Key(sha256.New, passphrase, salt, passphraseIterations, keySize)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(keys...)
+}
+
+// LoadKeyring unlocks the keyring configured in the environment. It
+// returns nil if no key is configured.
+func LoadKeyring() (*Keyring, error) {
+	if path := os.Getenv(keyFileEnv); path != "" {
+		return ReadKeyFile(path)
+	}
+
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return nil, nil
+	}
+
+	passphrases := []string{passphrase}
+	if old := os.Getenv(oldPassphraseEnv); old != "" {
+		passphrases = append(passphrases, old)
+	}
+
+	return DeriveKeyring(passphraseSaltFile, passphrases...)
+}
+
+// SetKeyring sets the keyring files are read and written with. Without
+// one, new files are written as they are and encrypted files can't be
+// read.
+func SetKeyring(ring *Keyring) {
+	keyring.Store(ring)
+}
+
+func (k *Keyring) active() keyringKey {
+	return k.keys[0]
+}
+
+func (k *Keyring) find(id []byte) (keyringKey, bool) {
+	for _, key := range k.keys {
+		if bytes.Equal(key.id, id) {
+			return key, true
+		}
+	}
+	return keyringKey{}, false
+}
+
+// isEncrypted reports whether data was written by seal.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(fileMagic))
+}
+
+// seal encrypts data with the active key. Data is returned unchanged
+// when there is no keyring.
+func seal(data []byte) ([]byte, error) {
+	ring := keyring.Load()
+	if ring == nil {
+		return data, nil
+	}
+
+	key := ring.active()
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, fileMagic...)
+	header = append(header, key.id...)
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	header = append(header, nonce...)
+
+	return key.aead.Seal(header, nonce, data, header), nil
+}
+
+// unseal decrypts data written by seal. Other data is returned unchanged,
+// so files from before encryption was turned on can still be read.
+func unseal(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	if len(data) < headerSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+
+	ring := keyring.Load()
+	if ring == nil {
+		return nil, ErrNoKey
+	}
+
+	header := data[:headerSize]
+	key, ok := ring.find(header[len(fileMagic) : len(fileMagic)+keyIdSize])
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	plain, err := key.aead.Open(nil, header[len(fileMagic)+keyIdSize:], data[headerSize:], header)
+	if err != nil {
+		return nil, errors.New("encrypted file failed authentication")
+	}
+	return plain, nil
+}
+
+// ReadFile returns the content of a stored file, decrypting it if it is
+// encrypted.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(data)
+}
+
+// WriteFile stores data in a file, encrypted with the active key if a
+// keyring is set. The file is replaced in one step, so readers never see
+// part of it.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	sealed, err := seal(data)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// SealValue encrypts a value for a database column with the active key.
+// The value is returned unchanged when there is no keyring.
+func SealValue(value string) (string, error) {
+	if keyring.Load() == nil {
+		return value, nil
+	}
+
+	sealed, err := seal([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return valuePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenValue decrypts a value written by SealValue. Values stored before
+// encryption was turned on are returned unchanged.
+func OpenValue(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, valuePrefix)
+	if !ok {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("encrypted value isn't valid base64: %w", err)
+	}
+	if !isEncrypted(sealed) {
+		return "", errors.New("encrypted value has no header")
+	}
+
+	plain, err := unseal(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// NeedsSealing reports whether a value is stored as plain text or under
+// a key other than the active one.
+func NeedsSealing(value string) bool {
+	ring := keyring.Load()
+	if ring == nil {
+		return false
+	}
+
+	encoded, ok := strings.CutPrefix(value, valuePrefix)
+	if !ok {
+		return true
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	return err == nil && needsSealing(ring, sealed)
+}
+
+func needsSealing(ring *Keyring, data []byte) bool {
+	if !isEncrypted(data) || len(data) < headerSize {
+		return true
+	}
+	return !bytes.Equal(data[len(fileMagic):len(fileMagic)+keyIdSize], ring.active().id)
+}
+
+// EncryptFiles encrypts the stored files that are plain text or were
+// encrypted with a key other than the active one, which finishes a key
+// rotation. Files that can't be decrypted are logged and left alone. It
+// returns how many files were encrypted.
+func EncryptFiles() (int, error) {
+	ring := keyring.Load()
+	if ring == nil {
+		return 0, nil
+	}
+
+	encrypted := 0
+
+	for _, root := range []string{DocumentsRoot, BlobsRoot, TemplatesRoot, GeneratedRoot} {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err != nil || !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), tempPrefix) {
+				return err
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !needsSealing(ring, data) {
+				return nil
+			}
+
+			plain, err := unseal(data)
+			if err != nil {
+				log.Printf("Failed to decrypt %s, leaving it as it is: %v", path, err)
+				return nil
+			}
+
+			if err := encryptFile(path, plain); err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", path, err)
+			}
+
+			encrypted++
+			return nil
+		})
+		if err != nil {
+			return encrypted, err
+		}
+	}
+
+	return encrypted, nil
+}
+
+// encryptFile replaces a file with its content, plain, encrypted with the
+// active key. Its mode and modification time are kept, as garbage
+// collection and the scrub go by the latter.
+func encryptFile(path string, plain []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFile(path, plain, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
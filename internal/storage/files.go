@@ -4,7 +4,31 @@
 
 package storage
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
 const (
 	DocumentsRoot = "upload"
 	TemplatesRoot = "templates"
+	GeneratedRoot = "generated"
+	BlobsRoot     = "blobs"
 )
+
+// HashFile returns the hex encoded SHA-256 of a file's content. The hash
+// of an encrypted file is that of its decrypted content.
+func HashFile(path string) (string, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return Hash(data), nil
+}
+
+// Hash returns the hex SHA-256 of data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
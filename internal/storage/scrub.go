@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package storage
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orphanGracePeriod keeps files that were just written, and may be about
+// to be recorded, from being reported as orphaned.
+const orphanGracePeriod = time.Hour
+
+var ErrScrubRunning = errors.New("a scrub is already running")
+
+// ScrubIndex lists the stored files to check and records scrub reports.
+type ScrubIndex interface {
+	GetStoredFiles() ([]models.StoredFile, error)
+	GetKnownPaths() ([]string, error)
+	Add(report models.ScrubReport) error
+}
+
+var scrubbing sync.Mutex
+
+// Scrub checks that every stored file printing relies on is present,
+// matches its recorded hash and size, and parses if it is a PDF, and
+// looks for files in the blob store and templates nothing refers to. The
+// report is recorded in the index. Only one scrub runs at a time.
+func Scrub(index ScrubIndex) (*models.ScrubReport, error) {
+	if !scrubbing.TryLock() {
+		return nil, ErrScrubRunning
+	}
+	defer scrubbing.Unlock()
+
+	report := models.ScrubReport{StartedAt: time.Now().Unix(), Problems: []models.ScrubProblem{}}
+
+	files, err := index.GetStoredFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		report.FilesChecked++
+
+		problem, detail := checkFile(file)
+		if problem == "" {
+			continue
+		}
+
+		report.Problems = append(report.Problems, models.ScrubProblem{Path: file.Path, Kind: file.Kind, Problem: problem, Detail: detail})
+		if problem == models.ScrubMissing {
+			report.Missing++
+		} else {
+			report.Corrupt++
+		}
+	}
+
+	orphans, err := findOrphans(index)
+	if err != nil {
+		return nil, err
+	}
+	report.Orphaned = len(orphans)
+	report.Problems = append(report.Problems, orphans...)
+
+	report.FinishedAt = time.Now().Unix()
+
+	if err := index.Add(report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// checkFile returns what is wrong with a stored file, if anything.
+func checkFile(file models.StoredFile) (string, string) {
+	data, err := ReadFile(file.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return models.ScrubMissing, ""
+	}
+	if err != nil {
+		return models.ScrubCorrupt, err.Error()
+	}
+
+	if len(data) == 0 {
+		return models.ScrubCorrupt, "file is empty"
+	}
+
+	if file.Size != nil && int64(len(data)) != *file.Size {
+		return models.ScrubCorrupt, fmt.Sprintf("size is %d bytes, expected %d", len(data), *file.Size)
+	}
+
+	if file.Hash != nil {
+		sum := sha256.Sum256(data)
+		if hash := hex.EncodeToString(sum[:]); hash != *file.Hash {
+			return models.ScrubCorrupt, fmt.Sprintf("SHA-256 is %s, expected %s", hash, *file.Hash)
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(file.Path), ".pdf") {
+		if _, err := pdf.PageCount(data); err != nil {
+			return models.ScrubCorrupt, fmt.Sprintf("PDF doesn't parse: %v", err)
+		}
+	}
+
+	return "", ""
+}
+
+// findOrphans returns the files in the blob store and templates that
+// nothing refers to. A PDF next to a blob is its conversion.
+func findOrphans(index ScrubIndex) ([]models.ScrubProblem, error) {
+	paths, err := index.GetKnownPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		known[filepath.Clean(path)] = true
+	}
+
+	orphans := []models.ScrubProblem{}
+
+	roots := []struct{ root, kind string }{
+		{BlobsRoot, models.StoredBlob},
+		{TemplatesRoot, models.StoredTemplate},
+	}
+
+	for _, r := range roots {
+		root, kind := r.root, r.kind
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+				return err
+			}
+
+			if known[path] || (root == BlobsRoot && known[strings.TrimSuffix(path, ".pdf")]) {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < orphanGracePeriod {
+				return err
+			}
+
+			orphans = append(orphans, models.ScrubProblem{Path: path, Kind: kind, Problem: models.ScrubOrphaned})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orphans, nil
+}
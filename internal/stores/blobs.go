@@ -0,0 +1,155 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"database/sql"
+	"time"
+)
+
+// BlobStore is the index of the content-addressed blob store. Reference
+// counts are maintained by triggers on the tables that use blobs.
+type BlobStore struct {
+	Db *sql.DB
+}
+
+const blobColumns = `path, hash, size, ref_count, created_at`
+
+// addBlob adds a document's file to the index if it is a blob. It must be
+// called before the document is saved, for the reference to be counted.
+func addBlob(db sqlExecer, model models.Document) error {
+	if model.FileHash == nil || model.FileSize == nil || !storage.IsBlob(model.FilePath) {
+		return nil
+	}
+
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO blobs (path, hash, size, created_at)
+		VALUES (?, ?, ?, ?)
+	`, model.FilePath, *model.FileHash, *model.FileSize, time.Now().Unix())
+	return err
+}
+
+// GetUnreferenced returns the blobs nothing uses any more.
+func (s *BlobStore) GetUnreferenced() ([]models.Blob, error) {
+	rows, err := s.Db.Query(`
+		SELECT ` + blobColumns + `
+		FROM blobs
+		WHERE ref_count <= 0
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []models.Blob
+
+	for rows.Next() {
+		var blob models.Blob
+		if err := rows.Scan(&blob.Path, &blob.Hash, &blob.Size, &blob.RefCount, &blob.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, rows.Err()
+}
+
+func (s *BlobStore) Exists(path string) (bool, error) {
+	var exists bool
+	err := s.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM blobs WHERE path = ?)`, path).Scan(&exists)
+	return exists, err
+}
+
+// Delete removes a blob from the index if it is still unused, and reports
+// whether it did.
+func (s *BlobStore) Delete(path string) (bool, error) {
+	result, err := s.Db.Exec(`
+		DELETE FROM blobs
+		WHERE path = ? AND ref_count <= 0
+	`, path)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// GetLegacyPaths returns the document files that aren't in the blob store.
+func (s *BlobStore) GetLegacyPaths() ([]string, error) {
+	rows, err := s.Db.Query(`
+		SELECT file_path FROM documents WHERE file_path NOT LIKE ?
+		UNION
+		SELECT file_path FROM document_versions WHERE file_path NOT LIKE ?
+	`, storage.BlobsRoot+"/%", storage.BlobsRoot+"/%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+// Adopt points everything using a file stored outside the blob store,
+// or its PDF conversion, at the blob the file was moved to.
+func (s *BlobStore) Adopt(oldPath string, blob models.Blob) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO blobs (path, hash, size, created_at)
+		VALUES (?, ?, ?, ?)
+	`, blob.Path, blob.Hash, blob.Size, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE documents
+		SET file_path = ?, file_size = ?, file_hash = ?,
+			converted_path = CASE WHEN converted_path IS NULL THEN NULL ELSE ? || '.pdf' END
+		WHERE file_path = ?
+	`, blob.Path, blob.Size, blob.Hash, blob.Path, oldPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE document_versions
+		SET file_path = ?, file_size = ?, file_hash = ?
+		WHERE file_path = ?
+	`, blob.Path, blob.Size, blob.Hash, oldPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE print_jobs
+		SET file_path = CASE WHEN file_path = ? THEN ? ELSE ? || '.pdf' END
+		WHERE file_path IN (?, ? || '.pdf')
+	`, oldPath, blob.Path, blob.Path, oldPath, oldPath)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -6,8 +6,13 @@ package stores
 
 import (
 	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/tagexpr"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -18,185 +23,564 @@ type DocumentStoreInterface interface {
 	GetById(id int64) (*models.Document, error)
 	GetByFileId(id int64) (*models.Document, error)
 	GetBySystemId(id int64) ([]models.Document, error)
+	GetByTags(systemId *int64, tags *tagexpr.Expr) ([]models.Document, error)
+	GetDueForPrinting(now int64) ([]models.Document, error)
+	MarkPrinted(id int64, printedAt int64) error
+	MarkPrintMissed(id int64, printAt int64) error
+	UpdateConversion(model models.Document) error
+	GetVersions(id int64) ([]models.DocumentVersion, error)
+	GetVersion(id int64, version int64) (*models.DocumentVersion, error)
+	PruneVersions(id int64) error
+	Delete(id int64) (bool, error)
+	Restore(id int64) (bool, error)
+	GetDeleted(systemId *int64) ([]models.DeletedDocument, error)
+	PurgeDeleted(now int64) ([]string, error)
 }
 
 type DocumentStore struct {
 	Db *sql.DB
 }
 
+// documentTags selects a document's tags, in order, as a JSON array.
+const documentTags = `(SELECT json_group_array(tag) FROM (SELECT tag FROM document_tags WHERE document_id = documents.id ORDER BY position))`
+
+// hasDocumentTag is the condition tag expressions are compiled with.
+const hasDocumentTag = `EXISTS (SELECT 1 FROM document_tags WHERE document_id = documents.id AND tag = ?)`
+
+const documentColumns = `id, system_id, file_id, file_path, file_size, file_hash, uploaded_by, conversion_status, converted_path, converted_hash, conversion_error, print_at, last_printed_at, missed_print_at, ` + documentTags + `, print_options, version, updated_at, deleted_at`
+
+// scanDocument scans documentColumns, followed by any extra columns.
+func scanDocument(row interface{ Scan(dest ...any) error }, extra ...any) (models.Document, error) {
+	var document models.Document
+	var tagsJSON sql.NullString
+	var printOptionsJSON *string
+
+	dest := []any{
+		&document.Id,
+		&document.SystemId,
+		&document.FileReference,
+		&document.FilePath,
+		&document.FileSize,
+		&document.FileHash,
+		&document.UploadedBy,
+		&document.ConversionStatus,
+		&document.ConvertedPath,
+		&document.ConvertedHash,
+		&document.ConversionError,
+		&document.PrintAt,
+		&document.LastPrintedAt,
+		&document.MissedPrintAt,
+		&tagsJSON,
+		&printOptionsJSON,
+		&document.Version,
+		&document.UpdatedAt,
+		&document.DeletedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return document, err
+	}
+
+	if tagsJSON.String != "" && tagsJSON.String != "[]" {
+		if err := json.Unmarshal([]byte(tagsJSON.String), &document.Tags); err != nil {
+			return document, err
+		}
+	}
+
+	document.PrintOptions, err = unmarshalPrintOptions(printOptionsJSON)
+	return document, err
+}
+
+func (s *DocumentStore) queryDocuments(query string, args ...any) ([]models.Document, error) {
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []models.Document
+
+	for rows.Next() {
+		document, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, rows.Err()
+}
+
+// snapshotDocument records a document's current file as a version.
+func snapshotDocument(db sqlExecer, id int64) error {
+	_, err := db.Exec(`
+		INSERT INTO document_versions (document_id, version, file_path, file_size, file_hash, uploaded_by, created_at)
+		SELECT id, version, file_path, file_size, file_hash, uploaded_by, updated_at
+		FROM documents
+		WHERE id = ? AND deleted_at IS NULL
+	`, id)
+	return err
+}
+
+// setDocumentTags replaces a document's tags, normalizing them.
+func setDocumentTags(db sqlExecer, id int64, tags []string) error {
+	if _, err := db.Exec(`DELETE FROM document_tags WHERE document_id = ?`, id); err != nil {
+		return err
+	}
+
+	for position, tag := range tagexpr.NormalizeAll(tags) {
+		_, err := db.Exec(`
+			INSERT INTO document_tags (document_id, tag, position)
+			VALUES (?, ?, ?)
+		`, id, tag, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Add stores a new document, recording its file as the first version.
 func (s *DocumentStore) Add(model models.Document) error {
-	tagsJSON, err := json.Marshal(model.Tags)
+	printOptionsJSON, err := marshalPrintOptions(model.PrintOptions)
 	if err != nil {
 		return err
 	}
 
+	conversionStatus := model.ConversionStatus
+	if conversionStatus == "" {
+		conversionStatus = models.ConversionPending
+	}
+
 	updatedAt := time.Now().Unix()
 
-	_, err = s.Db.Exec(`
-		INSERT INTO documents (system_id, file_id, file_path, print_at, last_printed_at, tags, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, model.SystemId, model.FileReference, model.FilePath, model.PrintAt, model.LastPrintedAt, string(tagsJSON), updatedAt)
+	tx, err := s.Db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err := addBlob(tx, model); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO documents (system_id, file_id, file_path, file_size, file_hash, uploaded_by, conversion_status, converted_path, converted_hash, conversion_error, print_at, last_printed_at, print_options, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, model.SystemId, model.FileReference, model.FilePath, model.FileSize, model.FileHash, model.UploadedBy, conversionStatus, model.ConvertedPath, model.ConvertedHash, model.ConversionError, model.PrintAt, model.LastPrintedAt, printOptionsJSON, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := setDocumentTags(tx, id, model.Tags); err != nil {
+		return err
+	}
+
+	if err := snapshotDocument(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// Get returns every document that isn't in the trash.
 func (s *DocumentStore) Get() ([]models.Document, error) {
-	query, err := s.Db.Query(`
-		SELECT id, system_id, file_id, file_path, print_at, last_printed_at, tags, updated_at, deleted_at
+	return s.queryDocuments(`
+		SELECT ` + documentColumns + `
 		FROM documents
+		WHERE deleted_at IS NULL
 	`)
+}
+
+// Update saves a document's file, conversion, schedule, tags and print
+// options. model.Version must be the version the changes were based on;
+// if the document has been updated since, ErrVersionConflict is returned
+// and nothing is saved. On success the version is bumped, and recorded as
+// a file version if the file changed.
+func (s *DocumentStore) Update(model models.Document) error {
+	printOptionsJSON, err := marshalPrintOptions(model.PrintOptions)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var documents []models.Document
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	for query.Next() {
-		var document models.Document
-		var tagsJSON string
-
-		err := query.Scan(
-			&document.Id,
-			&document.SystemId,
-			&document.FileReference,
-			&document.FilePath,
-			&document.PrintAt,
-			&document.LastPrintedAt,
-			&tagsJSON,
-			&document.UpdatedAt,
-			&document.DeletedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+	var filePath string
+	err = tx.QueryRow(`SELECT file_path FROM documents WHERE id = ?`, model.Id).Scan(&filePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
 
-		if tagsJSON != "" {
-			if err := json.Unmarshal([]byte(tagsJSON), &document.Tags); err != nil {
-				return nil, err
-			}
-		}
+	if err := addBlob(tx, model); err != nil {
+		return err
+	}
 
-		documents = append(documents, document)
+	result, err := tx.Exec(`
+		UPDATE documents
+		SET file_path = ?, file_size = ?, file_hash = ?, uploaded_by = ?, conversion_status = ?, converted_path = ?, converted_hash = ?, conversion_error = ?, print_at = ?, print_options = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ? AND deleted_at IS NULL
+	`, model.FilePath, model.FileSize, model.FileHash, model.UploadedBy, model.ConversionStatus, model.ConvertedPath, model.ConvertedHash, model.ConversionError, model.PrintAt, printOptionsJSON, time.Now().Unix(), model.Id, model.Version)
+	if err != nil {
+		return err
 	}
 
-	return documents, nil
-}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return models.ErrVersionConflict
+	}
 
-func (s *DocumentStore) Update(model models.Document) error {
-	return nil
+	if err := setDocumentTags(tx, model.Id, model.Tags); err != nil {
+		return err
+	}
+
+	if model.FilePath != filePath {
+		if err := snapshotDocument(tx, model.Id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// GetById returns a document outside the trash. Documents in the trash
+// are only reached through GetDeleted, Restore and PurgeDeleted, so they
+// can't be printed or changed; looking one up gives sql.ErrNoRows.
 func (s *DocumentStore) GetById(id int64) (*models.Document, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, system_id, file_id, file_path, print_at, last_printed_at, tags, updated_at, deleted_at
+		SELECT `+documentColumns+`
 		FROM documents
-		WHERE id = ?
+		WHERE id = ? AND deleted_at IS NULL
 	`, id)
 
-	var document models.Document
-	var tagsJSON string
-
-	err := row.Scan(
-		&document.Id,
-		&document.SystemId,
-		&document.FileReference,
-		&document.FilePath,
-		&document.PrintAt,
-		&document.LastPrintedAt,
-		&tagsJSON,
-		&document.UpdatedAt,
-		&document.DeletedAt,
-	)
+	document, err := scanDocument(row)
 	if err != nil {
 		return nil, err
 	}
 
-	if tagsJSON != "" {
-		if err := json.Unmarshal([]byte(tagsJSON), &document.Tags); err != nil {
-			return nil, err
-		}
-	}
-
 	return &document, nil
 }
 
 func (s *DocumentStore) GetByFileId(id int64) (*models.Document, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, system_id, file_id, file_path, print_at, last_printed_at, tags, updated_at, deleted_at
+		SELECT `+documentColumns+`
 		FROM documents
-		WHERE file_id = ?
+		WHERE file_id = ? AND deleted_at IS NULL
 	`, id)
 
-	var document models.Document
-	var tagsJSON string
+	document, err := scanDocument(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+func (s *DocumentStore) GetBySystemId(id int64) ([]models.Document, error) {
+	return s.queryDocuments(`
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE system_id = ? AND deleted_at IS NULL
+	`, id)
+}
+
+// GetByTags returns the documents outside the trash whose tags match an expression,
+// optionally only those of one system.
+func (s *DocumentStore) GetByTags(systemId *int64, tags *tagexpr.Expr) ([]models.Document, error) {
+	where, args := tags.SQL(hasDocumentTag)
+	where += ` AND deleted_at IS NULL`
+	if systemId != nil {
+		where += ` AND system_id = ?`
+		args = append(args, *systemId)
+	}
+
+	return s.queryDocuments(`
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE `+where, args...)
+}
+
+// GetDueForPrinting returns documents whose print_at has passed and that
+// have neither been printed nor marked as missed since.
+func (s *DocumentStore) GetDueForPrinting(now int64) ([]models.Document, error) {
+	return s.queryDocuments(`
+		SELECT `+documentColumns+`
+		FROM documents
+		WHERE print_at IS NOT NULL
+		AND print_at <= ?
+		AND (last_printed_at IS NULL OR last_printed_at < print_at)
+		AND (missed_print_at IS NULL OR missed_print_at != print_at)
+		AND deleted_at IS NULL
+		ORDER BY print_at
+	`, now)
+}
 
+func (s *DocumentStore) MarkPrinted(id int64, printedAt int64) error {
+	_, err := s.Db.Exec(`
+		UPDATE documents
+		SET last_printed_at = ?
+		WHERE id = ?
+	`, printedAt, id)
+	return err
+}
+
+func (s *DocumentStore) MarkPrintMissed(id int64, printAt int64) error {
+	_, err := s.Db.Exec(`
+		UPDATE documents
+		SET missed_print_at = ?
+		WHERE id = ?
+	`, printAt, id)
+	return err
+}
+
+// UpdateConversion records the outcome of converting a document to PDF.
+func (s *DocumentStore) UpdateConversion(model models.Document) error {
+	_, err := s.Db.Exec(`
+		UPDATE documents
+		SET conversion_status = ?, converted_path = ?, converted_hash = ?, conversion_error = ?
+		WHERE id = ?
+	`, model.ConversionStatus, model.ConvertedPath, model.ConvertedHash, model.ConversionError, model.Id)
+	return err
+}
+
+const documentVersionColumns = `id, document_id, version, file_path, file_size, file_hash, uploaded_by, created_at`
+
+func scanDocumentVersion(row interface{ Scan(dest ...any) error }) (models.DocumentVersion, error) {
+	var version models.DocumentVersion
 	err := row.Scan(
-		&document.Id,
-		&document.SystemId,
-		&document.FileReference,
-		&document.FilePath,
-		&document.PrintAt,
-		&document.LastPrintedAt,
-		&tagsJSON,
-		&document.UpdatedAt,
-		&document.DeletedAt,
+		&version.Id,
+		&version.DocumentId,
+		&version.Version,
+		&version.FilePath,
+		&version.FileSize,
+		&version.FileHash,
+		&version.UploadedBy,
+		&version.CreatedAt,
 	)
+	return version, err
+}
+
+// GetVersions returns the file versions of a document, newest first.
+func (s *DocumentStore) GetVersions(id int64) ([]models.DocumentVersion, error) {
+	rows, err := s.Db.Query(`
+		SELECT `+documentVersionColumns+`
+		FROM document_versions
+		WHERE document_id = ?
+		ORDER BY version DESC
+	`, id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	if tagsJSON != "" {
-		if err := json.Unmarshal([]byte(tagsJSON), &document.Tags); err != nil {
+	var versions []models.DocumentVersion
+
+	for rows.Next() {
+		version, err := scanDocumentVersion(rows)
+		if err != nil {
 			return nil, err
 		}
+
+		versions = append(versions, version)
 	}
 
-	return &document, nil
+	return versions, rows.Err()
 }
 
-func (s *DocumentStore) GetBySystemId(id int64) ([]models.Document, error) {
-	query, err := s.Db.Query(`
-		SELECT id, system_id, file_id, file_path, print_at, last_printed_at, tags, updated_at, deleted_at
-		FROM documents
-		WHERE system_id = ?
-	`, id)
+func (s *DocumentStore) GetVersion(id int64, version int64) (*models.DocumentVersion, error) {
+	row := s.Db.QueryRow(`
+		SELECT `+documentVersionColumns+`
+		FROM document_versions
+		WHERE document_id = ? AND version = ?
+	`, id, version)
+
+	documentVersion, err := scanDocumentVersion(row)
 	if err != nil {
 		return nil, err
 	}
-	defer query.Close()
 
-	var documents []models.Document
+	return &documentVersion, nil
+}
 
-	for query.Next() {
-		var document models.Document
-		var tagsJSON string
-
-		err := query.Scan(
-			&document.Id,
-			&document.SystemId,
-			&document.FileReference,
-			&document.FilePath,
-			&document.PrintAt,
-			&document.LastPrintedAt,
-			&tagsJSON,
-			&document.UpdatedAt,
-			&document.DeletedAt,
+// PruneVersions removes the file versions of a document beyond the number
+// its system keeps. Their blobs are garbage collected once nothing else
+// uses them.
+func (s *DocumentStore) PruneVersions(id int64) error {
+	return pruneVersions(s.Db, id)
+}
+
+func pruneVersions(db sqlExecer, id int64) error {
+	_, err := db.Exec(`
+		DELETE FROM document_versions
+		WHERE document_id = ? AND version NOT IN (
+			SELECT version FROM document_versions
+			WHERE document_id = ?
+			ORDER BY version DESC
+			LIMIT (
+				SELECT COALESCE(s.document_versions_kept, -1)
+				FROM documents d
+				JOIN systems s ON s.id = d.system_id
+				WHERE d.id = ?
+			)
 		)
+	`, id, id, id)
+	return err
+}
+
+// purgeAt is when a document in the trash is purged.
+const purgeAt = `documents.deleted_at + (SELECT COALESCE(trash_days, ?) FROM systems WHERE systems.id = documents.system_id) * 86400`
+
+// Delete moves a document to the trash, reporting whether there was one
+// to delete. Documents in the trash are no longer listed or printed, but
+// keep their files and versions until they are purged.
+func (s *DocumentStore) Delete(id int64) (bool, error) {
+	result, err := s.Db.Exec(`
+		UPDATE documents
+		SET deleted_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, time.Now().Unix(), id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// Restore takes a document out of the trash, reporting whether there was
+// one to restore. Documents of deleted systems can't be restored.
+func (s *DocumentStore) Restore(id int64) (bool, error) {
+	result, err := s.Db.Exec(`
+		UPDATE documents
+		SET deleted_at = NULL
+		WHERE id = ? AND deleted_at IS NOT NULL
+		AND system_id IN (SELECT id FROM systems WHERE deleted_at IS NULL)
+	`, id)
+	if err != nil {
+		return false, err
+	}
+
+	restored, err := result.RowsAffected()
+	return restored > 0, err
+}
+
+// GetDeleted returns the documents in the trash, most recently deleted
+// first, optionally only those of one system.
+func (s *DocumentStore) GetDeleted(systemId *int64) ([]models.DeletedDocument, error) {
+	rows, err := s.Db.Query(`
+		SELECT `+documentColumns+`, `+purgeAt+`
+		FROM documents
+		WHERE deleted_at IS NOT NULL
+		AND (? IS NULL OR system_id = ?)
+		ORDER BY deleted_at DESC, id
+	`, models.DefaultTrashDays, systemId, systemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []models.DeletedDocument
+
+	for rows.Next() {
+		var document models.DeletedDocument
+		document.Document, err = scanDocument(rows, &document.PurgeAt)
 		if err != nil {
 			return nil, err
 		}
 
-		if tagsJSON != "" {
-			if err := json.Unmarshal([]byte(tagsJSON), &document.Tags); err != nil {
-				return nil, err
+		documents = append(documents, document)
+	}
+
+	return documents, rows.Err()
+}
+
+// PurgeDeleted removes the documents that have been in the trash for
+// longer than their system keeps them, with their versions. Blobs they
+// used are left to garbage collection; the other files they used that
+// nothing refers to any more are returned for the caller to remove.
+func (s *DocumentStore) PurgeDeleted(now int64) ([]string, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, file_path, converted_path FROM documents
+		WHERE deleted_at IS NOT NULL AND `+purgeAt+` <= ?
+		UNION ALL
+		SELECT v.document_id, v.file_path, NULL FROM document_versions v
+		JOIN documents ON documents.id = v.document_id
+		WHERE documents.deleted_at IS NOT NULL AND `+purgeAt+` <= ?
+	`, models.DefaultTrashDays, now, models.DefaultTrashDays, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	var paths []string
+	for rows.Next() {
+		var id int64
+		var filePath string
+		var convertedPath *string
+		if err := rows.Scan(&id, &filePath, &convertedPath); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+		for _, path := range []*string{&filePath, convertedPath} {
+			if path != nil && !strings.HasPrefix(*path, storage.BlobsRoot+"/") && !slices.Contains(paths, *path) {
+				paths = append(paths, *path)
 			}
 		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		documents = append(documents, document)
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM documents WHERE id = ?`, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files outside the blob store aren't reference counted
+	var unused []string
+	for _, path := range paths {
+		var used bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM documents WHERE file_path = ?1 OR converted_path = ?1)
+			OR EXISTS (SELECT 1 FROM document_versions WHERE file_path = ?1)
+			OR EXISTS (SELECT 1 FROM print_jobs WHERE file_path = ?1)
+		`, path).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			unused = append(unused, path)
+		}
 	}
 
-	return documents, nil
+	return unused, tx.Commit()
 }
@@ -0,0 +1,177 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"time"
+)
+
+type FormStockStoreInterface interface {
+	Add(model models.FormStockRule) (int64, error)
+	Get() ([]models.FormStockRule, error)
+	GetById(id int64) (*models.FormStockRule, error)
+	GetBySystemId(id int64) ([]models.FormStockRule, error)
+	Update(model models.FormStockRule) error
+	Delete(id int64) error
+	GetCounts(systemId int64) ([]models.FormStockCount, error)
+}
+
+type FormStockStore struct {
+	Db *sql.DB
+}
+
+const formStockRuleColumns = `id, system_id, ward, template_id, copies_per_hour, created_at, updated_at`
+
+func scanFormStockRule(row interface{ Scan(dest ...any) error }) (models.FormStockRule, error) {
+	var rule models.FormStockRule
+
+	err := row.Scan(
+		&rule.Id,
+		&rule.SystemId,
+		&rule.Ward,
+		&rule.TemplateId,
+		&rule.CopiesPerHour,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+
+	return rule, err
+}
+
+func (s *FormStockStore) queryFormStockRules(query string, args ...any) ([]models.FormStockRule, error) {
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.FormStockRule
+
+	for rows.Next() {
+		rule, err := scanFormStockRule(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *FormStockStore) Add(model models.FormStockRule) (int64, error) {
+	now := time.Now().Unix()
+
+	result, err := s.Db.Exec(`
+		INSERT INTO form_stock_rules (system_id, ward, template_id, copies_per_hour, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, model.SystemId, model.Ward, model.TemplateId, model.CopiesPerHour, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *FormStockStore) Get() ([]models.FormStockRule, error) {
+	return s.queryFormStockRules(`
+		SELECT ` + formStockRuleColumns + `
+		FROM form_stock_rules
+		ORDER BY system_id, ward, id
+	`)
+}
+
+func (s *FormStockStore) GetById(id int64) (*models.FormStockRule, error) {
+	row := s.Db.QueryRow(`
+		SELECT `+formStockRuleColumns+`
+		FROM form_stock_rules
+		WHERE id = ?
+	`, id)
+
+	rule, err := scanFormStockRule(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// GetBySystemId returns the rules of a system ordered by ward, so stock
+// for one ward is printed together.
+func (s *FormStockStore) GetBySystemId(id int64) ([]models.FormStockRule, error) {
+	return s.queryFormStockRules(`
+		SELECT `+formStockRuleColumns+`
+		FROM form_stock_rules
+		WHERE system_id = ?
+		ORDER BY ward, id
+	`, id)
+}
+
+func (s *FormStockStore) Update(model models.FormStockRule) error {
+	_, err := s.Db.Exec(`
+		UPDATE form_stock_rules
+		SET system_id = ?, ward = ?, template_id = ?, copies_per_hour = ?, updated_at = ?
+		WHERE id = ?
+	`, model.SystemId, model.Ward, model.TemplateId, model.CopiesPerHour, time.Now().Unix(), model.Id)
+	return err
+}
+
+func (s *FormStockStore) Delete(id int64) error {
+	_, err := s.Db.Exec("DELETE FROM form_stock_rules WHERE id = ?", id)
+	return err
+}
+
+// GetCounts adds up the blank stock printed per batch, ward and template
+// version, newest batch first, for reconciling stock after an outage. A
+// systemId of 0 counts every system.
+func (s *FormStockStore) GetCounts(systemId int64) ([]models.FormStockCount, error) {
+	rows, err := s.Db.Query(`
+		SELECT
+			j.batch_id,
+			COALESCE(b.reason, ''),
+			MIN(j.queued_at),
+			j.stock_ward,
+			j.template_id,
+			j.template_version,
+			SUM(COALESCE(json_extract(j.options, '$.copies'), 1)),
+			SUM(CASE WHEN j.status = ? THEN COALESCE(json_extract(j.options, '$.copies'), 1) ELSE 0 END)
+		FROM print_jobs j
+		LEFT JOIN print_batches b ON b.id = j.batch_id
+		WHERE j.stock_ward IS NOT NULL
+		AND (? = 0 OR b.system_id = ?)
+		GROUP BY j.batch_id, j.stock_ward, j.template_id, j.template_version
+		ORDER BY MIN(j.queued_at) DESC, j.stock_ward, j.template_id
+	`, models.PrintJobCompleted, systemId, systemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.FormStockCount
+
+	for rows.Next() {
+		var count models.FormStockCount
+
+		err := rows.Scan(
+			&count.BatchId,
+			&count.Reason,
+			&count.QueuedAt,
+			&count.Ward,
+			&count.TemplateId,
+			&count.TemplateVersion,
+			&count.Queued,
+			&count.Printed,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
@@ -7,14 +7,20 @@ package stores
 import (
 	"blackoutbox/internal/models"
 	"database/sql"
+	"strings"
 	"time"
 )
 
 type PrintJobStoreInterface interface {
-	Add(job models.PrintJob) error
+	Add(job models.PrintJob) (int64, error)
+	AddBatch(batch models.PrintBatch, jobs []models.PrintJob) (bool, error)
 	Get() ([]models.PrintJob, error)
 	GetById(id int64) (*models.PrintJob, error)
 	GetByDocumentId(id int64) ([]models.PrintJob, error)
+	GetByTemplateId(id int64) ([]models.PrintJob, error)
+	GetBySource(source string) ([]models.PrintJob, error)
+	GetActiveJobs() ([]models.PrintJob, error)
+	GetQueued() ([]models.PrintJob, error)
 	GetStuckJobs(thresholdSeconds int) ([]models.PrintJob, error)
 	Update(job models.PrintJob) error
 	UpdateStatus(id int64, status string) error
@@ -24,40 +30,64 @@ type PrintJobStore struct {
 	Db *sql.DB
 }
 
-func (s *PrintJobStore) Add(job models.PrintJob) error {
-	_, err := s.Db.Exec(`
-		INSERT INTO print_jobs (document_id, cups_job_id, status, submitted_at, completed_at, error_message)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, job.DocumentId, job.CupsJobId, job.Status, job.SubmittedAt, job.CompletedAt, job.ErrorMessage)
+const printJobColumns = `id, batch_id, source, document_id, template_id, template_version, stock_ward, file_path, cups_job_id, status, state_reason, options, stamp, origin, parent_job_id, binder, content_hash, document_updated_at, dispatch_attempts, dispatch_started_at, next_dispatch_at, queued_at, submitted_at, completed_at, canceled_at, last_checked_at, error_message`
+
+func scanPrintJob(row interface{ Scan(dest ...any) error }) (models.PrintJob, error) {
+	var job models.PrintJob
+	var optionsJSON, stampJSON *string
+
+	err := row.Scan(
+		&job.Id,
+		&job.BatchId,
+		&job.Source,
+		&job.DocumentId,
+		&job.TemplateId,
+		&job.TemplateVersion,
+		&job.StockWard,
+		&job.FilePath,
+		&job.CupsJobId,
+		&job.Status,
+		&job.StateReason,
+		&optionsJSON,
+		&stampJSON,
+		&job.Origin,
+		&job.ParentJobId,
+		&job.Binder,
+		&job.ContentHash,
+		&job.DocumentUpdatedAt,
+		&job.DispatchAttempts,
+		&job.DispatchStartedAt,
+		&job.NextDispatchAt,
+		&job.QueuedAt,
+		&job.SubmittedAt,
+		&job.CompletedAt,
+		&job.CanceledAt,
+		&job.LastCheckedAt,
+		&job.ErrorMessage,
+	)
 	if err != nil {
-		return err
+		return job, err
 	}
-	return nil
+
+	if job.Options, err = unmarshalPrintOptions(optionsJSON); err != nil {
+		return job, err
+	}
+
+	job.Stamp, err = unmarshalPageStamp(stampJSON)
+	return job, err
 }
 
-func (s *PrintJobStore) Get() ([]models.PrintJob, error) {
-	query, err := s.Db.Query(`
-		SELECT id, document_id, cups_job_id, status, submitted_at, completed_at, error_message
-		FROM print_jobs
-	`)
+func (s *PrintJobStore) queryPrintJobs(query string, args ...any) ([]models.PrintJob, error) {
+	rows, err := s.Db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var jobs []models.PrintJob
 
-	for query.Next() {
-		var job models.PrintJob
-
-		err := query.Scan(
-			&job.Id,
-			&job.DocumentId,
-			&job.CupsJobId,
-			&job.Status,
-			&job.SubmittedAt,
-			&job.CompletedAt,
-			&job.ErrorMessage,
-		)
+	for rows.Next() {
+		job, err := scanPrintJob(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -65,27 +95,118 @@ func (s *PrintJobStore) Get() ([]models.PrintJob, error) {
 		jobs = append(jobs, job)
 	}
 
-	return jobs, nil
+	return jobs, rows.Err()
+}
+
+// activeStatesPlaceholder returns the IN clause arguments for the non-terminal states.
+func activeStatesPlaceholder() (string, []any) {
+	args := make([]any, len(models.ActivePrintJobStates))
+	for i, state := range models.ActivePrintJobStates {
+		args[i] = state
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "), args
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertPrintJob(db sqlExecer, job models.PrintJob) (int64, error) {
+	optionsJSON, err := marshalPrintOptions(job.Options)
+	if err != nil {
+		return 0, err
+	}
+
+	stampJSON, err := marshalPageStamp(job.Stamp)
+	if err != nil {
+		return 0, err
+	}
+
+	if job.Origin == "" {
+		job.Origin = models.PrintOriginEmergency
+	}
+	if job.Source == "" {
+		job.Source = models.PrintSourceDocument
+	}
+	if job.QueuedAt == 0 {
+		job.QueuedAt = time.Now().Unix()
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO print_jobs (batch_id, source, document_id, template_id, template_version, stock_ward, file_path, cups_job_id, status, state_reason, options, stamp, origin, parent_job_id, binder, content_hash, document_updated_at, dispatch_attempts, dispatch_started_at, next_dispatch_at, queued_at, submitted_at, completed_at, canceled_at, last_checked_at, error_message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.BatchId, job.Source, job.DocumentId, job.TemplateId, job.TemplateVersion, job.StockWard, job.FilePath, job.CupsJobId, job.Status, job.StateReason, optionsJSON, stampJSON, job.Origin, job.ParentJobId, job.Binder, job.ContentHash, job.DocumentUpdatedAt, job.DispatchAttempts, job.DispatchStartedAt, job.NextDispatchAt, job.QueuedAt, job.SubmittedAt, job.CompletedAt, job.CanceledAt, job.LastCheckedAt, job.ErrorMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *PrintJobStore) Add(job models.PrintJob) (int64, error) {
+	return insertPrintJob(s.Db, job)
+}
+
+// AddBatch records a batch and its jobs in a single transaction, so either
+// every job is queued or none is. It returns false without queuing anything
+// if a batch with the same key already exists.
+func (s *PrintJobStore) AddBatch(batch models.PrintBatch, jobs []models.PrintJob) (bool, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if batch.CreatedAt == 0 {
+		batch.CreatedAt = time.Now().Unix()
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO print_batches (key, system_id, reason, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO NOTHING
+	`, batch.Key, batch.SystemId, batch.Reason, batch.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return false, err
+	}
+
+	batchId, err := result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	for _, job := range jobs {
+		job.BatchId = &batchId
+		job.QueuedAt = batch.CreatedAt
+		if _, err := insertPrintJob(tx, job); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+func (s *PrintJobStore) Get() ([]models.PrintJob, error) {
+	return s.queryPrintJobs(`
+		SELECT ` + printJobColumns + `
+		FROM print_jobs
+	`)
 }
 
 func (s *PrintJobStore) GetById(id int64) (*models.PrintJob, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, document_id, cups_job_id, status, submitted_at, completed_at, error_message
+		SELECT `+printJobColumns+`
 		FROM print_jobs
 		WHERE id = ?
 	`, id)
 
-	var job models.PrintJob
-
-	err := row.Scan(
-		&job.Id,
-		&job.DocumentId,
-		&job.CupsJobId,
-		&job.Status,
-		&job.SubmittedAt,
-		&job.CompletedAt,
-		&job.ErrorMessage,
-	)
+	job, err := scanPrintJob(row)
 	if err != nil {
 		return nil, err
 	}
@@ -94,81 +215,69 @@ func (s *PrintJobStore) GetById(id int64) (*models.PrintJob, error) {
 }
 
 func (s *PrintJobStore) GetByDocumentId(id int64) ([]models.PrintJob, error) {
-	query, err := s.Db.Query(`
-		SELECT id, document_id, cups_job_id, status, submitted_at, completed_at, error_message
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
 		FROM print_jobs
 		WHERE document_id = ?
 	`, id)
-	if err != nil {
-		return nil, err
-	}
+}
 
-	var jobs []models.PrintJob
+func (s *PrintJobStore) GetByTemplateId(id int64) ([]models.PrintJob, error) {
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
+		FROM print_jobs
+		WHERE template_id = ?
+	`, id)
+}
 
-	for query.Next() {
-		var job models.PrintJob
-
-		err := query.Scan(
-			&job.Id,
-			&job.DocumentId,
-			&job.CupsJobId,
-			&job.Status,
-			&job.SubmittedAt,
-			&job.CompletedAt,
-			&job.ErrorMessage,
-		)
-		if err != nil {
-			return nil, err
-		}
+func (s *PrintJobStore) GetBySource(source string) ([]models.PrintJob, error) {
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
+		FROM print_jobs
+		WHERE source = ?
+	`, source)
+}
 
-		jobs = append(jobs, job)
-	}
+// GetActiveJobs returns every job that has not yet reached a terminal state.
+func (s *PrintJobStore) GetActiveJobs() ([]models.PrintJob, error) {
+	placeholders, args := activeStatesPlaceholder()
 
-	return jobs, nil
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
+		FROM print_jobs
+		WHERE status IN (`+placeholders+`)
+		ORDER BY id
+	`, args...)
+}
+
+// GetQueued returns the jobs waiting to be submitted, in the order they
+// were queued.
+func (s *PrintJobStore) GetQueued() ([]models.PrintJob, error) {
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
+		FROM print_jobs
+		WHERE status = ?
+		ORDER BY id
+	`, models.PrintJobQueued)
 }
 
 func (s *PrintJobStore) GetStuckJobs(thresholdSeconds int) ([]models.PrintJob, error) {
 	threshold := time.Now().Unix() - int64(thresholdSeconds)
+	placeholders, args := activeStatesPlaceholder()
 
-	query, err := s.Db.Query(`
-		SELECT id, document_id, cups_job_id, status, submitted_at, completed_at, error_message
+	return s.queryPrintJobs(`
+		SELECT `+printJobColumns+`
 		FROM print_jobs
-		WHERE status IN ('pending', 'printing') AND submitted_at < ?
-	`, threshold)
-	if err != nil {
-		return nil, err
-	}
-
-	var jobs []models.PrintJob
-
-	for query.Next() {
-		var job models.PrintJob
-
-		err := query.Scan(
-			&job.Id,
-			&job.DocumentId,
-			&job.CupsJobId,
-			&job.Status,
-			&job.SubmittedAt,
-			&job.CompletedAt,
-			&job.ErrorMessage,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		jobs = append(jobs, job)
-	}
-
-	return jobs, nil
+		WHERE status IN (`+placeholders+`) AND COALESCE(submitted_at, queued_at) < ?
+	`, append(args, threshold)...)
 }
 
 func (s *PrintJobStore) Update(job models.PrintJob) error {
 	_, err := s.Db.Exec(`
 		UPDATE print_jobs
-		SET cups_job_id = ?, status = ?, submitted_at = ?, completed_at = ?, error_message = ?
+		SET cups_job_id = ?, status = ?, state_reason = ?, content_hash = ?, dispatch_attempts = ?, dispatch_started_at = ?, next_dispatch_at = ?, submitted_at = ?, completed_at = ?, canceled_at = ?, last_checked_at = ?, error_message = ?
 		WHERE id = ?
-	`, job.CupsJobId, job.Status, job.SubmittedAt, job.CompletedAt, job.ErrorMessage, job.Id)
+	`, job.CupsJobId, job.Status, job.StateReason, job.ContentHash, job.DispatchAttempts, job.DispatchStartedAt, job.NextDispatchAt, job.SubmittedAt, job.CompletedAt, job.CanceledAt, job.LastCheckedAt, job.ErrorMessage, job.Id)
 	if err != nil {
 		return err
 	}
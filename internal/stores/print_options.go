@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type TagPrintOptionsStoreInterface interface {
+	Set(model models.TagPrintOptions) error
+	GetBySystemId(id int64) ([]models.TagPrintOptions, error)
+	GetById(id int64) (*models.TagPrintOptions, error)
+	Delete(id int64) error
+}
+
+type TagPrintOptionsStore struct {
+	Db *sql.DB
+}
+
+func marshalPrintOptions(options *models.PrintOptions) (*string, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(data)
+	return &s, nil
+}
+
+func unmarshalPrintOptions(raw *string) (*models.PrintOptions, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+
+	var options models.PrintOptions
+	if err := json.Unmarshal([]byte(*raw), &options); err != nil {
+		return nil, err
+	}
+
+	return &options, nil
+}
+
+// Set creates or replaces the print options for a tag within a system.
+func (s *TagPrintOptionsStore) Set(model models.TagPrintOptions) error {
+	optionsJSON, err := json.Marshal(model.Options)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	_, err = s.Db.Exec(`
+		INSERT INTO tag_print_options (system_id, tag, options, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (system_id, tag) DO UPDATE SET options = excluded.options, updated_at = excluded.updated_at
+	`, model.SystemId, model.Tag, string(optionsJSON), now, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *TagPrintOptionsStore) GetBySystemId(id int64) ([]models.TagPrintOptions, error) {
+	query, err := s.Db.Query(`
+		SELECT id, system_id, tag, options, created_at, updated_at
+		FROM tag_print_options
+		WHERE system_id = ?
+		ORDER BY tag
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	var options []models.TagPrintOptions
+
+	for query.Next() {
+		var option models.TagPrintOptions
+		var optionsJSON string
+
+		err := query.Scan(
+			&option.Id,
+			&option.SystemId,
+			&option.Tag,
+			&optionsJSON,
+			&option.CreatedAt,
+			&option.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(optionsJSON), &option.Options); err != nil {
+			return nil, err
+		}
+
+		options = append(options, option)
+	}
+
+	return options, nil
+}
+
+func (s *TagPrintOptionsStore) GetById(id int64) (*models.TagPrintOptions, error) {
+	row := s.Db.QueryRow(`
+		SELECT id, system_id, tag, options, created_at, updated_at
+		FROM tag_print_options
+		WHERE id = ?
+	`, id)
+
+	var option models.TagPrintOptions
+	var optionsJSON string
+
+	err := row.Scan(
+		&option.Id,
+		&option.SystemId,
+		&option.Tag,
+		&optionsJSON,
+		&option.CreatedAt,
+		&option.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(optionsJSON), &option.Options); err != nil {
+		return nil, err
+	}
+
+	return &option, nil
+}
+
+func (s *TagPrintOptionsStore) Delete(id int64) error {
+	_, err := s.Db.Exec("DELETE FROM tag_print_options WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"time"
+)
+
+type PrintScheduleStoreInterface interface {
+	Add(model models.PrintSchedule) (int64, error)
+	Get() ([]models.PrintSchedule, error)
+	GetById(id int64) (*models.PrintSchedule, error)
+	GetBySystemId(id int64) ([]models.PrintSchedule, error)
+	GetDue(now int64) ([]models.PrintSchedule, error)
+	Update(model models.PrintSchedule) error
+	RecordRun(id int64, lastRunAt *int64, nextRunAt *int64) error
+	RecordFailedRun(id int64, retryAt int64) error
+	MarkRunMissed(id int64, runAt int64) error
+	Delete(id int64) error
+}
+
+type PrintScheduleStore struct {
+	Db *sql.DB
+}
+
+const printScheduleColumns = `id, system_id, name, cron, time_zone, target, file_id, tag, mode, enabled, next_run_at, last_run_at, failed_runs, retry_at, missed_run_at, created_at, updated_at`
+
+func scanPrintSchedule(row interface{ Scan(dest ...any) error }) (models.PrintSchedule, error) {
+	var schedule models.PrintSchedule
+
+	err := row.Scan(
+		&schedule.Id,
+		&schedule.SystemId,
+		&schedule.Name,
+		&schedule.Cron,
+		&schedule.TimeZone,
+		&schedule.Target,
+		&schedule.FileReference,
+		&schedule.Tag,
+		&schedule.Mode,
+		&schedule.Enabled,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.FailedRuns,
+		&schedule.RetryAt,
+		&schedule.MissedRunAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+
+	return schedule, err
+}
+
+func (s *PrintScheduleStore) queryPrintSchedules(query string, args ...any) ([]models.PrintSchedule, error) {
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []models.PrintSchedule
+
+	for rows.Next() {
+		schedule, err := scanPrintSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (s *PrintScheduleStore) Add(model models.PrintSchedule) (int64, error) {
+	now := time.Now().Unix()
+
+	result, err := s.Db.Exec(`
+		INSERT INTO print_schedules (system_id, name, cron, time_zone, target, file_id, tag, mode, enabled, next_run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, model.SystemId, model.Name, model.Cron, model.TimeZone, model.Target, model.FileReference, model.Tag, model.Mode, model.Enabled, model.NextRunAt, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *PrintScheduleStore) Get() ([]models.PrintSchedule, error) {
+	return s.queryPrintSchedules(`
+		SELECT ` + printScheduleColumns + `
+		FROM print_schedules
+		ORDER BY id
+	`)
+}
+
+func (s *PrintScheduleStore) GetById(id int64) (*models.PrintSchedule, error) {
+	row := s.Db.QueryRow(`
+		SELECT `+printScheduleColumns+`
+		FROM print_schedules
+		WHERE id = ?
+	`, id)
+
+	schedule, err := scanPrintSchedule(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+func (s *PrintScheduleStore) GetBySystemId(id int64) ([]models.PrintSchedule, error) {
+	return s.queryPrintSchedules(`
+		SELECT `+printScheduleColumns+`
+		FROM print_schedules
+		WHERE system_id = ?
+		ORDER BY id
+	`, id)
+}
+
+// GetDue returns enabled schedules whose next run has passed, leaving out
+// failed runs until they are retried.
+func (s *PrintScheduleStore) GetDue(now int64) ([]models.PrintSchedule, error) {
+	return s.queryPrintSchedules(`
+		SELECT `+printScheduleColumns+`
+		FROM print_schedules
+		WHERE enabled = 1
+		AND next_run_at IS NOT NULL
+		AND next_run_at <= ?
+		AND (retry_at IS NULL OR retry_at <= ?)
+		ORDER BY next_run_at
+	`, now, now)
+}
+
+func (s *PrintScheduleStore) Update(model models.PrintSchedule) error {
+	_, err := s.Db.Exec(`
+		UPDATE print_schedules
+		SET name = ?, cron = ?, time_zone = ?, target = ?, file_id = ?, tag = ?, mode = ?, enabled = ?, next_run_at = ?, failed_runs = 0, retry_at = NULL, updated_at = ?
+		WHERE id = ?
+	`, model.Name, model.Cron, model.TimeZone, model.Target, model.FileReference, model.Tag, model.Mode, model.Enabled, model.NextRunAt, time.Now().Unix(), model.Id)
+	return err
+}
+
+// RecordRun stores when a schedule last ran and when it runs next. A nil
+// lastRunAt keeps the previous value, for runs that were skipped.
+func (s *PrintScheduleStore) RecordRun(id int64, lastRunAt *int64, nextRunAt *int64) error {
+	_, err := s.Db.Exec(`
+		UPDATE print_schedules
+		SET last_run_at = COALESCE(?, last_run_at), next_run_at = ?, failed_runs = 0, retry_at = NULL
+		WHERE id = ?
+	`, lastRunAt, nextRunAt, id)
+	return err
+}
+
+// RecordFailedRun counts a failed attempt at a schedule's due run, which
+// is tried again at retryAt.
+func (s *PrintScheduleStore) RecordFailedRun(id int64, retryAt int64) error {
+	_, err := s.Db.Exec(`
+		UPDATE print_schedules
+		SET failed_runs = failed_runs + 1, retry_at = ?
+		WHERE id = ?
+	`, retryAt, id)
+	return err
+}
+
+// MarkRunMissed records that the run due at runAt wasn't printed.
+func (s *PrintScheduleStore) MarkRunMissed(id int64, runAt int64) error {
+	_, err := s.Db.Exec(`
+		UPDATE print_schedules
+		SET missed_run_at = ?
+		WHERE id = ?
+	`, runAt, id)
+	return err
+}
+
+func (s *PrintScheduleStore) Delete(id int64) error {
+	_, err := s.Db.Exec("DELETE FROM print_schedules WHERE id = ?", id)
+	return err
+}
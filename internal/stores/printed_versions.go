@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"encoding/json"
+)
+
+type PrintedVersionStoreInterface interface {
+	Record(job models.PrintJob) error
+	GetByBinder(binder string) ([]models.PrintedVersion, error)
+	Delete(binder string, fileReference string) error
+}
+
+type PrintedVersionStore struct {
+	Db *sql.DB
+}
+
+// Record makes the content a completed job printed the binder's version of
+// its document. Jobs outside a binder, or whose document is gone, are ignored.
+func (s *PrintedVersionStore) Record(job models.PrintJob) error {
+	if job.Binder == nil || job.ContentHash == nil || job.CompletedAt == nil {
+		return nil
+	}
+
+	_, err := s.Db.Exec(`
+		INSERT INTO printed_versions (binder, system_id, file_id, tags, content_hash, document_updated_at, print_job_id, printed_at)
+		SELECT ?, system_id, file_id, `+documentTags+`, ?, ?, ?, ?
+		FROM documents
+		WHERE id = ?
+		ON CONFLICT (binder, file_id) DO UPDATE SET
+			tags = excluded.tags,
+			content_hash = excluded.content_hash,
+			document_updated_at = excluded.document_updated_at,
+			print_job_id = excluded.print_job_id,
+			printed_at = excluded.printed_at
+	`, *job.Binder, *job.ContentHash, job.DocumentUpdatedAt, job.Id, *job.CompletedAt, job.DocumentId)
+	return err
+}
+
+func (s *PrintedVersionStore) GetByBinder(binder string) ([]models.PrintedVersion, error) {
+	rows, err := s.Db.Query(`
+		SELECT binder, system_id, file_id, tags, content_hash, document_updated_at, print_job_id, printed_at
+		FROM printed_versions
+		WHERE binder = ?
+		ORDER BY file_id
+	`, binder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.PrintedVersion
+
+	for rows.Next() {
+		var version models.PrintedVersion
+		var tagsJSON sql.NullString
+
+		err := rows.Scan(
+			&version.Binder,
+			&version.SystemId,
+			&version.FileReference,
+			&tagsJSON,
+			&version.ContentHash,
+			&version.DocumentUpdatedAt,
+			&version.PrintJobId,
+			&version.PrintedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if tagsJSON.String != "" {
+			if err := json.Unmarshal([]byte(tagsJSON.String), &version.Tags); err != nil {
+				return nil, err
+			}
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (s *PrintedVersionStore) Delete(binder string, fileReference string) error {
+	_, err := s.Db.Exec("DELETE FROM printed_versions WHERE binder = ? AND file_id = ?", binder, fileReference)
+	return err
+}
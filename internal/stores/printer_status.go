@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"encoding/json"
+)
+
+type PrinterStatusStoreInterface interface {
+	Add(status models.PrinterStatus) error
+	GetLatest() (*models.PrinterStatus, error)
+	GetHistory(since int64, limit int) ([]models.PrinterStatus, error)
+	DeleteOlderThan(timestamp int64) error
+}
+
+type PrinterStatusStore struct {
+	Db *sql.DB
+}
+
+const printerStatusColumns = `id, printer_name, state, state_reasons, markers, ready, warnings, checked_at`
+
+func scanPrinterStatus(row interface{ Scan(dest ...any) error }) (models.PrinterStatus, error) {
+	var status models.PrinterStatus
+	var reasonsJSON, markersJSON, warningsJSON sql.NullString
+
+	err := row.Scan(
+		&status.Id,
+		&status.PrinterName,
+		&status.State,
+		&reasonsJSON,
+		&markersJSON,
+		&status.Ready,
+		&warningsJSON,
+		&status.CheckedAt,
+	)
+	if err != nil {
+		return status, err
+	}
+
+	for raw, dest := range map[*sql.NullString]any{
+		&reasonsJSON:  &status.StateReasons,
+		&markersJSON:  &status.Markers,
+		&warningsJSON: &status.Warnings,
+	} {
+		if raw.String == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(raw.String), dest); err != nil {
+			return status, err
+		}
+	}
+
+	return status, nil
+}
+
+func (s *PrinterStatusStore) Add(status models.PrinterStatus) error {
+	reasonsJSON, err := json.Marshal(status.StateReasons)
+	if err != nil {
+		return err
+	}
+
+	markersJSON, err := json.Marshal(status.Markers)
+	if err != nil {
+		return err
+	}
+
+	warningsJSON, err := json.Marshal(status.Warnings)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Db.Exec(`
+		INSERT INTO printer_status_history (printer_name, state, state_reasons, markers, ready, warnings, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, status.PrinterName, status.State, string(reasonsJSON), string(markersJSON), status.Ready, string(warningsJSON), status.CheckedAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLatest returns the most recent printer status, or nil if the printer
+// has never been checked.
+func (s *PrinterStatusStore) GetLatest() (*models.PrinterStatus, error) {
+	row := s.Db.QueryRow(`
+		SELECT ` + printerStatusColumns + `
+		FROM printer_status_history
+		ORDER BY checked_at DESC, id DESC
+		LIMIT 1
+	`)
+
+	status, err := scanPrinterStatus(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// GetHistory returns printer statuses checked at or after since, newest first.
+func (s *PrinterStatusStore) GetHistory(since int64, limit int) ([]models.PrinterStatus, error) {
+	query, err := s.Db.Query(`
+		SELECT `+printerStatusColumns+`
+		FROM printer_status_history
+		WHERE checked_at >= ?
+		ORDER BY checked_at DESC, id DESC
+		LIMIT ?
+	`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer query.Close()
+
+	var statuses []models.PrinterStatus
+
+	for query.Next() {
+		status, err := scanPrinterStatus(query)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, query.Err()
+}
+
+func (s *PrinterStatusStore) DeleteOlderThan(timestamp int64) error {
+	_, err := s.Db.Exec("DELETE FROM printer_status_history WHERE checked_at < ?", timestamp)
+	if err != nil {
+		return err
+	}
+	return nil
+}
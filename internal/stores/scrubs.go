@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"database/sql"
+)
+
+// scrubRunsKept is how many scrub reports are kept.
+const scrubRunsKept = 30
+
+type ScrubStoreInterface interface {
+	GetStoredFiles() ([]models.StoredFile, error)
+	GetKnownPaths() ([]string, error)
+	Add(report models.ScrubReport) error
+	GetLatest() (*models.ScrubReport, error)
+}
+
+type ScrubStore struct {
+	Db *sql.DB
+}
+
+// GetStoredFiles returns the files printing relies on: blobs in use, with
+// their recorded hash and size, PDF conversions, document files outside
+// the blob store and current templates.
+func (s *ScrubStore) GetStoredFiles() ([]models.StoredFile, error) {
+	rows, err := s.Db.Query(`
+		SELECT path, ?, hash, size FROM blobs WHERE ref_count > 0
+		UNION ALL
+		SELECT DISTINCT converted_path, ?, NULL, NULL FROM documents
+		WHERE conversion_status = ? AND converted_path IS NOT NULL AND deleted_at IS NULL
+		UNION ALL
+		SELECT DISTINCT file_path, ?, NULL, NULL FROM documents
+		WHERE file_path NOT LIKE ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT DISTINCT template_path, ?, NULL, NULL FROM templates
+		WHERE deleted_at IS NULL
+	`, models.StoredBlob, models.StoredConversion, models.ConversionConverted, models.StoredDocument, storage.BlobsRoot+"/%", models.StoredTemplate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.StoredFile
+
+	for rows.Next() {
+		var file models.StoredFile
+		if err := rows.Scan(&file.Path, &file.Kind, &file.Hash, &file.Size); err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// GetKnownPaths returns every stored file something refers to, including
+// unused blobs awaiting garbage collection and old template versions.
+func (s *ScrubStore) GetKnownPaths() ([]string, error) {
+	rows, err := s.Db.Query(`
+		SELECT path FROM blobs
+		UNION SELECT file_path FROM documents
+		UNION SELECT file_path FROM document_versions
+		UNION SELECT template_path FROM templates
+		UNION SELECT template_path FROM template_versions
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+// Add records a scrub report, dropping the oldest beyond those kept.
+func (s *ScrubStore) Add(report models.ScrubReport) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO scrub_runs (started_at, finished_at, files_checked, missing, corrupt, orphaned)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, report.StartedAt, report.FinishedAt, report.FilesChecked, report.Missing, report.Corrupt, report.Orphaned)
+	if err != nil {
+		return err
+	}
+
+	runId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range report.Problems {
+		_, err := tx.Exec(`
+			INSERT INTO scrub_problems (run_id, path, kind, problem, detail)
+			VALUES (?, ?, ?, ?, ?)
+		`, runId, problem.Path, problem.Kind, problem.Problem, problem.Detail)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM scrub_runs
+		WHERE id NOT IN (SELECT id FROM scrub_runs ORDER BY id DESC LIMIT ?)
+	`, scrubRunsKept)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLatest returns the most recent scrub report, or nil if storage has
+// never been scrubbed.
+func (s *ScrubStore) GetLatest() (*models.ScrubReport, error) {
+	var report models.ScrubReport
+
+	err := s.Db.QueryRow(`
+		SELECT id, started_at, finished_at, files_checked, missing, corrupt, orphaned
+		FROM scrub_runs
+		ORDER BY id DESC
+		LIMIT 1
+	`).Scan(&report.Id, &report.StartedAt, &report.FinishedAt, &report.FilesChecked, &report.Missing, &report.Corrupt, &report.Orphaned)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := s.Db.Query(`
+		SELECT path, kind, problem, COALESCE(detail, '')
+		FROM scrub_problems
+		WHERE run_id = ?
+		ORDER BY id
+	`, report.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Problems = []models.ScrubProblem{}
+
+	for rows.Next() {
+		var problem models.ScrubProblem
+		if err := rows.Scan(&problem.Path, &problem.Kind, &problem.Problem, &problem.Detail); err != nil {
+			return nil, err
+		}
+
+		report.Problems = append(report.Problems, problem)
+	}
+
+	return &report, rows.Err()
+}
@@ -7,9 +7,14 @@ package stores
 import (
 	"blackoutbox/internal/models"
 	"blackoutbox/internal/storage"
+	"blackoutbox/internal/tagexpr"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
@@ -27,7 +32,10 @@ type SystemStore struct {
 	Db *sql.DB
 }
 
-// Sync replaces all documents for a system and refreshes its filesystem folder.
+// Sync makes a system's documents match the given list. Documents are
+// matched by file id: listed ones are updated in place, keeping their
+// history and print state, and restored if they were in the trash; new
+// ones are added; and ones no longer listed are moved to the trash.
 func (s *SystemStore) Sync(systemId int64, documents []models.Document) error {
 	tx, err := s.Db.Begin()
 	if err != nil {
@@ -37,78 +45,293 @@ func (s *SystemStore) Sync(systemId int64, documents []models.Document) error {
 
 	// 1. Get the system reference for filesystem operations
 	var systemRef string
-	err = s.Db.QueryRow(`SELECT reference FROM systems WHERE id = ?`, systemId).Scan(&systemRef)
+	err = tx.QueryRow(`SELECT reference FROM systems WHERE id = ?`, systemId).Scan(&systemRef)
 	if err != nil {
 		return err
 	}
 
-	// 2. Remove existing documents for the system
-	_, err = tx.Exec(`
-		DELETE FROM documents
-		WHERE system_id = ?
-	`, systemId)
+	// 2. Find the documents the system has, including those in the trash
+	existing, err := syncedFiles(tx, systemId)
 	if err != nil {
 		return err
 	}
 
-	// 3. Insert new document metadata
-	stmt, err := tx.Prepare(`
-		INSERT INTO documents (
-			system_id,
-			file_id,
-			file_path,
-			print_at,
-			last_printed_at,
-			updated_at
-		) VALUES (?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
 	now := time.Now().Unix()
+	listed := make(map[int64]bool, len(documents))
 
+	// 3. Add new documents and update the others
 	for _, doc := range documents {
-		_, err := stmt.Exec(
-			systemId,
-			doc.FileReference,
-			doc.FilePath,
-			doc.PrintAt,
-			doc.LastPrintedAt,
-			now,
-		)
+		printOptionsJSON, err := marshalPrintOptions(doc.PrintOptions)
+		if err != nil {
+			return err
+		}
+
+		// Import the file into the blob store when the sync names a new
+		// one, or names one again that hadn't arrived before
+		syncedPath := doc.FilePath
+		file, ok := existing[doc.FileReference]
+		if !ok || file.syncedPath == nil || *file.syncedPath != syncedPath || !storage.IsBlob(file.path) {
+			if err := importSyncedFile(&doc); err != nil {
+				return err
+			}
+		} else {
+			doc.FilePath = file.path
+		}
+		if err := addBlob(tx, doc); err != nil {
+			return err
+		}
+
+		if !ok {
+			result, err := tx.Exec(`
+				INSERT INTO documents (system_id, file_id, file_path, file_size, file_hash, synced_path, print_at, last_printed_at, print_options, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, systemId, doc.FileReference, doc.FilePath, doc.FileSize, doc.FileHash, syncedPath, doc.PrintAt, doc.LastPrintedAt, printOptionsJSON, now)
+			if err != nil {
+				return err
+			}
+
+			file.id, err = result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			file.path = doc.FilePath
+			existing[doc.FileReference] = file
+
+			// Record the synced file as the document's first version
+			if err := snapshotDocument(tx, file.id); err != nil {
+				return err
+			}
+		} else {
+			fileChanged := doc.FilePath != file.path
+			tagsChanged, err := documentTagsChanged(tx, file.id, doc.Tags)
+			if err != nil {
+				return err
+			}
+
+			// Only a change to what is printed, or a restore from the
+			// trash, makes a new version of the document
+			_, err = tx.Exec(`
+				UPDATE documents
+				SET version = version + 1, updated_at = ?
+				WHERE id = ? AND (? OR print_at IS NOT ? OR print_options IS NOT ? OR deleted_at IS NOT NULL)
+			`, now, file.id, fileChanged || tagsChanged, doc.PrintAt, printOptionsJSON)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`
+				UPDATE documents
+				SET synced_path = ?, print_at = ?, last_printed_at = COALESCE(?, last_printed_at), print_options = ?, deleted_at = NULL
+				WHERE id = ?
+			`, syncedPath, doc.PrintAt, doc.LastPrintedAt, printOptionsJSON, file.id)
+			if err != nil {
+				return err
+			}
+
+			if fileChanged {
+				if err := syncFile(tx, file.id, doc); err != nil {
+					return err
+				}
+				file.path = doc.FilePath
+				existing[doc.FileReference] = file
+			}
+		}
+
+		if err := setDocumentTags(tx, file.id, doc.Tags); err != nil {
+			return err
+		}
+		listed[file.id] = true
+	}
+
+	// 4. Move documents that are no longer listed to the trash
+	for _, file := range existing {
+		if listed[file.id] {
+			continue
+		}
+
+		_, err := tx.Exec(`
+			UPDATE documents
+			SET deleted_at = ?
+			WHERE id = ? AND deleted_at IS NULL
+		`, now, file.id)
 		if err != nil {
 			return err
 		}
 	}
 
-	// 4. Commit database changes
+	// 5. Commit database changes
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	// 5. Sync filesystem
+	// 6. Make sure the system's folder exists. Files of documents in the
+	// trash are kept until they are purged.
 	systemDir := filepath.Join(storage.DocumentsRoot, systemRef)
+	return os.MkdirAll(systemDir, 0755)
+}
 
-	// Remove old system directory completely
-	if err := os.RemoveAll(systemDir); err != nil {
+// syncedFile is the id and current file of a document, and the path a
+// sync last named the file by.
+type syncedFile struct {
+	id         int64
+	path       string
+	syncedPath *string
+}
+
+// syncedFiles returns the documents of a system by file id, including
+// those in the trash.
+func syncedFiles(tx *sql.Tx, systemId int64) (map[string]syncedFile, error) {
+	rows, err := tx.Query(`
+		SELECT file_id, id, file_path, synced_path
+		FROM documents
+		WHERE system_id = ?
+	`, systemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]syncedFile)
+	for rows.Next() {
+		var fileId string
+		var file syncedFile
+		if err := rows.Scan(&fileId, &file.id, &file.path, &file.syncedPath); err != nil {
+			return nil, err
+		}
+		files[fileId] = file
+	}
+
+	return files, rows.Err()
+}
+
+// documentTagsChanged reports whether tags differ from a document's
+// current tags, once normalized.
+func documentTagsChanged(tx *sql.Tx, id int64, tags []string) (bool, error) {
+	rows, err := tx.Query(`SELECT tag FROM document_tags WHERE document_id = ? ORDER BY position`, id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var current []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return false, err
+		}
+		current = append(current, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	return !slices.Equal(current, tagexpr.NormalizeAll(tags)), nil
+}
+
+// importSyncedFile imports the file a sync names into the blob store and
+// points doc at the blob. A file that hasn't arrived yet is left for a
+// later sync to import, and is used from where it is meanwhile.
+func importSyncedFile(doc *models.Document) error {
+	blob, err := storage.ImportFile(doc.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", doc.FilePath, err)
+	}
+
+	doc.FilePath = blob.Path
+	doc.FileSize = &blob.Size
+	doc.FileHash = &blob.Hash
+	return nil
+}
+
+// syncFile replaces the file of a synced document, recording it as a new
+// version. The uploader isn't known for synced files, and the file is
+// converted again when it is next printed.
+func syncFile(tx *sql.Tx, id int64, doc models.Document) error {
+	_, err := tx.Exec(`
+		UPDATE documents
+		SET file_path = ?, file_size = ?, file_hash = ?, uploaded_by = NULL, conversion_status = ?, converted_path = NULL, converted_hash = NULL, conversion_error = NULL
+		WHERE id = ?
+	`, doc.FilePath, doc.FileSize, doc.FileHash, models.ConversionPending, id)
+	if err != nil {
 		return err
 	}
 
-	// Recreate system directory
-	if err := os.MkdirAll(systemDir, 0755); err != nil {
+	if err := snapshotDocument(tx, id); err != nil {
 		return err
 	}
+	return pruneVersions(tx, id)
+}
 
-	return nil
+const systemColumns = `id, reference, name, description, print_separators, stamp, outage_hours, document_versions_kept, trash_days, created_at, updated_at, deleted_at`
+
+func scanSystem(row interface{ Scan(dest ...any) error }) (models.System, error) {
+	var system models.System
+	var description sql.NullString
+	var stampJSON *string
+
+	err := row.Scan(
+		&system.Id,
+		&system.Reference,
+		&system.Name,
+		&description,
+		&system.PrintSeparators,
+		&stampJSON,
+		&system.OutageHours,
+		&system.DocumentVersionsKept,
+		&system.TrashDays,
+		&system.CreatedAt,
+		&system.UpdatedAt,
+		&system.DeletedAt,
+	)
+	if err != nil {
+		return system, err
+	}
+
+	system.Description = description.String
+	system.Stamp, err = unmarshalPageStamp(stampJSON)
+	return system, err
+}
+
+func marshalPageStamp(stamp *models.PageStamp) (*string, error) {
+	if stamp == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(stamp)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(data)
+	return &s, nil
+}
+
+func unmarshalPageStamp(raw *string) (*models.PageStamp, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+
+	var stamp models.PageStamp
+	if err := json.Unmarshal([]byte(*raw), &stamp); err != nil {
+		return nil, err
+	}
+
+	return &stamp, nil
 }
 
 func (s *SystemStore) AddSystem(system models.System) error {
-	_, err := s.Db.Exec(`
-		INSERT INTO systems (reference, name, description, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, system.Reference, system.Name, system.Description, system.CreatedAt, system.UpdatedAt)
+	stampJSON, err := marshalPageStamp(system.Stamp)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Db.Exec(`
+		INSERT INTO systems (reference, name, description, print_separators, stamp, outage_hours, document_versions_kept, trash_days, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, system.Reference, system.Name, system.Description, system.PrintSeparators, stampJSON, system.OutageHours, system.DocumentVersionsKept, system.TrashDays, system.CreatedAt, system.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -117,7 +340,7 @@ func (s *SystemStore) AddSystem(system models.System) error {
 
 func (s *SystemStore) GetSystems() ([]models.System, error) {
 	query, err := s.Db.Query(`
-		SELECT id, reference, name, description, created_at, updated_at, deleted_at
+		SELECT ` + systemColumns + `
 		FROM systems
 		WHERE deleted_at IS NULL
 	`)
@@ -129,17 +352,7 @@ func (s *SystemStore) GetSystems() ([]models.System, error) {
 	var systems []models.System
 
 	for query.Next() {
-		var system models.System
-
-		err := query.Scan(
-			&system.Id,
-			&system.Reference,
-			&system.Name,
-			&system.Description,
-			&system.CreatedAt,
-			&system.UpdatedAt,
-			system.DeletedAt,
-		)
+		system, err := scanSystem(query)
 		if err != nil {
 			return nil, err
 		}
@@ -152,22 +365,12 @@ func (s *SystemStore) GetSystems() ([]models.System, error) {
 
 func (s *SystemStore) GetSystemById(id int64) (*models.System, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, reference, name, description, created_at, updated_at, deleted_at
+		SELECT `+systemColumns+`
 		FROM systems
 		WHERE id = ? AND deleted_at IS NULL
 	`, id)
 
-	var system models.System
-
-	err := row.Scan(
-		&system.Id,
-		&system.Reference,
-		&system.Name,
-		&system.Description,
-		&system.CreatedAt,
-		&system.UpdatedAt,
-		system.DeletedAt,
-	)
+	system, err := scanSystem(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -180,22 +383,12 @@ func (s *SystemStore) GetSystemById(id int64) (*models.System, error) {
 
 func (s *SystemStore) GetSystemByReference(reference string) (*models.System, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, reference, name, description, created_at, updated_at, deleted_at
+		SELECT `+systemColumns+`
 		FROM systems
 		WHERE reference = ? AND deleted_at IS NULL
 	`, reference)
 
-	var system models.System
-
-	err := row.Scan(
-		&system.Id,
-		&system.Reference,
-		&system.Name,
-		&system.Description,
-		&system.CreatedAt,
-		&system.UpdatedAt,
-		system.DeletedAt,
-	)
+	system, err := scanSystem(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -207,17 +400,24 @@ func (s *SystemStore) GetSystemByReference(reference string) (*models.System, er
 }
 
 func (s *SystemStore) UpdateSystem(system models.System) error {
-	_, err := s.Db.Exec(`
+	stampJSON, err := marshalPageStamp(system.Stamp)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Db.Exec(`
 		UPDATE systems
-		SET reference = ?, name = ?, description = ?, updated_at = ?
+		SET reference = ?, name = ?, description = ?, print_separators = ?, stamp = ?, outage_hours = ?, document_versions_kept = ?, trash_days = ?, updated_at = ?
 		WHERE id = ?
-	`, system.Reference, system.Name, system.Description, time.Now().Unix(), system.Id)
+	`, system.Reference, system.Name, system.Description, system.PrintSeparators, stampJSON, system.OutageHours, system.DocumentVersionsKept, system.TrashDays, time.Now().Unix(), system.Id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// DeleteSystem marks a system as deleted and moves its documents to the
+// trash, where they are purged like other deleted documents.
 func (s *SystemStore) DeleteSystem(id int64) error {
 	tx, err := s.Db.Begin()
 	if err != nil {
@@ -225,24 +425,18 @@ func (s *SystemStore) DeleteSystem(id int64) error {
 	}
 	defer tx.Rollback()
 
-	// Get the system reference for filesystem operations
-	var systemRef string
-	err = s.Db.QueryRow(`SELECT reference FROM systems WHERE id = ?`, id).Scan(&systemRef)
-	if err != nil {
-		return err
-	}
+	now := time.Now().Unix()
 
 	_, err = tx.Exec(`
-		DELETE FROM documents
-		WHERE system_id = ?
-	`, id)
+		UPDATE documents
+		SET deleted_at = ?
+		WHERE system_id = ? AND deleted_at IS NULL
+	`, now, id)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now().Unix()
-
-	_, err = s.Db.Exec(`
+	_, err = tx.Exec(`
 		UPDATE systems
 		SET deleted_at = ?
 		WHERE id = ?
@@ -251,10 +445,5 @@ func (s *SystemStore) DeleteSystem(id int64) error {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-
-	systemDir := filepath.Join(storage.DocumentsRoot, systemRef)
-	return os.RemoveAll(systemDir)
+	return tx.Commit()
 }
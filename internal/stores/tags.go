@@ -0,0 +1,220 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/tagexpr"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+type TagStoreInterface interface {
+	GetCounts(systemId *int64) ([]models.TagCount, error)
+}
+
+type TagStore struct {
+	Db *sql.DB
+}
+
+// GetCounts returns the tags in use and how many documents have each,
+// per system, optionally only for one system.
+func (s *TagStore) GetCounts(systemId *int64) ([]models.TagCount, error) {
+	rows, err := s.Db.Query(`
+		SELECT d.system_id, t.tag, COUNT(*)
+		FROM document_tags t
+		JOIN documents d ON d.id = t.document_id
+		WHERE d.deleted_at IS NULL
+		AND (? IS NULL OR d.system_id = ?)
+		GROUP BY d.system_id, t.tag
+		ORDER BY d.system_id, t.tag
+	`, systemId, systemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.TagCount
+
+	for rows.Next() {
+		var count models.TagCount
+		if err := rows.Scan(&count.SystemId, &count.Tag, &count.Documents); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// Normalize brings tags stored before tags were normalized, such as those
+// migrated from the old documents.tags column, into normalized form. SQLite
+// only lower-cases ASCII, so this is done here rather than in a migration.
+// Tag print options that would clash with others for the same tag are left
+// alone. It returns how many rows were changed, and the problems found:
+// clashes and stored tags that can't be written in a tag expression.
+func (s *TagStore) Normalize() (int, []string, error) {
+	documentTags, err := s.storedDocumentTags()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	var problems []string
+	report := func(problem string) {
+		if !slices.Contains(problems, problem) {
+			problems = append(problems, problem)
+		}
+	}
+
+	// 1. Document tags
+	for _, document := range documentTags {
+		normalized := tagexpr.NormalizeAll(document.tags)
+		for _, tag := range normalized {
+			if err := tagexpr.CheckTag(tag); err != nil {
+				report(fmt.Sprintf("documents can't be selected by tag: %v", err))
+			}
+		}
+		if slices.Equal(normalized, document.tags) {
+			continue
+		}
+
+		if err := setDocumentTags(tx, document.id, normalized); err != nil {
+			return 0, nil, err
+		}
+		changed++
+	}
+
+	// 2. Tag print options
+	options, err := queryTags(tx, `SELECT id, tag, system_id FROM tag_print_options`)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, option := range options {
+		tag := tagexpr.Normalize(option.tag)
+		if err := tagexpr.CheckTag(tag); err != nil {
+			report(fmt.Sprintf("tag print options %d can't apply: %v", option.id, err))
+		}
+		if tag == option.tag {
+			continue
+		}
+
+		var clashes bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM tag_print_options WHERE system_id = ? AND tag = ?)
+		`, option.systemId, tag).Scan(&clashes)
+		if err != nil {
+			return 0, nil, err
+		}
+		if clashes {
+			report(fmt.Sprintf("tag print options %d for %q clash with the options for %q and were left as they are; delete one of them", option.id, option.tag, tag))
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE tag_print_options SET tag = ? WHERE id = ?`, tag, option.id); err != nil {
+			return 0, nil, err
+		}
+		changed++
+	}
+
+	// 3. Print schedules, which named a single tag before they took an
+	// expression
+	schedules, err := queryTags(tx, `SELECT id, tag, system_id FROM print_schedules WHERE tag IS NOT NULL`)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, schedule := range schedules {
+		var expression string
+		if expr, err := tagexpr.Parse(schedule.tag); err == nil {
+			expression = expr.String()
+		} else if tag := tagexpr.Normalize(schedule.tag); tagexpr.CheckTag(tag) == nil {
+			expression = tag
+		} else {
+			report(fmt.Sprintf("print schedule %d can't select documents by %q: %v", schedule.id, schedule.tag, err))
+			continue
+		}
+		if expression == schedule.tag {
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE print_schedules SET tag = ? WHERE id = ?`, expression, schedule.id); err != nil {
+			return 0, nil, err
+		}
+		changed++
+	}
+
+	return changed, problems, tx.Commit()
+}
+
+// taggedDocument is a document's tags as they are stored, in order.
+type taggedDocument struct {
+	id   int64
+	tags []string
+}
+
+func (s *TagStore) storedDocumentTags() ([]taggedDocument, error) {
+	rows, err := s.Db.Query(`
+		SELECT document_id, tag
+		FROM document_tags
+		ORDER BY document_id, position
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []taggedDocument
+
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+
+		if len(documents) == 0 || documents[len(documents)-1].id != id {
+			documents = append(documents, taggedDocument{id: id})
+		}
+		documents[len(documents)-1].tags = append(documents[len(documents)-1].tags, tag)
+	}
+
+	return documents, rows.Err()
+}
+
+// taggedRow is a row naming a tag, with the system it belongs to.
+type taggedRow struct {
+	id       int64
+	tag      string
+	systemId int64
+}
+
+func queryTags(tx *sql.Tx, query string) ([]taggedRow, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tagged []taggedRow
+
+	for rows.Next() {
+		var row taggedRow
+		if err := rows.Scan(&row.id, &row.tag, &row.systemId); err != nil {
+			return nil, err
+		}
+
+		tagged = append(tagged, row)
+	}
+
+	return tagged, rows.Err()
+}
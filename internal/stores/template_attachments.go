@@ -0,0 +1,89 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"database/sql"
+	"time"
+)
+
+type TemplateAttachmentStoreInterface interface {
+	GetByDocument(systemId int64, fileReference string) ([]models.TemplateAttachment, error)
+	Replace(systemId int64, fileReference string, attachments []models.TemplateAttachment) error
+}
+
+type TemplateAttachmentStore struct {
+	Db *sql.DB
+}
+
+// GetByDocument returns the templates attached to a document, in the order
+// they are printed.
+func (s *TemplateAttachmentStore) GetByDocument(systemId int64, fileReference string) ([]models.TemplateAttachment, error) {
+	rows, err := s.Db.Query(`
+		SELECT id, system_id, file_id, template_id, position, copies, created_at
+		FROM document_templates
+		WHERE system_id = ? AND file_id = ?
+		ORDER BY position, id
+	`, systemId, fileReference)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.TemplateAttachment
+
+	for rows.Next() {
+		var attachment models.TemplateAttachment
+
+		err := rows.Scan(
+			&attachment.Id,
+			&attachment.SystemId,
+			&attachment.FileReference,
+			&attachment.TemplateId,
+			&attachment.Position,
+			&attachment.Copies,
+			&attachment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+// Replace sets the templates attached to a document. They are printed in
+// the order given.
+func (s *TemplateAttachmentStore) Replace(systemId int64, fileReference string, attachments []models.TemplateAttachment) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM document_templates
+		WHERE system_id = ? AND file_id = ?
+	`, systemId, fileReference)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for i, attachment := range attachments {
+		_, err := tx.Exec(`
+			INSERT INTO document_templates (system_id, file_id, template_id, position, copies, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, systemId, fileReference, attachment.TemplateId, i, attachment.Copies, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,192 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package stores
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type TemplateDataStoreInterface interface {
+	GetByTemplateId(templateId int64) ([]models.TemplateData, error)
+	Set(model models.TemplateData) error
+	Replace(templateId int64, fileReference *string, data []models.TemplateData) error
+	Delete(templateId int64, fileReference *string, subject string) (bool, error)
+}
+
+type TemplateDataStore struct {
+	Db *sql.DB
+}
+
+// GetByTemplateId returns the data for every filled-in copy of a template,
+// the data for any document first, then by document and subject.
+func (s *TemplateDataStore) GetByTemplateId(templateId int64) ([]models.TemplateData, error) {
+	rows, err := s.Db.Query(`
+		SELECT id, template_id, NULLIF(file_id, ''), subject, data, updated_at
+		FROM template_data
+		WHERE template_id = ?
+		ORDER BY file_id, subject
+	`, templateId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var data []models.TemplateData
+
+	for rows.Next() {
+		var item models.TemplateData
+		var dataJSON string
+
+		if err := rows.Scan(&item.Id, &item.TemplateId, &item.FileReference, &item.Subject, &dataJSON, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		dataJSON, err := storage.OpenValue(dataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data for %s: %w", item.Subject, err)
+		}
+
+		if err := json.Unmarshal([]byte(dataJSON), &item.Data); err != nil {
+			return nil, err
+		}
+
+		data = append(data, item)
+	}
+
+	return data, rows.Err()
+}
+
+// Set creates or replaces the data for one subject of a template, for the
+// model's document or for any document when it has none.
+func (s *TemplateDataStore) Set(model models.TemplateData) error {
+	return setTemplateData(s.Db, model, time.Now().Unix())
+}
+
+// Replace swaps the data of a template for one document, or for any
+// document when fileReference is nil, for the given data in one
+// transaction, so a sync never leaves a mix of old and new data.
+func (s *TemplateDataStore) Replace(templateId int64, fileReference *string, data []models.TemplateData) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM template_data WHERE template_id = ? AND file_id = ?`, templateId, dataFileReference(fileReference)); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, item := range data {
+		item.TemplateId = templateId
+		item.FileReference = fileReference
+		if err := setTemplateData(tx, item, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func setTemplateData(db sqlExecer, model models.TemplateData, now int64) error {
+	dataJSON, err := json.Marshal(model.Data)
+	if err != nil {
+		return err
+	}
+
+	// The data fills in forms about patients, so it is encrypted like
+	// the stored files.
+	sealed, err := storage.SealValue(string(dataJSON))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO template_data (template_id, file_id, subject, data, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (template_id, file_id, subject) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at
+	`, model.TemplateId, dataFileReference(model.FileReference), model.Subject, sealed, now)
+	return err
+}
+
+// dataFileReference is the file_id stored for data of a document, which
+// is empty for data printed with any document.
+func dataFileReference(fileReference *string) string {
+	if fileReference == nil {
+		return ""
+	}
+	return *fileReference
+}
+
+// Delete removes the data for one subject of a document, or of any
+// document when fileReference is nil, reporting whether there was any.
+func (s *TemplateDataStore) Delete(templateId int64, fileReference *string, subject string) (bool, error) {
+	result, err := s.Db.Exec(`
+		DELETE FROM template_data
+		WHERE template_id = ? AND file_id = ? AND subject = ?
+	`, templateId, dataFileReference(fileReference), subject)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Reseal encrypts the data stored as plain text or under a key other than
+// the active one, which finishes a key rotation. It returns how many rows
+// were encrypted.
+func (s *TemplateDataStore) Reseal() (int, error) {
+	rows, err := s.Db.Query(`SELECT id, data FROM template_data`)
+	if err != nil {
+		return 0, err
+	}
+
+	sealed := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !storage.NeedsSealing(data) {
+			continue
+		}
+
+		plain, err := storage.OpenValue(data)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to decrypt template data %d: %w", id, err)
+		}
+		if sealed[id], err = storage.SealValue(plain); err != nil {
+			rows.Close()
+			return 0, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for id, data := range sealed {
+		if _, err := tx.Exec(`UPDATE template_data SET data = ? WHERE id = ?`, data, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(sealed), tx.Commit()
+}
@@ -7,6 +7,7 @@ package stores
 import (
 	"blackoutbox/internal/models"
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
@@ -14,146 +15,359 @@ type TemplateStoreInterface interface {
 	Add(model models.Template) error
 	Get() ([]models.Template, error)
 	Update(model models.Template) error
+	Delete(id int64) (bool, error)
 	GetById(id int64) (*models.Template, error)
 	GetByFileReference(id string) (*models.Template, error)
 	GetBySystemId(id int64) ([]models.Template, error)
+	GetVersions(id int64) ([]models.TemplateVersion, error)
+	GetVersion(id int64, version int) (*models.TemplateVersion, error)
+	Rollback(id int64, version int) error
 }
 
 type TemplateStore struct {
 	Db *sql.DB
 }
 
+const templateColumns = `id, system_id, file_id, template_path, description, fields, print_options, version, created_at, updated_at, deleted_at`
+
+func scanTemplate(row interface{ Scan(dest ...any) error }) (models.Template, error) {
+	var template models.Template
+	var description sql.NullString
+	var fieldsJSON *string
+	var printOptionsJSON *string
+
+	err := row.Scan(
+		&template.Id,
+		&template.SystemId,
+		&template.FileReference,
+		&template.FilePath,
+		&description,
+		&fieldsJSON,
+		&printOptionsJSON,
+		&template.Version,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+		&template.DeletedAt,
+	)
+	if err != nil {
+		return template, err
+	}
+
+	template.Description = description.String
+
+	if fieldsJSON != nil {
+		if err := json.Unmarshal([]byte(*fieldsJSON), &template.Fields); err != nil {
+			return template, err
+		}
+	}
+
+	template.PrintOptions, err = unmarshalPrintOptions(printOptionsJSON)
+	return template, err
+}
+
+func (s *TemplateStore) queryTemplates(query string, args ...any) ([]models.Template, error) {
+	rows, err := s.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []models.Template
+
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, rows.Err()
+}
+
+func marshalTemplateFields(fields []models.TemplateField) (*string, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := string(raw)
+	return &encoded, nil
+}
+
+// snapshotTemplate records the current state of a template as its
+// current version.
+func snapshotTemplate(db sqlExecer, id int64) error {
+	_, err := db.Exec(`
+		INSERT INTO template_versions (template_id, version, template_path, description, fields, print_options, created_at)
+		SELECT id, version, template_path, description, fields, print_options, updated_at
+		FROM templates
+		WHERE id = ?
+	`, id)
+	return err
+}
+
+// Add stores a new template as version 1.
 func (s *TemplateStore) Add(model models.Template) error {
+	printOptionsJSON, err := marshalPrintOptions(model.PrintOptions)
+	if err != nil {
+		return err
+	}
+
+	fieldsJSON, err := marshalTemplateFields(model.Fields)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	now := time.Now().Unix()
 
-	_, err := s.Db.Exec(`
-		INSERT INTO templates (system_id, file_id, file_path, description, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, model.SystemId, model.FileReference, model.FilePath, model.Description, now, now)
+	result, err := tx.Exec(`
+		INSERT INTO templates (system_id, file_id, template_path, description, fields, print_options, version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)
+	`, model.SystemId, model.FileReference, model.FilePath, model.Description, fieldsJSON, printOptionsJSON, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := snapshotTemplate(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
+// Get returns every template that hasn't been deleted.
 func (s *TemplateStore) Get() ([]models.Template, error) {
-	query, err := s.Db.Query(`
-		SELECT id, system_id, file_id, file_path, description, created_at, deleted_at
+	return s.queryTemplates(`
+		SELECT ` + templateColumns + `
 		FROM templates
+		WHERE deleted_at IS NULL
 	`)
+}
+
+// Update replaces a template's file, description, fields and print
+// options, making them a new version. It returns sql.ErrNoRows if the
+// template doesn't exist or has been deleted.
+func (s *TemplateStore) Update(model models.Template) error {
+	printOptionsJSON, err := marshalPrintOptions(model.PrintOptions)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var documents []models.Template
+	fieldsJSON, err := marshalTemplateFields(model.Fields)
+	if err != nil {
+		return err
+	}
 
-	for query.Next() {
-		var document models.Template
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		err := query.Scan(
-			&document.Id,
-			&document.SystemId,
-			&document.FileReference,
-			&document.FilePath,
-			&document.CreatedAt,
-			&document.DeletedAt,
+	result, err := tx.Exec(`
+		UPDATE templates
+		SET template_path = ?, description = ?, fields = ?, print_options = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, model.FilePath, model.Description, fieldsJSON, printOptionsJSON, time.Now().Unix(), model.Id)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := snapshotTemplate(tx, model.Id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Rollback makes an earlier version of a template current again, as a new
+// version, so the history still shows what was printed in between. It
+// returns sql.ErrNoRows if the template or version doesn't exist.
+func (s *TemplateStore) Rollback(id int64, version int) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE templates
+		SET (template_path, description, fields, print_options) = (
+			SELECT template_path, description, fields, print_options
+			FROM template_versions
+			WHERE template_id = templates.id AND version = ?
+		), version = version + 1, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL AND EXISTS (
+			SELECT 1 FROM template_versions WHERE template_id = templates.id AND version = ?
 		)
-		if err != nil {
-			return nil, err
-		}
+	`, version, time.Now().Unix(), id, version)
+	if err != nil {
+		return err
+	}
 
-		documents = append(documents, document)
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := snapshotTemplate(tx, id); err != nil {
+		return err
 	}
 
-	return documents, nil
+	return tx.Commit()
 }
 
-func (s *TemplateStore) Update(model models.Template) error {
-	return nil
+// Delete marks a template as deleted, reporting whether there was one to
+// delete. Deleted templates keep their versions and print history but are
+// no longer listed or printed.
+func (s *TemplateStore) Delete(id int64) (bool, error) {
+	result, err := s.Db.Exec(`
+		UPDATE templates
+		SET deleted_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, time.Now().Unix(), id)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
 }
 
 func (s *TemplateStore) GetById(id int64) (*models.Template, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, system_id, file_id, file_path, description, deleted_at
+		SELECT `+templateColumns+`
 		FROM templates
 		WHERE id = ?
 	`, id)
 
-	var document models.Template
-
-	err := row.Scan(
-		&document.Id,
-		&document.SystemId,
-		&document.FileReference,
-		&document.FilePath,
-		&document.Description,
-		&document.CreatedAt,
-		&document.DeletedAt,
-	)
+	template, err := scanTemplate(row)
 	if err != nil {
 		return nil, err
 	}
 
-	return &document, nil
+	return &template, nil
 }
 
 func (s *TemplateStore) GetByFileReference(id string) (*models.Template, error) {
 	row := s.Db.QueryRow(`
-		SELECT id, system_id, file_id, file_path, description, created_at, deleted_at
+		SELECT `+templateColumns+`
 		FROM templates
-		WHERE file_id = ?
+		WHERE file_id = ? AND deleted_at IS NULL
 	`, id)
 
-	var document models.Template
-
-	err := row.Scan(
-		&document.Id,
-		&document.SystemId,
-		&document.FileReference,
-		&document.FilePath,
-		&document.Description,
-		&document.CreatedAt,
-		&document.DeletedAt,
-	)
+	template, err := scanTemplate(row)
 	if err != nil {
 		return nil, err
 	}
 
-	return &document, nil
+	return &template, nil
 }
 
 func (s *TemplateStore) GetBySystemId(id int64) ([]models.Template, error) {
-	query, err := s.Db.Query(`
-		SELECT id, system_id, file_id, file_path, description, created_at, deleted_at
+	return s.queryTemplates(`
+		SELECT `+templateColumns+`
 		FROM templates
-		WHERE system_id = ?
+		WHERE system_id = ? AND deleted_at IS NULL
 	`, id)
+}
+
+const templateVersionColumns = `id, template_id, version, template_path, description, fields, print_options, created_at`
+
+func scanTemplateVersion(row interface{ Scan(dest ...any) error }) (models.TemplateVersion, error) {
+	var version models.TemplateVersion
+	var description sql.NullString
+	var fieldsJSON *string
+	var printOptionsJSON *string
+
+	err := row.Scan(
+		&version.Id,
+		&version.TemplateId,
+		&version.Version,
+		&version.FilePath,
+		&description,
+		&fieldsJSON,
+		&printOptionsJSON,
+		&version.CreatedAt,
+	)
 	if err != nil {
-		return nil, err
+		return version, err
 	}
-	defer query.Close()
 
-	var documents []models.Template
+	version.Description = description.String
 
-	for query.Next() {
-		var document models.Template
+	if fieldsJSON != nil {
+		if err := json.Unmarshal([]byte(*fieldsJSON), &version.Fields); err != nil {
+			return version, err
+		}
+	}
 
-		err := query.Scan(
-			&document.Id,
-			&document.SystemId,
-			&document.FileReference,
-			&document.FilePath,
-			&document.Description,
-			&document.CreatedAt,
-			&document.DeletedAt,
-		)
+	version.PrintOptions, err = unmarshalPrintOptions(printOptionsJSON)
+	return version, err
+}
+
+// GetVersions returns every version of a template, newest first.
+func (s *TemplateStore) GetVersions(id int64) ([]models.TemplateVersion, error) {
+	rows, err := s.Db.Query(`
+		SELECT `+templateVersionColumns+`
+		FROM template_versions
+		WHERE template_id = ?
+		ORDER BY version DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.TemplateVersion
+
+	for rows.Next() {
+		version, err := scanTemplateVersion(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		documents = append(documents, document)
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (s *TemplateStore) GetVersion(id int64, version int) (*models.TemplateVersion, error) {
+	row := s.Db.QueryRow(`
+		SELECT `+templateVersionColumns+`
+		FROM template_versions
+		WHERE template_id = ? AND version = ?
+	`, id, version)
+
+	templateVersion, err := scanTemplateVersion(row)
+	if err != nil {
+		return nil, err
 	}
 
-	return documents, nil
+	return &templateVersion, nil
 }
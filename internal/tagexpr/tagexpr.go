@@ -0,0 +1,379 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package tagexpr parses the tag expressions used wherever documents are
+// selected by their tags, such as listing, searching and print schedules.
+//
+// An expression combines tags with "and", "or" and "not", or &, | and !,
+// and parentheses:
+//
+//	medication
+//	wing-b and not (night or weekend)
+//	icu | hdu
+//
+// "not" binds tightest, then "and", then "or". Tags are compared after
+// normalizing, so "Wing B" and "wing-b" are the same tag.
+package tagexpr
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// maxDepth bounds how deeply expressions can nest.
+const maxDepth = 32
+
+var ErrEmpty = errors.New("tag expression is empty")
+
+type op int
+
+const (
+	opTag op = iota
+	opAnd
+	opOr
+	opNot
+)
+
+// Expr is a parsed tag expression.
+type Expr struct {
+	op   op
+	tag  string
+	args []*Expr
+}
+
+// keywords are the operators that are spelled as words, so they can't be
+// tags themselves.
+var keywords = map[string]op{"and": opAnd, "or": opOr, "not": opNot}
+
+// Normalize returns the form a tag is stored and compared in: trimmed,
+// lower case, with runs of spaces replaced by a hyphen.
+func Normalize(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+}
+
+// NormalizeAll normalizes a list of tags, dropping empty and repeated ones
+// but otherwise keeping their order.
+func NormalizeAll(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = Normalize(tag)
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// CheckTag returns an error if a normalized tag couldn't be written in an
+// expression. Tags are letters, digits and - _ . : /, and can't be one of
+// the words and, or and not.
+func CheckTag(tag string) error {
+	if tag == "" {
+		return errors.New("tag is empty")
+	}
+	if _, ok := keywords[tag]; ok {
+		return fmt.Errorf("%q is reserved and can't be used as a tag", tag)
+	}
+	for _, r := range tag {
+		if !isTagRune(r) {
+			return fmt.Errorf("tag %q contains %q; tags may only contain letters, digits and - _ . : /", tag, r)
+		}
+	}
+	return nil
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.:/", r)
+}
+
+// Tag returns an expression matching a single tag.
+func Tag(tag string) *Expr {
+	return &Expr{op: opTag, tag: Normalize(tag)}
+}
+
+// All returns an expression matching documents with every one of tags,
+// or nil if there are none.
+func All(tags ...string) *Expr {
+	return combine(opAnd, tags)
+}
+
+// Any returns an expression matching documents with at least one of
+// tags, or nil if there are none.
+func Any(tags ...string) *Expr {
+	return combine(opOr, tags)
+}
+
+func combine(o op, tags []string) *Expr {
+	tags = NormalizeAll(tags)
+	switch len(tags) {
+	case 0:
+		return nil
+	case 1:
+		return Tag(tags[0])
+	}
+
+	e := &Expr{op: o}
+	for _, tag := range tags {
+		e.args = append(e.args, Tag(tag))
+	}
+	return e
+}
+
+// And returns an expression matching what both a and b match. Either may
+// be nil, which matches everything.
+func And(a, b *Expr) *Expr {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &Expr{op: opAnd, args: []*Expr{a, b}}
+}
+
+// Parse reads a tag expression.
+func Parse(s string) (*Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrEmpty
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in tag expression", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+type token struct {
+	op    op
+	text  string
+	open  bool
+	close bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{text: "(", open: true})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{text: ")", close: true})
+			i++
+		case r == '&':
+			tokens = append(tokens, token{op: opAnd, text: "&"})
+			i++
+		case r == '|':
+			tokens = append(tokens, token{op: opOr, text: "|"})
+			i++
+		case r == '!':
+			tokens = append(tokens, token{op: opNot, text: "!"})
+			i++
+		case isTagRune(r):
+			start := i
+			for i < len(runes) && isTagRune(runes[i]) {
+				i++
+			}
+			word := strings.ToLower(string(runes[start:i]))
+			if o, ok := keywords[word]; ok {
+				tokens = append(tokens, token{op: o, text: word})
+			} else {
+				tokens = append(tokens, token{op: opTag, text: word})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in tag expression", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) or(depth int) (*Expr, error) {
+	return p.list(opOr, depth, p.and)
+}
+
+func (p *parser) and(depth int) (*Expr, error) {
+	return p.list(opAnd, depth, p.not)
+}
+
+// list parses operands joined by o.
+func (p *parser) list(o op, depth int, operand func(int) (*Expr, error)) (*Expr, error) {
+	first, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []*Expr{first}
+	for {
+		t := p.peek()
+		if t == nil || t.open || t.close || t.op != o {
+			break
+		}
+		p.pos++
+
+		next, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, next)
+	}
+
+	if len(args) == 1 {
+		return first, nil
+	}
+	return &Expr{op: o, args: args}, nil
+}
+
+func (p *parser) not(depth int) (*Expr, error) {
+	if depth > maxDepth {
+		return nil, errors.New("tag expression is nested too deeply")
+	}
+
+	t := p.peek()
+	switch {
+	case t == nil:
+		return nil, errors.New("tag expression ends where a tag was expected")
+	case t.open:
+		p.pos++
+		e, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t == nil || !t.close {
+			return nil, errors.New("tag expression is missing a )")
+		}
+		p.pos++
+		return e, nil
+	case t.close:
+		return nil, errors.New("unexpected \")\" in tag expression")
+	case t.op == opNot:
+		p.pos++
+		e, err := p.not(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{op: opNot, args: []*Expr{e}}, nil
+	case t.op == opTag:
+		p.pos++
+		return &Expr{op: opTag, tag: t.text}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q in tag expression where a tag was expected", t.text)
+}
+
+// Match reports whether a document with tags is selected. The tags are
+// expected to be normalized.
+func (e *Expr) Match(tags []string) bool {
+	switch e.op {
+	case opTag:
+		return slices.Contains(tags, e.tag)
+	case opAnd:
+		for _, arg := range e.args {
+			if !arg.Match(tags) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, arg := range e.args {
+			if arg.Match(tags) {
+				return true
+			}
+		}
+		return false
+	case opNot:
+		return !e.args[0].Match(tags)
+	}
+	return false
+}
+
+// SQL compiles the expression to an SQL condition. has is the condition
+// that a row has a tag, with a single ? for the tag, such as
+//
+//	EXISTS (SELECT 1 FROM document_tags WHERE document_id = documents.id AND tag = ?)
+//
+// The returned arguments fill the placeholders in order.
+func (e *Expr) SQL(has string) (string, []any) {
+	switch e.op {
+	case opTag:
+		return has, []any{e.tag}
+	case opNot:
+		cond, args := e.args[0].SQL(has)
+		return "NOT " + cond, args
+	}
+
+	join := " AND "
+	if e.op == opOr {
+		join = " OR "
+	}
+
+	var conds []string
+	var args []any
+	for _, arg := range e.args {
+		cond, argArgs := arg.SQL(has)
+		conds = append(conds, cond)
+		args = append(args, argArgs...)
+	}
+	return "(" + strings.Join(conds, join) + ")", args
+}
+
+// String formats the expression in its canonical form, which Parse reads
+// back to the same expression.
+func (e *Expr) String() string {
+	switch e.op {
+	case opTag:
+		return e.tag
+	case opNot:
+		return "not " + e.args[0].operand(opNot)
+	}
+
+	join := " and "
+	if e.op == opOr {
+		join = " or "
+	}
+
+	parts := make([]string, len(e.args))
+	for i, arg := range e.args {
+		parts[i] = arg.operand(e.op)
+	}
+	return strings.Join(parts, join)
+}
+
+// operand formats the expression as an operand of parent, in parentheses
+// if it binds more loosely.
+func (e *Expr) operand(parent op) string {
+	if e.op == opTag || e.op == opNot || e.op == parent || (parent == opOr && e.op == opAnd) {
+		return e.String()
+	}
+	return "(" + e.String() + ")"
+}
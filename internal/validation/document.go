@@ -0,0 +1,25 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import "errors"
+
+// ValidateDocumentVersionsKept checks a document version retention policy.
+// Nil keeps every version; otherwise at least the current one is kept.
+func ValidateDocumentVersionsKept(kept *int) error {
+	if kept != nil && *kept < 1 {
+		return errors.New("document_versions_kept must be at least 1")
+	}
+	return nil
+}
+
+// ValidateTrashDays checks how long deleted documents are kept. Nil keeps
+// them for the default; otherwise they are kept for at least a day.
+func ValidateTrashDays(days *int) error {
+	if days != nil && *days < 1 {
+		return errors.New("trash_days must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import (
+	"blackoutbox/internal/models"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxOutageHours bounds the expected outage length blank form stock
+	// is printed for.
+	MaxOutageHours = 336
+
+	maxWardLength = 100
+)
+
+// ValidateOutageHours checks an expected outage length. Nil uses the default.
+func ValidateOutageHours(hours *int) error {
+	if hours != nil && (*hours < 1 || *hours > MaxOutageHours) {
+		return fmt.Errorf("outage_hours must be between 1 and %d", MaxOutageHours)
+	}
+	return nil
+}
+
+// ValidateFormStockRule checks that a rule names a system and template and
+// asks for a sensible number of copies.
+func ValidateFormStockRule(rule models.FormStockRule) error {
+	if rule.SystemId <= 0 {
+		return errors.New("system_id is required")
+	}
+	if rule.TemplateId <= 0 {
+		return errors.New("template_id is required")
+	}
+	if len([]rune(rule.Ward)) > maxWardLength {
+		return fmt.Errorf("ward must be at most %d characters", maxWardLength)
+	}
+	if rule.CopiesPerHour < 1 || rule.CopiesPerHour > MaxCopies {
+		return fmt.Errorf("copies_per_hour must be between 1 and %d", MaxCopies)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import (
+	"blackoutbox/internal/models"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxStampLength = 200
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ValidatePageStamp checks that stamp text fits on a line and only uses
+// known placeholders. A nil stamp uses the defaults.
+func ValidatePageStamp(stamp *models.PageStamp) error {
+	if stamp == nil {
+		return nil
+	}
+
+	parts := map[string]*string{
+		"header":    stamp.Header,
+		"footer":    stamp.Footer,
+		"watermark": stamp.Watermark,
+	}
+
+	for part, text := range parts {
+		if text == nil {
+			continue
+		}
+
+		if utf8.RuneCountInString(*text) > maxStampLength {
+			return fmt.Errorf("stamp %s must be at most %d characters", part, maxStampLength)
+		}
+
+		for _, placeholder := range placeholderPattern.FindAllString(*text, -1) {
+			if !slices.Contains(models.StampPlaceholders, placeholder) {
+				return fmt.Errorf("stamp %s has unknown placeholder %s, must be one of %s",
+					part, placeholder, strings.Join(models.StampPlaceholders, ", "))
+			}
+		}
+	}
+
+	return nil
+}
@@ -5,11 +5,23 @@
 package validation
 
 import (
+	"blackoutbox/internal/storage"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
+// ValidateSyncedPath checks that a file a sync names is under the
+// documents folder, as the file is read into the blob store from there.
+func ValidateSyncedPath(path string) error {
+	rel, err := filepath.Rel(storage.DocumentsRoot, filepath.Clean(path))
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return fmt.Errorf("file_path must be under %s/", storage.DocumentsRoot)
+	}
+	return nil
+}
+
 func ValidatePrintableFile(path string) error {
 	info, err := os.Lstat(path)
 	if err != nil {
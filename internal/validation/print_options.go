@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import (
+	"blackoutbox/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxCopies is the most copies a single print job may ask for.
+const MaxCopies = 100
+
+var (
+	validSides        = []string{"one-sided", "two-sided-long-edge", "two-sided-short-edge"}
+	validMedia        = []string{"A3", "A4", "A5", "Letter", "Legal"}
+	validOrientations = []string{"portrait", "landscape"}
+
+	pageRangesPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+)
+
+// ValidatePrintOptions checks that every set option is something the
+// printer backend understands.
+func ValidatePrintOptions(options models.PrintOptions) error {
+	if options.Copies != nil && (*options.Copies < 1 || *options.Copies > MaxCopies) {
+		return fmt.Errorf("copies must be between 1 and %d", MaxCopies)
+	}
+
+	if options.Sides != nil && !slices.Contains(validSides, *options.Sides) {
+		return fmt.Errorf("sides must be one of %s", strings.Join(validSides, ", "))
+	}
+
+	if options.Media != nil && !slices.Contains(validMedia, *options.Media) {
+		return fmt.Errorf("media must be one of %s", strings.Join(validMedia, ", "))
+	}
+
+	if options.Orientation != nil && !slices.Contains(validOrientations, *options.Orientation) {
+		return fmt.Errorf("orientation must be one of %s", strings.Join(validOrientations, ", "))
+	}
+
+	if options.PageRanges != nil {
+		if err := validatePageRanges(*options.PageRanges); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validatePageRanges(ranges string) error {
+	if !pageRangesPattern.MatchString(ranges) {
+		return fmt.Errorf("page_ranges must look like 1-3,5")
+	}
+
+	last := 0
+	for part := range strings.SplitSeq(ranges, ",") {
+		from, to, found := strings.Cut(part, "-")
+		if !found {
+			to = from
+		}
+
+		start, _ := strconv.Atoi(from)
+		end, _ := strconv.Atoi(to)
+
+		if start < 1 || end < start || start <= last {
+			return fmt.Errorf("page_ranges must be ascending and start at page 1 or later")
+		}
+		last = end
+	}
+
+	return nil
+}
+
+// ParsePrintOptions decodes and validates print options sent as a JSON
+// form value. An empty value means no options.
+func ParsePrintOptions(raw string) (*models.PrintOptions, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	var options models.PrintOptions
+	if err := decoder.Decode(&options); err != nil {
+		return nil, fmt.Errorf("print_options must be a valid JSON object")
+	}
+
+	if err := ValidatePrintOptions(options); err != nil {
+		return nil, err
+	}
+
+	return &options, nil
+}
+
+// DecodePrintOverride decodes and validates an optional request body of the
+// form {"print_options": {...}}. An empty body means no override.
+func DecodePrintOverride(body io.Reader) (*models.PrintOptions, error) {
+	var req struct {
+		PrintOptions *models.PrintOptions `json:"print_options"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid JSON payload")
+	}
+
+	if req.PrintOptions != nil {
+		if err := ValidatePrintOptions(*req.PrintOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	return req.PrintOptions, nil
+}
@@ -0,0 +1,66 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import (
+	"blackoutbox/internal/cron"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/tagexpr"
+	"fmt"
+)
+
+// ValidatePrintSchedule checks the cron expression, time zone and that the
+// target names exactly what it prints. A tag schedule's tag is a tag
+// expression.
+func ValidatePrintSchedule(schedule models.PrintSchedule) error {
+	if schedule.SystemId == 0 {
+		return fmt.Errorf("system_id is required")
+	}
+
+	if schedule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if _, _, err := cron.Load(schedule.Cron, schedule.TimeZone); err != nil {
+		return err
+	}
+
+	if err := ValidatePrintMode(schedule.Mode); err != nil {
+		return err
+	}
+
+	hasFile := schedule.FileReference != nil && *schedule.FileReference != ""
+	hasTag := schedule.Tag != nil && *schedule.Tag != ""
+
+	switch schedule.Target {
+	case models.ScheduleTargetDocument:
+		if !hasFile || hasTag {
+			return fmt.Errorf("a document schedule needs a file_id and no tag")
+		}
+	case models.ScheduleTargetTag:
+		if !hasTag || hasFile {
+			return fmt.Errorf("a tag schedule needs a tag and no file_id")
+		}
+		if _, err := tagexpr.Parse(*schedule.Tag); err != nil {
+			return fmt.Errorf("tag: %w", err)
+		}
+	case models.ScheduleTargetSystem:
+		if hasFile || hasTag {
+			return fmt.Errorf("a system schedule takes no file_id or tag")
+		}
+	default:
+		return fmt.Errorf("target must be one of document, tag, system")
+	}
+
+	return nil
+}
+
+// ValidatePrintMode checks that mode is full or delta.
+func ValidatePrintMode(mode string) error {
+	if mode != models.PrintModeFull && mode != models.PrintModeDelta {
+		return fmt.Errorf("mode must be full or delta")
+	}
+	return nil
+}
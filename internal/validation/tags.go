@@ -0,0 +1,18 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import "blackoutbox/internal/tagexpr"
+
+// ValidateTags checks that every tag, once normalized, can be selected by
+// a tag expression.
+func ValidateTags(tags []string) error {
+	for _, tag := range tagexpr.NormalizeAll(tags) {
+		if err := tagexpr.CheckTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
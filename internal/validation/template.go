@@ -0,0 +1,126 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package validation
+
+import (
+	"blackoutbox/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxTemplateFields  = 500
+	maxFieldSize       = 72.0
+	maxSubjectLength   = 200
+	maxTemplateColumns = 50
+)
+
+// ParseTemplateFields decodes and validates template fields sent as a JSON
+// form value. An empty value means the template has no fields.
+func ParseTemplateFields(raw string) ([]models.TemplateField, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	var fields []models.TemplateField
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("fields must be a valid JSON array")
+	}
+
+	if err := ValidateTemplateFields(fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// ValidateTemplateFields checks that every field has a name and a
+// position that can be drawn.
+func ValidateTemplateFields(fields []models.TemplateField) error {
+	if len(fields) > maxTemplateFields {
+		return fmt.Errorf("a template can have at most %d fields", maxTemplateFields)
+	}
+
+	for i, field := range fields {
+		if strings.TrimSpace(field.Name) == "" {
+			return fmt.Errorf("field %d: name is required", i+1)
+		}
+
+		switch {
+		case field.Page < 0:
+			return fmt.Errorf("field %s: page must be positive", field.Name)
+		case field.X < 0 || field.Y < 0:
+			return fmt.Errorf("field %s: x and y must not be negative", field.Name)
+		case field.Size < 0 || field.Size > maxFieldSize:
+			return fmt.Errorf("field %s: size must be between 0 and %g", field.Name, maxFieldSize)
+		case field.Width < 0:
+			return fmt.Errorf("field %s: width must not be negative", field.Name)
+		case field.RowHeight < 0 || field.MaxRows < 0:
+			return fmt.Errorf("field %s: row_height and max_rows must not be negative", field.Name)
+		case len(field.Columns) > maxTemplateColumns:
+			return fmt.Errorf("field %s: a table can have at most %d columns", field.Name, maxTemplateColumns)
+		}
+
+		for _, column := range field.Columns {
+			if strings.TrimSpace(column.Name) == "" {
+				return fmt.Errorf("field %s: every column needs a name", field.Name)
+			}
+			if column.X < 0 || column.Width < 0 {
+				return fmt.Errorf("field %s: column %s must not have a negative position or width", field.Name, column.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateTemplateFieldPages checks that no field is placed on a page the
+// template doesn't have.
+func ValidateTemplateFieldPages(fields []models.TemplateField, pages int) error {
+	for _, field := range fields {
+		if max(field.Page, 1) > pages {
+			return fmt.Errorf("field %s is on page %d, but the template has %d", field.Name, max(field.Page, 1), pages)
+		}
+	}
+	return nil
+}
+
+// ValidateTemplateSubject checks the subject template data is stored under.
+func ValidateTemplateSubject(subject string) error {
+	if strings.TrimSpace(subject) == "" {
+		return errors.New("subject is required")
+	}
+	if len([]rune(subject)) > maxSubjectLength {
+		return fmt.Errorf("subject must be at most %d characters", maxSubjectLength)
+	}
+	return nil
+}
+
+// ValidateTemplateAttachments checks the templates attached to a document.
+// Copies may be left out, meaning one.
+func ValidateTemplateAttachments(attachments []models.TemplateAttachment) error {
+	seen := make(map[int64]bool)
+
+	for _, attachment := range attachments {
+		if attachment.TemplateId <= 0 {
+			return errors.New("every attachment needs a template_id")
+		}
+		if seen[attachment.TemplateId] {
+			return fmt.Errorf("template %d is attached more than once", attachment.TemplateId)
+		}
+		seen[attachment.TemplateId] = true
+
+		if attachment.Copies < 0 || attachment.Copies > MaxCopies {
+			return fmt.Errorf("copies must be between 1 and %d", MaxCopies)
+		}
+	}
+
+	return nil
+}
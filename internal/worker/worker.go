@@ -7,32 +7,90 @@ package worker
 import (
 	"blackoutbox/internal/cups"
 	"blackoutbox/internal/monitor"
+	"blackoutbox/internal/search"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"errors"
 	"log"
+	"os"
 	"time"
 )
 
 const (
 	checkInterval     = 30 * time.Second
 	stuckJobThreshold = 5 * time.Minute
+
+	// Unused blobs are collected hourly, once they have been unused for
+	// at least as long.
+	blobCollectInterval = time.Hour
+	blobGracePeriod     = time.Hour
+
+	// Stored files are checked for damage daily
+	scrubInterval = 24 * time.Hour
+
+	// Deleted documents whose time in the trash is up are purged hourly,
+	// before unused blobs are collected.
+	trashPurgeInterval = time.Hour
 )
 
 type Worker struct {
-	monitor *monitor.Monitor
-	printer *cups.Printer
-	stopCh  chan struct{}
+	monitor        *monitor.Monitor
+	printer        *cups.Printer
+	documents      stores.DocumentStoreInterface
+	trashPurged    time.Time
+	blobs          storage.BlobIndex
+	blobsCollected time.Time
+	scrubs         stores.ScrubStoreInterface
+	scrubbed       time.Time
+	index          *search.Index
+	stopCh         chan struct{}
 }
 
-func NewWorker(monitor *monitor.Monitor, printer *cups.Printer) *Worker {
+func NewWorker(monitor *monitor.Monitor, printer *cups.Printer, documents stores.DocumentStoreInterface, blobs storage.BlobIndex, scrubs stores.ScrubStoreInterface, index *search.Index) *Worker {
 	return &Worker{
-		monitor: monitor,
-		printer: printer,
-		stopCh:  make(chan struct{}),
+		monitor:   monitor,
+		printer:   printer,
+		documents: documents,
+		blobs:     blobs,
+		scrubs:    scrubs,
+		index:     index,
+		stopCh:    make(chan struct{}),
 	}
 }
 
 func (w *Worker) Start() {
 	log.Println("Starting background worker")
 
+	// Encrypt files stored in plain text or under a retired key.
+	if encrypted, err := storage.EncryptFiles(); err != nil {
+		log.Printf("Error encrypting stored files: %v", err)
+	} else if encrypted > 0 {
+		log.Printf("Encrypted %d stored files", encrypted)
+	}
+
+	// Import files stored before the blob store into it.
+	if imported, err := storage.ImportFiles(w.blobs); err != nil {
+		log.Printf("Error importing files into the blob store: %v", err)
+	} else if imported > 0 {
+		log.Printf("Imported %d files into the blob store", imported)
+	}
+	w.blobsCollected = time.Now()
+
+	// The search index lives in memory, so it starts out empty.
+	w.refreshIndex()
+
+	// Carry on the scrub schedule from before a restart.
+	if latest, err := w.scrubs.GetLatest(); err != nil {
+		log.Printf("Error getting the latest scrub: %v", err)
+	} else if latest != nil {
+		w.scrubbed = time.Unix(latest.FinishedAt, 0)
+	}
+
+	// Resume jobs that were queued but not yet submitted when we stopped.
+	if err := w.printer.DispatchQueued(); err != nil {
+		log.Printf("Error dispatching queued jobs: %v", err)
+	}
+
 	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
@@ -40,6 +98,12 @@ func (w *Worker) Start() {
 		select {
 		case <-ticker.C:
 			w.runChecks()
+		case <-w.printer.Queued():
+			if err := w.printer.DispatchQueued(); err != nil {
+				log.Printf("Error dispatching queued jobs: %v", err)
+			}
+		case <-w.index.RefreshRequested():
+			w.refreshIndex()
 		case <-w.stopCh:
 			log.Println("Stopping background worker")
 			return
@@ -58,7 +122,90 @@ func (w *Worker) runChecks() {
 		log.Printf("Error checking triggers: %v", err)
 	}
 
+	if err := w.monitor.PrintScheduledDocuments(); err != nil {
+		log.Printf("Error printing scheduled documents: %v", err)
+	}
+
+	if err := w.monitor.RunPrintSchedules(); err != nil {
+		log.Printf("Error running print schedules: %v", err)
+	}
+
+	if err := w.printer.DispatchQueued(); err != nil {
+		log.Printf("Error dispatching queued jobs: %v", err)
+	}
+
+	if err := w.printer.CheckPrinterHealth(); err != nil {
+		log.Printf("Error checking printer health: %v", err)
+	}
+
+	if err := w.printer.CheckActiveJobs(); err != nil {
+		log.Printf("Error checking active jobs: %v", err)
+	}
+
 	if err := w.printer.CheckStuckJobs(int(stuckJobThreshold.Seconds())); err != nil {
 		log.Printf("Error checking stuck jobs: %v", err)
 	}
+
+	// Catch changes made without going through the API, such as
+	// conversions done while printing.
+	w.refreshIndex()
+
+	if time.Since(w.trashPurged) >= trashPurgeInterval {
+		w.trashPurged = time.Now()
+		w.purgeTrash()
+	}
+
+	if time.Since(w.blobsCollected) >= blobCollectInterval {
+		w.blobsCollected = time.Now()
+		if removed, err := storage.CollectBlobs(w.blobs, blobGracePeriod); err != nil {
+			log.Printf("Error collecting unused blobs: %v", err)
+		} else if removed > 0 {
+			log.Printf("Removed %d unused blob files", removed)
+		}
+	}
+
+	if time.Since(w.scrubbed) >= scrubInterval {
+		w.scrubbed = time.Now()
+		w.scrub()
+	}
+}
+
+func (w *Worker) scrub() {
+	report, err := storage.Scrub(w.scrubs)
+	if errors.Is(err, storage.ErrScrubRunning) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error scrubbing stored files: %v", err)
+		return
+	}
+
+	if !report.Healthy() || report.Orphaned > 0 {
+		log.Printf("Storage scrub found %d missing, %d corrupt and %d orphaned files", report.Missing, report.Corrupt, report.Orphaned)
+	}
+}
+
+// purgeTrash removes the documents whose time in the trash is up, and the
+// files outside the blob store only they used. Their blobs are removed by
+// garbage collection once unused.
+func (w *Worker) purgeTrash() {
+	paths, err := w.documents.PurgeDeleted(time.Now().Unix())
+	if err != nil {
+		log.Printf("Error purging deleted documents: %v", err)
+		return
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove purged file %s: %v", path, err)
+		}
+	}
+}
+
+func (w *Worker) refreshIndex() {
+	if indexed, err := w.index.Refresh(); err != nil {
+		log.Printf("Error updating the search index: %v", err)
+	} else if indexed > 0 {
+		log.Printf("Indexed %d documents for search", indexed)
+	}
 }
@@ -7,16 +7,25 @@ package main
 import (
 	"blackoutbox/internal/cups"
 	"blackoutbox/internal/handlers/documents"
+	"blackoutbox/internal/handlers/formstock"
+	"blackoutbox/internal/handlers/health"
+	"blackoutbox/internal/handlers/printer"
 	"blackoutbox/internal/handlers/printjobs"
+	"blackoutbox/internal/handlers/printoptions"
+	"blackoutbox/internal/handlers/schedules"
 	"blackoutbox/internal/handlers/systems"
+	"blackoutbox/internal/handlers/tags"
 	"blackoutbox/internal/handlers/templates"
 	"blackoutbox/internal/handlers/triggers"
 	"blackoutbox/internal/middleware"
 	"blackoutbox/internal/monitor"
+	"blackoutbox/internal/search"
+	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
 	"blackoutbox/internal/worker"
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -28,32 +37,97 @@ import (
 )
 
 func main() {
+	// Stored files are encrypted with a key unlocked from the
+	// environment at startup.
+	keyring, err := storage.LoadKeyring()
+	if err != nil {
+		log.Panicf("Unable to unlock the keyring: %s", err.Error())
+	}
+	if keyring == nil {
+		log.Println("No encryption key configured, stored files will not be encrypted")
+	}
+	storage.SetKeyring(keyring)
+
 	db, err := sql.Open("sqlite3", "file:./app.db?_foreign_keys=on")
 	if err != nil {
 		log.Panic("unable to connect to db")
 	}
 
 	documentStore := stores.DocumentStore{Db: db}
-	documentHandler := documents.DocumentHandler{Store: &documentStore}
+	blobStore := stores.BlobStore{Db: db}
+	scrubStore := stores.ScrubStore{Db: db}
+
+	searchIndex, err := search.NewIndex(&documentStore)
+	if errors.Is(err, search.ErrUnavailable) {
+		log.Printf("WARNING: Full-text search is disabled and /documents/search will return 503: %v", err)
+	} else if err != nil {
+		log.Printf("Full-text search is disabled: %v", err)
+	}
 
 	templateStore := stores.TemplateStore{Db: db}
-	templateHandler := templates.TemplatesHandler{Store: &templateStore}
+	templateDataStore := stores.TemplateDataStore{Db: db}
+	templateHandler := templates.TemplatesHandler{Store: &templateStore, DataStore: &templateDataStore}
+	templateAttachmentStore := stores.TemplateAttachmentStore{Db: db}
+
+	if resealed, err := templateDataStore.Reseal(); err != nil {
+		log.Printf("Failed to encrypt template data: %v", err)
+	} else if resealed > 0 {
+		log.Printf("Encrypted template data for %d subjects", resealed)
+	}
 
 	triggerStore := stores.TriggerStore{Db: db}
 	triggerHandler := triggers.TriggerHandler{Store: &triggerStore}
 
 	printJobStore := stores.PrintJobStore{Db: db}
-	printJobHandler := printjobs.PrintJobHandler{Store: &printJobStore}
 
 	systemStore := stores.SystemStore{
 		Db: db,
 	}
 
-	systemHandler := systems.SystemHandler{SystemStore: &systemStore}
+	tagPrintOptionsStore := stores.TagPrintOptionsStore{Db: db}
+	tagPrintOptionsHandler := printoptions.TagPrintOptionsHandler{Store: &tagPrintOptionsStore}
+
+	tagStore := stores.TagStore{Db: db}
+	tagHandler := tags.TagHandler{Store: &tagStore}
+
+	// Tags migrated from before tags were normalized are normalized here.
+	normalized, problems, err := tagStore.Normalize()
+	if err != nil {
+		log.Printf("Failed to normalize tags: %v", err)
+	} else if normalized > 0 {
+		log.Printf("Normalized the tags of %d rows", normalized)
+	}
+	for _, problem := range problems {
+		log.Printf("Tag problem: %s", problem)
+	}
 
-	printer := cups.NewPrinter(&printJobStore)
-	monitorService := monitor.NewMonitor(&triggerStore, &documentStore, &templateStore, &printJobStore, printer)
-	workerService := worker.NewWorker(monitorService, printer)
+	printerStatusStore := stores.PrinterStatusStore{Db: db}
+	printerHandler := printer.PrinterHandler{Store: &printerStatusStore}
+	healthHandler := health.HealthHandler{Printer: &printerStatusStore, Scrubs: &scrubStore}
+
+	printScheduleStore := stores.PrintScheduleStore{Db: db}
+	printScheduleHandler := schedules.PrintScheduleHandler{Store: &printScheduleStore}
+
+	printedVersionStore := stores.PrintedVersionStore{Db: db}
+
+	formStockStore := stores.FormStockStore{Db: db}
+
+	printerService := cups.NewPrinter(&printJobStore, &printerStatusStore, &printedVersionStore)
+	monitorService := monitor.NewMonitor(&systemStore, &triggerStore, &documentStore, &templateStore, &templateDataStore, &templateAttachmentStore, &formStockStore, &printJobStore, &tagPrintOptionsStore, &printScheduleStore, &printedVersionStore, printerService)
+
+	documentHandler := documents.DocumentHandler{Store: &documentStore, Templates: &templateStore, Attachments: &templateAttachmentStore, Printer: monitorService}
+	printJobHandler := printjobs.PrintJobHandler{Store: &printJobStore, Canceler: printerService, Reprinter: monitorService}
+	systemHandler := systems.SystemHandler{SystemStore: &systemStore, Emergency: monitorService}
+
+	// Only a usable index is handed to the handlers, so they see an untyped
+	// nil, and answer 503 for search, rather than a nil *search.Index.
+	if searchIndex != nil {
+		documentHandler.Index = searchIndex
+		systemHandler.Index = searchIndex
+	}
+
+	formStockHandler := formstock.FormStockHandler{Store: &formStockStore, Templates: &templateStore, Printer: monitorService}
+	workerService := worker.NewWorker(monitorService, printerService, &documentStore, &blobStore, &scrubStore, searchIndex)
 
 	go workerService.Start()
 
@@ -63,13 +137,33 @@ func main() {
 	mux := http.NewServeMux()
 
 	mux.Handle("GET /documents", baseMiddleware.Then(documentHandler.Get()))
+	mux.Handle("GET /documents/search", authMiddleware.Then(documentHandler.Search()))
+	mux.Handle("GET /documents/trash", authMiddleware.Then(documentHandler.Trash()))
 	mux.Handle("GET /documents/{id}", baseMiddleware.Then(documentHandler.GetById()))
+	mux.Handle("GET /documents/{id}/content", authMiddleware.Then(documentHandler.Content()))
 	mux.Handle("POST /documents", authMiddleware.Then(documentHandler.Post()))
-	mux.Handle("PATCH /documents", authMiddleware.Then(documentHandler.Update()))
+	mux.Handle("PATCH /documents/{id}", authMiddleware.Then(documentHandler.Update()))
+	mux.Handle("DELETE /documents/{id}", authMiddleware.Then(documentHandler.Delete()))
+	mux.Handle("POST /documents/{id}/restore", authMiddleware.Then(documentHandler.Restore()))
+	mux.Handle("POST /documents/{id}/print", authMiddleware.Then(documentHandler.Print()))
+	mux.Handle("GET /documents/{id}/versions", baseMiddleware.Then(documentHandler.GetVersions()))
+	mux.Handle("POST /documents/{id}/versions/{version}/rollback", authMiddleware.Then(documentHandler.Rollback()))
+	mux.Handle("GET /documents/{id}/templates", baseMiddleware.Then(documentHandler.GetTemplates()))
+	mux.Handle("PUT /documents/{id}/templates", authMiddleware.Then(documentHandler.PutTemplates()))
 
 	mux.Handle("GET /templates", authMiddleware.Then(templateHandler.Get()))
 	mux.Handle("POST /templates", authMiddleware.Then(templateHandler.Post()))
-	mux.Handle("DELETE /templates", authMiddleware.Then(templateHandler.Delete()))
+	mux.Handle("GET /templates/{id}", authMiddleware.Then(templateHandler.GetById()))
+	mux.Handle("PUT /templates/{id}", authMiddleware.Then(templateHandler.Put()))
+	mux.Handle("DELETE /templates/{id}", authMiddleware.Then(templateHandler.Delete()))
+	mux.Handle("GET /templates/{id}/content", authMiddleware.Then(templateHandler.Content()))
+	mux.Handle("GET /templates/{id}/versions", authMiddleware.Then(templateHandler.GetVersions()))
+	mux.Handle("POST /templates/{id}/versions/{version}/rollback", authMiddleware.Then(templateHandler.Rollback()))
+	mux.Handle("GET /templates/{id}/data", authMiddleware.Then(templateHandler.GetData()))
+	mux.Handle("PUT /templates/{id}/data", authMiddleware.Then(templateHandler.PutData()))
+	mux.Handle("PUT /templates/{id}/data/{subject}", authMiddleware.Then(templateHandler.PutSubjectData()))
+	mux.Handle("DELETE /templates/{id}/data/{subject}", authMiddleware.Then(templateHandler.DeleteData()))
+	mux.Handle("POST /templates/{id}/render", authMiddleware.Then(templateHandler.Render()))
 
 	mux.Handle("GET /triggers", baseMiddleware.Then(triggerHandler.Get()))
 	mux.Handle("GET /triggers/{id}", baseMiddleware.Then(triggerHandler.GetById()))
@@ -79,6 +173,15 @@ func main() {
 	mux.Handle("GET /print_jobs", baseMiddleware.Then(printJobHandler.Get()))
 	mux.Handle("GET /print_jobs/{id}", baseMiddleware.Then(printJobHandler.GetById()))
 	mux.Handle("GET /print_jobs/stuck", baseMiddleware.Then(printJobHandler.GetStuck()))
+	mux.Handle("POST /print_jobs/{id}/cancel", authMiddleware.Then(printJobHandler.Cancel()))
+	mux.Handle("POST /print_jobs/{id}/reprint", authMiddleware.Then(printJobHandler.Reprint()))
+
+	mux.Handle("GET /printer/status", baseMiddleware.Then(printerHandler.GetStatus()))
+	mux.Handle("GET /printer/status/history", baseMiddleware.Then(printerHandler.GetHistory()))
+
+	mux.Handle("GET /health", baseMiddleware.Then(healthHandler.Get()))
+	mux.Handle("GET /storage/scrub", baseMiddleware.Then(healthHandler.GetScrub()))
+	mux.Handle("POST /storage/scrub", authMiddleware.Then(healthHandler.PostScrub()))
 
 	// System CRUD routes
 	mux.Handle("GET /systems", baseMiddleware.Then(systemHandler.GetSystems()))
@@ -87,6 +190,28 @@ func main() {
 	mux.Handle("PUT /systems/{id}", authMiddleware.Then(systemHandler.UpdateSystem()))
 	mux.Handle("DELETE /systems/{id}", authMiddleware.Then(systemHandler.DeleteSystem()))
 	mux.Handle("POST /systems/{id}/sync", authMiddleware.Then(systemHandler.Sync()))
+	mux.Handle("POST /systems/{id}/emergency", authMiddleware.Then(systemHandler.ActivateEmergency()))
+
+	mux.Handle("GET /tags", baseMiddleware.Then(tagHandler.Get()))
+
+	mux.Handle("GET /tag_print_options", baseMiddleware.Then(tagPrintOptionsHandler.Get()))
+	mux.Handle("PUT /tag_print_options", authMiddleware.Then(tagPrintOptionsHandler.Put()))
+	mux.Handle("DELETE /tag_print_options/{id}", authMiddleware.Then(tagPrintOptionsHandler.Delete()))
+
+	mux.Handle("GET /schedules", baseMiddleware.Then(printScheduleHandler.Get()))
+	mux.Handle("GET /schedules/{id}", baseMiddleware.Then(printScheduleHandler.GetById()))
+	mux.Handle("GET /schedules/{id}/upcoming", baseMiddleware.Then(printScheduleHandler.GetUpcoming()))
+	mux.Handle("POST /schedules", authMiddleware.Then(printScheduleHandler.Post()))
+	mux.Handle("PUT /schedules/{id}", authMiddleware.Then(printScheduleHandler.Put()))
+	mux.Handle("DELETE /schedules/{id}", authMiddleware.Then(printScheduleHandler.Delete()))
+
+	mux.Handle("GET /form_stock_rules", baseMiddleware.Then(formStockHandler.Get()))
+	mux.Handle("GET /form_stock_rules/{id}", baseMiddleware.Then(formStockHandler.GetById()))
+	mux.Handle("POST /form_stock_rules", authMiddleware.Then(formStockHandler.Post()))
+	mux.Handle("PUT /form_stock_rules/{id}", authMiddleware.Then(formStockHandler.Put()))
+	mux.Handle("DELETE /form_stock_rules/{id}", authMiddleware.Then(formStockHandler.Delete()))
+	mux.Handle("POST /form_stock/print", authMiddleware.Then(formStockHandler.Print()))
+	mux.Handle("GET /form_stock/prints", authMiddleware.Then(formStockHandler.GetCounts()))
 
 	serverTimeout := 5 * time.Second
 	server := &http.Server{
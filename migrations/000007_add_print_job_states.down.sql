@@ -0,0 +1,10 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+UPDATE print_jobs SET status = 'pending' WHERE status = 'queued';
+UPDATE print_jobs SET status = 'printing' WHERE status IN ('submitted', 'processing', 'held', 'stopped');
+UPDATE print_jobs SET status = 'failed' WHERE status IN ('aborted', 'canceled');
+
+ALTER TABLE print_jobs DROP COLUMN last_checked_at;
+ALTER TABLE print_jobs DROP COLUMN state_reason;
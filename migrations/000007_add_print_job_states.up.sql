@@ -0,0 +1,12 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Track the CUPS state reason and when a job was last polled
+ALTER TABLE print_jobs ADD COLUMN state_reason TEXT NULL;
+ALTER TABLE print_jobs ADD COLUMN last_checked_at INTEGER NULL;
+
+-- Map the old statuses onto the print job state machine
+UPDATE print_jobs SET status = 'queued' WHERE status = 'pending';
+UPDATE print_jobs SET status = 'submitted' WHERE status = 'printing';
+UPDATE print_jobs SET status = 'aborted' WHERE status = 'failed';
@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+DROP TABLE IF EXISTS tag_print_options;
+
+ALTER TABLE print_jobs DROP COLUMN options;
+ALTER TABLE templates DROP COLUMN print_options;
+ALTER TABLE documents DROP COLUMN print_options;
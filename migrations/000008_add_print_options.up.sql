@@ -0,0 +1,23 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Print options are stored as JSON, see models.PrintOptions
+ALTER TABLE documents ADD COLUMN print_options TEXT NULL;
+ALTER TABLE templates ADD COLUMN print_options TEXT NULL;
+
+-- The options that were actually sent to the printer
+ALTER TABLE print_jobs ADD COLUMN options TEXT NULL;
+
+CREATE TABLE tag_print_options (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    system_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    options TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    UNIQUE(system_id, tag),
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_tag_print_options_system_id ON tag_print_options(system_id);
@@ -0,0 +1,18 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Periodic snapshots of the printer state, see models.PrinterStatus
+CREATE TABLE printer_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    printer_name TEXT NOT NULL,
+    state TEXT NOT NULL,
+    state_reasons TEXT NULL,
+    markers TEXT NULL,
+    ready INTEGER NOT NULL DEFAULT 0,
+    warnings TEXT NULL,
+    checked_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+
+-- Create index on checked_at for history lookups and pruning
+CREATE INDEX idx_printer_status_history_checked_at ON printer_status_history(checked_at);
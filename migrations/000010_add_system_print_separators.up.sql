@@ -0,0 +1,6 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Whether emergency print batches get a separator page between documents
+ALTER TABLE systems ADD COLUMN print_separators INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+DROP INDEX IF EXISTS idx_print_jobs_parent_job_id;
+
+ALTER TABLE print_jobs DROP COLUMN canceled_at;
+ALTER TABLE print_jobs DROP COLUMN parent_job_id;
+ALTER TABLE print_jobs DROP COLUMN origin;
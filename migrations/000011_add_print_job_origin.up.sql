@@ -0,0 +1,10 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Why a job was printed, and the job it reprints
+ALTER TABLE print_jobs ADD COLUMN origin TEXT NOT NULL DEFAULT 'emergency';
+ALTER TABLE print_jobs ADD COLUMN parent_job_id INTEGER NULL REFERENCES print_jobs(id) ON DELETE SET NULL;
+ALTER TABLE print_jobs ADD COLUMN canceled_at INTEGER NULL;
+
+CREATE INDEX idx_print_jobs_parent_job_id ON print_jobs(parent_job_id);
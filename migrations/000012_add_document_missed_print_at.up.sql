@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- The print_at a scheduled print was skipped for, because it was missed
+-- by more than the catch-up window
+ALTER TABLE documents ADD COLUMN missed_print_at INTEGER NULL;
+
+CREATE INDEX idx_documents_print_at ON documents(print_at);
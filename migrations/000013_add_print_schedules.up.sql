@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Recurring print schedules. Documents are targeted by file_id rather than
+-- id, so schedules survive a sync replacing the document.
+CREATE TABLE print_schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    system_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    cron TEXT NOT NULL,
+    time_zone TEXT NOT NULL,
+    target TEXT NOT NULL CHECK (target IN ('document', 'tag', 'system')),
+    file_id TEXT NULL,
+    tag TEXT NULL,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    next_run_at INTEGER NULL,
+    last_run_at INTEGER NULL,
+    failed_runs INTEGER NOT NULL DEFAULT 0,
+    retry_at INTEGER NULL,
+    missed_run_at INTEGER NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_print_schedules_system_id ON print_schedules(system_id);
+CREATE INDEX idx_print_schedules_next_run_at ON print_schedules(next_run_at);
@@ -0,0 +1,11 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+DROP TABLE IF EXISTS printed_versions;
+
+ALTER TABLE print_schedules DROP COLUMN mode;
+
+ALTER TABLE print_jobs DROP COLUMN document_updated_at;
+ALTER TABLE print_jobs DROP COLUMN content_hash;
+ALTER TABLE print_jobs DROP COLUMN binder;
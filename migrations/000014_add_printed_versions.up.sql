@@ -0,0 +1,25 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- What each job printed, so completed jobs can update their binder
+ALTER TABLE print_jobs ADD COLUMN binder TEXT NULL;
+ALTER TABLE print_jobs ADD COLUMN content_hash TEXT NULL;
+ALTER TABLE print_jobs ADD COLUMN document_updated_at INTEGER NULL;
+
+ALTER TABLE print_schedules ADD COLUMN mode TEXT NOT NULL DEFAULT 'full' CHECK (mode IN ('full', 'delta'));
+
+-- The version of each document last printed successfully into a binder.
+-- Documents are referenced by file_id, which survives a sync.
+CREATE TABLE printed_versions (
+    binder TEXT NOT NULL,
+    system_id INTEGER NOT NULL,
+    file_id TEXT NOT NULL,
+    tags TEXT NULL,
+    content_hash TEXT NOT NULL,
+    document_updated_at INTEGER NULL,
+    print_job_id INTEGER NOT NULL,
+    printed_at INTEGER NOT NULL,
+    PRIMARY KEY (binder, file_id),
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE CASCADE
+);
@@ -0,0 +1,43 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Jobs without a document can't be represented before the outbox
+CREATE TABLE print_jobs_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    document_id INTEGER NOT NULL,
+    cups_job_id TEXT,
+    status TEXT NOT NULL DEFAULT 'pending',
+    submitted_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    completed_at INTEGER NULL,
+    error_message TEXT,
+    state_reason TEXT NULL,
+    last_checked_at INTEGER NULL,
+    options TEXT NULL,
+    origin TEXT NOT NULL DEFAULT 'emergency',
+    parent_job_id INTEGER NULL REFERENCES print_jobs(id) ON DELETE SET NULL,
+    canceled_at INTEGER NULL,
+    binder TEXT NULL,
+    content_hash TEXT NULL,
+    document_updated_at INTEGER NULL,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+INSERT INTO print_jobs_old (
+    id, document_id, cups_job_id, status, submitted_at, completed_at, error_message, state_reason,
+    last_checked_at, options, origin, parent_job_id, canceled_at, binder, content_hash, document_updated_at
+)
+SELECT
+    id, document_id, cups_job_id, status, COALESCE(submitted_at, queued_at), completed_at, error_message, state_reason,
+    last_checked_at, options, origin, parent_job_id, canceled_at, binder, content_hash, document_updated_at
+FROM print_jobs
+WHERE document_id IS NOT NULL;
+
+DROP TABLE print_jobs;
+ALTER TABLE print_jobs_old RENAME TO print_jobs;
+
+CREATE INDEX idx_print_jobs_document_id ON print_jobs(document_id);
+CREATE INDEX idx_print_jobs_status ON print_jobs(status);
+CREATE INDEX idx_print_jobs_parent_job_id ON print_jobs(parent_job_id);
+
+DROP TABLE IF EXISTS print_batches;
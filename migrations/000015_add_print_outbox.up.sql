@@ -0,0 +1,65 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- A batch groups the jobs written together, such as everything printed for
+-- one emergency. A batch key makes writing the same batch twice a no-op.
+CREATE TABLE print_batches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key TEXT NULL UNIQUE,
+    system_id INTEGER NULL,
+    reason TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE SET NULL
+);
+
+-- Print jobs become an outbox: jobs are written as queued and submitted to
+-- CUPS by the dispatcher. Generated sheets have no document, so document_id
+-- becomes optional and every job records the file it prints. SQLite can't
+-- relax a NOT NULL constraint in place, so the table is rebuilt.
+CREATE TABLE print_jobs_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    batch_id INTEGER NULL,
+    document_id INTEGER NULL,
+    file_path TEXT NOT NULL DEFAULT '',
+    cups_job_id TEXT,
+    status TEXT NOT NULL DEFAULT 'queued',
+    state_reason TEXT NULL,
+    options TEXT NULL,
+    origin TEXT NOT NULL DEFAULT 'emergency',
+    parent_job_id INTEGER NULL REFERENCES print_jobs(id) ON DELETE SET NULL,
+    binder TEXT NULL,
+    content_hash TEXT NULL,
+    document_updated_at INTEGER NULL,
+    dispatch_attempts INTEGER NOT NULL DEFAULT 0,
+    dispatch_started_at INTEGER NULL,
+    next_dispatch_at INTEGER NULL,
+    queued_at INTEGER NOT NULL,
+    submitted_at INTEGER NULL,
+    completed_at INTEGER NULL,
+    canceled_at INTEGER NULL,
+    last_checked_at INTEGER NULL,
+    error_message TEXT,
+    FOREIGN KEY (batch_id) REFERENCES print_batches(id) ON DELETE SET NULL,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE SET NULL
+);
+
+INSERT INTO print_jobs_new (
+    id, document_id, file_path, cups_job_id, status, state_reason, options, origin, parent_job_id,
+    binder, content_hash, document_updated_at, queued_at, submitted_at, completed_at, canceled_at,
+    last_checked_at, error_message
+)
+SELECT
+    j.id, j.document_id, COALESCE(d.file_path, ''), j.cups_job_id, j.status, j.state_reason, j.options, j.origin, j.parent_job_id,
+    j.binder, j.content_hash, j.document_updated_at, j.submitted_at, j.submitted_at, j.completed_at, j.canceled_at,
+    j.last_checked_at, j.error_message
+FROM print_jobs j
+LEFT JOIN documents d ON d.id = j.document_id;
+
+DROP TABLE print_jobs;
+ALTER TABLE print_jobs_new RENAME TO print_jobs;
+
+CREATE INDEX idx_print_jobs_document_id ON print_jobs(document_id);
+CREATE INDEX idx_print_jobs_status ON print_jobs(status);
+CREATE INDEX idx_print_jobs_parent_job_id ON print_jobs(parent_job_id);
+CREATE INDEX idx_print_jobs_batch_id ON print_jobs(batch_id);
@@ -0,0 +1,10 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Per system header, footer and watermark stamped on printed pages, as JSON.
+-- NULL uses the defaults.
+ALTER TABLE systems ADD COLUMN stamp TEXT NULL;
+
+-- The stamp a job is printed with, resolved when the job is queued
+ALTER TABLE print_jobs ADD COLUMN stamp TEXT NULL;
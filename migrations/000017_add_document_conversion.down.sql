@@ -0,0 +1,7 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+ALTER TABLE documents DROP COLUMN conversion_error;
+ALTER TABLE documents DROP COLUMN converted_path;
+ALTER TABLE documents DROP COLUMN conversion_status;
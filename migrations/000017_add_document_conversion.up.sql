@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Uploads that printers handle badly are converted to a PDF stored next
+-- to the original. Existing documents are converted when next printed.
+ALTER TABLE documents ADD COLUMN conversion_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE documents ADD COLUMN converted_path TEXT NULL;
+ALTER TABLE documents ADD COLUMN conversion_error TEXT NULL;
@@ -0,0 +1,20 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- JSON array of the fields filled in on the template
+ALTER TABLE templates ADD COLUMN fields TEXT NULL;
+
+-- The data each filled-in copy of a template is printed with, one row per
+-- subject such as a resident. file_id limits the data to printing with
+-- that document, and is empty for data printed with any document.
+CREATE TABLE template_data (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    template_id INTEGER NOT NULL,
+    file_id TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL,
+    data TEXT NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE(template_id, file_id, subject),
+    FOREIGN KEY (template_id) REFERENCES templates(id) ON DELETE CASCADE
+);
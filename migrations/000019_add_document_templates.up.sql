@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Templates attached to a document, printed after it in position order.
+-- Attachments refer to the document by file_id, since sync replaces the
+-- document rows of a system.
+CREATE TABLE document_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    system_id INTEGER NOT NULL,
+    file_id TEXT NOT NULL,
+    template_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    copies INTEGER NOT NULL DEFAULT 1 CHECK (copies >= 1),
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    UNIQUE(system_id, file_id, template_id),
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE CASCADE,
+    FOREIGN KEY (template_id) REFERENCES templates(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_document_templates_document ON document_templates(system_id, file_id, position);
+
+-- Templates used to be printed after the document sharing their file_id
+INSERT INTO document_templates (system_id, file_id, template_id, position)
+SELECT DISTINCT d.system_id, d.file_id, t.id, 0
+FROM templates t
+JOIN documents d ON d.system_id = t.system_id AND d.file_id = t.file_id
+WHERE t.deleted_at IS NULL;
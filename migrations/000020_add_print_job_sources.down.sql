@@ -0,0 +1,51 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- SQLite can't drop a column with a foreign key, so the table is rebuilt
+CREATE TABLE print_jobs_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    batch_id INTEGER NULL,
+    document_id INTEGER NULL,
+    file_path TEXT NOT NULL DEFAULT '',
+    cups_job_id TEXT,
+    status TEXT NOT NULL DEFAULT 'queued',
+    state_reason TEXT NULL,
+    options TEXT NULL,
+    origin TEXT NOT NULL DEFAULT 'emergency',
+    parent_job_id INTEGER NULL REFERENCES print_jobs(id) ON DELETE SET NULL,
+    binder TEXT NULL,
+    content_hash TEXT NULL,
+    document_updated_at INTEGER NULL,
+    dispatch_attempts INTEGER NOT NULL DEFAULT 0,
+    dispatch_started_at INTEGER NULL,
+    next_dispatch_at INTEGER NULL,
+    queued_at INTEGER NOT NULL,
+    submitted_at INTEGER NULL,
+    completed_at INTEGER NULL,
+    canceled_at INTEGER NULL,
+    last_checked_at INTEGER NULL,
+    error_message TEXT,
+    stamp TEXT NULL,
+    FOREIGN KEY (batch_id) REFERENCES print_batches(id) ON DELETE SET NULL,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE SET NULL
+);
+
+INSERT INTO print_jobs_old (
+    id, batch_id, document_id, file_path, cups_job_id, status, state_reason, options, origin, parent_job_id,
+    binder, content_hash, document_updated_at, dispatch_attempts, dispatch_started_at, next_dispatch_at, queued_at, submitted_at,
+    completed_at, canceled_at, last_checked_at, error_message, stamp
+)
+SELECT
+    id, batch_id, document_id, file_path, cups_job_id, status, state_reason, options, origin, parent_job_id,
+    binder, content_hash, document_updated_at, dispatch_attempts, dispatch_started_at, next_dispatch_at, queued_at, submitted_at,
+    completed_at, canceled_at, last_checked_at, error_message, stamp
+FROM print_jobs;
+
+DROP TABLE print_jobs;
+ALTER TABLE print_jobs_old RENAME TO print_jobs;
+
+CREATE INDEX idx_print_jobs_document_id ON print_jobs(document_id);
+CREATE INDEX idx_print_jobs_status ON print_jobs(status);
+CREATE INDEX idx_print_jobs_parent_job_id ON print_jobs(parent_job_id);
+CREATE INDEX idx_print_jobs_batch_id ON print_jobs(batch_id);
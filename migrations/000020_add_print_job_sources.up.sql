@@ -0,0 +1,37 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- What a job prints: a document, a template, or a generated sheet
+ALTER TABLE print_jobs ADD COLUMN source TEXT NOT NULL DEFAULT 'document'
+    CHECK (source IN ('document', 'template', 'cover_sheet', 'separator', 'report'));
+
+-- Template jobs point at their template rather than at a document
+ALTER TABLE print_jobs ADD COLUMN template_id INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_print_jobs_template_id ON print_jobs(template_id);
+
+-- Jobs without a document printed a template or a generated sheet. Blank
+-- templates are matched by path, filled-in ones by their generated name.
+UPDATE print_jobs
+SET source = 'template',
+    template_id = (SELECT t.id FROM templates t WHERE t.template_path = print_jobs.file_path)
+WHERE document_id IS NULL
+  AND EXISTS (SELECT 1 FROM templates t WHERE t.template_path = print_jobs.file_path);
+
+UPDATE print_jobs
+SET source = 'template',
+    template_id = (
+        SELECT d.template_id FROM template_data d
+        WHERE print_jobs.file_path LIKE '%\_form\_' || d.id || '\_%' ESCAPE '\'
+    )
+WHERE document_id IS NULL AND file_path LIKE '%\_form\_%' ESCAPE '\';
+
+UPDATE print_jobs SET source = 'cover_sheet'
+WHERE document_id IS NULL AND file_path LIKE '%\_cover.pdf' ESCAPE '\';
+
+UPDATE print_jobs SET source = 'separator'
+WHERE document_id IS NULL AND file_path LIKE '%\_separator.pdf' ESCAPE '\';
+
+UPDATE print_jobs SET source = 'report'
+WHERE document_id IS NULL AND file_path LIKE '%\_changes.pdf' ESCAPE '\';
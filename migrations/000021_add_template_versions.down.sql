@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+ALTER TABLE print_jobs DROP COLUMN template_version;
+
+DROP TABLE IF EXISTS template_versions;
+
+ALTER TABLE templates DROP COLUMN version;
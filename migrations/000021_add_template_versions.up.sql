@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- The current version of each template
+ALTER TABLE templates ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Every version a template has had. Versions are never changed; rolling
+-- back copies an old version into a new one.
+CREATE TABLE template_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    template_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    template_path TEXT NOT NULL,
+    description TEXT,
+    fields TEXT NULL,
+    print_options TEXT NULL,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    UNIQUE(template_id, version),
+    FOREIGN KEY (template_id) REFERENCES templates(id) ON DELETE CASCADE
+);
+
+INSERT INTO template_versions (template_id, version, template_path, description, fields, print_options, created_at)
+SELECT id, 1, template_path, description, fields, print_options, updated_at
+FROM templates;
+
+-- The template version a job printed
+ALTER TABLE print_jobs ADD COLUMN template_version INTEGER NULL;
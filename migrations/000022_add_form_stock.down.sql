@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+ALTER TABLE print_jobs DROP COLUMN stock_ward;
+
+DROP TABLE IF EXISTS form_stock_rules;
+
+ALTER TABLE systems DROP COLUMN outage_hours;
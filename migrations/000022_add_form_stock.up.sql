@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- How many hours an outage of the system is expected to last. NULL uses
+-- the default.
+ALTER TABLE systems ADD COLUMN outage_hours INTEGER NULL;
+
+-- Blank copies of a template to print per expected outage hour, for the
+-- whole system or one ward ('' for the whole system)
+CREATE TABLE form_stock_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    system_id INTEGER NOT NULL,
+    ward TEXT NOT NULL DEFAULT '',
+    template_id INTEGER NOT NULL,
+    copies_per_hour INTEGER NOT NULL CHECK (copies_per_hour >= 1),
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    updated_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    UNIQUE(system_id, ward, template_id),
+    FOREIGN KEY (system_id) REFERENCES systems(id) ON DELETE CASCADE,
+    FOREIGN KEY (template_id) REFERENCES templates(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_form_stock_rules_system_id ON form_stock_rules(system_id);
+
+-- The ward a job printed blank stock for, so stock can be reconciled per
+-- incident. NULL for jobs that aren't stock.
+ALTER TABLE print_jobs ADD COLUMN stock_ward TEXT NULL;
@@ -0,0 +1,7 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- The SHA-256 of a document's PDF conversion, its ETag for downloads.
+-- Conversions made before it was recorded are hashed when they are served.
+ALTER TABLE documents ADD COLUMN converted_hash TEXT NULL;
@@ -0,0 +1,7 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Bumped on every update, so clients can send it back in If-Match and
+-- updates made against a stale copy are rejected.
+ALTER TABLE documents ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
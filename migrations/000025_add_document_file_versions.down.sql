@@ -0,0 +1,11 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+ALTER TABLE systems DROP COLUMN document_versions_kept;
+
+DROP TABLE IF EXISTS document_versions;
+
+ALTER TABLE documents DROP COLUMN uploaded_by;
+ALTER TABLE documents DROP COLUMN file_hash;
+ALTER TABLE documents DROP COLUMN file_size;
@@ -0,0 +1,32 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- The current file of each document. Size and hash are unknown for files
+-- recorded before they were tracked, and for synced documents.
+ALTER TABLE documents ADD COLUMN file_size INTEGER NULL;
+ALTER TABLE documents ADD COLUMN file_hash TEXT NULL;
+ALTER TABLE documents ADD COLUMN uploaded_by TEXT NULL;
+
+-- Every file a document has had, by the document version that set it.
+-- Versions are never changed; rolling back copies an old file into a new
+-- version.
+CREATE TABLE document_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    document_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    file_size INTEGER NULL,
+    file_hash TEXT NULL,
+    uploaded_by TEXT NULL,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now')),
+    UNIQUE(document_id, version),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+INSERT INTO document_versions (document_id, version, file_path, created_at)
+SELECT id, version, file_path, COALESCE(updated_at, strftime('%s', 'now'))
+FROM documents;
+
+-- How many file versions to keep per document. NULL keeps all of them.
+ALTER TABLE systems ADD COLUMN document_versions_kept INTEGER NULL;
@@ -0,0 +1,17 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+DROP TRIGGER IF EXISTS blobs_print_job_delete;
+DROP TRIGGER IF EXISTS blobs_print_job_update;
+DROP TRIGGER IF EXISTS blobs_print_job_insert;
+DROP TRIGGER IF EXISTS blobs_document_version_delete;
+DROP TRIGGER IF EXISTS blobs_document_version_update;
+DROP TRIGGER IF EXISTS blobs_document_version_insert;
+DROP TRIGGER IF EXISTS blobs_document_delete;
+DROP TRIGGER IF EXISTS blobs_document_update;
+DROP TRIGGER IF EXISTS blobs_document_insert;
+
+DROP TABLE IF EXISTS blobs;
+
+ALTER TABLE documents DROP COLUMN synced_path;
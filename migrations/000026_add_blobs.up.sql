@@ -0,0 +1,73 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Uploaded files, stored once per content under blobs/ and named by their
+-- SHA-256. ref_count counts the documents, document versions and print
+-- jobs using a blob, and is kept up to date by the triggers below; blobs
+-- that are no longer used are garbage collected.
+CREATE TABLE blobs (
+    path TEXT PRIMARY KEY,
+    hash TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+
+CREATE INDEX idx_blobs_ref_count ON blobs(ref_count);
+
+-- The path a sync last named a document's file by. Synced files are
+-- imported into the blob store, so later syncs compare against this
+-- rather than file_path. Files stored so far are imported on startup.
+ALTER TABLE documents ADD COLUMN synced_path TEXT;
+
+UPDATE documents SET synced_path = file_path;
+
+CREATE TRIGGER blobs_document_insert AFTER INSERT ON documents
+BEGIN
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE path = NEW.file_path;
+END;
+
+CREATE TRIGGER blobs_document_update AFTER UPDATE OF file_path ON documents
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE path = OLD.file_path;
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE path = NEW.file_path;
+END;
+
+CREATE TRIGGER blobs_document_delete AFTER DELETE ON documents
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE path = OLD.file_path;
+END;
+
+CREATE TRIGGER blobs_document_version_insert AFTER INSERT ON document_versions
+BEGIN
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE path = NEW.file_path;
+END;
+
+CREATE TRIGGER blobs_document_version_update AFTER UPDATE OF file_path ON document_versions
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE path = OLD.file_path;
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE path = NEW.file_path;
+END;
+
+CREATE TRIGGER blobs_document_version_delete AFTER DELETE ON document_versions
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE path = OLD.file_path;
+END;
+
+-- Print jobs keep a blob, or its PDF conversion, so they can be reprinted
+CREATE TRIGGER blobs_print_job_insert AFTER INSERT ON print_jobs
+BEGIN
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE NEW.file_path IN (path, path || '.pdf');
+END;
+
+CREATE TRIGGER blobs_print_job_update AFTER UPDATE OF file_path ON print_jobs
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE OLD.file_path IN (path, path || '.pdf');
+    UPDATE blobs SET ref_count = ref_count + 1 WHERE NEW.file_path IN (path, path || '.pdf');
+END;
+
+CREATE TRIGGER blobs_print_job_delete AFTER DELETE ON print_jobs
+BEGIN
+    UPDATE blobs SET ref_count = ref_count - 1 WHERE OLD.file_path IN (path, path || '.pdf');
+END;
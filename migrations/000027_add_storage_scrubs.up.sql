@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Integrity checks of the stored files. Each run re-hashes the files with
+-- a recorded checksum, checks that PDFs parse and looks for files nothing
+-- refers to.
+CREATE TABLE scrub_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at INTEGER NOT NULL,
+    finished_at INTEGER NOT NULL,
+    files_checked INTEGER NOT NULL DEFAULT 0,
+    missing INTEGER NOT NULL DEFAULT 0,
+    corrupt INTEGER NOT NULL DEFAULT 0,
+    orphaned INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE scrub_problems (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    problem TEXT NOT NULL CHECK (problem IN ('missing', 'corrupt', 'orphaned')),
+    detail TEXT NULL,
+    FOREIGN KEY (run_id) REFERENCES scrub_runs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_scrub_problems_run_id ON scrub_problems(run_id);
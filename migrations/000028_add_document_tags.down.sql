@@ -0,0 +1,14 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+ALTER TABLE documents ADD COLUMN tags TEXT;
+
+UPDATE documents SET tags = (
+    SELECT json_group_array(tag)
+    FROM (SELECT tag FROM document_tags WHERE document_id = documents.id ORDER BY position)
+)
+WHERE id IN (SELECT document_id FROM document_tags);
+
+DROP INDEX IF EXISTS idx_document_tags_tag;
+DROP TABLE IF EXISTS document_tags;
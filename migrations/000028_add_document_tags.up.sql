@@ -0,0 +1,28 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- Document tags, one row per tag so documents can be selected by them.
+-- position keeps the order the tags were given in, which tag print
+-- options are merged in. Tags are stored normalized: trimmed, lower case
+-- and with spaces replaced by hyphens.
+--
+-- Existing tags are copied as they are. SQLite only lower-cases ASCII, so
+-- the server normalizes them, and the tags of tag print options and print
+-- schedules, when it starts (see TagStore.Normalize).
+CREATE TABLE document_tags (
+    document_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (document_id, tag),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_document_tags_tag ON document_tags(tag);
+
+INSERT OR IGNORE INTO document_tags (document_id, tag, position)
+SELECT d.id, t.value, coalesce(t.key, 0)
+FROM documents d, json_each(CASE WHEN json_valid(d.tags) THEN d.tags ELSE '[]' END) t
+WHERE t.type = 'text';
+
+ALTER TABLE documents DROP COLUMN tags;
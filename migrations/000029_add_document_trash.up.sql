@@ -0,0 +1,9 @@
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+-- How many days deleted documents are kept in the trash before they and
+-- their files are purged. NULL keeps them for the default of 30 days.
+ALTER TABLE systems ADD COLUMN trash_days INTEGER NULL;
+
+CREATE INDEX idx_documents_deleted_at ON documents(deleted_at);
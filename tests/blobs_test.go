@@ -0,0 +1,185 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobStore(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	t.Chdir(t.TempDir())
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1'), (2, 'care-2', 'Care 2')`); err != nil {
+		t.Fatalf("Failed to add systems: %v", err)
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	blobStore := stores.BlobStore{Db: db}
+
+	var paths []string
+	for systemId := int64(1); systemId <= 2; systemId++ {
+		blob, err := storage.PutBlob(strings.NewReader("Evacuate via the east stairs"), "evacuation.TXT")
+		if err != nil {
+			t.Fatalf("Failed to store blob: %v", err)
+		}
+		paths = append(paths, blob.Path)
+
+		document := models.Document{SystemId: systemId, FileReference: "evacuation", FilePath: blob.Path, FileSize: &blob.Size, FileHash: &blob.Hash}
+		if err := documentStore.Add(document); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	if paths[0] != paths[1] || filepath.Ext(paths[0]) != ".txt" {
+		t.Fatalf("Expected identical uploads to share a blob, got %v", paths)
+	}
+
+	// Each document and its first version use the blob
+	var refCount int
+	if err := db.QueryRow(`SELECT ref_count FROM blobs WHERE path = ?`, paths[0]).Scan(&refCount); err != nil || refCount != 4 {
+		t.Fatalf("Expected 4 references, got %d (%v)", refCount, err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM documents WHERE system_id = 1`); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if removed, err := storage.CollectBlobs(&blobStore, 0); err != nil || removed != 0 {
+		t.Fatalf("Expected a used blob to be kept, removed %d (%v)", removed, err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM documents`); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, err := storage.CollectBlobs(&blobStore, 0); err != nil {
+		t.Fatalf("Failed to collect blobs: %v", err)
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the unused blob to be removed, got %v", err)
+	}
+	if exists, err := blobStore.Exists(paths[0]); err != nil || exists {
+		t.Errorf("Expected the unused blob to leave the index, got %v (%v)", exists, err)
+	}
+
+	// Files stored before the blob store are imported into it
+	legacy := filepath.Join(t.TempDir(), "1738581234_protocol.txt")
+	if err := os.WriteFile(legacy, []byte("Close all fire doors"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := documentStore.Add(models.Document{SystemId: 1, FileReference: "protocol", FilePath: legacy}); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	if imported, err := storage.ImportFiles(&blobStore); err != nil || imported != 1 {
+		t.Fatalf("Expected 1 file to be imported, got %d (%v)", imported, err)
+	}
+
+	documents, err := documentStore.GetBySystemId(1)
+	if err != nil || len(documents) != 1 {
+		t.Fatalf("Failed to get documents: %v", err)
+	}
+	if !storage.IsBlob(documents[0].FilePath) || documents[0].FileHash == nil {
+		t.Errorf("Expected the document to point at a blob, got %+v", documents[0])
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("Expected the legacy file to be left in place, got %v", err)
+	}
+	if err := db.QueryRow(`SELECT ref_count FROM blobs WHERE path = ?`, documents[0].FilePath).Scan(&refCount); err != nil || refCount != 2 {
+		t.Errorf("Expected the document and its version to use the imported blob, got %d (%v)", refCount, err)
+	}
+}
+
+func TestSyncImportsFiles(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	defer storage.SetKeyring(nil)
+	t.Chdir(t.TempDir())
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	ring, err := storage.NewKeyring(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("Failed to make keyring: %v", err)
+	}
+	storage.SetKeyring(ring)
+
+	systemStore := stores.SystemStore{Db: db}
+	documentStore := stores.DocumentStore{Db: db}
+	blobStore := stores.BlobStore{Db: db}
+
+	const content = "Medication round at 8, 12 and 18"
+	synced := filepath.Join(storage.DocumentsRoot, "care-1", "meds.txt")
+	meds := models.Document{FileReference: "meds", FilePath: synced}
+
+	sync := func() *models.Document {
+		t.Helper()
+		if err := systemStore.Sync(1, []models.Document{meds}); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		document, err := documentStore.GetById(1)
+		if err != nil {
+			t.Fatalf("Failed to get document: %v", err)
+		}
+		return document
+	}
+
+	// A file that hasn't arrived yet is used from where it will be
+	if document := sync(); document.FilePath != synced {
+		t.Fatalf("Expected the missing file to be used from %s, got %s", synced, document.FilePath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(synced), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if err := os.WriteFile(synced, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Imported on startup, the file is left where the system put it
+	if imported, err := storage.ImportFiles(&blobStore); err != nil || imported != 1 {
+		t.Fatalf("Expected 1 file to be imported, got %d (%v)", imported, err)
+	}
+	imported, err := documentStore.GetById(1)
+	if err != nil || !storage.IsBlob(imported.FilePath) {
+		t.Fatalf("Expected the document to point at a blob, got %+v (%v)", imported, err)
+	}
+	raw, err := os.ReadFile(synced)
+	if err != nil || bytes.Contains(raw, []byte(content)) {
+		t.Fatalf("Expected the synced file to be kept encrypted, got %q (%v)", raw, err)
+	}
+
+	// Syncing the same path again keeps the blob
+	document := sync()
+	if document.FilePath != imported.FilePath || document.Version != imported.Version {
+		t.Errorf("Expected the document to keep %s at version %d, got %s at version %d", imported.FilePath, imported.Version, document.FilePath, document.Version)
+	}
+
+	// A file arriving with a sync is imported straight away
+	replaced := filepath.Join(storage.DocumentsRoot, "care-1", "meds-2.txt")
+	if err := os.WriteFile(replaced, []byte(content+" and 22"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	meds.FilePath = replaced
+	document = sync()
+	if !storage.IsBlob(document.FilePath) || document.FilePath == imported.FilePath || document.FileHash == nil || document.Version != imported.Version+1 {
+		t.Errorf("Expected the new file to be imported as a new version, got %+v", document)
+	}
+	if data, err := storage.ReadFile(document.FilePath); err != nil || string(data) != content+" and 22" {
+		t.Errorf("Expected the blob to hold the synced file, got %q (%v)", data, err)
+	}
+	if raw, err := os.ReadFile(replaced); err != nil || bytes.Contains(raw, []byte(content)) {
+		t.Errorf("Expected the synced file to be encrypted, got %q (%v)", raw, err)
+	}
+}
@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"archive/zip"
+	"blackoutbox/internal/convert"
+	"blackoutbox/internal/handlers/documents"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertDocument(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for x := range 40 {
+		img.Set(x, 10, color.NRGBA{R: 255, A: 255})
+	}
+	var pngData bytes.Buffer
+	png.Encode(&pngData, img)
+
+	var docxData bytes.Buffer
+	archive := zip.NewWriter(&docxData)
+	part, _ := archive.Create("word/document.xml")
+	part.Write([]byte(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+		<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Evacuation</w:t></w:r></w:p>
+		<w:p><w:r><w:t xml:space="preserve">Use the </w:t></w:r><w:r><w:t>stairs</w:t></w:r></w:p>
+	</w:body></w:document>`))
+	archive.Close()
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		status   string
+		contains []string
+	}{
+		{
+			name:     "Markdown",
+			fileName: "routine.md",
+			content:  []byte("# Fire routine\n\nCall **112** and see [the map](https://example.com/map).\n\n- Close doors\n- Gather at point A\n"),
+			status:   models.ConversionConverted,
+			contains: []string{"(Fire routine)", "(Call 112 and see the map \\(https://example.com/map\\).)", "(Close doors)"},
+		},
+		{
+			name:     "Plain text",
+			fileName: "contacts.txt",
+			content:  []byte("Ward 3\text. 4021\n"),
+			status:   models.ConversionConverted,
+			contains: []string{"(Ward 3  ext. 4021)", "/Courier"},
+		},
+		{
+			name:     "HTML",
+			fileName: "page.html",
+			content:  []byte("<html><head><title>x</title></head><body><h2>Medication</h2><ul><li><p>Check &amp; sign</p></li></ul><script>alert(1)</script></body></html>"),
+			status:   models.ConversionConverted,
+			contains: []string{"(Medication)", "(Check & sign)"},
+		},
+		{
+			name:     "DOCX",
+			fileName: "plan.docx",
+			content:  docxData.Bytes(),
+			status:   models.ConversionConverted,
+			contains: []string{"(Evacuation)", "(Use the stairs)"},
+		},
+		{
+			name:     "PNG",
+			fileName: "scan.png",
+			content:  pngData.Bytes(),
+			status:   models.ConversionConverted,
+			contains: []string{"/Subtype /Image /Width 40 /Height 20", "/Im1 Do"},
+		},
+		{
+			name:     "PDF",
+			fileName: "already.pdf",
+			content:  pdf.New().Bytes(),
+			status:   models.ConversionNotNeeded,
+		},
+		{
+			name:     "Unknown binary",
+			fileName: "archive.bin",
+			content:  []byte{0x00, 0x01, 0x02, 0xff},
+			status:   models.ConversionUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.fileName)
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+
+			doc := models.Document{FilePath: path, ConversionStatus: models.ConversionPending}
+			convert.Document(&doc)
+
+			if doc.ConversionStatus != tt.status {
+				t.Fatalf("Expected status %s, got %s (error: %v)", tt.status, doc.ConversionStatus, doc.ConversionError)
+			}
+
+			if tt.status != models.ConversionConverted {
+				if doc.PrintPath() != path {
+					t.Errorf("Expected the original to be printed, got %s", doc.PrintPath())
+				}
+				return
+			}
+
+			if doc.PrintPath() != convert.Path(path) {
+				t.Errorf("Expected the conversion to be printed, got %s", doc.PrintPath())
+			}
+
+			data, err := os.ReadFile(doc.PrintPath())
+			if err != nil {
+				t.Fatalf("Failed to read converted file: %v", err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(string(data), s) {
+					t.Errorf("Expected converted PDF to contain %q", s)
+				}
+			}
+
+			// The conversion must be readable by the stamping reader.
+			if _, err := pdf.StampPDF(data, pdf.Stamp{Watermark: "EMERGENCY COPY"}); err != nil {
+				t.Errorf("Failed to stamp converted PDF: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadIsConverted(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	store := &MockDocumentStore{}
+	handler := documents.DocumentHandler{Store: store}
+
+	rr := httptest.NewRecorder()
+	handler.Post()(rr, createMultipartRequest(t, "123", "contacts", "", "", []byte("Ward 3, ext. 4021"), "contacts.txt"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	if store.LastAdded.ConversionStatus != models.ConversionConverted {
+		t.Fatalf("Expected the upload to be converted, got %s", store.LastAdded.ConversionStatus)
+	}
+	if _, err := os.Stat(store.LastAdded.PrintPath()); err != nil {
+		t.Errorf("Expected the conversion to be stored, got %v", err)
+	}
+}
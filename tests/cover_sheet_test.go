@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/sheets"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// checkXref verifies that every xref entry points at the object it names.
+func checkXref(t *testing.T, data []byte) {
+	t.Helper()
+
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if startxref == nil {
+		t.Fatal("Expected PDF to end with startxref and EOF marker")
+	}
+
+	offset, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(data[offset:], []byte("xref\n")) {
+		t.Fatalf("Expected xref table at offset %d", offset)
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[offset:], -1)
+	for i, entry := range entries {
+		objOffset, _ := strconv.Atoi(string(entry[1]))
+		expected := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(data[objOffset:], []byte(expected)) {
+			t.Errorf("Expected object %d at offset %d", i+1, objOffset)
+		}
+	}
+}
+
+func TestCoverSheetRender(t *testing.T) {
+	updatedAt := time.Date(2026, 2, 4, 10, 0, 0, 0, time.UTC).Unix()
+
+	var documents []models.Document
+	for i := range 80 {
+		documents = append(documents, models.Document{
+			Id:            int64(i + 1),
+			FileReference: fmt.Sprintf("care-plan-(room %d)", i+1),
+			Tags:          []string{"care-plan", "avdelning-ö"},
+			UpdatedAt:     &updatedAt,
+		})
+	}
+
+	cover := sheets.CoverSheet{
+		SystemName: "Elderly Care Facility Alpha",
+		IncidentAt: time.Unix(updatedAt, 0),
+		Reason:     "Health check of https://example.com failed: server error: 503 Service Unavailable",
+		Documents:  documents,
+	}
+
+	data := cover.Render().Bytes()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4")) {
+		t.Fatal("Expected PDF header")
+	}
+
+	checkXref(t, data)
+
+	if !bytes.Contains(data, []byte("/Count 3")) {
+		t.Error("Expected 80 documents to spread the table of contents over 3 pages")
+	}
+
+	if !bytes.Contains(data, []byte(`(care-plan-\(room 80\))`)) {
+		t.Error("Expected escaped file id of the last document in the table of contents")
+	}
+
+	if !bytes.Contains(data, []byte("avdelning-\xf6")) {
+		t.Error("Expected tags to be WinAnsi encoded")
+	}
+}
+
+func TestSeparatorSheetRender(t *testing.T) {
+	document := models.Document{FileReference: "ward-roster", Tags: []string{"roster"}}
+
+	data := sheets.SeparatorSheet("Facility Alpha", document, 2, 5).Bytes()
+
+	checkXref(t, data)
+
+	if !bytes.Contains(data, []byte("(Document 2 of 5)")) {
+		t.Error("Expected separator to show the document position")
+	}
+}
+
+func TestChangeSummaryRender(t *testing.T) {
+	updatedAt := time.Date(2026, 2, 4, 10, 0, 0, 0, time.UTC).Unix()
+
+	summary := sheets.ChangeSummary{
+		SystemName:  "Facility Alpha",
+		Binder:      "schedule:3",
+		GeneratedAt: time.Unix(updatedAt, 0),
+		Added:       []models.Document{{FileReference: "new-roster", UpdatedAt: &updatedAt}},
+		Replaced:    []models.Document{{FileReference: "medication-list", Tags: []string{"medication"}}},
+		Removed:     []models.PrintedVersion{{FileReference: "old-protocol"}},
+	}
+
+	if summary.IsEmpty() {
+		t.Fatal("Expected summary with changes not to be empty")
+	}
+
+	data := summary.Render().Bytes()
+
+	checkXref(t, data)
+
+	for _, expected := range []string{"(1 new, 1 replaced, 1 removed)", "(new-roster)", "(medication-list)", "(old-protocol)", "(Removed)"} {
+		if !bytes.Contains(data, []byte(expected)) {
+			t.Errorf("Expected change summary to contain %s", expected)
+		}
+	}
+
+	if !(sheets.ChangeSummary{}).IsEmpty() {
+		t.Error("Expected summary without changes to be empty")
+	}
+}
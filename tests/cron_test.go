@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/cron"
+	"testing"
+	"time"
+)
+
+func TestCronUpcomingAcrossDaylightSaving(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		after    time.Time
+		expected []string
+	}{
+		{
+			name:  "shift changes keep local time over spring forward",
+			expr:  "0 7,15,23 * * *",
+			after: time.Date(2026, 3, 28, 16, 0, 0, 0, stockholm),
+			expected: []string{
+				"2026-03-28T23:00:00+01:00",
+				"2026-03-29T07:00:00+02:00",
+				"2026-03-29T15:00:00+02:00",
+			},
+		},
+		{
+			name:  "skipped time runs when the clock jumps",
+			expr:  "30 2 * * *",
+			after: time.Date(2026, 3, 28, 12, 0, 0, 0, stockholm),
+			expected: []string{
+				"2026-03-29T03:00:00+02:00",
+				"2026-03-30T02:30:00+02:00",
+			},
+		},
+		{
+			name:  "repeated time runs once when the clock falls back",
+			expr:  "30 2 * * *",
+			after: time.Date(2026, 10, 24, 12, 0, 0, 0, stockholm),
+			expected: []string{
+				"2026-10-25T02:30:00+02:00",
+				"2026-10-26T02:30:00+01:00",
+			},
+		},
+		{
+			name:  "weekday names",
+			expr:  "0 14 * * fri",
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, stockholm),
+			expected: []string{
+				"2026-10-23T14:00:00+02:00",
+				"2026-10-30T14:00:00+01:00",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tt.expr, err)
+			}
+
+			runs, err := schedule.Upcoming(tt.after, stockholm, len(tt.expected))
+			if err != nil {
+				t.Fatalf("Failed to get upcoming runs: %v", err)
+			}
+
+			for i, run := range runs {
+				if got := run.In(stockholm).Format(time.RFC3339); got != tt.expected[i] {
+					t.Errorf("Run %d: expected %s, got %s", i, tt.expected[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestCronParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "0 0 30 2 *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := cron.Parse(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+
+	if _, _, err := cron.Load("0 7 * * *", "Europe/Nowhere"); err == nil {
+		t.Error("Expected unknown time zone to be rejected")
+	}
+}
@@ -0,0 +1,172 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/handlers/documents"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentHandlerContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1738581234_routine.txt")
+	if err := os.WriteFile(path, []byte("Close all fire doors"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The recorded hash is the ETag, so a revalidation never reads the
+	// file, which here can't be read at all.
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	converted := t.TempDir()
+	updatedAt := int64(1738581234)
+	handler := documents.DocumentHandler{Store: &MockDocumentStore{
+		GetByIdFunc: func(id int64) (*models.Document, error) {
+			switch id {
+			case 1:
+				return &models.Document{Id: 1, FilePath: path, ConversionStatus: models.ConversionPending}, nil
+			case 3:
+				return &models.Document{Id: 3, FilePath: path, ConversionStatus: models.ConversionConverted, ConvertedPath: &converted, ConvertedHash: &hash, Version: 2, UpdatedAt: &updatedAt}, nil
+			}
+			return nil, sql.ErrNoRows
+		},
+	}}
+
+	serve := func(h http.HandlerFunc, id, query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/documents/"+id+"?"+query, nil)
+		req.SetPathValue("id", id)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(id string, header http.Header) *httptest.ResponseRecorder {
+		return serve(handler.Content(), id, "", header)
+	}
+
+	rr := get("1", nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "Close all fire doors" {
+		t.Fatalf("Expected the file, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected a text content type, got %q", rr.Header().Get("Content-Type"))
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected ETag and Last-Modified headers, got %v", rr.Header())
+	}
+
+	rr = get("1", http.Header{"Range": {"bytes=0-4"}})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "Close" {
+		t.Errorf("Expected the first five bytes, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = get("1", http.Header{"If-None-Match": {etag}})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected a matching ETag to return 304, got %d", rr.Code)
+	}
+
+	if rr = get("2", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing document to return 404, got %d", rr.Code)
+	}
+
+	rr = serve(handler.Content(), "3", "format=pdf", http.Header{"If-None-Match": {`"` + hash + `"`}})
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != `"`+hash+`"` {
+		t.Errorf("Expected the recorded hash to revalidate without reading the file, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Metadata is revalidated by version and update time
+	rr = serve(handler.GetById(), "3", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` || rr.Header().Get("Last-Modified") != "Mon, 03 Feb 2025 11:13:54 GMT" {
+		t.Fatalf("Expected the document with its ETag and Last-Modified, got %d %v", rr.Code, rr.Header())
+	}
+	if rr = serve(handler.GetById(), "3", "", http.Header{"If-None-Match": {`"1", "2"`}}); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected a matching ETag to return 304, got %d", rr.Code)
+	}
+	if rr = serve(handler.GetById(), "3", "", http.Header{"If-None-Match": {`"1"`}}); rr.Code != http.StatusOK {
+		t.Errorf("Expected an earlier version to get the document, got %d", rr.Code)
+	}
+	if rr = serve(handler.GetById(), "3", "", http.Header{"If-Modified-Since": {"Mon, 03 Feb 2025 11:13:54 GMT"}}); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected an unchanged document to return 304, got %d", rr.Code)
+	}
+	if rr = serve(handler.GetById(), "3", "", http.Header{"If-Modified-Since": {"Mon, 03 Feb 2025 11:13:53 GMT"}}); rr.Code != http.StatusOK {
+		t.Errorf("Expected a changed document to be returned, got %d", rr.Code)
+	}
+}
+
+func TestSyncKeepsVersionOfUnchangedDocuments(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	t.Chdir(t.TempDir())
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	systemStore := stores.SystemStore{Db: db}
+	documentStore := stores.DocumentStore{Db: db}
+	printAt := int64(1700000000)
+	meds := models.Document{FileReference: "meds", FilePath: "documents/care-1/meds.pdf", PrintAt: &printAt, Tags: []string{"Medication"}}
+
+	sync := func(doc models.Document) *models.Document {
+		t.Helper()
+		if err := systemStore.Sync(1, []models.Document{doc}); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		document, err := documentStore.GetById(1)
+		if err != nil {
+			t.Fatalf("Failed to get document: %v", err)
+		}
+		return document
+	}
+
+	// Syncing the same list again leaves the version, and so the ETag,
+	// alone. The update time is wound back to see that it isn't touched.
+	version := sync(meds).Version
+	if _, err := db.Exec(`UPDATE documents SET updated_at = 1 WHERE id = 1`); err != nil {
+		t.Fatalf("Failed to set update time: %v", err)
+	}
+
+	lastPrintedAt := printAt + 60
+	unchanged := meds
+	unchanged.Tags = []string{"medication"}
+	unchanged.LastPrintedAt = &lastPrintedAt
+	document := sync(unchanged)
+	if document.Version != version || document.UpdatedAt == nil || *document.UpdatedAt != 1 {
+		t.Errorf("Expected an unchanged document to keep version %d, got version %d updated at %v", version, document.Version, document.UpdatedAt)
+	}
+	if document.LastPrintedAt == nil || *document.LastPrintedAt != lastPrintedAt {
+		t.Errorf("Expected the last print time to be updated, got %v", document.LastPrintedAt)
+	}
+
+	tests := []struct {
+		name   string
+		change func(doc *models.Document)
+	}{
+		{"Print time", func(doc *models.Document) { later := printAt + 3600; doc.PrintAt = &later }},
+		{"Print options", func(doc *models.Document) { copies := 2; doc.PrintOptions = &models.PrintOptions{Copies: &copies} }},
+		{"Tags", func(doc *models.Document) { doc.Tags = []string{"medication", "night"} }},
+		{"File", func(doc *models.Document) { doc.FilePath = "documents/care-1/meds-2.pdf" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := meds
+			tt.change(&changed)
+			document := sync(changed)
+			if document.Version != version+1 {
+				t.Errorf("Expected version %d, got %d", version+1, document.Version)
+			}
+			version = sync(meds).Version
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/handlers/documents"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocumentUpdate(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	original := filepath.Join(t.TempDir(), "routine.txt")
+	if err := os.WriteFile(original, []byte("Close all fire doors"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	store := stores.DocumentStore{Db: db}
+	printAt := int64(1738581234)
+	if err := store.Add(models.Document{SystemId: 1, FileReference: "routine", FilePath: original, PrintAt: &printAt, Tags: []string{"icu"}}); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	handler := documents.DocumentHandler{Store: &store}
+
+	patch := func(body *bytes.Buffer, contentType, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/documents/1", body)
+		req.SetPathValue("id", "1")
+		req.Header.Set("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler.Update().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := patch(bytes.NewBufferString(`{"tags": ["ward-3"]}`), "application/json", ""); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected an update without a version to return 428, got %d", rr.Code)
+	}
+
+	rr := patch(bytes.NewBufferString(`{"tags": ["ward-3"], "print_at": null}`), "application/json", `"1"`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the update to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected ETag \"2\", got %q", rr.Header().Get("ETag"))
+	}
+
+	var document models.Document
+	if err := json.NewDecoder(rr.Body).Decode(&document); err != nil {
+		t.Fatalf("Failed to decode document: %v", err)
+	}
+	if document.Version != 2 || document.PrintAt != nil || len(document.Tags) != 1 || document.Tags[0] != "ward-3" || document.FilePath != original {
+		t.Errorf("Expected only tags and print_at to change, got %+v", document)
+	}
+
+	if rr := patch(bytes.NewBufferString(`{"tags": []}`), "application/json", `"1"`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected a stale version to return 412, got %d", rr.Code)
+	}
+
+	if rr := patch(bytes.NewBufferString(`{"version": 2, "file_id": "other"}`), "application/json", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown field to return 400, got %d", rr.Code)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("version", "2")
+	part, err := writer.CreateFormFile("file", "routine-v2.txt")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write([]byte("Close all fire doors and windows"))
+	writer.Close()
+
+	rr = patch(body, writer.FormDataContentType(), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the file replacement to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	updated, err := store.GetById(1)
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	defer os.RemoveAll(storage.BlobsRoot)
+	if updated.Version != 3 || updated.FileReference != "routine" || !storage.IsBlob(updated.FilePath) || updated.FileHash == nil || !strings.HasPrefix(filepath.Base(updated.FilePath), *updated.FileHash) {
+		t.Errorf("Expected the file to be replaced by a blob under the same file_id, got %+v", updated)
+	}
+	if updated.ConversionStatus == models.ConversionPending {
+		t.Errorf("Expected the new file to be converted, got %q", updated.ConversionStatus)
+	}
+	if updated.Tags[0] != "ward-3" {
+		t.Errorf("Expected tags to be kept, got %v", updated.Tags)
+	}
+	if _, err := os.Stat(original); err != nil {
+		t.Errorf("Expected the previous file to be kept: %v", err)
+	}
+}
+
+func TestDocumentVersions(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name, document_versions_kept) VALUES (1, 'care-1', 'Care 1', 2)`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	dir := t.TempDir()
+	files := make([]string, 3)
+	for i, content := range []string{"first", "second", "third"} {
+		files[i] = filepath.Join(dir, content+".txt")
+		if err := os.WriteFile(files[i], []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	store := stores.DocumentStore{Db: db}
+	if err := store.Add(models.Document{SystemId: 1, FileReference: "care-plan", FilePath: files[0]}); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	update := func(change func(document *models.Document)) {
+		document, err := store.GetById(1)
+		if err != nil {
+			t.Fatalf("Failed to get document: %v", err)
+		}
+		change(document)
+		if err := store.Update(*document); err != nil {
+			t.Fatalf("Failed to update document: %v", err)
+		}
+	}
+
+	update(func(document *models.Document) { document.FilePath = files[1] })
+	update(func(document *models.Document) { document.Tags = []string{"icu"} })
+	update(func(document *models.Document) { document.FilePath = files[2] })
+
+	versions, err := store.GetVersions(1)
+	if err != nil {
+		t.Fatalf("Failed to get versions: %v", err)
+	}
+	if len(versions) != 3 || versions[0].Version != 4 || versions[1].Version != 2 || versions[2].Version != 1 {
+		t.Fatalf("Expected file versions 4, 2 and 1, got %+v", versions)
+	}
+
+	if err := store.PruneVersions(1); err != nil {
+		t.Fatalf("Failed to prune versions: %v", err)
+	}
+	if versions, err = store.GetVersions(1); err != nil || len(versions) != 2 || versions[1].Version != 2 {
+		t.Errorf("Expected versions 4 and 2 to be kept, got %+v (%v)", versions, err)
+	}
+
+	handler := documents.DocumentHandler{Store: &store}
+	req := httptest.NewRequest(http.MethodPost, "/documents/1/versions/2/rollback", nil)
+	req.SetPathValue("id", "1")
+	req.SetPathValue("version", "2")
+	req.Header.Set("If-Match", `"4"`)
+	rr := httptest.NewRecorder()
+	handler.Rollback().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the rollback to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	document, err := store.GetById(1)
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if document.FilePath != files[1] || document.Version != 5 || len(document.Tags) != 1 {
+		t.Errorf("Expected the second file to be current again at version 5, got %+v", document)
+	}
+
+	versions, err = store.GetVersions(1)
+	if err != nil {
+		t.Fatalf("Failed to get versions: %v", err)
+	}
+	if len(versions) != 2 || versions[0].Version != 5 || versions[0].FilePath != files[1] || versions[1].Version != 4 {
+		t.Errorf("Expected the rollback to be kept as version 5 next to version 4, got %+v", versions)
+	}
+	if _, err := os.Stat(files[1]); err != nil {
+		t.Errorf("Expected the current file to be kept: %v", err)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"blackoutbox/internal/handlers/documents"
 	"blackoutbox/internal/models"
 	"blackoutbox/internal/storage"
+	"blackoutbox/internal/tagexpr"
 	"bytes"
+	"database/sql"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -21,9 +23,9 @@ type MockDocumentStore struct {
 	AddFunc           func(model models.Document) error
 	GetFunc           func() ([]models.Document, error)
 	UpdateFunc        func(model models.Document) error
-	GetByIdFunc       func(id string) (*models.Document, error)
-	GetByFileIdFunc   func(id string) (*models.Document, error)
-	GetBySystemIdFunc func(id string) ([]models.Document, error)
+	GetByIdFunc       func(id int64) (*models.Document, error)
+	GetByFileIdFunc   func(id int64) (*models.Document, error)
+	GetBySystemIdFunc func(id int64) ([]models.Document, error)
 	LastAdded         models.Document
 	AddCalled         bool
 }
@@ -51,27 +53,75 @@ func (m *MockDocumentStore) Update(model models.Document) error {
 	return nil
 }
 
-func (m *MockDocumentStore) GetById(id string) (*models.Document, error) {
+func (m *MockDocumentStore) GetById(id int64) (*models.Document, error) {
 	if m.GetByIdFunc != nil {
 		return m.GetByIdFunc(id)
 	}
 	return nil, nil
 }
 
-func (m *MockDocumentStore) GetByFileId(id string) (*models.Document, error) {
+func (m *MockDocumentStore) GetByFileId(id int64) (*models.Document, error) {
 	if m.GetByFileIdFunc != nil {
 		return m.GetByFileIdFunc(id)
 	}
 	return nil, nil
 }
 
-func (m *MockDocumentStore) GetBySystemId(id string) ([]models.Document, error) {
+func (m *MockDocumentStore) GetBySystemId(id int64) ([]models.Document, error) {
 	if m.GetBySystemIdFunc != nil {
 		return m.GetBySystemIdFunc(id)
 	}
 	return []models.Document{}, nil
 }
 
+func (m *MockDocumentStore) GetByTags(systemId *int64, tags *tagexpr.Expr) ([]models.Document, error) {
+	return []models.Document{}, nil
+}
+
+func (m *MockDocumentStore) GetDueForPrinting(now int64) ([]models.Document, error) {
+	return []models.Document{}, nil
+}
+
+func (m *MockDocumentStore) MarkPrinted(id int64, printedAt int64) error {
+	return nil
+}
+
+func (m *MockDocumentStore) MarkPrintMissed(id int64, printAt int64) error {
+	return nil
+}
+
+func (m *MockDocumentStore) UpdateConversion(model models.Document) error {
+	return nil
+}
+
+func (m *MockDocumentStore) GetVersions(id int64) ([]models.DocumentVersion, error) {
+	return nil, nil
+}
+
+func (m *MockDocumentStore) GetVersion(id int64, version int64) (*models.DocumentVersion, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (m *MockDocumentStore) PruneVersions(id int64) error {
+	return nil
+}
+
+func (m *MockDocumentStore) Delete(id int64) (bool, error) {
+	return false, nil
+}
+
+func (m *MockDocumentStore) Restore(id int64) (bool, error) {
+	return false, nil
+}
+
+func (m *MockDocumentStore) GetDeleted(systemId *int64) ([]models.DeletedDocument, error) {
+	return nil, nil
+}
+
+func (m *MockDocumentStore) PurgeDeleted(now int64) ([]string, error) {
+	return nil, nil
+}
+
 func TestDocumentHandlerPost(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -86,7 +136,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 	}{
 		{
 			name:           "Valid request with all fields",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "file456",
 			tags:           `["daily", "instant"]`,
 			printAt:        "1738581234",
@@ -96,7 +146,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Valid request with minimal fields",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "file456",
 			fileContent:    []byte("test"),
 			fileName:       "test.txt",
@@ -113,7 +163,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Missing file_id",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "",
 			fileContent:    []byte("test"),
 			fileName:       "test.txt",
@@ -122,7 +172,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Invalid print_at (not a number)",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "file456",
 			printAt:        "not_a_timestamp",
 			fileContent:    []byte("test"),
@@ -132,7 +182,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Invalid tags JSON",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "file456",
 			tags:           "not_json_array",
 			fileContent:    []byte("test"),
@@ -142,7 +192,7 @@ func TestDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Missing file",
-			systemId:       "system123",
+			systemId:       "123",
 			fileId:         "file456",
 			fileContent:    nil,
 			fileName:       "",
@@ -209,15 +259,15 @@ func TestDocumentHandlerPost(t *testing.T) {
 					t.Error("Expected Store.Add to be called")
 				}
 
-				if mockStore.LastAdded.SystemId != "system123" {
-					t.Errorf("Expected SystemId 'system123', got '%s'", mockStore.LastAdded.SystemId)
+				if mockStore.LastAdded.SystemId != 123 {
+					t.Errorf("Expected SystemId 123, got %d", mockStore.LastAdded.SystemId)
 				}
 
-				if mockStore.LastAdded.FileId != "file456" {
-					t.Errorf("Expected FileId 'file456', got '%s'", mockStore.LastAdded.FileId)
+				if mockStore.LastAdded.FileReference != "file456" {
+					t.Errorf("Expected FileReference 'file456', got '%s'", mockStore.LastAdded.FileReference)
 				}
 
-				expectedPathPrefix := filepath.Join(storage.DocumentsRoot, "system123")
+				expectedPathPrefix := storage.BlobsRoot
 				if mockStore.LastAdded.FilePath[:len(expectedPathPrefix)] != expectedPathPrefix {
 					t.Errorf("Expected FilePath to start with '%s', got '%s'", expectedPathPrefix, mockStore.LastAdded.FilePath)
 				}
@@ -239,10 +289,12 @@ func TestDocumentHandlerPost(t *testing.T) {
 					}
 				}
 
-				// Clean up uploaded file
+				// Clean up uploaded file and its conversion
 				if _, err := os.Stat(mockStore.LastAdded.FilePath); err == nil {
 					os.Remove(mockStore.LastAdded.FilePath)
+					os.Remove(mockStore.LastAdded.PrintPath())
 					os.Remove(filepath.Dir(mockStore.LastAdded.FilePath))
+					os.Remove(storage.BlobsRoot)
 				}
 			}
 		})
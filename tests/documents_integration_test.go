@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"blackoutbox/internal/convert"
 	"blackoutbox/internal/handlers/documents"
 	"blackoutbox/internal/storage"
 	"blackoutbox/internal/stores"
@@ -36,11 +37,46 @@ func setupTestDB(t *testing.T) *sql.DB {
 			system_id TEXT NOT NULL,
 			file_id TEXT NOT NULL,
 			file_path TEXT NOT NULL,
+			file_size INTEGER,
+			file_hash TEXT,
+			uploaded_by TEXT,
+			conversion_status TEXT NOT NULL DEFAULT 'pending',
+			converted_path TEXT,
+			converted_hash TEXT,
+			conversion_error TEXT,
 			print_at INTEGER,
 			last_printed_at INTEGER,
-			tags TEXT,
-			updated_at DATETIME NOT NULL,
-			deleted_at DATETIME
+			missed_print_at INTEGER,
+			print_options TEXT,
+			version INTEGER NOT NULL DEFAULT 1,
+			updated_at INTEGER,
+			deleted_at INTEGER
+		);
+
+		CREATE TABLE document_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			document_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			file_path TEXT NOT NULL,
+			file_size INTEGER,
+			file_hash TEXT,
+			uploaded_by TEXT,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE document_tags (
+			document_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (document_id, tag)
+		);
+
+		CREATE TABLE blobs (
+			path TEXT PRIMARY KEY,
+			hash TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL
 		)
 	`)
 	if err != nil {
@@ -83,11 +119,12 @@ func createMultipartRequest(t *testing.T, systemId, fileId, tags, printAt string
 func cleanupUploads(filePath string) {
 	if filePath != "" {
 		os.Remove(filePath)
+		os.Remove(convert.Path(filePath))
 		dir := filepath.Dir(filePath)
-		if dir != storage.DocumentsRoot && dir != "." {
+		if dir != storage.BlobsRoot && dir != "." {
 			os.Remove(dir)
 			parentDir := filepath.Dir(dir)
-			if parentDir == storage.DocumentsRoot {
+			if parentDir == storage.BlobsRoot {
 				os.Remove(parentDir)
 			}
 		}
@@ -110,12 +147,12 @@ func TestIntegrationDocumentHandlerPost(t *testing.T) {
 	}{
 		{
 			name:           "Valid document upload with all fields",
-			request:        createMultipartRequest(t, "system123", "file456", `["daily", "instant"]`, "1738581234", []byte("test file content"), "test.txt"),
+			request:        createMultipartRequest(t, "123", "file456", `["daily", "instant"]`, "1738581234", []byte("test file content"), "test.txt"),
 			expectedStatus: http.StatusCreated,
 		},
 		{
 			name:           "Valid document upload with minimal fields",
-			request:        createMultipartRequest(t, "system456", "file789", "", "", []byte("minimal content"), "minimal.txt"),
+			request:        createMultipartRequest(t, "456", "file789", "", "", []byte("minimal content"), "minimal.txt"),
 			expectedStatus: http.StatusCreated,
 		},
 		{
@@ -126,25 +163,25 @@ func TestIntegrationDocumentHandlerPost(t *testing.T) {
 		},
 		{
 			name:           "Missing file_id",
-			request:        createMultipartRequest(t, "system123", "", "", "", []byte("test"), "test.txt"),
+			request:        createMultipartRequest(t, "123", "", "", "", []byte("test"), "test.txt"),
 			expectedStatus: http.StatusBadRequest,
 			expectError:    "file_id is required",
 		},
 		{
 			name:           "Invalid print_at timestamp",
-			request:        createMultipartRequest(t, "system123", "file456", "", "not_a_timestamp", []byte("test"), "test.txt"),
+			request:        createMultipartRequest(t, "123", "file456", "", "not_a_timestamp", []byte("test"), "test.txt"),
 			expectedStatus: http.StatusBadRequest,
 			expectError:    "print_at must be a valid Unix timestamp",
 		},
 		{
 			name:           "Invalid tags JSON",
-			request:        createMultipartRequest(t, "system123", "file456", "not_json_array", "", []byte("test"), "test.txt"),
+			request:        createMultipartRequest(t, "123", "file456", "not_json_array", "", []byte("test"), "test.txt"),
 			expectedStatus: http.StatusBadRequest,
 			expectError:    "tags must be a valid JSON array",
 		},
 		{
 			name:           "Missing file",
-			request:        createMultipartRequest(t, "system123", "file456", "", "", nil, ""),
+			request:        createMultipartRequest(t, "123", "file456", "", "", nil, ""),
 			expectedStatus: http.StatusBadRequest,
 			expectError:    "file is required",
 		},
@@ -173,7 +210,7 @@ func TestIntegrationDocumentHandlerPost(t *testing.T) {
 
 			// Verify document was stored in database for successful requests
 			if tt.expectedStatus == http.StatusCreated {
-				rows, err := db.Query("SELECT system_id, file_id, tags FROM documents WHERE system_id = ?", "system123")
+				rows, err := db.Query("SELECT system_id, file_id, (SELECT json_group_array(tag) FROM document_tags WHERE document_id = documents.id) FROM documents WHERE system_id = ?", "123")
 				if err != nil {
 					t.Fatalf("Failed to query documents: %v", err)
 				}
@@ -189,8 +226,8 @@ func TestIntegrationDocumentHandlerPost(t *testing.T) {
 					t.Fatalf("Failed to scan document: %v", err)
 				}
 
-				if systemId != "system123" {
-					t.Errorf("Expected system_id 'system123', got '%s'", systemId)
+				if systemId != "123" {
+					t.Errorf("Expected system_id '123', got '%s'", systemId)
 				}
 				if fileId != "file456" {
 					t.Errorf("Expected file_id 'file456', got '%s'", fileId)
@@ -211,7 +248,7 @@ func TestIntegrationDocumentHandlerGet(t *testing.T) {
 	documentHandler := documents.DocumentHandler{Store: &documentStore}
 
 	// First, insert a document via POST
-	postReq := createMultipartRequest(t, "system123", "file456", `["test"]`, "", []byte("test content"), "test.txt")
+	postReq := createMultipartRequest(t, "123", "file456", `["test"]`, "", []byte("test content"), "test.txt")
 	postRR := httptest.NewRecorder()
 	postHandler := documentHandler.Post()
 	postHandler(postRR, postReq)
@@ -254,7 +291,7 @@ func TestIntegrationDocumentHandlerLargeFile(t *testing.T) {
 
 	// Create a file larger than 10MB limit
 	largeContent := make([]byte, 11<<20) // 11MB
-	req := createMultipartRequest(t, "system123", "file456", "", "", largeContent, "large.txt")
+	req := createMultipartRequest(t, "123", "file456", "", "", largeContent, "large.txt")
 
 	rr := httptest.NewRecorder()
 	postHandler := documentHandler.Post()
@@ -285,7 +322,7 @@ func TestIntegrationDocumentHandlerMultipleUploads(t *testing.T) {
 
 	// Upload multiple documents sequentially
 	for i := range numUploads {
-		systemId := fmt.Sprintf("system%d", i)
+		systemId := fmt.Sprintf("%d", i+1)
 		fileId := fmt.Sprintf("file%d", i)
 		req := createMultipartRequest(t, systemId, fileId, "", "", []byte("test content"), "test.txt")
 		rr := httptest.NewRecorder()
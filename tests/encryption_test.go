@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionAtRest(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	defer storage.SetKeyring(nil)
+
+	// EncryptFiles walks every storage root, so start from an empty tree
+	t.Chdir(t.TempDir())
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	const content = "Turn every two hours, check pressure areas"
+
+	// Stored before encryption was turned on
+	storage.SetKeyring(nil)
+	plain, err := storage.PutBlob(strings.NewReader(content), "plan.txt")
+	if err != nil {
+		t.Fatalf("Failed to store blob: %v", err)
+	}
+
+	oldRing, err := storage.NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("Failed to make keyring: %v", err)
+	}
+	storage.SetKeyring(oldRing)
+
+	if encrypted, err := storage.EncryptFiles(); err != nil || encrypted != 1 {
+		t.Fatalf("Expected the plain text blob to be encrypted, encrypted %d (%v)", encrypted, err)
+	}
+
+	raw, err := os.ReadFile(plain.Path)
+	if err != nil || bytes.Contains(raw, []byte(content)) {
+		t.Fatalf("Expected the file on disk to be encrypted, got %q (%v)", raw, err)
+	}
+
+	data, err := storage.ReadFile(plain.Path)
+	if err != nil || string(data) != content {
+		t.Fatalf("Expected the decrypted content, got %q (%v)", data, err)
+	}
+	if hash, err := storage.HashFile(plain.Path); err != nil || hash != plain.Hash {
+		t.Fatalf("Expected the hash of the decrypted content, got %s (%v)", hash, err)
+	}
+
+	// Tampering is caught by the authenticated cipher
+	raw[len(raw)-1] ^= 1
+	tampered := plain.Path + ".tampered"
+	if err := os.WriteFile(tampered, raw, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := storage.ReadFile(tampered); err == nil {
+		t.Fatal("Expected a tampered file to fail to decrypt")
+	}
+	os.Remove(tampered)
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+	if err := (&stores.TemplateStore{Db: db}).Add(models.Template{SystemId: 1, FileReference: "meds", FilePath: "templates/meds.pdf"}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	dataStore := stores.TemplateDataStore{Db: db}
+	if err := dataStore.Set(models.TemplateData{TemplateId: 1, Subject: "resident-1", Data: map[string]any{"name": "Erik Lund"}}); err != nil {
+		t.Fatalf("Failed to set data: %v", err)
+	}
+
+	var stored string
+	if err := db.QueryRow(`SELECT data FROM template_data`).Scan(&stored); err != nil || strings.Contains(stored, "Erik Lund") {
+		t.Fatalf("Expected the data column to be encrypted, got %q (%v)", stored, err)
+	}
+
+	// Rotate: the new key encrypts, the old one still decrypts
+	rotated, err := storage.NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to make keyring: %v", err)
+	}
+	storage.SetKeyring(rotated)
+
+	if encrypted, err := storage.EncryptFiles(); err != nil || encrypted != 1 {
+		t.Fatalf("Expected the blob to be encrypted with the new key, encrypted %d (%v)", encrypted, err)
+	}
+	if resealed, err := dataStore.Reseal(); err != nil || resealed != 1 {
+		t.Fatalf("Expected the data to be encrypted with the new key, encrypted %d (%v)", resealed, err)
+	}
+
+	newRing, err := storage.NewKeyring(newKey)
+	if err != nil {
+		t.Fatalf("Failed to make keyring: %v", err)
+	}
+	storage.SetKeyring(newRing)
+
+	if data, err := storage.ReadFile(plain.Path); err != nil || string(data) != content {
+		t.Fatalf("Expected the file to decrypt without the old key, got %q (%v)", data, err)
+	}
+	items, err := dataStore.GetByTemplateId(1)
+	if err != nil || len(items) != 1 || items[0].Data["name"] != "Erik Lund" {
+		t.Fatalf("Expected the data to decrypt without the old key, got %+v (%v)", items, err)
+	}
+
+	storage.SetKeyring(oldRing)
+	if _, err := storage.ReadFile(plain.Path); !errors.Is(err, storage.ErrUnknownKey) {
+		t.Fatalf("Expected a retired key to be unable to decrypt, got %v", err)
+	}
+}
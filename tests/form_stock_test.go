@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"testing"
+)
+
+func TestFormStockCounts(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+	if err := (&stores.TemplateStore{Db: db}).Add(models.Template{SystemId: 1, FileReference: "mar", FilePath: "templates/mar.pdf"}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	store := stores.FormStockStore{Db: db}
+	rule := models.FormStockRule{SystemId: 1, Ward: "3B", TemplateId: 1, CopiesPerHour: 15}
+	if _, err := store.Add(rule); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+	if copies := rule.Copies(8); copies != 120 {
+		t.Errorf("Expected 120 copies for an 8 hour outage, got %d", copies)
+	}
+
+	// 120 copies are split over two jobs, of which one has printed
+	ward, templateId, version := "3B", int64(1), 1
+	hundred, twenty := 100, 20
+	systemId := int64(1)
+	jobs := []models.PrintJob{
+		{Source: models.PrintSourceTemplate, TemplateId: &templateId, TemplateVersion: &version, StockWard: &ward, Options: &models.PrintOptions{Copies: &hundred}, Status: models.PrintJobCompleted},
+		{Source: models.PrintSourceTemplate, TemplateId: &templateId, TemplateVersion: &version, StockWard: &ward, Options: &models.PrintOptions{Copies: &twenty}, Status: models.PrintJobQueued},
+		{Source: models.PrintSourceTemplate, TemplateId: &templateId, TemplateVersion: &version, Status: models.PrintJobCompleted},
+	}
+	if _, err := (&stores.PrintJobStore{Db: db}).AddBatch(models.PrintBatch{SystemId: &systemId, Reason: "Health check failed"}, jobs); err != nil {
+		t.Fatalf("Failed to queue batch: %v", err)
+	}
+
+	counts, err := store.GetCounts(1)
+	if err != nil {
+		t.Fatalf("Failed to count stock: %v", err)
+	}
+	if len(counts) != 1 {
+		t.Fatalf("Expected one count for the ward, got %+v", counts)
+	}
+	if counts[0].Ward != "3B" || counts[0].Queued != 120 || counts[0].Printed != 100 || counts[0].Reason != "Health check failed" {
+		t.Errorf("Expected 120 copies queued and 100 printed for 3B, got %+v", counts[0])
+	}
+}
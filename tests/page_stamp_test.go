@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/validation"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func twoPagePDF() []byte {
+	doc := pdf.New()
+	doc.AddPage().Text(50, 700, pdf.Helvetica, 12, "Medication list")
+	doc.AddPage().Text(50, 700, pdf.Helvetica, 12, "Continued")
+	return doc.Bytes()
+}
+
+func TestStampPDF(t *testing.T) {
+	original := twoPagePDF()
+	stamp := pdf.Stamp{Header: "EMERGENCY COPY", Footer: "Page {page} of {pages}", Watermark: "EMERGENCY COPY"}
+
+	stamped, err := pdf.StampPDF(original, stamp)
+	if err != nil {
+		t.Fatalf("Failed to stamp PDF: %v", err)
+	}
+
+	if !bytes.HasPrefix(stamped, original) {
+		t.Error("Expected the stamp to be appended as an incremental update")
+	}
+
+	update := stamped[len(original):]
+	for _, expected := range []string{"(Page 1 of 2)", "(Page 2 of 2)", "(EMERGENCY COPY)", "/Prev ", "/BlackoutBoxF2"} {
+		if !bytes.Contains(update, []byte(expected)) {
+			t.Errorf("Expected stamped PDF to contain %s", expected)
+		}
+	}
+
+	// The update must be readable itself, so stamping again works.
+	if _, err := pdf.StampPDF(stamped, stamp); err != nil {
+		t.Errorf("Failed to stamp an already stamped PDF: %v", err)
+	}
+}
+
+func TestStampDamagedPDF(t *testing.T) {
+	damaged := bytes.Replace(twoPagePDF(), []byte("startxref\n"), []byte("startxref\n99"), 1)
+
+	stamped, err := pdf.StampPDF(damaged, pdf.Stamp{Footer: "Page {page} of {pages}"})
+	if err != nil {
+		t.Fatalf("Failed to stamp damaged PDF: %v", err)
+	}
+
+	checkXref(t, stamped)
+
+	if !bytes.Contains(stamped, []byte("(Page 2 of 2)")) || !bytes.Contains(stamped, []byte("(Continued)")) {
+		t.Error("Expected the rewritten PDF to keep its pages and gain the stamp")
+	}
+}
+
+func TestStampRejectsEncryptedPDF(t *testing.T) {
+	encrypted := bytes.Replace(twoPagePDF(), []byte("trailer\n<< "), []byte("trailer\n<< /Encrypt 1 0 R "), 1)
+
+	if _, err := pdf.StampPDF(encrypted, pdf.Stamp{Header: "EMERGENCY COPY"}); !errors.Is(err, pdf.ErrEncrypted) {
+		t.Errorf("Expected ErrEncrypted, got %v", err)
+	}
+}
+
+func TestPageStampForDocument(t *testing.T) {
+	footer := "{file_id} printed {printed_at}"
+	empty := ""
+
+	stamp := (&models.PageStamp{Footer: &footer, Watermark: &empty}).ForDocument("Facility Alpha", "medication-list")
+
+	if *stamp.Header != "EMERGENCY COPY · Facility Alpha" {
+		t.Errorf("Expected default header with the system name, got %q", *stamp.Header)
+	}
+	if *stamp.Footer != "medication-list printed {printed_at}" {
+		t.Errorf("Expected footer with the file id filled in, got %q", *stamp.Footer)
+	}
+	if *stamp.Watermark != "" {
+		t.Errorf("Expected an empty watermark to stay empty, got %q", *stamp.Watermark)
+	}
+
+	if (&models.PageStamp{Disabled: true}).ForDocument("Facility Alpha", "medication-list") != nil {
+		t.Error("Expected no stamp when stamping is disabled")
+	}
+
+	unknown := "Printed {date}"
+	if err := validation.ValidatePageStamp(&models.PageStamp{Header: &unknown}); err == nil {
+		t.Error("Expected unknown placeholder to be rejected")
+	}
+}
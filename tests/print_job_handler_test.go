@@ -0,0 +1,191 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/cups"
+	"blackoutbox/internal/handlers/documents"
+	"blackoutbox/internal/handlers/printjobs"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/monitor"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/stores"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTestMonitor creates a monitor over db, printing with a printer that
+// queues jobs for the worker.
+func newTestMonitor(db *sql.DB) (*monitor.Monitor, *cups.Printer) {
+	printJobStore := stores.PrintJobStore{Db: db}
+	printedVersionStore := stores.PrintedVersionStore{Db: db}
+	printer := cups.NewPrinter(&printJobStore, &stores.PrinterStatusStore{Db: db}, &printedVersionStore)
+
+	return monitor.NewMonitor(
+		&stores.SystemStore{Db: db},
+		&stores.TriggerStore{Db: db},
+		&stores.DocumentStore{Db: db},
+		&stores.TemplateStore{Db: db},
+		&stores.TemplateDataStore{Db: db},
+		&stores.TemplateAttachmentStore{Db: db},
+		&stores.FormStockStore{Db: db},
+		&printJobStore,
+		&stores.TagPrintOptionsStore{Db: db},
+		&stores.PrintScheduleStore{Db: db},
+		&printedVersionStore,
+		printer,
+	), printer
+}
+
+// setupPrintJobHandlers creates the print job and document handlers over a
+// migrated database with one system and one document, whose file is a PDF
+// in the current directory.
+func setupPrintJobHandlers(t *testing.T) (*sql.DB, printjobs.PrintJobHandler, documents.DocumentHandler) {
+	t.Helper()
+
+	db := setupMigratedDB(t)
+	t.Chdir(t.TempDir())
+
+	doc := pdf.New()
+	doc.AddPage().Text(50, 700, pdf.Helvetica, 12, "Medication list")
+	if err := os.WriteFile("meds.pdf", doc.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	if err := documentStore.Add(models.Document{SystemId: 1, FileReference: "meds", FilePath: "meds.pdf"}); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	printJobStore := stores.PrintJobStore{Db: db}
+	monitorService, printer := newTestMonitor(db)
+
+	return db,
+		printjobs.PrintJobHandler{Store: &printJobStore, Canceler: printer, Reprinter: monitorService},
+		documents.DocumentHandler{Store: &documentStore, Printer: monitorService}
+}
+
+// postPrintJob posts body to handler with id as the path's id.
+func postPrintJob(handler http.HandlerFunc, id, body string) (*httptest.ResponseRecorder, models.PrintJob) {
+	req := httptest.NewRequest(http.MethodPost, "/"+id, strings.NewReader(body))
+	req.SetPathValue("id", id)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	var job models.PrintJob
+	json.Unmarshal(rr.Body.Bytes(), &job)
+	return rr, job
+}
+
+func TestPrintJobCancel(t *testing.T) {
+	db, handler, _ := setupPrintJobHandlers(t)
+	defer db.Close()
+
+	store := stores.PrintJobStore{Db: db}
+	for _, status := range []string{models.PrintJobQueued, models.PrintJobCompleted} {
+		if _, err := store.Add(models.PrintJob{Source: models.PrintSourceDocument, FilePath: "meds.pdf", Status: status, Origin: models.PrintOriginManual}); err != nil {
+			t.Fatalf("Failed to add print job: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		expected int
+	}{
+		{"Invalid id", "abc", http.StatusBadRequest},
+		{"Missing job", "99", http.StatusNotFound},
+		{"Finished job", "2", http.StatusConflict},
+		{"Queued job", "1", http.StatusOK},
+		{"Canceled job", "1", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, job := postPrintJob(handler.Cancel(), tt.id, "")
+			if rr.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusOK && (job.Status != models.PrintJobCanceled || job.CanceledAt == nil) {
+				t.Errorf("Expected the job to be canceled, got %+v", job)
+			}
+		})
+	}
+
+	if job, err := store.GetById(2); err != nil || job.Status != models.PrintJobCompleted {
+		t.Errorf("Expected the finished job to stay completed, got %+v (%v)", job, err)
+	}
+}
+
+func TestPrintJobReprint(t *testing.T) {
+	db, handler, _ := setupPrintJobHandlers(t)
+	defer db.Close()
+
+	copies := 3
+	a4 := "A4"
+	documentId := int64(1)
+	store := stores.PrintJobStore{Db: db}
+	original, err := store.Add(models.PrintJob{
+		Source:     models.PrintSourceDocument,
+		DocumentId: &documentId,
+		FilePath:   "meds.pdf",
+		Status:     models.PrintJobCompleted,
+		Options:    &models.PrintOptions{Copies: &copies, Media: &a4},
+		Origin:     models.PrintOriginEmergency,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add print job: %v", err)
+	}
+
+	rr, job := postPrintJob(handler.Reprint(), "1", `{"print_options": {"copies": 1}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if job.Id == original || job.ParentJobId == nil || *job.ParentJobId != original {
+		t.Errorf("Expected a new job linked to job %d, got %+v", original, job)
+	}
+	if job.Origin != models.PrintOriginReprint || job.DocumentId == nil || *job.DocumentId != documentId {
+		t.Errorf("Expected a reprint of document %d, got %+v", documentId, job)
+	}
+	if job.Options == nil || *job.Options.Copies != 1 || *job.Options.Media != "A4" {
+		t.Errorf("Expected the original options with the override, got %+v", job.Options)
+	}
+
+	if rr, _ := postPrintJob(handler.Reprint(), "99", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing job to give %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr, _ := postPrintJob(handler.Reprint(), "1", `{"print_options": {"copies": 0}}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid options to give %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestDocumentPrint(t *testing.T) {
+	db, _, handler := setupPrintJobHandlers(t)
+	defer db.Close()
+
+	rr, job := postPrintJob(handler.Print(), "1", `{"print_options": {"copies": 2}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if job.Origin != models.PrintOriginManual || job.DocumentId == nil || *job.DocumentId != 1 || job.ParentJobId != nil {
+		t.Errorf("Expected a manual print of document 1, got %+v", job)
+	}
+	if job.Options == nil || job.Options.Copies == nil || *job.Options.Copies != 2 {
+		t.Errorf("Expected the override to be used, got %+v", job.Options)
+	}
+
+	if rr, _ := postPrintJob(handler.Print(), "99", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing document to give %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
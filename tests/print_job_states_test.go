@@ -0,0 +1,54 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"testing"
+)
+
+func TestPrintJobTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		expected bool
+	}{
+		{"Queued to submitted", models.PrintJobQueued, models.PrintJobSubmitted, true},
+		{"Queued straight to completed", models.PrintJobQueued, models.PrintJobCompleted, false},
+		{"Submitted to completed", models.PrintJobSubmitted, models.PrintJobCompleted, true},
+		{"Processing back to submitted", models.PrintJobProcessing, models.PrintJobSubmitted, true},
+		{"Held to canceled", models.PrintJobHeld, models.PrintJobCanceled, true},
+		{"Stopped to processing", models.PrintJobStopped, models.PrintJobProcessing, true},
+		{"Submitted back to queued", models.PrintJobSubmitted, models.PrintJobQueued, false},
+		{"Completed to processing", models.PrintJobCompleted, models.PrintJobProcessing, false},
+		{"Canceled to completed", models.PrintJobCanceled, models.PrintJobCompleted, false},
+		{"Aborted to submitted", models.PrintJobAborted, models.PrintJobSubmitted, false},
+		{"Same state", models.PrintJobHeld, models.PrintJobHeld, true},
+		{"Unknown state", "printing", models.PrintJobCompleted, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := models.CanTransitionPrintJob(tt.from, tt.to); got != tt.expected {
+				t.Errorf("CanTransitionPrintJob(%s, %s) = %v, expected %v", tt.from, tt.to, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrintJobTerminalStates(t *testing.T) {
+	for _, state := range models.ActivePrintJobStates {
+		if models.IsTerminalPrintJobState(state) {
+			t.Errorf("Expected %s to be non-terminal", state)
+		}
+	}
+
+	for _, state := range []string{models.PrintJobCanceled, models.PrintJobAborted, models.PrintJobCompleted} {
+		if !models.IsTerminalPrintJobState(state) {
+			t.Errorf("Expected %s to be terminal", state)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/validation"
+	"testing"
+)
+
+func TestParsePrintOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		expectError bool
+	}{
+		{"Empty", "", false},
+		{"All options", `{"copies": 3, "sides": "two-sided-long-edge", "media": "A4", "page_ranges": "1-3,5", "orientation": "landscape"}`, false},
+		{"Zero copies", `{"copies": 0}`, true},
+		{"Too many copies", `{"copies": 1000}`, true},
+		{"Unknown sides", `{"sides": "both"}`, true},
+		{"Unknown media", `{"media": "B5"}`, true},
+		{"Unknown orientation", `{"orientation": "upside-down"}`, true},
+		{"Malformed page ranges", `{"page_ranges": "1-"}`, true},
+		{"Descending page ranges", `{"page_ranges": "5,1-3"}`, true},
+		{"Reversed page range", `{"page_ranges": "3-1"}`, true},
+		{"Page zero", `{"page_ranges": "0-2"}`, true},
+		{"Unknown field", `{"colour": true}`, true},
+		{"Not JSON", `copies=2`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validation.ParsePrintOptions(tt.raw)
+			if tt.expectError && err == nil {
+				t.Error("Expected an error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPrintOptionsMerge(t *testing.T) {
+	copies := 3
+	a4 := "A4"
+	letter := "Letter"
+	duplex := "two-sided-long-edge"
+
+	base := models.PrintOptions{Copies: &copies, Media: &a4}
+	merged := base.Merge(&models.PrintOptions{Media: &letter, Sides: &duplex})
+
+	if merged.Copies == nil || *merged.Copies != 3 {
+		t.Errorf("Expected copies to be kept, got %v", merged.Copies)
+	}
+	if merged.Media == nil || *merged.Media != "Letter" {
+		t.Errorf("Expected media to be overridden with Letter, got %v", merged.Media)
+	}
+	if merged.Sides == nil || *merged.Sides != duplex {
+		t.Errorf("Expected sides to be added, got %v", merged.Sides)
+	}
+	if *base.Media != "A4" {
+		t.Errorf("Expected base options to be unchanged, got %s", *base.Media)
+	}
+
+	if base.Merge(nil).Media != base.Media {
+		t.Error("Expected merging nil to keep the options")
+	}
+}
@@ -0,0 +1,165 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/cups"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/stores"
+	"os"
+	"testing"
+)
+
+func TestAddBatchIsIdempotent(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	store := stores.PrintJobStore{Db: db}
+
+	key := "trigger:1:1738581234"
+	batch := models.PrintBatch{Key: &key, Reason: "Health check failed"}
+	jobs := []models.PrintJob{
+		{FilePath: "generated/1/cover.pdf", Status: models.PrintJobQueued},
+		{FilePath: "uploads/1/roster.pdf", Status: models.PrintJobQueued},
+	}
+
+	queued, err := store.AddBatch(batch, jobs)
+	if err != nil || !queued {
+		t.Fatalf("Expected batch to be queued, got %v, %v", queued, err)
+	}
+
+	queued, err = store.AddBatch(batch, jobs)
+	if err != nil || queued {
+		t.Fatalf("Expected batch with the same key to be ignored, got %v, %v", queued, err)
+	}
+
+	pending, err := store.GetQueued()
+	if err != nil {
+		t.Fatalf("Failed to get queued jobs: %v", err)
+	}
+
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 queued jobs, got %d", len(pending))
+	}
+	if pending[0].FilePath != "generated/1/cover.pdf" || pending[0].BatchId == nil || pending[0].DocumentId != nil {
+		t.Errorf("Expected the cover sheet first, in the batch and without a document, got %+v", pending[0])
+	}
+	if pending[0].QueuedAt == 0 || pending[0].SubmittedAt != nil {
+		t.Errorf("Expected a queued job with a queue time and no submission time, got %+v", pending[0])
+	}
+}
+
+func TestPrintJobSources(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+	if err := (&stores.TemplateStore{Db: db}).Add(models.Template{SystemId: 1, FileReference: "meds", FilePath: "templates/meds.pdf"}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	store := stores.PrintJobStore{Db: db}
+
+	templateId := int64(1)
+	if _, err := store.AddBatch(models.PrintBatch{Reason: "Health check failed"}, []models.PrintJob{
+		{Source: models.PrintSourceCoverSheet, FilePath: "generated/1/cover_sheet.pdf", Status: models.PrintJobQueued},
+		{Source: models.PrintSourceTemplate, TemplateId: &templateId, FilePath: "templates/meds.pdf", Status: models.PrintJobQueued},
+	}); err != nil {
+		t.Fatalf("Failed to queue batch: %v", err)
+	}
+
+	// A template id is not a document id
+	missing := int64(99)
+	if _, err := store.Add(models.PrintJob{Source: models.PrintSourceTemplate, TemplateId: &missing, Status: models.PrintJobQueued}); err == nil {
+		t.Error("Expected a job for a missing template to be rejected")
+	}
+
+	jobs, err := store.GetByTemplateId(templateId)
+	if err != nil {
+		t.Fatalf("Failed to get template jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Source != models.PrintSourceTemplate || jobs[0].DocumentId != nil {
+		t.Fatalf("Expected one template job without a document, got %+v", jobs)
+	}
+
+	if _, err := db.Exec(`DELETE FROM templates WHERE id = 1`); err != nil {
+		t.Fatalf("Failed to delete template: %v", err)
+	}
+	job, err := store.GetById(jobs[0].Id)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if job.TemplateId != nil || job.Source != models.PrintSourceTemplate {
+		t.Errorf("Expected the job to outlive its template, got %+v", job)
+	}
+}
+
+func TestDispatchRetriesWhileCupsIsDown(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	// lp fails as it does while CUPS is down
+	if err := os.WriteFile("lp", []byte("#!/bin/sh\necho 'lp: Scheduler is not running.' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatalf("Failed to write lp: %v", err)
+	}
+	t.Setenv("PATH", dir)
+
+	doc := pdf.New()
+	doc.AddPage().Text(50, 700, pdf.Helvetica, 12, "Cover sheet")
+	if err := os.WriteFile("cover.pdf", doc.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	store := stores.PrintJobStore{Db: db}
+	printer := cups.NewPrinter(&store, &stores.PrinterStatusStore{Db: db}, &stores.PrintedVersionStore{Db: db})
+
+	id, err := printer.CreatePrintJob(models.PrintRequest{Source: models.PrintSourceCoverSheet, FilePath: "cover.pdf", Origin: models.PrintOriginEmergency})
+	if err != nil {
+		t.Fatalf("Failed to queue job: %v", err)
+	}
+
+	// The job is left for the worker to dispatch
+	select {
+	case <-printer.Queued():
+	default:
+		t.Fatal("Expected the worker to be signaled")
+	}
+	if job, _ := store.GetById(id); job.DispatchAttempts != 0 {
+		t.Fatalf("Expected the job not to be dispatched yet, got %d attempts", job.DispatchAttempts)
+	}
+	if err := printer.DispatchQueued(); err != nil {
+		t.Fatalf("Failed to dispatch: %v", err)
+	}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		job, err := store.GetById(id)
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if job.Status != models.PrintJobQueued || job.DispatchAttempts != attempt || job.NextDispatchAt == nil || job.ErrorMessage == nil {
+			t.Fatalf("Expected the job to be queued for a retry after attempt %d, got %+v", attempt, job)
+		}
+
+		// Nothing is tried again before the job is due
+		if err := printer.DispatchQueued(); err != nil {
+			t.Fatalf("Failed to dispatch: %v", err)
+		}
+		if job, _ := store.GetById(id); job.DispatchAttempts != attempt {
+			t.Fatalf("Expected the job not to be retried early, got %d attempts", job.DispatchAttempts)
+		}
+
+		if _, err := db.Exec(`UPDATE print_jobs SET next_dispatch_at = 0 WHERE id = ?`, id); err != nil {
+			t.Fatalf("Failed to make job due: %v", err)
+		}
+		if err := printer.DispatchQueued(); err != nil {
+			t.Fatalf("Failed to dispatch: %v", err)
+		}
+	}
+}
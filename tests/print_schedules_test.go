@@ -0,0 +1,100 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/monitor"
+	"blackoutbox/internal/stores"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrintScheduleRunRetries(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	store := stores.PrintScheduleStore{Db: db}
+	dueAt := time.Now().Add(-time.Minute).Unix()
+	tag := "medication"
+	id, err := store.Add(models.PrintSchedule{
+		SystemId:  1,
+		Name:      "Medication list",
+		Cron:      "0 7 * * *",
+		TimeZone:  "UTC",
+		Target:    models.ScheduleTargetTag,
+		Tag:       &tag,
+		Mode:      models.PrintModeFull,
+		Enabled:   true,
+		NextRunAt: &dueAt,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add print schedule: %v", err)
+	}
+
+	// The documents can't be read, so every run fails
+	documentStore := &MockDocumentStore{
+		GetBySystemIdFunc: func(id int64) ([]models.Document, error) {
+			return nil, errors.New("database is locked")
+		},
+	}
+	monitorService := monitor.NewMonitor(
+		&stores.SystemStore{Db: db},
+		&stores.TriggerStore{Db: db},
+		documentStore,
+		&stores.TemplateStore{Db: db},
+		&stores.TemplateDataStore{Db: db},
+		&stores.TemplateAttachmentStore{Db: db},
+		&stores.FormStockStore{Db: db},
+		&stores.PrintJobStore{Db: db},
+		&stores.TagPrintOptionsStore{Db: db},
+		&store,
+		&stores.PrintedVersionStore{Db: db},
+		nil,
+	)
+
+	for attempt := 1; attempt < 5; attempt++ {
+		if err := monitorService.RunPrintSchedules(); err != nil {
+			t.Fatalf("Failed to run print schedules: %v", err)
+		}
+
+		schedule, err := store.GetById(id)
+		if err != nil {
+			t.Fatalf("Failed to get print schedule: %v", err)
+		}
+		if schedule.FailedRuns != attempt || schedule.RetryAt == nil || *schedule.NextRunAt != dueAt || schedule.LastRunAt != nil {
+			t.Fatalf("Expected the run to stay due after attempt %d, got %+v", attempt, schedule)
+		}
+
+		// Nothing is tried again before the retry is due
+		if due, err := store.GetDue(time.Now().Unix()); err != nil || len(due) != 0 {
+			t.Fatalf("Expected the failed run not to be due yet, got %+v (%v)", due, err)
+		}
+		if _, err := db.Exec(`UPDATE print_schedules SET retry_at = 0 WHERE id = ?`, id); err != nil {
+			t.Fatalf("Failed to make retry due: %v", err)
+		}
+	}
+
+	// The last attempt gives up on the run and moves on to the next one
+	if err := monitorService.RunPrintSchedules(); err != nil {
+		t.Fatalf("Failed to run print schedules: %v", err)
+	}
+
+	schedule, err := store.GetById(id)
+	if err != nil {
+		t.Fatalf("Failed to get print schedule: %v", err)
+	}
+	if schedule.MissedRunAt == nil || *schedule.MissedRunAt != dueAt {
+		t.Errorf("Expected the run to be marked missed, got %v", schedule.MissedRunAt)
+	}
+	if *schedule.NextRunAt <= time.Now().Unix() || schedule.FailedRuns != 0 || schedule.RetryAt != nil || schedule.LastRunAt != nil {
+		t.Errorf("Expected the schedule to move on to its next run, got %+v", schedule)
+	}
+}
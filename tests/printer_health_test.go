@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/cups"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// setupMigratedDB creates an in-memory database with every migration applied.
+func setupMigratedDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	migrations, err := filepath.Glob("../migrations/*.up.sql")
+	if err != nil {
+		t.Fatalf("Failed to list migrations: %v", err)
+	}
+
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", migration, err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			t.Fatalf("Failed to apply %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return db
+}
+
+func TestSplitStateReason(t *testing.T) {
+	tests := []struct {
+		reason   string
+		keyword  string
+		severity string
+	}{
+		{"media-empty-error", "media-empty", "error"},
+		{"toner-low-warning", "toner-low", "warning"},
+		{"cover-open-report", "cover-open", "report"},
+		{"paused", "paused", ""},
+		{"media-needed", "media-needed", ""},
+		{"com.example-fuser-error", "com.example-fuser", "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			keyword, severity := cups.SplitStateReason(tt.reason)
+			if keyword != tt.keyword || severity != tt.severity {
+				t.Errorf("SplitStateReason(%s) = (%s, %s), expected (%s, %s)", tt.reason, keyword, severity, tt.keyword, tt.severity)
+			}
+		})
+	}
+}
+
+func TestEvaluateReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    models.PrinterStatus
+		accepting bool
+		ready     bool
+		warnings  []string
+	}{
+		{
+			name:      "Idle printer",
+			status:    models.PrinterStatus{State: models.PrinterIdle},
+			accepting: true,
+			ready:     true,
+		},
+		{
+			name:      "Stopped printer",
+			status:    models.PrinterStatus{State: models.PrinterStopped},
+			accepting: true,
+			warnings:  []string{"printer is stopped"},
+		},
+		{
+			name:     "Not accepting jobs",
+			status:   models.PrinterStatus{State: models.PrinterIdle},
+			warnings: []string{"printer is not accepting jobs"},
+		},
+		{
+			name:      "Paper out reported as a warning",
+			status:    models.PrinterStatus{State: models.PrinterIdle, StateReasons: []string{"media-empty-warning"}},
+			accepting: true,
+			warnings:  []string{"media-empty-warning"},
+		},
+		{
+			name:      "Unknown error",
+			status:    models.PrinterStatus{State: models.PrinterProcessing, StateReasons: []string{"fuser-error"}},
+			accepting: true,
+			warnings:  []string{"fuser-error"},
+		},
+		{
+			name:      "Warnings keep the printer ready",
+			status:    models.PrinterStatus{State: models.PrinterIdle, StateReasons: []string{"toner-low-warning", "cleaning-report"}},
+			accepting: true,
+			ready:     true,
+			warnings:  []string{"toner-low-warning"},
+		},
+		{
+			name: "Markers",
+			status: models.PrinterStatus{State: models.PrinterIdle, Markers: []models.PrinterMarker{
+				{Name: "Black", Level: 5, LowLevel: 10},
+				{Name: "Drum", Level: -1, LowLevel: 10},
+				{Name: "Cyan", Level: 80, LowLevel: 10},
+			}},
+			accepting: true,
+			ready:     true,
+			warnings:  []string{"Black is low (5%)"},
+		},
+		{
+			name: "Empty marker",
+			status: models.PrinterStatus{State: models.PrinterIdle, Markers: []models.PrinterMarker{
+				{Name: "Black", Level: 0, LowLevel: 10},
+			}},
+			accepting: true,
+			warnings:  []string{"Black is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			cups.EvaluateReadiness(&status, tt.accepting)
+
+			if status.Ready != tt.ready {
+				t.Errorf("Expected ready %v, got %v", tt.ready, status.Ready)
+			}
+			if !slices.Equal(status.Warnings, tt.warnings) {
+				t.Errorf("Expected warnings %v, got %v", tt.warnings, status.Warnings)
+			}
+		})
+	}
+}
+
+func TestPrinterStatusHistoryPruning(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	statusStore := stores.PrinterStatusStore{Db: db}
+	now := time.Now()
+	for _, age := range []time.Duration{8 * 24 * time.Hour, 6 * 24 * time.Hour} {
+		status := models.PrinterStatus{PrinterName: "ward", State: models.PrinterIdle, Ready: true, CheckedAt: now.Add(-age).Unix()}
+		if err := statusStore.Add(status); err != nil {
+			t.Fatalf("Failed to add printer status: %v", err)
+		}
+	}
+
+	// A check is recorded and the history pruned whether or not CUPS is
+	// reachable.
+	printer := cups.NewPrinter(&stores.PrintJobStore{Db: db}, &statusStore, &stores.PrintedVersionStore{Db: db})
+	if err := printer.CheckPrinterHealth(); err != nil {
+		t.Fatalf("Failed to check printer health: %v", err)
+	}
+
+	history, err := statusStore.GetHistory(0, 10)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 2 || history[1].CheckedAt != now.Add(-6*24*time.Hour).Unix() {
+		t.Errorf("Expected the week-old status to be pruned, got %+v", history)
+	}
+}
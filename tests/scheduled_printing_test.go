@@ -0,0 +1,66 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"testing"
+)
+
+func TestGetDueForPrinting(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := stores.DocumentStore{Db: db}
+
+	now := int64(1738581234)
+	past := now - 60
+	future := now + 60
+	earlier := past - 3600
+
+	documents := []models.Document{
+		{FileReference: "due", PrintAt: &past},
+		{FileReference: "not-yet", PrintAt: &future},
+		{FileReference: "unscheduled"},
+		{FileReference: "printed", PrintAt: &past, LastPrintedAt: &now},
+		{FileReference: "rescheduled", PrintAt: &past, LastPrintedAt: &earlier},
+	}
+	for _, doc := range documents {
+		doc.SystemId = 1
+		doc.FilePath = "uploads/1/" + doc.FileReference + ".pdf"
+		if err := store.Add(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	due, err := store.GetDueForPrinting(now)
+	if err != nil {
+		t.Fatalf("Failed to get due documents: %v", err)
+	}
+
+	var references []string
+	for _, doc := range due {
+		references = append(references, doc.FileReference)
+	}
+	if len(references) != 2 || references[0] != "due" || references[1] != "rescheduled" {
+		t.Fatalf("Expected due and rescheduled documents, got %v", references)
+	}
+
+	if err := store.MarkPrintMissed(due[0].Id, past); err != nil {
+		t.Fatalf("Failed to mark print missed: %v", err)
+	}
+	if err := store.MarkPrinted(due[1].Id, now); err != nil {
+		t.Fatalf("Failed to mark printed: %v", err)
+	}
+
+	due, err = store.GetDueForPrinting(now)
+	if err != nil {
+		t.Fatalf("Failed to get due documents: %v", err)
+	}
+	if len(due) != 0 {
+		t.Errorf("Expected no documents to be due after printing, got %d", len(due))
+	}
+}
@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/handlers/health"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageScrub(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	defer os.RemoveAll(storage.BlobsRoot)
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	scrubStore := stores.ScrubStore{Db: db}
+
+	blobs := map[string]models.Blob{}
+	for name, content := range map[string]string{
+		"intact.txt":  "Close all fire doors",
+		"changed.txt": "Evacuate via the east stairs",
+		"missing.txt": "Call the on-call physician",
+		"broken.pdf":  "%PDF-1.4 not really a PDF",
+	} {
+		blob, err := storage.PutBlob(strings.NewReader(content), name)
+		if err != nil {
+			t.Fatalf("Failed to store blob: %v", err)
+		}
+		blobs[name] = blob
+
+		document := models.Document{SystemId: 1, FileReference: name, FilePath: blob.Path, FileSize: &blob.Size, FileHash: &blob.Hash}
+		if err := documentStore.Add(document); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(blobs["changed.txt"].Path, []byte("Evacuate via the west stairs"), 0644); err != nil {
+		t.Fatalf("Failed to change blob: %v", err)
+	}
+	if err := os.Remove(blobs["missing.txt"].Path); err != nil {
+		t.Fatalf("Failed to remove blob: %v", err)
+	}
+
+	orphan := filepath.Join(storage.BlobsRoot, "00", "orphan.txt")
+	os.MkdirAll(filepath.Dir(orphan), 0755)
+	if err := os.WriteFile(orphan, []byte("left behind"), 0644); err != nil {
+		t.Fatalf("Failed to write orphan: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(orphan, old, old)
+
+	report, err := storage.Scrub(&scrubStore)
+	if err != nil {
+		t.Fatalf("Failed to scrub: %v", err)
+	}
+
+	if report.FilesChecked != 4 || report.Missing != 1 || report.Corrupt != 2 || report.Orphaned != 1 {
+		t.Fatalf("Expected 4 files checked, 1 missing, 2 corrupt and 1 orphaned, got %+v", report)
+	}
+
+	problems := map[string]string{}
+	for _, problem := range report.Problems {
+		problems[problem.Path] = problem.Problem
+	}
+	expected := map[string]string{
+		blobs["changed.txt"].Path: models.ScrubCorrupt,
+		blobs["missing.txt"].Path: models.ScrubMissing,
+		blobs["broken.pdf"].Path:  models.ScrubCorrupt,
+		orphan:                    models.ScrubOrphaned,
+	}
+	for path, problem := range expected {
+		if problems[path] != problem {
+			t.Errorf("Expected %s to be %s, got %q", path, problem, problems[path])
+		}
+	}
+
+	handler := health.HealthHandler{Printer: &stores.PrinterStatusStore{Db: db}, Scrubs: &scrubStore}
+	rr := httptest.NewRecorder()
+	handler.Get().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var status struct {
+		Status   string              `json:"status"`
+		Storage  *models.ScrubReport `json:"storage"`
+		Warnings []string            `json:"warnings"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode health: %v", err)
+	}
+	if status.Status != health.StatusDegraded || status.Storage == nil || len(status.Storage.Problems) != 4 {
+		t.Errorf("Expected the box to be degraded by the scrub problems, got %+v", status)
+	}
+	if len(rr.Header().Values("Warning")) != len(status.Warnings) || len(status.Warnings) != 4 {
+		t.Errorf("Expected a Warning header per warning, got %v and %v", rr.Header().Values("Warning"), status.Warnings)
+	}
+}
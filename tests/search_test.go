@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/search"
+	"blackoutbox/internal/storage"
+	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// compositeFontPDF shows "Room 12" in a composite font with a ToUnicode
+// map, as word processors write them. It has no cross-reference table,
+// which the reader rebuilds.
+const compositeFontPDF = `%PDF-1.7
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >> endobj
+4 0 obj << /Type /Font /Subtype /Type0 /BaseFont /Arial /Encoding /Identity-H /ToUnicode 6 0 R >> endobj
+5 0 obj << /Length 60 >> stream
+BT /F1 12 Tf 50 700 Td <0001000200030004000500060007> Tj ET
+endstream endobj
+6 0 obj << /Length 200 >> stream
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+1 beginbfchar <0005> <0020> endbfchar
+2 beginbfrange <0001> <0004> [<0052> <006F> <006F> <006D>] <0006> <0007> <0031> endbfrange
+endcmap
+endstream endobj
+trailer << /Root 1 0 R >>
+%%EOF
+`
+
+func TestExtractText(t *testing.T) {
+	doc := pdf.New()
+	page := doc.AddPage()
+	page.Text(50, 700, pdf.Helvetica, 12, "Care plan for Åsa Berg")
+	page.Text(50, 680, pdf.Helvetica, 12, "Room 12 – turn every two hours")
+
+	text, err := pdf.ExtractText(doc.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to extract text: %v", err)
+	}
+	if text != "Care plan for Åsa Berg\nRoom 12 – turn every two hours" {
+		t.Errorf("Unexpected text %q", text)
+	}
+
+	text, err = pdf.ExtractText([]byte(compositeFontPDF))
+	if err != nil {
+		t.Fatalf("Failed to extract text: %v", err)
+	}
+	if text != "Room 12" {
+		t.Errorf("Expected the ToUnicode map to be used, got %q", text)
+	}
+}
+
+func TestDocumentSearch(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	t.Chdir(t.TempDir())
+
+	documentStore := stores.DocumentStore{Db: db}
+	index, err := search.NewIndex(&documentStore)
+	if errors.Is(err, search.ErrUnavailable) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1'), (2, 'care-2', 'Care 2')`); err != nil {
+		t.Fatalf("Failed to add systems: %v", err)
+	}
+
+	plan := pdf.New()
+	plan.AddPage().Text(50, 700, pdf.Helvetica, 12, "Resident in room 12 needs turning every two hours")
+
+	files := []struct {
+		systemId int64
+		name     string
+		content  string
+		tags     []string
+	}{
+		{1, "plan.pdf", string(plan.Bytes()), []string{"wing-b"}},
+		{1, "menu.txt", "Lunch is served in room 4 at noon", []string{"kitchen"}},
+		{2, "other.txt", "Room 12 in the other home", nil},
+	}
+	for _, file := range files {
+		blob, err := storage.PutBlob(strings.NewReader(file.content), file.name)
+		if err != nil {
+			t.Fatalf("Failed to store file: %v", err)
+		}
+		document := models.Document{SystemId: file.systemId, FileReference: strings.TrimSuffix(file.name, ".txt"), FilePath: blob.Path, Tags: file.tags}
+		if err := documentStore.Add(document); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	// A requested refresh is left to the worker, and requests made
+	// before it gets to them are merged
+	index.RequestRefresh()
+	index.RequestRefresh()
+	select {
+	case <-index.RefreshRequested():
+	default:
+		t.Fatal("Expected a refresh to be requested")
+	}
+	select {
+	case <-index.RefreshRequested():
+		t.Fatal("Expected the requests to be merged")
+	default:
+	}
+
+	if indexed, err := index.Refresh(); err != nil || indexed != 3 {
+		t.Fatalf("Expected 3 documents to be indexed, got %d (%v)", indexed, err)
+	}
+	if indexed, err := index.Refresh(); err != nil || indexed != 0 {
+		t.Fatalf("Expected unchanged documents to be skipped, got %d (%v)", indexed, err)
+	}
+
+	results, err := index.Search(models.SearchQuery{Text: "room-12"})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v (%v)", results, err)
+	}
+
+	systemId := int64(1)
+	results, err = index.Search(models.SearchQuery{Text: "room 12", SystemId: &systemId})
+	if err != nil || len(results) != 1 || results[0].DocumentId != 1 {
+		t.Fatalf("Expected only the care plan, got %+v (%v)", results, err)
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>room</mark> <mark>12</mark>") {
+		t.Errorf("Expected the matched words to be marked, got %q", results[0].Snippet)
+	}
+
+	results, err = index.Search(models.SearchQuery{Text: "roo*", Tags: tagexpr.Tag("kitchen")})
+	if err != nil || len(results) != 1 || results[0].DocumentId != 2 {
+		t.Fatalf("Expected only the menu, got %+v (%v)", results, err)
+	}
+
+	// A document that has gone is dropped from the index
+	if _, err := db.Exec(`DELETE FROM documents WHERE id = 1`); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, err := index.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh index: %v", err)
+	}
+	if results, err := index.Search(models.SearchQuery{Text: "turning"}); err != nil || len(results) != 0 {
+		t.Fatalf("Expected the deleted document to be gone, got %+v (%v)", results, err)
+	}
+}
@@ -0,0 +1,227 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"blackoutbox/internal/tagexpr"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTagExpressions(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+		tags       []string
+		matches    bool
+	}{
+		{"medication", "medication", []string{"medication"}, true},
+		{"Wing-B & !night", "wing-b and not night", []string{"wing-b"}, true},
+		{"wing-b and not (night or weekend)", "wing-b and not (night or weekend)", []string{"wing-b", "weekend"}, false},
+		{"icu | hdu and night", "icu or hdu and night", []string{"hdu"}, false},
+		{"(icu | hdu) and night", "(icu or hdu) and night", []string{"hdu", "night"}, true},
+		{"not not icu", "not not icu", []string{"icu"}, true},
+	}
+
+	for _, tt := range tests {
+		expr, err := tagexpr.Parse(tt.expression)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tt.expression, err)
+			continue
+		}
+		if expr.String() != tt.canonical {
+			t.Errorf("Expected %q to be written as %q, got %q", tt.expression, tt.canonical, expr.String())
+		}
+		if reparsed, err := tagexpr.Parse(expr.String()); err != nil || reparsed.String() != tt.canonical {
+			t.Errorf("Expected %q to parse back to itself, got %v (%v)", tt.canonical, reparsed, err)
+		}
+		if expr.Match(tt.tags) != tt.matches {
+			t.Errorf("Expected %q matching %v to be %v", tt.expression, tt.tags, tt.matches)
+		}
+	}
+
+	for _, invalid := range []string{"", "icu and", "(icu", "icu)", "icu hdu", "icu, hdu", "and"} {
+		if _, err := tagexpr.Parse(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+
+	if tags := tagexpr.NormalizeAll([]string{" Wing  B ", "ICU", "icu", ""}); !slices.Equal(tags, []string{"wing-b", "icu"}) {
+		t.Errorf("Unexpected normalized tags %v", tags)
+	}
+	for _, invalid := range []string{"not", "a&b", "(icu)"} {
+		if err := tagexpr.CheckTag(invalid); err == nil {
+			t.Errorf("Expected tag %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestDocumentTags(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1'), (2, 'care-2', 'Care 2')`); err != nil {
+		t.Fatalf("Failed to add systems: %v", err)
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	documents := []models.Document{
+		{SystemId: 1, FileReference: "meds", FilePath: "upload/meds.pdf", Tags: []string{"Medication", "wing-b"}},
+		{SystemId: 1, FileReference: "night-meds", FilePath: "upload/night-meds.pdf", Tags: []string{"medication", "night"}},
+		{SystemId: 2, FileReference: "plan", FilePath: "upload/plan.pdf", Tags: []string{"wing-b"}},
+	}
+	for _, document := range documents {
+		if err := documentStore.Add(document); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	document, err := documentStore.GetById(1)
+	if err != nil || !slices.Equal(document.Tags, []string{"medication", "wing-b"}) {
+		t.Fatalf("Expected normalized tags in order, got %+v (%v)", document, err)
+	}
+
+	references := func(expression string, systemId *int64) []string {
+		t.Helper()
+		expr, err := tagexpr.Parse(expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", expression, err)
+		}
+		found, err := documentStore.GetByTags(systemId, expr)
+		if err != nil {
+			t.Fatalf("Failed to select %q: %v", expression, err)
+		}
+		var refs []string
+		for _, document := range found {
+			refs = append(refs, document.FileReference)
+		}
+		slices.Sort(refs)
+		return refs
+	}
+
+	if refs := references("medication and not night", nil); !slices.Equal(refs, []string{"meds"}) {
+		t.Errorf("Expected only meds, got %v", refs)
+	}
+	if refs := references("night or wing-b", nil); !slices.Equal(refs, []string{"meds", "night-meds", "plan"}) {
+		t.Errorf("Expected every document, got %v", refs)
+	}
+	systemId := int64(2)
+	if refs := references("wing-b", &systemId); !slices.Equal(refs, []string{"plan"}) {
+		t.Errorf("Expected only the second system's document, got %v", refs)
+	}
+
+	counts, err := (&stores.TagStore{Db: db}).GetCounts(nil)
+	if err != nil {
+		t.Fatalf("Failed to count tags: %v", err)
+	}
+	expected := []models.TagCount{
+		{SystemId: 1, Tag: "medication", Documents: 2},
+		{SystemId: 1, Tag: "night", Documents: 1},
+		{SystemId: 1, Tag: "wing-b", Documents: 1},
+		{SystemId: 2, Tag: "wing-b", Documents: 1},
+	}
+	if !slices.Equal(counts, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, counts)
+	}
+}
+
+func TestDocumentTagsMigration(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrations, err := filepath.Glob("../migrations/*.up.sql")
+	if err != nil {
+		t.Fatalf("Failed to list migrations: %v", err)
+	}
+
+	for _, migration := range migrations {
+		if strings.HasPrefix(filepath.Base(migration), "000028") {
+			_, err := db.Exec(`
+				INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1');
+				INSERT INTO documents (system_id, file_id, file_path, tags) VALUES
+					(1, 'meds', 'upload/meds.pdf', '["Wing  B", "icu", " ICU ", "", "Övervakning"]'),
+					(1, 'plan', 'upload/plan.pdf', 'null'),
+					(1, 'menu', 'upload/menu.pdf', 'kitchen'),
+					(1, 'rota', 'upload/rota.pdf', '["a&b"]');
+				INSERT INTO tag_print_options (system_id, tag, options) VALUES
+					(1, 'Övervakning', '{}'),
+					(1, 'Wing B', '{}'),
+					(1, 'wing-b', '{}');
+				INSERT INTO print_schedules (system_id, name, cron, time_zone, target, tag, created_at, updated_at) VALUES
+					(1, 'Wing B', '0 6 * * *', 'Europe/Stockholm', 'tag', 'Wing B', 0, 0),
+					(1, 'Övervakning', '0 6 * * *', 'Europe/Stockholm', 'tag', 'ÖVERVAKNING', 0, 0);
+			`)
+			if err != nil {
+				t.Fatalf("Failed to add documents: %v", err)
+			}
+		}
+
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", migration, err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			t.Fatalf("Failed to apply %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	normalized, problems, err := (&stores.TagStore{Db: db}).Normalize()
+	if err != nil || normalized != 4 {
+		t.Fatalf("Expected 4 rows to be normalized, got %d (%v)", normalized, err)
+	}
+	if len(problems) != 2 || !strings.Contains(problems[0], `"a&b"`) || !strings.Contains(problems[1], `"Wing B" clash`) {
+		t.Errorf("Expected the unusable tag and the clash to be reported, got %q", problems)
+	}
+
+	documents, err := (&stores.DocumentStore{Db: db}).Get()
+	if err != nil || len(documents) != 4 {
+		t.Fatalf("Expected 4 documents, got %+v (%v)", documents, err)
+	}
+	if !slices.Equal(documents[0].Tags, []string{"wing-b", "icu", "övervakning"}) {
+		t.Errorf("Expected the tags to be normalized in order, got %v", documents[0].Tags)
+	}
+	if documents[1].Tags != nil || documents[2].Tags != nil {
+		t.Errorf("Expected documents without a tag list to have no tags, got %v and %v", documents[1].Tags, documents[2].Tags)
+	}
+
+	var optionTags, scheduleTags []string
+	for query, tags := range map[string]*[]string{
+		`SELECT tag FROM tag_print_options ORDER BY id`: &optionTags,
+		`SELECT tag FROM print_schedules ORDER BY id`:   &scheduleTags,
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("Failed to read tags: %v", err)
+		}
+		for rows.Next() {
+			var tag string
+			if err := rows.Scan(&tag); err != nil {
+				t.Fatalf("Failed to read tag: %v", err)
+			}
+			*tags = append(*tags, tag)
+		}
+		rows.Close()
+	}
+	if !slices.Equal(optionTags, []string{"övervakning", "Wing B", "wing-b"}) {
+		t.Errorf("Expected tag print options to be normalized unless they clash, got %v", optionTags)
+	}
+	if !slices.Equal(scheduleTags, []string{"wing-b", "övervakning"}) {
+		t.Errorf("Expected schedule tags to be normalized, got %v", scheduleTags)
+	}
+
+	if normalized, _, err := (&stores.TagStore{Db: db}).Normalize(); err != nil || normalized != 0 {
+		t.Errorf("Expected normalized tags to be left alone, got %d (%v)", normalized, err)
+	}
+}
@@ -0,0 +1,221 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/forms"
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/pdf"
+	"blackoutbox/internal/stores"
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	template := pdf.New()
+	template.AddPage().Text(50, 780, pdf.HelveticaBold, 14, "MEDICATION LIST")
+
+	fields := []models.TemplateField{
+		{Name: "resident.name", X: 50, Y: 750},
+		{Name: "allergies", X: 300, Y: 750},
+		{Name: "medications", X: 50, Y: 700, MaxRows: 2, Columns: []models.TemplateColumn{
+			{Name: "drug", X: 50, Width: 200},
+			{Name: "dose", X: 300},
+		}},
+	}
+
+	data := map[string]any{
+		"resident":  map[string]any{"name": "Anna Berg"},
+		"allergies": []any{"Penicillin", "Nuts"},
+		"medications": []any{
+			map[string]any{"drug": "Waran", "dose": "2.5 mg"},
+			map[string]any{"drug": "Alvedon", "dose": 500.0},
+			map[string]any{"drug": "Furix", "dose": "40 mg"},
+		},
+	}
+
+	sheets, err := forms.Render(template.Bytes(), fields, data)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if len(sheets) != 2 {
+		t.Fatalf("Expected the third medication to continue on a second sheet, got %d sheets", len(sheets))
+	}
+
+	expected := [][]string{
+		{"(Anna Berg)", "(Penicillin, Nuts)", "(Waran)", "(500)"},
+		{"(Anna Berg)", "(Furix)", "(40 mg)"},
+	}
+	for i, sheet := range sheets {
+		if !bytes.HasPrefix(sheet, template.Bytes()) {
+			t.Errorf("Sheet %d should keep the template as it is", i+1)
+		}
+		for _, s := range expected[i] {
+			if !bytes.Contains(sheet, []byte(s)) {
+				t.Errorf("Expected sheet %d to contain %s", i+1, s)
+			}
+		}
+	}
+	if bytes.Contains(sheets[1], []byte("(Waran)")) {
+		t.Error("Rows of the first sheet should not be repeated on the second")
+	}
+}
+
+func TestReplaceTemplateData(t *testing.T) {
+	db := setupMigratedDB(t)
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+	if err := (&stores.TemplateStore{Db: db}).Add(models.Template{SystemId: 1, FileReference: "meds", FilePath: "templates/meds.pdf"}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	store := stores.TemplateDataStore{Db: db}
+
+	if err := store.Set(models.TemplateData{TemplateId: 1, Subject: "resident-1", Data: map[string]any{"name": "Old"}}); err != nil {
+		t.Fatalf("Failed to set data: %v", err)
+	}
+
+	careplan := "careplan"
+	if err := store.Set(models.TemplateData{TemplateId: 1, FileReference: &careplan, Subject: "resident-1", Data: map[string]any{"name": "Kept"}}); err != nil {
+		t.Fatalf("Failed to set data: %v", err)
+	}
+
+	if err := store.Replace(1, nil, []models.TemplateData{
+		{Subject: "resident-2", Data: map[string]any{"name": "Erik Lund"}},
+		{Subject: "resident-3", Data: map[string]any{"name": "Anna Berg"}},
+	}); err != nil {
+		t.Fatalf("Failed to replace data: %v", err)
+	}
+
+	data, err := store.GetByTemplateId(1)
+	if err != nil {
+		t.Fatalf("Failed to get data: %v", err)
+	}
+	if len(data) != 3 || data[0].Subject != "resident-2" || data[1].Data["name"] != "Anna Berg" || data[0].FileReference != nil {
+		t.Fatalf("Expected only the replaced data for any document, got %+v", data)
+	}
+	if data[2].FileReference == nil || *data[2].FileReference != careplan || data[2].Data["name"] != "Kept" {
+		t.Fatalf("Expected the document's data to be kept, got %+v", data[2])
+	}
+
+	if deleted, err := store.Delete(1, nil, "resident-1"); err != nil || deleted {
+		t.Fatalf("Expected no data for any document to be deleted, got %v (%v)", deleted, err)
+	}
+	if deleted, err := store.Delete(1, &careplan, "resident-1"); err != nil || !deleted {
+		t.Fatalf("Expected the document's data to be deleted, got %v (%v)", deleted, err)
+	}
+}
+
+func TestReplaceTemplateAttachments(t *testing.T) {
+	db := setupMigratedDB(t)
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+	templates := stores.TemplateStore{Db: db}
+	for _, ref := range []string{"meds", "falls", "visitors"} {
+		if err := templates.Add(models.Template{SystemId: 1, FileReference: ref, FilePath: "templates/" + ref + ".pdf"}); err != nil {
+			t.Fatalf("Failed to add template: %v", err)
+		}
+	}
+
+	store := stores.TemplateAttachmentStore{Db: db}
+
+	if err := store.Replace(1, "routine", []models.TemplateAttachment{{TemplateId: 1, Copies: 1}}); err != nil {
+		t.Fatalf("Failed to attach templates: %v", err)
+	}
+	if err := store.Replace(1, "routine", []models.TemplateAttachment{
+		{TemplateId: 3, Copies: 2},
+		{TemplateId: 2, Copies: 1},
+	}); err != nil {
+		t.Fatalf("Failed to replace attachments: %v", err)
+	}
+
+	attachments, err := store.GetByDocument(1, "routine")
+	if err != nil {
+		t.Fatalf("Failed to get attachments: %v", err)
+	}
+	if len(attachments) != 2 || attachments[0].TemplateId != 3 || attachments[0].Copies != 2 || attachments[1].TemplateId != 2 {
+		t.Fatalf("Expected the replaced attachments in the given order, got %+v", attachments)
+	}
+}
+
+func TestTemplateDataPrintedOncePerEmergency(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	t.Chdir(t.TempDir())
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	file := pdf.New()
+	file.AddPage().Text(50, 780, pdf.HelveticaBold, 14, "OBSERVATIONS")
+	for _, name := range []string{"careplan.pdf", "routine.pdf", "observations.pdf"} {
+		if err := os.WriteFile(name, file.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	for _, ref := range []string{"careplan", "routine"} {
+		if err := documentStore.Add(models.Document{SystemId: 1, FileReference: ref, FilePath: ref + ".pdf"}); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	fields := []models.TemplateField{{Name: "name", X: 50, Y: 750}}
+	if err := (&stores.TemplateStore{Db: db}).Add(models.Template{SystemId: 1, FileReference: "observations", FilePath: "observations.pdf", Fields: fields}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	attachments := stores.TemplateAttachmentStore{Db: db}
+	for _, ref := range []string{"careplan", "routine"} {
+		if err := attachments.Replace(1, ref, []models.TemplateAttachment{{TemplateId: 1, Copies: 1}}); err != nil {
+			t.Fatalf("Failed to attach template: %v", err)
+		}
+	}
+
+	// Two residents' data for any document, and one more for the care plan
+	dataStore := stores.TemplateDataStore{Db: db}
+	careplan := "careplan"
+	for _, item := range []models.TemplateData{
+		{TemplateId: 1, Subject: "resident-1", Data: map[string]any{"name": "Anna Berg"}},
+		{TemplateId: 1, Subject: "resident-2", Data: map[string]any{"name": "Erik Lund"}},
+		{TemplateId: 1, FileReference: &careplan, Subject: "resident-3", Data: map[string]any{"name": "Maja Holm"}},
+	} {
+		if err := dataStore.Set(item); err != nil {
+			t.Fatalf("Failed to set data: %v", err)
+		}
+	}
+
+	monitorService, _ := newTestMonitor(db)
+	if err := monitorService.ActivateEmergency(1, models.PrintModeFull, nil, nil); err != nil {
+		t.Fatalf("Failed to activate emergency: %v", err)
+	}
+
+	jobs, err := (&stores.PrintJobStore{Db: db}).GetByTemplateId(1)
+	if err != nil {
+		t.Fatalf("Failed to get template jobs: %v", err)
+	}
+
+	// Every resident is printed once with the care plan, and the routine
+	// gets a blank copy
+	var filled, blank int
+	for _, job := range jobs {
+		if job.FilePath == "observations.pdf" {
+			blank++
+		} else {
+			filled++
+		}
+	}
+	if filled != 3 || blank != 1 {
+		t.Errorf("Expected 3 filled-in copies and 1 blank copy, got %d and %d", filled, blank)
+	}
+}
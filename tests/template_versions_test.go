@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestTemplateVersions(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	store := stores.TemplateStore{Db: db}
+
+	if err := store.Add(models.Template{SystemId: 1, FileReference: "meds", FilePath: "templates/meds/v1.pdf", Description: "First"}); err != nil {
+		t.Fatalf("Failed to add template: %v", err)
+	}
+
+	if err := store.Update(models.Template{Id: 1, FilePath: "templates/meds/v2.pdf", Description: "Second"}); err != nil {
+		t.Fatalf("Failed to update template: %v", err)
+	}
+
+	if err := store.Rollback(1, 1); err != nil {
+		t.Fatalf("Failed to roll back template: %v", err)
+	}
+
+	template, err := store.GetById(1)
+	if err != nil {
+		t.Fatalf("Failed to get template: %v", err)
+	}
+	if template.Version != 3 || template.FilePath != "templates/meds/v1.pdf" || template.Description != "First" {
+		t.Errorf("Expected version 1 to be current again as version 3, got %+v", template)
+	}
+
+	versions, err := store.GetVersions(1)
+	if err != nil {
+		t.Fatalf("Failed to get versions: %v", err)
+	}
+	if len(versions) != 3 || versions[0].Version != 3 || versions[1].FilePath != "templates/meds/v2.pdf" {
+		t.Fatalf("Expected three versions, newest first, got %+v", versions)
+	}
+
+	if err := store.Rollback(1, 7); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected rolling back to a missing version to fail with no rows, got %v", err)
+	}
+
+	if deleted, err := store.Delete(1); err != nil || !deleted {
+		t.Fatalf("Expected template to be deleted, got %v, %v", deleted, err)
+	}
+	if deleted, _ := store.Delete(1); deleted {
+		t.Error("Expected deleting a deleted template to report nothing deleted")
+	}
+
+	listed, err := store.GetBySystemId(1)
+	if err != nil {
+		t.Fatalf("Failed to list templates: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected deleted templates not to be listed, got %+v", listed)
+	}
+
+	if err := store.Update(models.Template{Id: 1, FilePath: "templates/meds/v4.pdf"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected updating a deleted template to fail with no rows, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tests
+
+import (
+	"blackoutbox/internal/models"
+	"blackoutbox/internal/stores"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDocumentTrash(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name, trash_days) VALUES (1, 'care-1', 'Care 1', 7)`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	documentStore := stores.DocumentStore{Db: db}
+	for _, reference := range []string{"meds", "plan"} {
+		document := models.Document{SystemId: 1, FileReference: reference, FilePath: "documents/care-1/" + reference + ".pdf"}
+		if err := documentStore.Add(document); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	if deleted, err := documentStore.Delete(1); err != nil || !deleted {
+		t.Fatalf("Expected the document to be deleted, got %v (%v)", deleted, err)
+	}
+	if deleted, err := documentStore.Delete(1); err != nil || deleted {
+		t.Fatalf("Expected a document in the trash not to be deleted again, got %v (%v)", deleted, err)
+	}
+	if documents, err := documentStore.GetBySystemId(1); err != nil || len(documents) != 1 || documents[0].Id != 2 {
+		t.Fatalf("Expected the deleted document to be hidden, got %+v (%v)", documents, err)
+	}
+	if _, err := documentStore.GetById(1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected the deleted document not to be found, got %v", err)
+	}
+
+	trash, err := documentStore.GetDeleted(nil)
+	if err != nil || len(trash) != 1 || trash[0].Id != 1 {
+		t.Fatalf("Expected the deleted document in the trash, got %+v (%v)", trash, err)
+	}
+	if trash[0].PurgeAt != *trash[0].DeletedAt+7*86400 {
+		t.Errorf("Expected the document to be purged after 7 days, got %d", trash[0].PurgeAt)
+	}
+
+	if restored, err := documentStore.Restore(1); err != nil || !restored {
+		t.Fatalf("Expected the document to be restored, got %v (%v)", restored, err)
+	}
+	if document, err := documentStore.GetById(1); err != nil || document.DeletedAt != nil {
+		t.Fatalf("Expected the restored document, got %+v (%v)", document, err)
+	}
+
+	// Documents are only purged once their time in the trash is up
+	if _, err := documentStore.Delete(2); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	paths, err := documentStore.PurgeDeleted(time.Now().Unix())
+	if err != nil || len(paths) != 0 {
+		t.Fatalf("Expected nothing to be purged yet, got %v (%v)", paths, err)
+	}
+	paths, err = documentStore.PurgeDeleted(time.Now().Add(8 * 24 * time.Hour).Unix())
+	if err != nil || !slices.Equal(paths, []string{"documents/care-1/plan.pdf"}) {
+		t.Fatalf("Expected the deleted document's file to be purged, got %v (%v)", paths, err)
+	}
+	if trash, err := documentStore.GetDeleted(nil); err != nil || len(trash) != 0 {
+		t.Fatalf("Expected the trash to be empty, got %+v (%v)", trash, err)
+	}
+
+	// Documents of a deleted system go to the trash and stay there
+	if err := (&stores.SystemStore{Db: db}).DeleteSystem(1); err != nil {
+		t.Fatalf("Failed to delete system: %v", err)
+	}
+	if trash, err := documentStore.GetDeleted(nil); err != nil || len(trash) != 1 || trash[0].Id != 1 {
+		t.Fatalf("Expected the system's document in the trash, got %+v (%v)", trash, err)
+	}
+	if restored, err := documentStore.Restore(1); err != nil || restored {
+		t.Fatalf("Expected a deleted system's document not to be restored, got %v (%v)", restored, err)
+	}
+}
+
+func TestSyncTrash(t *testing.T) {
+	db := setupMigratedDB(t)
+	defer db.Close()
+	t.Chdir(t.TempDir())
+
+	if _, err := db.Exec(`INSERT INTO systems (id, reference, name) VALUES (1, 'care-1', 'Care 1')`); err != nil {
+		t.Fatalf("Failed to add system: %v", err)
+	}
+
+	systemStore := stores.SystemStore{Db: db}
+	documentStore := stores.DocumentStore{Db: db}
+	meds := models.Document{FileReference: "meds", FilePath: "documents/care-1/meds.pdf"}
+	plan := models.Document{FileReference: "plan", FilePath: "documents/care-1/plan.pdf"}
+
+	if err := systemStore.Sync(1, []models.Document{meds, plan}); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if err := systemStore.Sync(1, []models.Document{meds}); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	trash, err := documentStore.GetDeleted(nil)
+	if err != nil || len(trash) != 1 || trash[0].FileReference != "plan" {
+		t.Fatalf("Expected the unlisted document in the trash, got %+v (%v)", trash, err)
+	}
+	planId := trash[0].Id
+
+	if err := systemStore.Sync(1, []models.Document{meds, plan}); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	document, err := documentStore.GetById(planId)
+	if err != nil || document.DeletedAt != nil {
+		t.Fatalf("Expected the listed document to be restored with id %d, got %+v (%v)", planId, document, err)
+	}
+	if trash, err := documentStore.GetDeleted(nil); err != nil || len(trash) != 0 {
+		t.Fatalf("Expected the trash to be empty, got %+v (%v)", trash, err)
+	}
+}